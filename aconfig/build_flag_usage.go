@@ -0,0 +1,98 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aconfig
+
+import (
+	"encoding/json"
+
+	"android/soong/android"
+)
+
+// A singleton that writes which release build flags are read by modules, and which
+// aconfig_declarations and aconfig_value_set modules are in the tree, to
+// build_flag_usage.json.  `build-flag usage` cross-references it with the release config
+// to report flags that Soong doesn't read, flags read but not declared, and the consumers of
+// each flag.  Only the flags read by mutators and modules are reported, see
+// android.Config.BuildFlagReads, so that the file doesn't depend on the order in which the
+// parallel singletons run.
+func BuildFlagUsageFactory() android.Singleton {
+	return &buildFlagUsageSingleton{}
+}
+
+type buildFlagUsageSingleton struct {
+	outputPath android.OutputPath
+}
+
+// The contents of build_flag_usage.json.  This must match FlagUsage in
+// cmd/release_config/release_config_lib.
+type buildFlagUsage struct {
+	// The modules that read each release build flag.
+	BuildFlagReads map[string][]string `json:"build_flag_reads"`
+
+	AconfigDeclarations []aconfigDeclarationsUsage `json:"aconfig_declarations"`
+	AconfigValueSets    []aconfigValueSetUsage     `json:"aconfig_value_sets"`
+}
+
+type aconfigDeclarationsUsage struct {
+	Name      string `json:"name"`
+	Package   string `json:"package"`
+	Container string `json:"container"`
+}
+
+type aconfigValueSetUsage struct {
+	Name string `json:"name"`
+
+	// The packages of the aconfig_values in the value set.
+	Packages []string `json:"packages"`
+}
+
+func (this *buildFlagUsageSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	usage := buildFlagUsage{
+		BuildFlagReads: ctx.Config().BuildFlagReads(),
+	}
+
+	var valueSetReaders []string
+	ctx.VisitAllModules(func(module android.Module) {
+		if decl, ok := android.SingletonModuleProvider(ctx, module, android.AconfigDeclarationsProviderKey); ok {
+			usage.AconfigDeclarations = append(usage.AconfigDeclarations, aconfigDeclarationsUsage{
+				Name:      ctx.ModuleName(module),
+				Package:   decl.Package,
+				Container: decl.Container,
+			})
+			// aconfig_declarations find their values through RELEASE_ACONFIG_VALUE_SETS.
+			valueSetReaders = append(valueSetReaders, ctx.ModuleName(module))
+		}
+		if valueSet, ok := android.SingletonModuleProvider(ctx, module, valueSetProviderKey); ok {
+			usage.AconfigValueSets = append(usage.AconfigValueSets, aconfigValueSetUsage{
+				Name:     ctx.ModuleName(module),
+				Packages: android.SortedKeys(valueSet.AvailablePackages),
+			})
+		}
+	})
+	if len(valueSetReaders) > 0 {
+		usage.BuildFlagReads["RELEASE_ACONFIG_VALUE_SETS"] = android.SortedUniqueStrings(
+			append(usage.BuildFlagReads["RELEASE_ACONFIG_VALUE_SETS"], valueSetReaders...))
+	}
+
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		ctx.Errorf("failed to marshal build flag usage: %s", err)
+		return
+	}
+
+	this.outputPath = android.PathForOutput(ctx, "build_flag_usage.json")
+	android.WriteFileRuleVerbatim(ctx, this.outputPath, string(data))
+	ctx.Phony("build_flag_usage", this.outputPath)
+}
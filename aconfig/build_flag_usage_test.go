@@ -0,0 +1,81 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestBuildFlagUsage(t *testing.T) {
+	bp := `
+		aconfig_declarations {
+			name: "module_name.foo",
+			package: "com.example.foo",
+			container: "system",
+			srcs: [
+				"foo.aconfig",
+			],
+		}
+
+		aconfig_value_set {
+			name: "aconfig_value_set-platform_build_release-trunk",
+			values: [
+				"aconfig-values-platform_build_release-trunk-com.example.foo",
+			],
+		}
+
+		aconfig_values {
+			name: "aconfig-values-platform_build_release-trunk-com.example.foo",
+			package: "com.example.foo",
+			srcs: [
+				"foo.values",
+			],
+		}
+	`
+	result := android.GroupFixturePreparers(
+		PrepareForTestWithAconfigBuildComponents,
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.ReleaseAconfigValueSets = []string{"aconfig_value_set-platform_build_release-trunk"}
+		}),
+	).RunTestWithBp(t, bp)
+
+	output := result.SingletonForTests("build_flag_usage").Output("build_flag_usage.json")
+	var usage buildFlagUsage
+	if err := json.Unmarshal([]byte(android.ContentFromFileRuleForTests(t, result.TestContext, output)), &usage); err != nil {
+		t.Fatal(err)
+	}
+
+	// Soong itself reads RELEASE_ACONFIG_VALUE_SETS for the aconfig_declarations.
+	if g, w := usage.BuildFlagReads["RELEASE_ACONFIG_VALUE_SETS"], []string{"module_name.foo", "soong"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected RELEASE_ACONFIG_VALUE_SETS to be read by %q, got %q", w, g)
+	}
+
+	expectedDeclarations := []aconfigDeclarationsUsage{
+		{Name: "module_name.foo", Package: "com.example.foo", Container: "system"},
+	}
+	if !reflect.DeepEqual(usage.AconfigDeclarations, expectedDeclarations) {
+		t.Errorf("expected aconfig declarations %#v, got %#v", expectedDeclarations, usage.AconfigDeclarations)
+	}
+	expectedValueSets := []aconfigValueSetUsage{
+		{Name: "aconfig_value_set-platform_build_release-trunk", Packages: []string{"com.example.foo"}},
+	}
+	if !reflect.DeepEqual(usage.AconfigValueSets, expectedValueSets) {
+		t.Errorf("expected aconfig value sets %#v, got %#v", expectedValueSets, usage.AconfigValueSets)
+	}
+}
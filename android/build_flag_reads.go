@@ -0,0 +1,68 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"sort"
+)
+
+type buildFlagRead struct {
+	flag   string
+	module string
+}
+
+// soongBuildFlagReader is the module recorded for the release build flags that Soong reads
+// directly through the Config, rather than through release_flag() conditions in selects.
+const soongBuildFlagReader = "soong"
+
+// addBuildFlagRead records that a module read a release build flag, even if the flag is not
+// declared in the current release config.
+func (c *config) addBuildFlagRead(flag, module string) {
+	if c.buildFlagReadsFrozen.Load() {
+		return
+	}
+	c.buildFlagReadsSet.LoadOrStore(buildFlagRead{flag, module}, true)
+}
+
+// freezeBuildFlagReads stops recording release build flag reads.  It is called when the
+// singletons start, so that the reads reported by BuildFlagReads don't depend on the order in
+// which the parallel singletons run.
+func (c *config) freezeBuildFlagReads() {
+	c.buildFlagReadsFrozen.Store(true)
+}
+
+// getBuildFlagBool returns the value of a boolean release build flag, and records that Soong
+// read it.
+func (c *config) getBuildFlagBool(flag string) bool {
+	c.addBuildFlagRead(flag, soongBuildFlagReader)
+	return c.productVariables.GetBuildFlagBool(flag)
+}
+
+// BuildFlagReads returns the names of the modules that read each release build flag through
+// release_flag() conditions in selects, sorted by module name.  The flags that Soong reads
+// directly are read by the "soong" module.  Only the reads made by mutators and modules are
+// reported, the flags that are only read by singletons are missing.
+func (c *config) BuildFlagReads() map[string][]string {
+	reads := make(map[string][]string)
+	c.buildFlagReadsSet.Range(func(key, value interface{}) bool {
+		read := key.(buildFlagRead)
+		reads[read.flag] = append(reads[read.flag], read.module)
+		return true
+	})
+	for _, modules := range reads {
+		sort.Strings(modules)
+	}
+	return reads
+}
@@ -26,6 +26,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unicode"
 
 	"android/soong/shared"
@@ -190,29 +191,33 @@ func (c Config) NoBionicPageSizeMacro() bool {
 
 // The release version passed to aconfig, derived from RELEASE_VERSION
 func (c Config) ReleaseVersion() string {
+	c.config.addBuildFlagRead("RELEASE_VERSION", soongBuildFlagReader)
 	return c.config.productVariables.ReleaseVersion
 }
 
 // The aconfig value set passed to aconfig, derived from RELEASE_VERSION
 func (c Config) ReleaseAconfigValueSets() []string {
+	c.config.addBuildFlagRead("RELEASE_ACONFIG_VALUE_SETS", soongBuildFlagReader)
 	return c.config.productVariables.ReleaseAconfigValueSets
 }
 
 // The flag default permission value passed to aconfig
 // derived from RELEASE_ACONFIG_FLAG_DEFAULT_PERMISSION
 func (c Config) ReleaseAconfigFlagDefaultPermission() string {
+	c.config.addBuildFlagRead("RELEASE_ACONFIG_FLAG_DEFAULT_PERMISSION", soongBuildFlagReader)
 	return c.config.productVariables.ReleaseAconfigFlagDefaultPermission
 }
 
 // The flag indicating behavior for the tree wrt building modules or using prebuilts
 // derived from RELEASE_DEFAULT_MODULE_BUILD_FROM_SOURCE
 func (c Config) ReleaseDefaultModuleBuildFromSource() bool {
+	c.config.addBuildFlagRead("RELEASE_DEFAULT_MODULE_BUILD_FROM_SOURCE", soongBuildFlagReader)
 	return c.config.productVariables.ReleaseDefaultModuleBuildFromSource == nil ||
 		Bool(c.config.productVariables.ReleaseDefaultModuleBuildFromSource)
 }
 
 func (c Config) ReleaseDisableVerifyOverlaps() bool {
-	return c.config.productVariables.GetBuildFlagBool("RELEASE_DISABLE_VERIFY_OVERLAPS_CHECK")
+	return c.config.getBuildFlagBool("RELEASE_DISABLE_VERIFY_OVERLAPS_CHECK")
 }
 
 // Enables flagged apis annotated with READ_WRITE aconfig flags to be included in the stubs
@@ -223,22 +228,22 @@ func (c Config) ReleaseExportRuntimeApis() bool {
 
 // Enables ABI monitoring of NDK libraries
 func (c Config) ReleaseNdkAbiMonitored() bool {
-	return c.config.productVariables.GetBuildFlagBool("RELEASE_NDK_ABI_MONITORED")
+	return c.config.getBuildFlagBool("RELEASE_NDK_ABI_MONITORED")
 }
 
 // Enable read flag from new storage, for C/C++
 func (c Config) ReleaseReadFromNewStorageCc() bool {
-	return c.config.productVariables.GetBuildFlagBool("RELEASE_READ_FROM_NEW_STORAGE_CC")
+	return c.config.getBuildFlagBool("RELEASE_READ_FROM_NEW_STORAGE_CC")
 }
 
 func (c Config) ReleaseHiddenApiExportableStubs() bool {
-	return c.config.productVariables.GetBuildFlagBool("RELEASE_HIDDEN_API_EXPORTABLE_STUBS") ||
+	return c.config.getBuildFlagBool("RELEASE_HIDDEN_API_EXPORTABLE_STUBS") ||
 		Bool(c.config.productVariables.HiddenapiExportableStubs)
 }
 
 // Enable read flag from new storage
 func (c Config) ReleaseReadFromNewStorage() bool {
-	return c.config.productVariables.GetBuildFlagBool("RELEASE_READ_FROM_NEW_STORAGE")
+	return c.config.getBuildFlagBool("RELEASE_READ_FROM_NEW_STORAGE")
 }
 
 // A DeviceConfig object represents the configuration for a particular device
@@ -311,6 +316,11 @@ type config struct {
 	// regenerate build.ninja.
 	ninjaFileDepsSet sync.Map
 
+	// The release build flags read by modules, see BuildFlagReads.
+	buildFlagReadsSet sync.Map
+	// Set when the singletons start, the reads after that are not recorded.
+	buildFlagReadsFrozen atomic.Bool
+
 	OncePer
 
 	// If buildFromSourceStub is true then the Java API stubs are
@@ -886,7 +896,7 @@ func (c *config) PlatformPreviewSdkVersion() string {
 }
 
 func (c *config) PlatformMinSupportedTargetSdkVersion() string {
-	var val, ok = c.productVariables.BuildFlags["RELEASE_PLATFORM_MIN_SUPPORTED_TARGET_SDK_VERSION"]
+	var val, ok = c.GetBuildFlag("RELEASE_PLATFORM_MIN_SUPPORTED_TARGET_SDK_VERSION")
 	if !ok {
 		return ""
 	}
@@ -1348,7 +1358,7 @@ func IsTrunkStableVendorApiLevel(level string) bool {
 }
 
 func (c *config) VendorApiLevelFrozen() bool {
-	return c.productVariables.GetBuildFlagBool("RELEASE_BOARD_API_LEVEL_FROZEN")
+	return c.getBuildFlagBool("RELEASE_BOARD_API_LEVEL_FROZEN")
 }
 
 func (c *deviceConfig) Arches() []Arch {
@@ -2000,12 +2010,13 @@ func (c *deviceConfig) CheckVendorSeappViolations() bool {
 }
 
 func (c *config) GetBuildFlag(name string) (string, bool) {
+	c.addBuildFlagRead(name, soongBuildFlagReader)
 	val, ok := c.productVariables.BuildFlags[name]
 	return val, ok
 }
 
 func (c *config) UseResourceProcessorByDefault() bool {
-	return c.productVariables.GetBuildFlagBool("RELEASE_USE_RESOURCE_PROCESSOR_BY_DEFAULT")
+	return c.getBuildFlagBool("RELEASE_USE_RESOURCE_PROCESSOR_BY_DEFAULT")
 }
 
 var (
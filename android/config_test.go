@@ -180,3 +180,25 @@ func TestConfiguredJarList(t *testing.T) {
 		assertStringEquals(t, "apex1:jarA", list5.String())
 	})
 }
+
+func TestBuildFlagReadsBySoong(t *testing.T) {
+	c := Config{&config{}}
+	c.ReleaseNdkAbiMonitored()
+	c.GetBuildFlag("RELEASE_FOO")
+	c.addBuildFlagRead("RELEASE_FOO", "libfoo")
+
+	expected := map[string][]string{
+		"RELEASE_FOO":               {"libfoo", "soong"},
+		"RELEASE_NDK_ABI_MONITORED": {"soong"},
+	}
+	if g, w := c.BuildFlagReads(), expected; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected build flag reads %q, got %q", w, g)
+	}
+
+	// The reads made once the singletons started are not recorded.
+	c.freezeBuildFlagReads()
+	c.GetBuildFlag("RELEASE_BAR")
+	if g, w := c.BuildFlagReads(), expected; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected build flag reads %q after freezing, got %q", w, g)
+	}
+}
@@ -2171,6 +2171,7 @@ func (e configurationEvalutor) EvaluateConfiguration(condition proptools.Configu
 			ctx.OtherModulePropertyErrorf(m, property, "release_flag requires 1 argument, found %d", condition.NumArgs())
 			return proptools.ConfigurableValueUndefined()
 		}
+		ctx.Config().addBuildFlagRead(condition.Arg(0), m.Name())
 		if ty, ok := ctx.Config().productVariables.BuildFlagTypes[condition.Arg(0)]; ok {
 			v := ctx.Config().productVariables.BuildFlags[condition.Arg(0)]
 			switch ty {
@@ -57,6 +57,9 @@ type RuleBuilder struct {
 	sboxTools        bool
 	sboxInputs       bool
 	sboxManifestPath WritablePath
+	sboxNoCache      bool
+	sboxHermetic     bool
+	sboxAccessTrace  WritablePath
 	missingDeps      []string
 }
 
@@ -179,6 +182,8 @@ func (r *RuleBuilder) SandboxTools() *RuleBuilder {
 // that are passed to RuleBuilder outside of the methods that expect inputs, for example
 // FlagWithArg, must use RuleBuilderCommand.PathForInput to translate the path to one that matches
 // the sandbox layout.
+//
+// If SOONG_SBOX_HERMETIC_INPUTS=true is set in the environment it also implies HermeticInputs().
 func (r *RuleBuilder) SandboxInputs() *RuleBuilder {
 	if !r.sbox {
 		panic("SandboxInputs() must be called after Sbox()")
@@ -188,6 +193,41 @@ func (r *RuleBuilder) SandboxInputs() *RuleBuilder {
 	}
 	r.sboxTools = true
 	r.sboxInputs = true
+	if r.ctx.Config().IsEnvTrue("SOONG_SBOX_HERMETIC_INPUTS") {
+		r.sboxHermetic = true
+	}
+	return r
+}
+
+// HermeticInputs enables hermetic input sandboxing for the rule.  On Linux hosts sbox runs the
+// command in a mount namespace where only the sandbox directory and the system directories needed
+// to run tools are visible, so reading an input that was not declared to the RuleBuilder fails
+// even if it is referenced by an absolute path.  It implies SandboxInputs().
+func (r *RuleBuilder) HermeticInputs() *RuleBuilder {
+	r.SandboxInputs()
+	r.sboxHermetic = true
+	return r
+}
+
+// DisableOutputCache prevents sbox from restoring the outputs of the rule from, or storing them
+// in, the sbox output cache.  It should be used for rules whose outputs depend on anything other
+// than the contents of their sandboxed inputs and tools, for example the current time.
+func (r *RuleBuilder) DisableOutputCache() *RuleBuilder {
+	if !r.sbox {
+		panic("DisableOutputCache() must be called after Sbox()")
+	}
+	r.sboxNoCache = true
+	return r
+}
+
+// TraceFileAccesses makes sbox trace the files read by the command and write the ones that
+// were read from outside the sandbox directory to traceFile, which is added as an implicit output
+// of the rule.  It is only supported on Linux hosts.
+func (r *RuleBuilder) TraceFileAccesses(traceFile WritablePath) *RuleBuilder {
+	if !r.sbox {
+		panic("TraceFileAccesses() must be called after Sbox()")
+	}
+	r.sboxAccessTrace = traceFile
 	return r
 }
 
@@ -507,11 +547,18 @@ func (r *RuleBuilder) build(name string, desc string, ninjaEscapeCommandString b
 		command := sbox_proto.Command{}
 		manifest.Commands = append(manifest.Commands, &command)
 		command.Command = proto.String(commandString)
+		if r.sboxNoCache {
+			command.DisableOutputCache = proto.Bool(true)
+		}
 
 		if depFile != nil {
 			manifest.OutputDepfile = proto.String(depFile.String())
 		}
 
+		if r.sboxHermetic {
+			manifest.HermeticInputs = proto.Bool(true)
+		}
+
 		// If sandboxing tools is enabled, add copy rules to the manifest to copy each tool
 		// into the sbox directory.
 		if r.sboxTools {
@@ -642,6 +689,19 @@ func (r *RuleBuilder) build(name string, desc string, ninjaEscapeCommandString b
 			sboxCmd.Flag("--write-if-changed")
 		}
 
+		if cacheDir := r.ctx.Config().Getenv("SBOX_OUTPUT_CACHE_DIR"); cacheDir != "" {
+			sboxCmd.FlagWithArg("--output-cache-dir ", cacheDir)
+		}
+
+		if r.sboxHermetic && r.ctx.Config().BuildOS == Linux {
+			sboxCmd.FlagWithInput("--nsjail ", r.ctx.Config().PrebuiltBuildTool(r.ctx, "nsjail"))
+		}
+
+		if r.sboxAccessTrace != nil {
+			sboxCmd.FlagWithArg("--file-access-trace ", r.sboxAccessTrace.String())
+			outputs = append(outputs, r.sboxAccessTrace)
+		}
+
 		// Replace the command string, and add the sbox tool and manifest textproto to the
 		// dependencies of the final sbox rule.
 		commandString = sboxCmd.buf.String()
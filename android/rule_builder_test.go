@@ -475,10 +475,11 @@ type testRuleBuilderModule struct {
 		Srcs  []string
 		Flags []string
 
-		Restat              bool
-		Sbox                bool
-		Sbox_inputs         bool
-		Unescape_ninja_vars bool
+		Restat               bool
+		Sbox                 bool
+		Sbox_inputs          bool
+		Unescape_ninja_vars  bool
+		Disable_output_cache bool
 	}
 }
 
@@ -499,6 +500,7 @@ func (t *testRuleBuilderModule) GenerateAndroidBuildActions(ctx ModuleContext) {
 	testRuleBuilder_Build(ctx, in, implicit, orderOnly, validation, t.properties.Flags,
 		out, outDep, outDir,
 		manifestPath, t.properties.Restat, t.properties.Sbox, t.properties.Sbox_inputs, t.properties.Unescape_ninja_vars,
+		t.properties.Disable_output_cache,
 		rspFile, rspFileContents, rspFile2, rspFileContents2)
 }
 
@@ -523,14 +525,14 @@ func (t *testRuleBuilderSingleton) GenerateBuildActions(ctx SingletonContext) {
 	manifestPath := PathForOutput(ctx, "singleton/sbox.textproto")
 
 	testRuleBuilder_Build(ctx, in, implicit, orderOnly, validation, nil, out, outDep, outDir,
-		manifestPath, true, false, false, false,
+		manifestPath, true, false, false, false, false,
 		rspFile, rspFileContents, rspFile2, rspFileContents2)
 }
 
 func testRuleBuilder_Build(ctx BuilderContext, in Paths, implicit, orderOnly, validation Path,
 	flags []string,
 	out, outDep, outDir, manifestPath WritablePath,
-	restat, sbox, sboxInputs, unescapeNinjaVars bool,
+	restat, sbox, sboxInputs, unescapeNinjaVars, disableOutputCache bool,
 	rspFile WritablePath, rspFileContents Paths, rspFile2 WritablePath, rspFileContents2 Paths) {
 
 	rule := NewRuleBuilder(pctx_ruleBuilderTest, ctx)
@@ -540,6 +542,9 @@ func testRuleBuilder_Build(ctx BuilderContext, in Paths, implicit, orderOnly, va
 		if sboxInputs {
 			rule.SandboxInputs()
 		}
+		if disableOutputCache {
+			rule.DisableOutputCache()
+		}
 	}
 
 	rule.Command().
@@ -822,3 +827,103 @@ func TestRuleBuilderWithNinjaVarEscaping(t *testing.T) {
 		"$${cmdFlags}",
 	)
 }
+
+func TestRuleBuilderHermeticInputs(t *testing.T) {
+	bp := `
+		rule_builder_test {
+			name: "foo_sbox",
+			sbox: true,
+		}
+		rule_builder_test {
+			name: "foo_sbox_inputs",
+			sbox: true,
+			sbox_inputs: true,
+		}
+	`
+
+	t.Run("default", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+		).RunTest(t)
+
+		manifest := RuleBuilderSboxProtoForTests(t, result.TestContext,
+			result.ModuleForTests("foo_sbox_inputs", "").Output("sbox.textproto"))
+		AssertBoolEquals(t, "hermetic_inputs", false, manifest.GetHermeticInputs())
+	})
+
+	t.Run("SOONG_SBOX_HERMETIC_INPUTS", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+			FixtureMergeEnv(map[string]string{
+				"SOONG_SBOX_HERMETIC_INPUTS": "true",
+			}),
+		).RunTest(t)
+
+		inputs := result.ModuleForTests("foo_sbox_inputs", "")
+		manifest := RuleBuilderSboxProtoForTests(t, result.TestContext, inputs.Output("sbox.textproto"))
+		AssertBoolEquals(t, "hermetic_inputs", true, manifest.GetHermeticInputs())
+		// nsjail is only used on Linux hosts.
+		if result.Config.BuildOS == Linux {
+			AssertStringDoesContain(t, "sbox command", inputs.Rule("rule").RuleParams.Command,
+				"--nsjail prebuilts/build-tools/linux-x86/bin/nsjail")
+		} else {
+			AssertStringDoesNotContain(t, "sbox command", inputs.Rule("rule").RuleParams.Command, "--nsjail")
+		}
+
+		// Rules that don't sandbox their inputs can't be hermetic.
+		noInputs := result.ModuleForTests("foo_sbox", "")
+		manifest = RuleBuilderSboxProtoForTests(t, result.TestContext, noInputs.Output("sbox.textproto"))
+		AssertBoolEquals(t, "hermetic_inputs", false, manifest.GetHermeticInputs())
+		AssertStringDoesNotContain(t, "sbox command", noInputs.Rule("rule").RuleParams.Command, "--nsjail")
+	})
+}
+
+func TestRuleBuilderOutputCache(t *testing.T) {
+	bp := `
+		rule_builder_test {
+			name: "foo_sbox",
+			sbox: true,
+		}
+		rule_builder_test {
+			name: "foo_sbox_no_cache",
+			sbox: true,
+			disable_output_cache: true,
+		}
+	`
+
+	t.Run("default", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+		).RunTest(t)
+
+		module := result.ModuleForTests("foo_sbox", "")
+		manifest := RuleBuilderSboxProtoForTests(t, result.TestContext, module.Output("sbox.textproto"))
+		AssertBoolEquals(t, "disable_output_cache", false, manifest.Commands[0].GetDisableOutputCache())
+		AssertStringDoesNotContain(t, "sbox command", module.Rule("rule").RuleParams.Command, "--output-cache-dir")
+
+		noCache := result.ModuleForTests("foo_sbox_no_cache", "")
+		manifest = RuleBuilderSboxProtoForTests(t, result.TestContext, noCache.Output("sbox.textproto"))
+		AssertBoolEquals(t, "disable_output_cache", true, manifest.Commands[0].GetDisableOutputCache())
+	})
+
+	t.Run("SBOX_OUTPUT_CACHE_DIR", func(t *testing.T) {
+		result := GroupFixturePreparers(
+			prepareForRuleBuilderTest,
+			FixtureWithRootAndroidBp(bp),
+			FixtureMergeEnv(map[string]string{
+				"SBOX_OUTPUT_CACHE_DIR": "/tmp/sbox-cache",
+			}),
+		).RunTest(t)
+
+		// The cache directory is passed to every sbox command, sbox skips the cache for the
+		// commands that disable it.
+		for _, name := range []string{"foo_sbox", "foo_sbox_no_cache"} {
+			AssertStringDoesContain(t, name+" sbox command",
+				result.ModuleForTests(name, "").Rule("rule").RuleParams.Command,
+				"--output-cache-dir /tmp/sbox-cache")
+		}
+	})
+}
@@ -840,6 +840,50 @@ func TestSelects(t *testing.T) {
 	}
 }
 
+func TestSelectsRecordBuildFlagReads(t *testing.T) {
+	result := GroupFixturePreparers(
+		PrepareForTestWithDefaults,
+		PrepareForTestWithArchMutator,
+		FixtureRegisterWithContext(func(ctx RegistrationContext) {
+			ctx.RegisterModuleType("my_module_type", newSelectsMockModule)
+		}),
+		FixtureModifyProductVariables(func(variables FixtureProductVariables) {
+			variables.BuildFlags = map[string]string{"RELEASE_FOO": "true"}
+			variables.BuildFlagTypes = map[string]string{"RELEASE_FOO": "bool"}
+		}),
+	).RunTestWithBp(t, `
+		my_module_type {
+			name: "foo",
+			my_bool: select(release_flag("RELEASE_FOO"), {
+				true: true,
+				default: false,
+			}),
+		}
+		my_module_type {
+			name: "bar",
+			my_string: select(release_flag("RELEASE_UNDECLARED"), {
+				"a": "a.cpp",
+				default: "b.cpp",
+			}),
+			my_bool: select(release_flag("RELEASE_FOO"), {
+				true: true,
+				default: false,
+			}),
+		}
+	`)
+
+	expected := map[string][]string{
+		"RELEASE_FOO":        {"bar", "foo"},
+		"RELEASE_UNDECLARED": {"bar"},
+	}
+	reads := result.Config.BuildFlagReads()
+	for flag, w := range expected {
+		if g := reads[flag]; !reflect.DeepEqual(g, w) {
+			t.Errorf("expected %s to be read by %q, got %q", flag, w, g)
+		}
+	}
+}
+
 type selectsTestProvider struct {
 	my_bool                        *bool
 	my_string                      *string
@@ -106,6 +106,8 @@ func (s *singletonAdaptor) GenerateBuildActions(ctx blueprint.SingletonContext)
 	if sctx.Config().captureBuild {
 		sctx.ruleParams = make(map[blueprint.Rule]blueprint.RuleParams)
 	}
+	// Singletons may run in parallel, only report the build flags read before any of them ran.
+	sctx.Config().freezeBuildFlagReads()
 
 	s.Singleton.GenerateBuildActions(sctx)
 
@@ -0,0 +1,131 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	RegisterAbiDiffReportBuildComponents(android.InitRegistrationContext)
+}
+
+func RegisterAbiDiffReportBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterParallelSingletonType("abi_diff_report", abiDiffReportSingletonFactory)
+}
+
+// The kinds of reference dumps a source dump is compared to.
+const (
+	abiDiffReportCurrent  = "current"
+	abiDiffReportPrevious = "previous"
+	abiDiffReportOptIn    = "opt-in"
+)
+
+// abiDiffReportEntry is a diff between the source dump of a library and a reference dump.
+type abiDiffReportEntry struct {
+	tag     lsdumpTag
+	kind    string
+	libName string
+
+	diff          android.Path
+	sourceDump    android.Path
+	referenceDump android.Path
+}
+
+// String returns the line of the entry in the list of diffs that is passed to abi_diff_report.
+func (e abiDiffReportEntry) String() string {
+	return strings.Join([]string{string(e.tag), e.kind, e.libName,
+		e.diff.String(), e.sourceDump.String(), e.referenceDump.String()}, " ")
+}
+
+func getAbiDiffReportDir(ctx android.PathContext) android.OutputPath {
+	return android.PathForOutput(ctx, "abi-diff-report")
+}
+
+func abiDiffReportSingletonFactory() android.Singleton {
+	return &abiDiffReportSingleton{}
+}
+
+// abiDiffReportSingleton aggregates the diffs between the source ABI dumps and the reference ABI
+// dumps of all the libraries into a report, grouped by lsdump tag and severity.
+//
+// `m abi-diff-report` writes the report to abi-diff-report/report.{json,html} in the soong output
+// directory.  Unlike the diffs that are built with the libraries, the diffs for the report don't
+// fail the build, so the report lists all the ABI changes in the tree at once.  The headers in the
+// HTML report link to ANDROID_ABI_DIFF_REPORT_URL_PREFIX followed by their path in the source
+// tree, or to the source tree relative to the report by default.
+//
+// `abi_diff_report update <library>...` then copies the source dumps of the chosen libraries over
+// their reference dumps.
+type abiDiffReportSingleton struct {
+	jsonReport android.Path
+	htmlReport android.Path
+}
+
+func (s *abiDiffReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var entries []abiDiffReportEntry
+	ctx.VisitAllModules(func(module android.Module) {
+		if !module.Enabled(ctx) {
+			return
+		}
+
+		if m, ok := module.(*Module); ok {
+			if library, ok := m.library.(*libraryDecorator); ok {
+				entries = append(entries, library.sAbiDiffReportEntries...)
+			}
+		}
+	})
+
+	lines := make([]string, 0, len(entries))
+	diffs := make(android.Paths, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+		diffs = append(diffs, entry.diff)
+	}
+	sort.Strings(lines)
+
+	dir := getAbiDiffReportDir(ctx)
+	list := dir.Join(ctx, "diffs.txt")
+	jsonReport := dir.Join(ctx, "report.json")
+	htmlReport := dir.Join(ctx, "report.html")
+
+	android.WriteFileRule(ctx, list, strings.Join(lines, "\n"))
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("abi_diff_report").
+		Text("report").
+		FlagWithInput("-l ", list).
+		FlagWithOutput("-o ", jsonReport).
+		FlagWithOutput("-html ", htmlReport).
+		Implicits(diffs)
+	if urlPrefix := ctx.Config().Getenv("ANDROID_ABI_DIFF_REPORT_URL_PREFIX"); urlPrefix != "" {
+		cmd.FlagWithArg("-header-url-prefix ", proptools.ShellEscape(urlPrefix))
+	}
+	rule.Build("abi_diff_report", "ABI diff report")
+
+	s.jsonReport = jsonReport
+	s.htmlReport = htmlReport
+
+	ctx.Phony("abi-diff-report", jsonReport, htmlReport)
+}
+
+func (s *abiDiffReportSingleton) MakeVars(ctx android.MakeVarsContext) {
+	ctx.DistForGoal("abi-diff-report", s.jsonReport, s.htmlReport)
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestAbiDiffReport(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(RegisterAbiDiffReportBuildComponents),
+		android.FixtureMergeMockFs(android.MockFS{
+			"abi-dumps/arm64/source-based/libfoo.so.lsdump": nil,
+		}),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.cpp"],
+			header_abi_checker: {
+				enabled: true,
+				ref_dump_dirs: ["abi-dumps"],
+			},
+		}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	// The diff that fails the build is still built with the library.
+	libfoo.Output("libfoo.so.opt0.abidiff")
+	reportDiff := libfoo.Output("abi-diff-report/libfoo.so.opt0.abidiff")
+	if reportDiff.Rule != sAbiDiffForReport {
+		t.Errorf("expected the sAbiDiffForReport rule, got %q", reportDiff.Rule)
+	}
+	android.AssertPathRelativeToTopEquals(t, "source dump",
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.lsdump", reportDiff.Input)
+	android.AssertStringEquals(t, "reference dump",
+		"abi-dumps/arm64/source-based/libfoo.so.lsdump", reportDiff.Args["referenceDump"])
+
+	singleton := result.SingletonForTests("abi_diff_report")
+	list := android.ContentFromFileRuleForTests(t, result.TestContext,
+		singleton.Output("abi-diff-report/diffs.txt"))
+	android.AssertStringEquals(t, "list of diffs",
+		"PLATFORM opt-in libfoo"+
+			" out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/abi-diff-report/libfoo.so.opt0.abidiff"+
+			" out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/libfoo.so.lsdump"+
+			" abi-dumps/arm64/source-based/libfoo.so.lsdump",
+		android.StringRelativeToTop(result.Config, list))
+
+	report := singleton.Output("abi-diff-report/report.html")
+	android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "abi_diff_report report")
+	android.AssertStringDoesNotContain(t, "header url prefix", report.RuleParams.Command, "-header-url-prefix")
+	android.AssertStringListContains(t, "report implicits",
+		android.PathsRelativeToTop(report.Implicits),
+		"out/soong/.intermediates/libfoo/android_arm64_armv8-a_shared/abi-diff-report/libfoo.so.opt0.abidiff")
+}
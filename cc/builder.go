@@ -301,6 +301,15 @@ var (
 		},
 		"extraFlags", "referenceDump", "libName", "arch", "errorMessage")
 
+	// Rule to compare linked sAbi dump files for the ABI diff report, which doesn't fail for ABI
+	// changes so that the report can collect them.
+	sAbiDiffForReport = pctx.AndroidStaticRule("sAbiDiffForReport",
+		blueprint.RuleParams{
+			Command:     "rm -f ${out} && ($sAbiDiffer ${extraFlags} -lib ${libName} -arch ${arch} -o ${out} -new ${in} -old ${referenceDump} || test -f ${out})",
+			CommandDeps: []string{"$sAbiDiffer"},
+		},
+		"extraFlags", "referenceDump", "libName", "arch")
+
 	// Rule to zip files.
 	zip = pctx.AndroidStaticRule("zip",
 		blueprint.RuleParams{
@@ -969,8 +978,10 @@ func transformDumpToLinkedDump(ctx android.ModuleContext, sAbiDumps android.Path
 	return outputFile
 }
 
+// transformAbiDumpToAbiDiff returns the diff that fails the build for ABI changes, and the diff
+// for the ABI diff report.
 func transformAbiDumpToAbiDiff(ctx android.ModuleContext, inputDump, referenceDump android.Path,
-	baseName, nameExt string, extraFlags []string, errorMessage string) android.Path {
+	baseName, nameExt string, extraFlags []string, errorMessage string) (android.Path, android.Path) {
 
 	var outputFile android.ModuleOutPath
 	if nameExt != "" {
@@ -994,7 +1005,22 @@ func transformAbiDumpToAbiDiff(ctx android.ModuleContext, inputDump, referenceDu
 			"errorMessage":  errorMessage,
 		},
 	})
-	return outputFile
+
+	reportFile := android.PathForModuleOut(ctx, "abi-diff-report", outputFile.Base())
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sAbiDiffForReport,
+		Description: "header-abi-diff for report " + reportFile.Base(),
+		Output:      reportFile,
+		Input:       inputDump,
+		Implicit:    referenceDump,
+		Args: map[string]string{
+			"referenceDump": referenceDump.String(),
+			"libName":       libName,
+			"arch":          ctx.Arch().ArchType.Name,
+			"extraFlags":    strings.Join(extraFlags, " "),
+		},
+	})
+	return outputFile, reportFile
 }
 
 // Generate a rule for extracting a table of contents from a shared library (.so)
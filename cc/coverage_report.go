@@ -0,0 +1,202 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+	"android/soong/cc/config"
+)
+
+func init() {
+	android.InitRegistrationContext.RegisterModuleType("native_coverage_report", CoverageReportFactory)
+}
+
+var coverageReportBinaryTag = dependencyTag{name: "coverage report binary"}
+
+type CoverageReportProperties struct {
+	// cc and rust binaries, tests and shared libraries whose coverage is reported.  Their
+	// unstripped outputs are passed to llvm-cov, so they must be built with clang coverage,
+	// for example with CLANG_COVERAGE=true and NATIVE_COVERAGE_PATHS set to their directories.
+	Binaries []string
+
+	// Raw (.profraw) or indexed (.profdata) profiles collected by running the binaries.
+	Profiles []string `android:"path"`
+
+	// Regular expressions matching the source files to leave out of the report.
+	Ignore_filename_regex []string
+}
+
+type CoverageReport struct {
+	android.ModuleBase
+
+	properties CoverageReportProperties
+
+	profdata   android.Path
+	htmlReport android.Path
+	lcovReport android.Path
+	jsonReport android.Path
+}
+
+var _ UseCoverage = (*CoverageReport)(nil)
+
+// native_coverage_report merges the clang coverage profiles collected by running cc and rust
+// binaries and tests and generates an HTML report, an LCOV tracefile and a JSON summary of
+// the coverage of those binaries with llvm-cov.
+//
+// The reports are built by building the module name, and are written to report-html.zip,
+// report.lcov and summary.json in the module output directory.
+func CoverageReportFactory() android.Module {
+	module := &CoverageReport{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.HostAndDeviceSupported, android.MultilibFirst)
+	return module
+}
+
+// IsNativeCoverageNeeded makes the report depend on the coverage variants of the binaries.
+func (r *CoverageReport) IsNativeCoverageNeeded(ctx android.IncomingTransitionContext) bool {
+	return ctx.DeviceConfig().NativeCoverageEnabled()
+}
+
+func (r *CoverageReport) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddFarVariationDependencies(ctx.Target().Variations(), coverageReportBinaryTag, r.properties.Binaries...)
+}
+
+func (r *CoverageReport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(r.properties.Binaries) == 0 {
+		ctx.PropertyErrorf("binaries", "at least one binary is required")
+	}
+	profiles := android.PathsForModuleSrc(ctx, r.properties.Profiles)
+	if len(profiles) == 0 {
+		ctx.PropertyErrorf("profiles", "at least one profile is required")
+	}
+
+	var binaries android.Paths
+	ctx.VisitDirectDepsWithTag(coverageReportBinaryTag, func(m android.Module) {
+		linkable, ok := m.(LinkableInterface)
+		if !ok || linkable.UnstrippedOutputFile() == nil {
+			ctx.PropertyErrorf("binaries", "%s is not a cc or rust binary, test or shared library",
+				ctx.OtherModuleName(m))
+			return
+		}
+		binaries = append(binaries, linkable.UnstrippedOutputFile())
+	})
+
+	if ctx.Failed() {
+		return
+	}
+
+	profdata := android.PathForModuleOut(ctx, "coverage.profdata")
+	htmlReport := android.PathForModuleOut(ctx, "report-html.zip")
+	lcovReport := android.PathForModuleOut(ctx, "report.lcov")
+	jsonReport := android.PathForModuleOut(ctx, "summary.json")
+
+	if !ctx.DeviceConfig().ClangCoverageEnabled() {
+		// Only fail when the report is built, so that the tree still builds without coverage.
+		ctx.Build(pctx, android.BuildParams{
+			Rule:            android.ErrorRule,
+			Description:     "coverage report",
+			Output:          htmlReport,
+			ImplicitOutputs: android.WritablePaths{profdata, lcovReport, jsonReport},
+			Args: map[string]string{
+				"error": fmt.Sprintf("%s: the binaries are not built with clang coverage, build with CLANG_COVERAGE=true",
+					ctx.ModuleName()),
+			},
+		})
+	} else {
+		r.buildReports(ctx, profiles, binaries, profdata, htmlReport, lcovReport, jsonReport)
+	}
+
+	r.profdata = profdata
+	r.htmlReport = htmlReport
+	r.lcovReport = lcovReport
+	r.jsonReport = jsonReport
+
+	ctx.Phony(ctx.ModuleName(), htmlReport, lcovReport, jsonReport)
+}
+
+func (r *CoverageReport) buildReports(ctx android.ModuleContext, profiles, binaries android.Paths,
+	profdata, htmlReport, lcovReport, jsonReport android.WritablePath) {
+
+	llvmProfdata := config.ClangPath(ctx, "bin/llvm-profdata")
+	llvmCov := config.ClangPath(ctx, "bin/llvm-cov")
+	htmlDir := android.PathForModuleOut(ctx, "html")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+
+	rule.Command().Tool(llvmProfdata).
+		Text("merge").
+		Flag("-sparse").
+		FlagWithOutput("-o ", profdata).
+		Inputs(profiles)
+
+	// llvm-cov takes the first binary as an argument and the rest with -object.
+	llvmCovArgs := func(cmd *android.RuleBuilderCommand) *android.RuleBuilderCommand {
+		cmd.FlagWithInput("-instr-profile=", profdata)
+		for _, regex := range r.properties.Ignore_filename_regex {
+			cmd.FlagWithArg("-ignore-filename-regex=", proptools.ShellEscape(regex))
+		}
+		cmd.Input(binaries[0])
+		for _, binary := range binaries[1:] {
+			cmd.FlagWithInput("-object ", binary)
+		}
+		return cmd
+	}
+
+	llvmCovArgs(rule.Command().Tool(llvmCov).Text("export").Flag("-format=lcov")).
+		FlagWithOutput("> ", lcovReport)
+
+	llvmCovArgs(rule.Command().Tool(llvmCov).Text("export").Flag("-format=text").Flag("-summary-only")).
+		FlagWithOutput("> ", jsonReport)
+
+	rule.Command().Text("rm -rf").Text(htmlDir.String())
+	llvmCovArgs(rule.Command().Tool(llvmCov).Text("show").Flag("-format=html").
+		FlagWithArg("-output-dir=", htmlDir.String()))
+
+	// The HTML report contains the time it was created, remove it to make the output deterministic.
+	rule.Command().Text("find").Text(htmlDir.String()).Text("-name '*.html'").
+		Text(`-exec sed -i -e 's|<h4>Created: [^<]*</h4>||' {} +`)
+
+	rule.Command().BuiltTool("soong_zip").
+		FlagWithOutput("-o ", htmlReport).
+		FlagWithArg("-C ", htmlDir.String()).
+		FlagWithArg("-D ", htmlDir.String())
+
+	rule.Command().Text("rm -rf").Text(htmlDir.String())
+
+	rule.Build("native_coverage_report", "coverage report")
+}
+
+func (r *CoverageReport) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{r.htmlReport, r.lcovReport, r.jsonReport}, nil
+	case ".html":
+		return android.Paths{r.htmlReport}, nil
+	case ".lcov":
+		return android.Paths{r.lcovReport}, nil
+	case ".json":
+		return android.Paths{r.jsonReport}, nil
+	case ".profdata":
+		return android.Paths{r.profdata}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+var _ android.OutputFileProducer = (*CoverageReport)(nil)
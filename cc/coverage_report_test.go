@@ -0,0 +1,117 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+
+	"github.com/google/blueprint/proptools"
+)
+
+var prepareForClangCoverage = android.FixtureModifyProductVariables(
+	func(variables android.FixtureProductVariables) {
+		variables.ClangCoverage = proptools.BoolPtr(true)
+		variables.Native_coverage = proptools.BoolPtr(true)
+		variables.NativeCoveragePaths = []string{"*"}
+	},
+)
+
+const coverageReportBp = `
+	cc_binary {
+		name: "foo",
+		srcs: ["foo.cpp"],
+	}
+
+	cc_test {
+		name: "foo_test",
+		srcs: ["foo_test.cpp"],
+		gtest: false,
+		compile_multilib: "first",
+	}
+
+	native_coverage_report {
+		name: "foo_coverage",
+		binaries: ["foo_test", "foo"],
+		profiles: ["coverage/foo.profraw", "coverage/foo_test.profdata"],
+		ignore_filename_regex: ["external/.*"],
+	}
+`
+
+func TestNativeCoverageReport(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForClangCoverage,
+		android.FixtureAddFile("coverage/foo.profraw", nil),
+		android.FixtureAddFile("coverage/foo_test.profdata", nil),
+	).RunTestWithBp(t, coverageReportBp)
+
+	report := result.ModuleForTests("foo_coverage", "android_arm64_armv8-a_cov")
+	rule := report.Output("report-html.zip")
+	command := android.StringRelativeToTop(result.Config, rule.RuleParams.Command)
+
+	// The unstripped outputs of the coverage variants are passed to llvm-cov.
+	fooTest := result.ModuleForTests("foo_test", "android_arm64_armv8-a_cov").Module().(*Module)
+	foo := result.ModuleForTests("foo", "android_arm64_armv8-a_cov").Module().(*Module)
+	android.AssertStringDoesContain(t, "binaries", command,
+		"-ignore-filename-regex='external/.*' "+android.PathRelativeToTop(fooTest.UnstrippedOutputFile())+
+			" -object "+android.PathRelativeToTop(foo.UnstrippedOutputFile()))
+	android.AssertStringDoesContain(t, "profiles", command,
+		"merge -sparse -o out/soong/.intermediates/foo_coverage/android_arm64_armv8-a_cov/coverage.profdata coverage/foo.profraw coverage/foo_test.profdata")
+	android.AssertStringDoesContain(t, "lcov", command,
+		"export -format=lcov")
+	android.AssertStringDoesContain(t, "json", command,
+		"export -format=text -summary-only")
+	android.AssertStringDoesContain(t, "html", command,
+		"show -format=html")
+
+	android.AssertStringPathsRelativeToTopEquals(t, "outputs", result.Config, []string{
+		"out/soong/.intermediates/foo_coverage/android_arm64_armv8-a_cov/coverage.profdata",
+		"out/soong/.intermediates/foo_coverage/android_arm64_armv8-a_cov/report-html.zip",
+		"out/soong/.intermediates/foo_coverage/android_arm64_armv8-a_cov/report.lcov",
+		"out/soong/.intermediates/foo_coverage/android_arm64_armv8-a_cov/summary.json",
+	}, android.SortedUniqueStrings(rule.AllOutputs()))
+}
+
+func TestNativeCoverageReportWithoutCoverage(t *testing.T) {
+	t.Parallel()
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddFile("coverage/foo.profraw", nil),
+		android.FixtureAddFile("coverage/foo_test.profdata", nil),
+	).RunTestWithBp(t, coverageReportBp)
+
+	report := result.ModuleForTests("foo_coverage", "android_arm64_armv8-a").Output("report-html.zip")
+	if report.Rule != android.ErrorRule {
+		t.Errorf("expected an error rule without clang coverage, got %q", report.Rule)
+	}
+}
+
+func TestNativeCoverageReportErrors(t *testing.T) {
+	t.Parallel()
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForClangCoverage,
+	).ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+		`binaries: at least one binary is required`,
+		`profiles: at least one profile is required`,
+	})).RunTestWithBp(t, `
+		native_coverage_report {
+			name: "foo_coverage",
+		}
+	`)
+}
@@ -409,6 +409,8 @@ type libraryDecorator struct {
 
 	// Source Abi Diff
 	sAbiDiff android.Paths
+	// Source Abi Diffs for the ABI diff report
+	sAbiDiffReportEntries []abiDiffReportEntry
 
 	// Location of the static library in the sysroot. Empty if the library is
 	// not included in the NDK.
@@ -1337,7 +1339,7 @@ func currRefAbiDumpSdkVersion(ctx ModuleContext) string {
 func (library *libraryDecorator) sourceAbiDiff(ctx android.ModuleContext,
 	sourceDump, referenceDump android.Path,
 	baseName, nameExt string, isLlndk, allowExtensions bool,
-	sourceVersion, errorMessage string) {
+	sourceVersion, errorMessage, lsdumpTagName, reportKind string) {
 
 	extraFlags := []string{"-target-version", sourceVersion}
 	headerAbiChecker := library.getHeaderAbiCheckerProperties(ctx)
@@ -1356,20 +1358,27 @@ func (library *libraryDecorator) sourceAbiDiff(ctx android.ModuleContext,
 	}
 	extraFlags = append(extraFlags, headerAbiChecker.Diff_flags...)
 
-	library.sAbiDiff = append(
-		library.sAbiDiff,
-		transformAbiDumpToAbiDiff(ctx, sourceDump, referenceDump,
-			baseName, nameExt, extraFlags, errorMessage))
+	diff, reportDiff := transformAbiDumpToAbiDiff(ctx, sourceDump, referenceDump,
+		baseName, nameExt, extraFlags, errorMessage)
+	library.sAbiDiff = append(library.sAbiDiff, diff)
+	library.sAbiDiffReportEntries = append(library.sAbiDiffReportEntries, abiDiffReportEntry{
+		tag:           lsdumpTag(lsdumpTagName),
+		kind:          reportKind,
+		libName:       strings.TrimSuffix(baseName, filepath.Ext(baseName)),
+		diff:          reportDiff,
+		sourceDump:    sourceDump,
+		referenceDump: referenceDump,
+	})
 }
 
 func (library *libraryDecorator) crossVersionAbiDiff(ctx android.ModuleContext,
 	sourceDump, referenceDump android.Path,
-	baseName, nameExt string, isLlndk bool, sourceVersion, prevDumpDir string) {
+	baseName, nameExt string, isLlndk bool, sourceVersion, prevDumpDir, lsdumpTagName string) {
 
 	errorMessage := "error: Please follow https://android.googlesource.com/platform/development/+/main/vndk/tools/header-checker/README.md#configure-cross_version-abi-check to resolve the difference between your source code and the ABI dumps in " + prevDumpDir
 
 	library.sourceAbiDiff(ctx, sourceDump, referenceDump, baseName, nameExt,
-		isLlndk, true /* allowExtensions */, sourceVersion, errorMessage)
+		isLlndk, true /* allowExtensions */, sourceVersion, errorMessage, lsdumpTagName, abiDiffReportPrevious)
 }
 
 func (library *libraryDecorator) sameVersionAbiDiff(ctx android.ModuleContext,
@@ -1385,7 +1394,7 @@ func (library *libraryDecorator) sameVersionAbiDiff(ctx android.ModuleContext,
 	}
 
 	library.sourceAbiDiff(ctx, sourceDump, referenceDump, baseName, nameExt,
-		isLlndk, false /* allowExtensions */, "current", errorMessage)
+		isLlndk, false /* allowExtensions */, "current", errorMessage, lsdumpTagName, abiDiffReportCurrent)
 }
 
 func (library *libraryDecorator) optInAbiDiff(ctx android.ModuleContext,
@@ -1406,7 +1415,7 @@ func (library *libraryDecorator) optInAbiDiff(ctx android.ModuleContext,
 	}
 
 	library.sourceAbiDiff(ctx, sourceDump, referenceDump, baseName, nameExt,
-		false /* isLlndk */, false /* allowExtensions */, "current", errorMessage)
+		false /* isLlndk */, false /* allowExtensions */, "current", errorMessage, lsdumpTagName, abiDiffReportOptIn)
 }
 
 func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, deps PathDeps, objs Objects, fileName string, soFile android.Path) {
@@ -1496,7 +1505,7 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, deps PathD
 			prevDumpFile := getRefAbiDumpFile(ctx, prevDumpDir, fileName)
 			if prevDumpFile.Valid() {
 				library.crossVersionAbiDiff(ctx, sourceDump, prevDumpFile.Path(),
-					fileName, nameExt+prevVersion, isLlndk, currVersion, prevDumpDir)
+					fileName, nameExt+prevVersion, isLlndk, currVersion, prevDumpDir, string(tag))
 			}
 			// Check against the current version.
 			sourceDump = implDump
@@ -0,0 +1,653 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// abi_diff_report aggregates the .abidiff files written by header-abi-diff for the libraries in a
+// build into a single JSON and HTML report, grouped by lsdump tag and severity, and updates the
+// reference ABI dumps of chosen libraries from the dumps of the last build.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	severityIncompatible = "incompatible"
+	severityExtension    = "extension"
+	severityCompatible   = "compatible"
+
+	// kindPrevious is the kind of the diffs against the reference dumps of the previous version,
+	// which are frozen and are never updated.
+	kindPrevious = "previous"
+)
+
+var severityOrder = map[string]int{
+	severityIncompatible: 0,
+	severityExtension:    1,
+	severityCompatible:   2,
+}
+
+var tagOrder = map[string]int{
+	"LLNDK":    0,
+	"APEX":     1,
+	"PLATFORM": 2,
+	"PRODUCT":  3,
+	"VENDOR":   4,
+}
+
+// listEntry is a line of the list of diffs written by Soong:
+//
+//	<tag> <kind> <library> <abidiff file> <source dump> <reference dump>
+type listEntry struct {
+	tag           string
+	kind          string
+	library       string
+	diffFile      string
+	sourceDump    string
+	referenceDump string
+}
+
+func readList(r io.Reader) ([]listEntry, error) {
+	var entries []listEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: expected 6 fields, got %d", line, len(fields))
+		}
+		entries = append(entries, listEntry{
+			tag:           fields[0],
+			kind:          fields[1],
+			library:       fields[2],
+			diffFile:      fields[3],
+			sourceDump:    fields[4],
+			referenceDump: fields[5],
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// textNode is a field of a message in the protobuf text format.  Messages have children, scalars
+// have a value.
+type textNode struct {
+	name     string
+	value    string
+	children []*textNode
+}
+
+type textParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *textParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' || c == ';':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '-' || c == '+' || c == '.' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *textParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.data) && isIdentChar(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *textParser) quoted() (string, error) {
+	quote := p.data[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return sb.String(), nil
+		case '\\':
+			if p.pos >= len(p.data) {
+				return "", fmt.Errorf("unterminated string")
+			}
+			switch e := p.data[p.pos]; e {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(e)
+			}
+			p.pos++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+// fields parses fields until the end of the input or the closing bracket of a message.
+func (p *textParser) fields(closing byte) ([]*textNode, error) {
+	// Messages have a non-nil list of fields, even when they are empty.
+	nodes := []*textNode{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			if closing != 0 {
+				return nil, fmt.Errorf("unexpected end of input, expected %q", closing)
+			}
+			return nodes, nil
+		}
+		if closing != 0 && p.data[p.pos] == closing {
+			p.pos++
+			return nodes, nil
+		}
+
+		node := &textNode{name: p.ident()}
+		if node.name == "" {
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.data[p.pos], p.pos)
+		}
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ':' {
+			p.pos++
+			p.skipSpace()
+		}
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("missing value for %q", node.name)
+		}
+		switch c := p.data[p.pos]; c {
+		case '{', '<':
+			p.pos++
+			end := byte('}')
+			if c == '<' {
+				end = '>'
+			}
+			children, err := p.fields(end)
+			if err != nil {
+				return nil, err
+			}
+			node.children = children
+		case '"', '\'':
+			value, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			node.value = value
+		default:
+			node.value = p.ident()
+			if node.value == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+func parseText(data []byte) ([]*textNode, error) {
+	p := &textParser{data: data}
+	return p.fields(0)
+}
+
+// find returns the value of the first field with the given name, searching the direct fields of
+// the node before the nested messages.
+func (n *textNode) find(name string) string {
+	for _, child := range n.children {
+		if child.name == name && child.children == nil {
+			return child.value
+		}
+	}
+	for _, child := range n.children {
+		if value := child.find(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Change is a difference between the source dump and the reference dump of a library.
+type Change struct {
+	// The field of the header-abi-diff output, for example removed_functions or record_type_diffs.
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	// The header that declares the changed type, function or variable, relative to the root of
+	// the source tree.
+	Header string `json:"header,omitempty"`
+}
+
+// LibraryDiff is the result of comparing the source dump of a library to a reference dump.
+type LibraryDiff struct {
+	Library  string `json:"library"`
+	Arch     string `json:"arch"`
+	Severity string `json:"severity"`
+	// current for the reference dumps of the current version, previous for the reference dumps of
+	// the previous version, or opt-in for the reference dumps in ref_dump_dirs.
+	Kind          string   `json:"kind"`
+	DiffFile      string   `json:"diff_file"`
+	SourceDump    string   `json:"source_dump"`
+	ReferenceDump string   `json:"reference_dump"`
+	Changes       []Change `json:"changes"`
+
+	tag string
+}
+
+type Group struct {
+	Tag      string         `json:"tag"`
+	Severity string         `json:"severity"`
+	Diffs    []*LibraryDiff `json:"diffs"`
+}
+
+// ReferenceDump is a reference dump that `abi_diff_report update` can regenerate.
+type ReferenceDump struct {
+	Library       string `json:"library"`
+	Tag           string `json:"tag"`
+	SourceDump    string `json:"source_dump"`
+	ReferenceDump string `json:"reference_dump"`
+}
+
+type Report struct {
+	// The number of diffs that were aggregated, including the compatible diffs without changes,
+	// which are not listed in the groups.
+	DiffCount      int             `json:"diff_count"`
+	Groups         []*Group        `json:"groups"`
+	ReferenceDumps []ReferenceDump `json:"reference_dumps"`
+
+	headerURLPrefix string
+}
+
+func librarySeverity(status string) string {
+	switch {
+	case strings.Contains(status, "INCOMPATIBLE"):
+		return severityIncompatible
+	case status == "EXTENSION":
+		return severityExtension
+	default:
+		return severityCompatible
+	}
+}
+
+// changeSeverity classifies a change by its kind.  Additions are extensions and the rest are
+// incompatible, but a change is never more severe than the library, as header-abi-diff allows
+// some changes depending on its flags.
+func changeSeverity(kind, library string) string {
+	severity := severityIncompatible
+	if strings.HasPrefix(kind, "added_") {
+		severity = severityExtension
+	}
+	if severityOrder[severity] < severityOrder[library] {
+		return library
+	}
+	return severity
+}
+
+func readDiff(entry listEntry) (*LibraryDiff, error) {
+	data, err := os.ReadFile(entry.diffFile)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseText(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", entry.diffFile, err)
+	}
+
+	root := &textNode{children: nodes}
+	diff := &LibraryDiff{
+		Library:       entry.library,
+		Arch:          root.find("arch"),
+		Severity:      librarySeverity(root.find("compatibility_status")),
+		Kind:          entry.kind,
+		DiffFile:      entry.diffFile,
+		SourceDump:    entry.sourceDump,
+		ReferenceDump: entry.referenceDump,
+		Changes:       []Change{},
+		tag:           entry.tag,
+	}
+	for _, node := range nodes {
+		if node.children == nil {
+			continue
+		}
+		name := node.find("name")
+		if name == "" {
+			name = node.find("linker_set_key")
+		}
+		diff.Changes = append(diff.Changes, Change{
+			Kind:     node.name,
+			Name:     name,
+			Severity: changeSeverity(node.name, diff.Severity),
+			Header:   node.find("source_file"),
+		})
+	}
+	return diff, nil
+}
+
+func buildReport(entries []listEntry) (*Report, error) {
+	report := &Report{
+		DiffCount:      len(entries),
+		Groups:         []*Group{},
+		ReferenceDumps: []ReferenceDump{},
+	}
+	groups := make(map[[2]string]*Group)
+	for _, entry := range entries {
+		if entry.kind != kindPrevious {
+			report.ReferenceDumps = append(report.ReferenceDumps, ReferenceDump{
+				Library:       entry.library,
+				Tag:           entry.tag,
+				SourceDump:    entry.sourceDump,
+				ReferenceDump: entry.referenceDump,
+			})
+		}
+
+		diff, err := readDiff(entry)
+		if err != nil {
+			return nil, err
+		}
+		if diff.Severity == severityCompatible && len(diff.Changes) == 0 {
+			continue
+		}
+		key := [2]string{diff.tag, diff.Severity}
+		group := groups[key]
+		if group == nil {
+			group = &Group{Tag: diff.tag, Severity: diff.Severity}
+			groups[key] = group
+			report.Groups = append(report.Groups, group)
+		}
+		group.Diffs = append(group.Diffs, diff)
+	}
+
+	rank := func(order map[string]int, s string) int {
+		if r, ok := order[s]; ok {
+			return r
+		}
+		return len(order)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if ra, rb := rank(tagOrder, a.Tag), rank(tagOrder, b.Tag); ra != rb {
+			return ra < rb
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return rank(severityOrder, a.Severity) < rank(severityOrder, b.Severity)
+	})
+	for _, group := range report.Groups {
+		sort.SliceStable(group.Diffs, func(i, j int) bool {
+			a, b := group.Diffs[i], group.Diffs[j]
+			if a.Library != b.Library {
+				return a.Library < b.Library
+			}
+			if a.Arch != b.Arch {
+				return a.Arch < b.Arch
+			}
+			return a.Kind < b.Kind
+		})
+	}
+	return report, nil
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ABI diff report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+.incompatible { color: #b00020; }
+.extension { color: #b06000; }
+.compatible { color: #006000; }
+</style>
+</head>
+<body>
+<h1>ABI diff report</h1>
+<p>{{.DiffCount}} ABI diffs checked.</p>
+{{- if not .Groups}}
+<p>No ABI changes.</p>
+{{- else}}
+<table>
+<tr><th>Tag</th><th>Severity</th><th>Libraries</th></tr>
+{{- range .Groups}}
+<tr><td>{{.Tag}}</td><td class="{{.Severity}}"><a href="#{{.Tag | lower}}-{{.Severity}}">{{.Severity}}</a></td><td>{{len .Diffs}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- $prefix := .HeaderURLPrefix}}
+{{- range .Groups}}
+<h2 id="{{.Tag | lower}}-{{.Severity}}">{{.Tag}}: <span class="{{.Severity}}">{{.Severity}}</span></h2>
+{{- range .Diffs}}
+<h3>{{.Library}} ({{.Arch}}, {{.Kind}})</h3>
+<p>Reference dump: <a href="{{$prefix}}{{.ReferenceDump}}">{{.ReferenceDump}}</a>,
+diff: <a href="{{$prefix}}{{.DiffFile}}">{{.DiffFile}}</a></p>
+<table>
+<tr><th>Change</th><th>Name</th><th>Severity</th><th>Header</th></tr>
+{{- range .Changes}}
+<tr><td>{{.Kind}}</td><td><code>{{.Name}}</code></td><td class="{{.Severity}}">{{.Severity}}</td><td>
+{{- if .Header}}<a href="{{$prefix}}{{.Header}}">{{.Header}}</a>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// HeaderURLPrefix is prepended to the paths of the headers and dumps, which are relative to the
+// root of the source tree, to link to them from the HTML report.
+func (r *Report) HeaderURLPrefix() string {
+	return r.headerURLPrefix
+}
+
+// relativeURLPrefix returns the prefix of the links from the HTML report to the paths relative
+// to the current directory, which is the root of the source tree when run by Soong.
+func relativeURLPrefix(htmlFile string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	dir, err := filepath.Abs(filepath.Dir(htmlFile))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, wd)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel) + "/", nil
+}
+
+func writeHTML(w io.Writer, report *Report) error {
+	return htmlTemplate.Execute(w, report)
+}
+
+func writeJSON(w io.Writer, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func writeFile(file string, write func(io.Writer, *Report) error, report *Report) error {
+	buf := &bytes.Buffer{}
+	if err := write(buf, report); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0666)
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0666)
+}
+
+// updateReferenceDumps copies the source dumps of the libraries over their reference dumps.  The
+// relative paths in the report are relative to top; the source dumps are absolute when OUT_DIR is.
+func updateReferenceDumps(top string, report *Report, libraries []string) error {
+	for _, library := range libraries {
+		library = strings.TrimSuffix(library, ".so")
+		found := false
+		for _, dump := range report.ReferenceDumps {
+			if dump.Library != library {
+				continue
+			}
+			found = true
+			if err := copyFile(pathFromTop(top, dump.SourceDump), pathFromTop(top, dump.ReferenceDump)); err != nil {
+				return err
+			}
+			fmt.Printf("Updated %s (%s)\n", dump.ReferenceDump, dump.Tag)
+		}
+		if !found {
+			return fmt.Errorf("no reference dumps for %s in the report", library)
+		}
+	}
+	return nil
+}
+
+func pathFromTop(top, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(top, path)
+}
+
+func buildTop() string {
+	if top := os.Getenv("ANDROID_BUILD_TOP"); top != "" {
+		return top
+	}
+	return "."
+}
+
+func defaultReportFile(top string) string {
+	outDir := os.Getenv("OUT_DIR")
+	if outDir == "" {
+		outDir = "out"
+	}
+	if !filepath.IsAbs(outDir) {
+		outDir = filepath.Join(top, outDir)
+	}
+	return filepath.Join(outDir, "soong", "abi-diff-report", "report.json")
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: abi_diff_report report -l list -o report.json -html report.html [-header-url-prefix prefix]")
+	fmt.Fprintln(os.Stderr, "       abi_diff_report update [-report report.json] library [library...]")
+	fmt.Fprintln(os.Stderr, "report aggregates the .abidiff files in the list written by Soong.")
+	fmt.Fprintln(os.Stderr, "update copies the source dumps of the libraries from the last `m abi-diff-report`")
+	fmt.Fprintln(os.Stderr, "over their reference dumps of the current version and in ref_dump_dirs.")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("abi_diff_report "+os.Args[1], flag.ExitOnError)
+	flags.Usage = usage
+
+	var err error
+	switch os.Args[1] {
+	case "report":
+		list := flags.String("l", "", "file listing the diffs to report")
+		out := flags.String("o", "", "output JSON report")
+		htmlOut := flags.String("html", "", "output HTML report")
+		headerURLPrefix := flags.String("header-url-prefix", "",
+			"prefix of the links to the headers, defaults to the current directory relative to the HTML report")
+		flags.Parse(os.Args[2:])
+		if *list == "" || *out == "" || *htmlOut == "" || flags.NArg() != 0 {
+			usage()
+		}
+		err = func() error {
+			f, err := os.Open(*list)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			entries, err := readList(f)
+			if err != nil {
+				return fmt.Errorf("%s: %w", *list, err)
+			}
+			report, err := buildReport(entries)
+			if err != nil {
+				return err
+			}
+			report.headerURLPrefix = *headerURLPrefix
+			if report.headerURLPrefix == "" {
+				report.headerURLPrefix, err = relativeURLPrefix(*htmlOut)
+				if err != nil {
+					return err
+				}
+			}
+			if err := writeFile(*out, writeJSON, report); err != nil {
+				return err
+			}
+			return writeFile(*htmlOut, writeHTML, report)
+		}()
+	case "update":
+		top := buildTop()
+		reportFile := flags.String("report", defaultReportFile(top), "JSON report written by `m abi-diff-report`")
+		flags.Parse(os.Args[2:])
+		if flags.NArg() == 0 {
+			usage()
+		}
+		err = func() error {
+			data, err := os.ReadFile(*reportFile)
+			if err != nil {
+				return fmt.Errorf("%w, run `m abi-diff-report` first", err)
+			}
+			var report Report
+			if err := json.Unmarshal(data, &report); err != nil {
+				return fmt.Errorf("failed to parse %s: %w", *reportFile, err)
+			}
+			return updateReferenceDumps(top, &report, flags.Args())
+		}()
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
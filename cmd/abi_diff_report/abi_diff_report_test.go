@@ -0,0 +1,254 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIncompatibleDiff = `lib_name: "libfoo"
+arch: "arm64"
+record_type_diffs {
+  name: "Foo"
+  type_stack: "Foo"
+  type_info_diff {
+    old_type_info {
+      size: 4
+      alignment: 4
+    }
+    new_type_info {
+      size: 8
+      alignment: 4
+    }
+  }
+  fields_diff {
+    old_field {
+      referenced_type: "int"
+      field_offset: 0
+      field_name: "a"
+      access: public_access
+    }
+  }
+  source_file: "external/foo/include/foo.h"
+}
+compatibility_status: INCOMPATIBLE
+removed_functions {
+  return_type: "void"
+  function_name: "foo_close"
+  source_file: "external/foo/include/foo.h"
+  linker_set_key: "_Z9foo_closev"
+  access: public_access
+}
+added_functions {
+  function_name: "foo_open2"
+  source_file: "external/foo/include/foo.h"
+  linker_set_key: "_Z9foo_open2v"
+  access: public_access
+}
+`
+
+const testExtensionDiff = `# An extension.
+lib_name: "libbar"
+arch: "arm64"
+compatibility_status: EXTENSION
+added_elf_functions {
+  name: "bar_new"
+  binding: Global
+}
+`
+
+const testCompatibleDiff = `lib_name: "libbaz"
+arch: "arm64"
+`
+
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func testReport(t *testing.T) *Report {
+	t.Helper()
+	dir := t.TempDir()
+	foo := writeTestFile(t, dir, "libfoo.so.abidiff", testIncompatibleDiff)
+	bar := writeTestFile(t, dir, "libbar.so.abidiff", testExtensionDiff)
+	baz := writeTestFile(t, dir, "libbaz.so.abidiff", testCompatibleDiff)
+
+	entries, err := readList(strings.NewReader(strings.Join([]string{
+		"PLATFORM opt-in libbaz " + baz + " baz.lsdump ref/baz.lsdump",
+		"APEX previous libbar " + bar + " bar.lsdump prebuilts/34/bar.lsdump",
+		"",
+		"LLNDK current libfoo " + foo + " foo.lsdump prebuilts/current/foo.lsdump",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := buildReport(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestBuildReport(t *testing.T) {
+	report := testReport(t)
+
+	if report.DiffCount != 3 {
+		t.Errorf("expected 3 diffs, got %d", report.DiffCount)
+	}
+
+	var groups []string
+	for _, group := range report.Groups {
+		for _, diff := range group.Diffs {
+			groups = append(groups, group.Tag+" "+group.Severity+" "+diff.Library)
+		}
+	}
+	// The compatible diff without changes is left out, and the groups are ordered by tag.
+	if w := []string{"LLNDK incompatible libfoo", "APEX extension libbar"}; !reflect.DeepEqual(groups, w) {
+		t.Errorf("expected groups %q, got %q", w, groups)
+	}
+
+	foo := report.Groups[0].Diffs[0]
+	if foo.Arch != "arm64" || foo.Kind != "current" || foo.ReferenceDump != "prebuilts/current/foo.lsdump" {
+		t.Errorf("unexpected diff %#v", foo)
+	}
+	wantChanges := []Change{
+		{Kind: "record_type_diffs", Name: "Foo", Severity: severityIncompatible, Header: "external/foo/include/foo.h"},
+		{Kind: "removed_functions", Name: "_Z9foo_closev", Severity: severityIncompatible, Header: "external/foo/include/foo.h"},
+		{Kind: "added_functions", Name: "_Z9foo_open2v", Severity: severityExtension, Header: "external/foo/include/foo.h"},
+	}
+	if !reflect.DeepEqual(foo.Changes, wantChanges) {
+		t.Errorf("expected changes %#v, got %#v", wantChanges, foo.Changes)
+	}
+
+	bar := report.Groups[1].Diffs[0]
+	if w := []Change{{Kind: "added_elf_functions", Name: "bar_new", Severity: severityExtension}}; !reflect.DeepEqual(bar.Changes, w) {
+		t.Errorf("expected changes %#v, got %#v", w, bar.Changes)
+	}
+
+	// The reference dumps of the previous version are frozen.
+	wantDumps := []ReferenceDump{
+		{Library: "libbaz", Tag: "PLATFORM", SourceDump: "baz.lsdump", ReferenceDump: "ref/baz.lsdump"},
+		{Library: "libfoo", Tag: "LLNDK", SourceDump: "foo.lsdump", ReferenceDump: "prebuilts/current/foo.lsdump"},
+	}
+	if !reflect.DeepEqual(report.ReferenceDumps, wantDumps) {
+		t.Errorf("expected reference dumps %#v, got %#v", wantDumps, report.ReferenceDumps)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := testReport(t)
+	report.headerURLPrefix = "https://cs.example.com/"
+
+	buf := &bytes.Buffer{}
+	if err := writeJSON(buf, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Groups) != 2 || decoded.Groups[0].Diffs[0].Changes[0].Header != "external/foo/include/foo.h" {
+		t.Errorf("unexpected JSON report %s", buf)
+	}
+
+	buf.Reset()
+	if err := writeHTML(buf, report); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<h2 id="llndk-incompatible">LLNDK: <span class="incompatible">incompatible</span></h2>`,
+		`<a href="https://cs.example.com/external/foo/include/foo.h">external/foo/include/foo.h</a>`,
+		`<code>_Z9foo_closev</code>`,
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected %q in the HTML report:\n%s", s, buf)
+		}
+	}
+}
+
+func TestParseTextErrors(t *testing.T) {
+	for _, data := range []string{
+		`record_type_diffs {`,
+		`lib_name: "libfoo`,
+		`lib_name:`,
+		`}`,
+	} {
+		if _, err := parseText([]byte(data)); err == nil {
+			t.Errorf("expected an error parsing %q", data)
+		}
+	}
+}
+
+func TestUpdateReferenceDumps(t *testing.T) {
+	top := t.TempDir()
+	writeTestFile(t, top, "out/foo.lsdump", "new")
+	writeTestFile(t, top, "prebuilts/foo.lsdump", "old")
+	writeTestFile(t, top, "prebuilts/bar.lsdump", "old")
+
+	report := &Report{ReferenceDumps: []ReferenceDump{
+		{Library: "libfoo", Tag: "LLNDK", SourceDump: "out/foo.lsdump", ReferenceDump: "prebuilts/foo.lsdump"},
+		{Library: "libbar", Tag: "APEX", SourceDump: "out/bar.lsdump", ReferenceDump: "prebuilts/bar.lsdump"},
+	}}
+	if err := updateReferenceDumps(top, report, []string{"libfoo.so"}); err != nil {
+		t.Fatal(err)
+	}
+	for file, w := range map[string]string{"prebuilts/foo.lsdump": "new", "prebuilts/bar.lsdump": "old"} {
+		if data, err := os.ReadFile(filepath.Join(top, file)); err != nil {
+			t.Error(err)
+		} else if string(data) != w {
+			t.Errorf("expected %s to contain %q, got %q", file, w, data)
+		}
+	}
+
+	// The source dumps are absolute when OUT_DIR is outside the tree.
+	outDir := t.TempDir()
+	writeTestFile(t, outDir, "bar.lsdump", "new")
+	report.ReferenceDumps[1].SourceDump = filepath.Join(outDir, "bar.lsdump")
+	if err := updateReferenceDumps(top, report, []string{"libbar"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(top, "prebuilts/bar.lsdump")); err != nil {
+		t.Error(err)
+	} else if string(data) != "new" {
+		t.Errorf("expected prebuilts/bar.lsdump to contain %q, got %q", "new", data)
+	}
+
+	if err := updateReferenceDumps(top, report, []string{"libqux"}); err == nil {
+		t.Error("expected an error for a library that is not in the report")
+	}
+}
+
+func TestRelativeURLPrefix(t *testing.T) {
+	prefix, err := relativeURLPrefix("out/soong/abi-diff-report/report.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefix != "../../../" {
+		t.Errorf("expected ../../../, got %q", prefix)
+	}
+}
@@ -30,6 +30,12 @@ type allowList struct {
 	ignoreMatchingLines []string
 }
 
+// jsonAllowList is the format of the entries in allowlist files.
+type jsonAllowList struct {
+	Paths               []string
+	IgnoreMatchingLines []string `json:",omitempty"`
+}
+
 func parseAllowLists(allowLists []string, allowListFiles []string) ([]allowList, error) {
 	var ret []allowList
 
@@ -79,10 +85,7 @@ func parseAllowListFile(file string) ([]allowList, error) {
 
 	d := json.NewDecoder(newJSONCommentStripper(r))
 
-	var jsonAllowLists []struct {
-		Paths               []string
-		IgnoreMatchingLines []string
-	}
+	var jsonAllowLists []jsonAllowList
 
 	if err := d.Decode(&jsonAllowLists); err != nil {
 		return nil, err
@@ -126,6 +129,8 @@ outer:
 	return l, nil
 }
 
+// filterNewPaths removes the files that match any of the allow lists without ignoreMatchingLines,
+// which only apply to files that are in both zips.
 func filterNewPaths(l []*ZipArtifactFile, allowLists []allowList) ([]*ZipArtifactFile, error) {
 outer:
 	for i := 0; i < len(l); i++ {
@@ -135,8 +140,8 @@ outer:
 			} else if match && len(w.ignoreMatchingLines) == 0 {
 				l = append(l[:i], l[i+1:]...)
 				i--
+				continue outer
 			}
-			continue outer
 		}
 	}
 
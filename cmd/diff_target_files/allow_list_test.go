@@ -40,7 +40,7 @@ func bytesToZipArtifactFile(name string, data []byte) *ZipArtifactFile {
 		panic(err)
 	}
 
-	return &ZipArtifactFile{r.File[0]}
+	return &ZipArtifactFile{File: r.File[0]}
 }
 
 var f1a = bytesToZipArtifactFile("dir/f1", []byte(`
@@ -90,6 +90,30 @@ func Test_applyAllowLists(t *testing.T) {
 			},
 			want: zipDiff{},
 		},
+		{
+			name: "second allow list",
+			args: args{
+				diff: zipDiff{
+					onlyInA: []*ZipArtifactFile{f1a, f2},
+				},
+				allowLists: []allowList{{path: "other/*"}, {path: "dir/f2"}},
+			},
+			want: zipDiff{
+				onlyInA: []*ZipArtifactFile{f1a},
+			},
+		},
+		{
+			name: "new path with matching lines",
+			args: args{
+				diff: zipDiff{
+					onlyInB: []*ZipArtifactFile{f2},
+				},
+				allowLists: []allowList{{path: "dir/*", ignoreMatchingLines: []string{"foo: .*"}}},
+			},
+			want: zipDiff{
+				onlyInB: []*ZipArtifactFile{f2},
+			},
+		},
 		{
 			name: "modified",
 			args: args{
@@ -20,32 +20,55 @@ import (
 )
 
 // compareTargetFiles takes two ZipArtifacts and compares the files they contain by examining
-// the path, size, and CRC of each file.
-func compareTargetFiles(priZip, refZip ZipArtifact, artifact string, allowLists []allowList, filters []string) (zipDiff, error) {
+// the path, size, and CRC of each file.  It returns the differences that are not allowlisted, and
+// all the differences before the allowlists were applied.  If nestedZips is true modified APKs,
+// APEXes and other zip files are replaced by the differences between the files they contain,
+// ignoring their signatures.  If compareELF is true modified ELF files are also compared
+// semantically, files that only differ in their build ID and debug sections are not considered
+// different, and the semantic differences of the other ELF files are returned.
+func compareTargetFiles(priZip, refZip ZipArtifact, artifact string, allowLists []allowList, filters []string,
+	nestedZips, compareELF bool) (diff, unfiltered zipDiff, elfDiffs elfDiffs, err error) {
+
 	priZipFiles, err := priZip.Files()
 	if err != nil {
-		return zipDiff{}, fmt.Errorf("error fetching target file lists from primary zip %v", err)
+		return zipDiff{}, zipDiff{}, nil, fmt.Errorf("error fetching target file lists from primary zip %v", err)
 	}
 
 	refZipFiles, err := refZip.Files()
 	if err != nil {
-		return zipDiff{}, fmt.Errorf("error fetching target file lists from reference zip %v", err)
+		return zipDiff{}, zipDiff{}, nil, fmt.Errorf("error fetching target file lists from reference zip %v", err)
 	}
 
 	priZipFiles, err = filterTargetZipFiles(priZipFiles, artifact, filters)
 	if err != nil {
-		return zipDiff{}, err
+		return zipDiff{}, zipDiff{}, nil, err
 	}
 
 	refZipFiles, err = filterTargetZipFiles(refZipFiles, artifact, filters)
 	if err != nil {
-		return zipDiff{}, err
+		return zipDiff{}, zipDiff{}, nil, err
 	}
 
 	// Compare the file lists from both builds
-	diff := diffTargetFilesLists(refZipFiles, priZipFiles)
+	unfiltered = diffTargetFilesLists(refZipFiles, priZipFiles)
+
+	if nestedZips {
+		unfiltered, err = compareNestedZips(unfiltered)
+		if err != nil {
+			return zipDiff{}, zipDiff{}, nil, err
+		}
+	}
+
+	if compareELF {
+		unfiltered.modified, elfDiffs, err = compareELFFiles(unfiltered.modified)
+		if err != nil {
+			return zipDiff{}, zipDiff{}, nil, err
+		}
+	}
 
-	return applyAllowLists(diff, allowLists)
+	// applyAllowLists modifies the lists in place
+	diff, err = applyAllowLists(unfiltered.copy(), allowLists)
+	return diff, unfiltered, elfDiffs, err
 }
 
 // zipDiff contains the list of files that differ between two zip files.
@@ -54,6 +77,15 @@ type zipDiff struct {
 	onlyInA, onlyInB []*ZipArtifactFile
 }
 
+// copy returns a zipDiff with copies of the lists in d.
+func (d zipDiff) copy() zipDiff {
+	return zipDiff{
+		modified: append([][2]*ZipArtifactFile(nil), d.modified...),
+		onlyInA:  append([]*ZipArtifactFile(nil), d.onlyInA...),
+		onlyInB:  append([]*ZipArtifactFile(nil), d.onlyInB...),
+	}
+}
+
 // String pretty-prints the list of files that differ between two zip files.
 func (d *zipDiff) String() string {
 	buf := &bytes.Buffer{}
@@ -17,6 +17,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -26,6 +27,13 @@ var (
 	allowListFiles = newMultiString("allowlist_file", "files containing allowlist definitions")
 
 	filters = newMultiString("filter", "filter patterns to apply to files in target-files.zip before comparing")
+
+	jsonReport         = flag.String("json_report", "", "write a JSON report of the differences to this file")
+	htmlReport         = flag.String("html_report", "", "write an HTML report of the differences to this file")
+	suggestedAllowList = flag.String("suggested_allowlist", "", "write an allowlist file that would ignore the remaining differences to this file")
+
+	nestedZips = flag.Bool("nested_zips", false, "compare the files inside modified APK, APEX, JAR and zip files, ignoring signatures; apex payload images are compared as a whole, ignoring their AVB footers, and the files inside them are not compared")
+	compareELF = flag.Bool("elf", false, "compare ELF files semantically, ignoring build IDs and debug sections")
 )
 
 func newMultiString(name, usage string) *multiString {
@@ -67,16 +75,54 @@ func main() {
 	}
 	defer refZip.Close()
 
-	diff, err := compareTargetFiles(priZip, refZip, targetFilesPattern, allowLists, *filters)
+	diff, unfiltered, elfDiffs, err := compareTargetFiles(priZip, refZip, targetFilesPattern, allowLists,
+		*filters, *nestedZips, *compareELF)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error comparing zip files: %v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Print(diff.String())
+	fmt.Print(elfDiffs.String(diff.modified))
+
+	if *jsonReport != "" || *htmlReport != "" || *suggestedAllowList != "" {
+		report, err := newDiffReport(flag.Arg(1), flag.Arg(0), diff, unfiltered, elfDiffs, allowLists)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating report: %v\n", err)
+			os.Exit(1)
+		}
+		for _, output := range []struct {
+			file  string
+			write func(io.Writer) error
+		}{
+			{*jsonReport, report.writeJSON},
+			{*htmlReport, report.writeHTML},
+			{*suggestedAllowList, report.writeSuggestedAllowList},
+		} {
+			if output.file == "" {
+				continue
+			}
+			if err := writeReportFile(output.file, output.write); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", output.file, err)
+				os.Exit(1)
+			}
+		}
+	}
 
 	if len(diff.modified) > 0 || len(diff.onlyInA) > 0 || len(diff.onlyInB) > 0 {
 		fmt.Fprintln(os.Stderr, "differences found")
 		os.Exit(1)
 	}
 }
+
+func writeReportFile(file string, write func(io.Writer) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
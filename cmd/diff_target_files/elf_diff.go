@@ -0,0 +1,359 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// elfDiffs contains the semantic differences between modified ELF files, indexed by the file in
+// the reference zip.
+type elfDiffs map[*ZipArtifactFile][]string
+
+// String pretty-prints the semantic differences of the modified files in the order of modified.
+func (d elfDiffs) String(modified [][2]*ZipArtifactFile) string {
+	buf := &strings.Builder{}
+	for _, f := range modified {
+		if diffs := d[f[0]]; len(diffs) > 0 {
+			fmt.Fprintf(buf, "ELF differences in %v:\n", f[0].Name)
+			for _, diff := range diffs {
+				fmt.Fprintf(buf, "   %v\n", diff)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// compareELFFiles compares the modified files that are ELF files in both zips semantically.  It
+// returns the modified files without the ELF files whose sections are identical except for the
+// build ID and debug sections, and the semantic differences of the other ELF files.
+func compareELFFiles(modified [][2]*ZipArtifactFile) ([][2]*ZipArtifactFile, elfDiffs, error) {
+	diffs := make(elfDiffs)
+	var ret [][2]*ZipArtifactFile
+	for _, f := range modified {
+		a, err := readELFSummary(f[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		b, err := readELFSummary(f[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		if a != nil && b != nil {
+			diff := diffELFSummaries(a, b)
+			if len(diff) == 0 {
+				continue
+			}
+			diffs[f[0]] = diff
+		}
+		ret = append(ret, f)
+	}
+	return ret, diffs, nil
+}
+
+// elfSummary contains the parts of an ELF file that are compared.
+type elfSummary struct {
+	soname string
+	needed []string
+
+	// symbols maps the versioned names of the dynamic symbols to a description of their type,
+	// binding and visibility.
+	symbols map[string]string
+
+	// sections maps the names of the sections to their sizes.
+	sections map[string]uint64
+
+	// contents maps the names of the sections to the hashes of their contents, which catch the
+	// changes to the code and data that don't change any of the other properties.
+	contents map[string][sha256.Size]byte
+
+	// relocations maps the names of the relocation sections to the number of relocations of
+	// each type.
+	relocations map[string]map[string]int
+}
+
+// ignoredELFSection returns true for the sections that don't affect the behavior of an ELF file
+// and are expected to differ between builds.
+func ignoredELFSection(name string) bool {
+	switch name {
+	case ".note.gnu.build-id", ".gnu_debuglink", ".gnu_debugdata":
+		return true
+	}
+	return strings.HasPrefix(name, ".debug") || strings.HasPrefix(name, ".zdebug")
+}
+
+// readELFSummary returns a summary of a file in a zip, or nil if it is not an ELF file.  Files that
+// start with the ELF magic but can't be parsed, like some firmware blobs, are also compared byte
+// for byte.
+func readELFSummary(zf *ZipArtifactFile) (*elfSummary, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != elf.ELFMAG {
+		return nil, nil
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := parseELFSummary(append(magic, rest...))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: comparing %s byte for byte: %v\n", zf.Name, err)
+		return nil, nil
+	}
+	return s, nil
+}
+
+// parseELFSummary returns a summary of the contents of an ELF file.
+func parseELFSummary(data []byte) (*elfSummary, error) {
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse elf file: %w", err)
+	}
+	defer f.Close()
+
+	s := &elfSummary{
+		symbols:     make(map[string]string),
+		sections:    make(map[string]uint64),
+		contents:    make(map[string][sha256.Size]byte),
+		relocations: make(map[string]map[string]int),
+	}
+
+	if sonames, err := f.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+		s.soname = sonames[0]
+	}
+	if s.needed, err = f.DynString(elf.DT_NEEDED); err != nil {
+		return nil, fmt.Errorf("failed to read DT_NEEDED entries: %w", err)
+	}
+
+	symbols, err := f.DynamicSymbols()
+	if err != nil && err != elf.ErrNoSymbols {
+		return nil, fmt.Errorf("failed to read dynamic symbols: %w", err)
+	}
+	for _, sym := range symbols {
+		name := sym.Name
+		if sym.Version != "" {
+			name += "@" + sym.Version
+		}
+		s.symbols[name] = describeELFSymbol(sym)
+	}
+
+	for _, section := range f.Sections {
+		if section.Type == elf.SHT_NULL || ignoredELFSection(section.Name) {
+			continue
+		}
+		s.sections[section.Name] = section.Size
+
+		if section.Type != elf.SHT_NOBITS {
+			data, err := section.Data()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", section.Name, err)
+			}
+			s.contents[section.Name] = sha256.Sum256(data)
+		}
+
+		if section.Type == elf.SHT_REL || section.Type == elf.SHT_RELA {
+			counts, err := countRelocations(f, section)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read relocations in %s: %w", section.Name, err)
+			}
+			s.relocations[section.Name] = counts
+		}
+	}
+
+	return s, nil
+}
+
+// describeELFSymbol returns the properties of a dynamic symbol that affect its users.  The value
+// and the size of functions are not included as they change with any change to the code.
+func describeELFSymbol(sym elf.Symbol) string {
+	typ := elf.ST_TYPE(sym.Info)
+	desc := fmt.Sprintf("%v %v %v", typ, elf.ST_BIND(sym.Info), elf.ST_VISIBILITY(sym.Other))
+	if sym.Section == elf.SHN_UNDEF {
+		desc += " undefined"
+	} else if typ == elf.STT_OBJECT || typ == elf.STT_TLS {
+		desc += fmt.Sprintf(" size %d", sym.Size)
+	}
+	return desc
+}
+
+// countRelocations returns the number of relocations of each type in a SHT_REL or SHT_RELA
+// section.
+func countRelocations(f *elf.File, section *elf.Section) (map[string]int, error) {
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	var entSize int
+	var relocType func(entry []byte) uint32
+	switch {
+	case f.Class == elf.ELFCLASS64 && section.Type == elf.SHT_RELA:
+		entSize = binary.Size(elf.Rela64{})
+	case f.Class == elf.ELFCLASS64:
+		entSize = binary.Size(elf.Rel64{})
+	case section.Type == elf.SHT_RELA:
+		entSize = binary.Size(elf.Rela32{})
+	default:
+		entSize = binary.Size(elf.Rel32{})
+	}
+	if f.Class == elf.ELFCLASS64 {
+		relocType = func(entry []byte) uint32 { return elf.R_TYPE64(f.ByteOrder.Uint64(entry[8:])) }
+	} else {
+		relocType = func(entry []byte) uint32 { return elf.R_TYPE32(f.ByteOrder.Uint32(entry[4:])) }
+	}
+	if len(data)%entSize != 0 {
+		return nil, fmt.Errorf("section size %d is not a multiple of the entry size %d", len(data), entSize)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < len(data); i += entSize {
+		counts[relocationTypeName(f.Machine, relocType(data[i:i+entSize]))]++
+	}
+	return counts, nil
+}
+
+// relocationTypeName returns the name of a relocation type of a machine.
+func relocationTypeName(machine elf.Machine, typ uint32) string {
+	switch machine {
+	case elf.EM_AARCH64:
+		return elf.R_AARCH64(typ).String()
+	case elf.EM_ARM:
+		return elf.R_ARM(typ).String()
+	case elf.EM_386:
+		return elf.R_386(typ).String()
+	case elf.EM_X86_64:
+		return elf.R_X86_64(typ).String()
+	case elf.EM_RISCV:
+		return elf.R_RISCV(typ).String()
+	}
+	return fmt.Sprintf("type %d", typ)
+}
+
+// diffELFSummaries returns the differences between two ELF files.
+func diffELFSummaries(a, b *elfSummary) []string {
+	var diffs []string
+
+	if a.soname != b.soname {
+		diffs = append(diffs, fmt.Sprintf("SONAME: %q -> %q", a.soname, b.soname))
+	}
+
+	neededA := make(map[string]bool)
+	for _, n := range a.needed {
+		neededA[n] = true
+	}
+	neededB := make(map[string]bool)
+	for _, n := range b.needed {
+		neededB[n] = true
+	}
+	neededChanged := false
+	for _, n := range a.needed {
+		if !neededB[n] {
+			diffs = append(diffs, "NEEDED removed: "+n)
+			neededChanged = true
+		}
+	}
+	for _, n := range b.needed {
+		if !neededA[n] {
+			diffs = append(diffs, "NEEDED added: "+n)
+			neededChanged = true
+		}
+	}
+	// The order of the NEEDED entries affects symbol resolution
+	if !neededChanged && strings.Join(a.needed, "\n") != strings.Join(b.needed, "\n") {
+		diffs = append(diffs, fmt.Sprintf("NEEDED reordered: %s -> %s",
+			strings.Join(a.needed, ", "), strings.Join(b.needed, ", ")))
+	}
+
+	for _, name := range sortedUnion(a.symbols, b.symbols) {
+		descA, inA := a.symbols[name]
+		descB, inB := b.symbols[name]
+		switch {
+		case !inB:
+			diffs = append(diffs, fmt.Sprintf("symbol removed: %s (%s)", name, descA))
+		case !inA:
+			diffs = append(diffs, fmt.Sprintf("symbol added: %s (%s)", name, descB))
+		case descA != descB:
+			diffs = append(diffs, fmt.Sprintf("symbol changed: %s: %s -> %s", name, descA, descB))
+		}
+	}
+
+	for _, name := range sortedUnion(a.sections, b.sections) {
+		sizeA, inA := a.sections[name]
+		sizeB, inB := b.sections[name]
+		switch {
+		case !inB:
+			diffs = append(diffs, fmt.Sprintf("section removed: %s (%d bytes)", name, sizeA))
+		case !inA:
+			diffs = append(diffs, fmt.Sprintf("section added: %s (%d bytes)", name, sizeB))
+		case sizeA != sizeB:
+			diffs = append(diffs, fmt.Sprintf("section size changed: %s: %d -> %d bytes", name, sizeA, sizeB))
+		}
+	}
+
+	for _, section := range sortedUnion(a.relocations, b.relocations) {
+		countsA, countsB := a.relocations[section], b.relocations[section]
+		for _, typ := range sortedUnion(countsA, countsB) {
+			if countsA[typ] != countsB[typ] {
+				diffs = append(diffs, fmt.Sprintf("relocations changed: %s %s: %d -> %d",
+					section, typ, countsA[typ], countsB[typ]))
+			}
+		}
+	}
+
+	// Changes to the code or data with the same layout are reported as a whole, as the contents of
+	// the sections can't be compared semantically.
+	if len(diffs) == 0 {
+		var changed []string
+		for _, name := range sortedUnion(a.contents, b.contents) {
+			if a.contents[name] != b.contents[name] {
+				changed = append(changed, name)
+			}
+		}
+		if len(changed) > 0 {
+			diffs = append(diffs, "section contents changed: "+strings.Join(changed, ", "))
+		}
+	}
+
+	return diffs
+}
+
+// sortedUnion returns the sorted keys that are in either map.
+func sortedUnion[T any](a, b map[string]T) []string {
+	var keys []string
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,295 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testELFSymbol struct {
+	name    string
+	typ     elf.SymType
+	size    uint64
+	defined bool
+}
+
+// testELFFile describes a minimal x86_64 shared library.
+type testELFFile struct {
+	soname      string
+	needed      []string
+	symbols     []testELFSymbol
+	textSize    int
+	textFill    byte
+	relocations []elf.R_X86_64
+	buildID     string
+	debugInfo   string
+}
+
+func (e testELFFile) bytes() []byte {
+	le := binary.LittleEndian
+	write := func(buf *bytes.Buffer, data any) {
+		if err := binary.Write(buf, le, data); err != nil {
+			panic(err)
+		}
+	}
+
+	dynstr := &bytes.Buffer{}
+	dynstr.WriteByte(0)
+	addString := func(s string) uint32 {
+		i := uint32(dynstr.Len())
+		dynstr.WriteString(s + "\x00")
+		return i
+	}
+
+	dynsym := &bytes.Buffer{}
+	write(dynsym, elf.Sym64{})
+	for _, s := range e.symbols {
+		shndx := uint16(elf.SHN_UNDEF)
+		if s.defined {
+			shndx = 5
+		}
+		write(dynsym, elf.Sym64{
+			Name:  addString(s.name),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, s.typ),
+			Shndx: shndx,
+			Size:  s.size,
+		})
+	}
+
+	dynamic := &bytes.Buffer{}
+	if e.soname != "" {
+		write(dynamic, elf.Dyn64{Tag: int64(elf.DT_SONAME), Val: uint64(addString(e.soname))})
+	}
+	for _, n := range e.needed {
+		write(dynamic, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: uint64(addString(n))})
+	}
+	write(dynamic, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+
+	rela := &bytes.Buffer{}
+	for _, r := range e.relocations {
+		write(rela, elf.Rela64{Info: elf.R_INFO(0, uint32(r))})
+	}
+
+	note := &bytes.Buffer{}
+	desc := make([]byte, align4(len(e.buildID)))
+	copy(desc, e.buildID)
+	write(note, [3]uint32{4, uint32(len(e.buildID)), 3 /* NT_GNU_BUILD_ID */})
+	note.WriteString(gnuBuildID)
+	note.Write(desc)
+
+	sections := []struct {
+		name        string
+		typ         elf.SectionType
+		link        uint32
+		entSize     uint64
+		data        []byte
+		omitIfEmpty bool
+	}{
+		{name: ".dynstr", typ: elf.SHT_STRTAB, data: dynstr.Bytes()},
+		{name: ".dynsym", typ: elf.SHT_DYNSYM, link: 1, entSize: 24, data: dynsym.Bytes()},
+		{name: ".dynamic", typ: elf.SHT_DYNAMIC, link: 1, entSize: 16, data: dynamic.Bytes()},
+		{name: ".rela.dyn", typ: elf.SHT_RELA, link: 2, entSize: 24, data: rela.Bytes()},
+		{name: ".text", typ: elf.SHT_PROGBITS, data: bytes.Repeat([]byte{e.textFill}, e.textSize)},
+		{name: ".note.gnu.build-id", typ: elf.SHT_NOTE, data: note.Bytes()},
+		{name: ".debug_info", typ: elf.SHT_PROGBITS, data: []byte(e.debugInfo), omitIfEmpty: true},
+	}
+
+	shstrtab := &bytes.Buffer{}
+	shstrtab.WriteByte(0)
+	body := &bytes.Buffer{}
+	headerSize := binary.Size(elf.Header64{})
+	headers := []elf.Section64{{}}
+	for _, s := range sections {
+		if s.omitIfEmpty && len(s.data) == 0 {
+			continue
+		}
+		headers = append(headers, elf.Section64{
+			Name:    uint32(shstrtab.Len()),
+			Type:    uint32(s.typ),
+			Off:     uint64(headerSize + body.Len()),
+			Size:    uint64(len(s.data)),
+			Link:    s.link,
+			Entsize: s.entSize,
+		})
+		shstrtab.WriteString(s.name + "\x00")
+		body.Write(s.data)
+	}
+	headers = append(headers, elf.Section64{
+		Name: uint32(shstrtab.Len()),
+		Type: uint32(elf.SHT_STRTAB),
+		Off:  uint64(headerSize + body.Len()),
+		Size: uint64(shstrtab.Len() + len(".shstrtab\x00")),
+	})
+	shstrtab.WriteString(".shstrtab\x00")
+	body.Write(shstrtab.Bytes())
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(headerSize + body.Len()),
+		Ehsize:    uint16(headerSize),
+		Shentsize: uint16(binary.Size(elf.Section64{})),
+		Shnum:     uint16(len(headers)),
+		Shstrndx:  uint16(len(headers) - 1),
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := &bytes.Buffer{}
+	write(buf, header)
+	buf.Write(body.Bytes())
+	write(buf, headers)
+	return buf.Bytes()
+}
+
+const gnuBuildID = "GNU\x00"
+
+func align4(i int) int {
+	return (i + 3) &^ 3
+}
+
+func TestCompareELFFiles(t *testing.T) {
+	lib := testELFFile{
+		soname: "libfoo.so",
+		needed: []string{"libc.so", "libm.so"},
+		symbols: []testELFSymbol{
+			{name: "foo", typ: elf.STT_FUNC, size: 16, defined: true},
+			{name: "bar", typ: elf.STT_OBJECT, size: 8, defined: true},
+			{name: "malloc", typ: elf.STT_FUNC},
+		},
+		textSize:    32,
+		relocations: []elf.R_X86_64{elf.R_X86_64_RELATIVE, elf.R_X86_64_JMP_SLOT},
+		buildID:     "1234",
+		debugInfo:   "debug",
+	}
+
+	// Only the build ID and the debug sections differ
+	rebuilt := lib
+	rebuilt.buildID = "5678"
+	rebuilt.debugInfo = "other debug info"
+
+	// Changes to the NEEDED entries, dynamic symbols, section sizes and relocations
+	changed := lib
+	changed.needed = []string{"libc.so", "liblog.so"}
+	changed.symbols = []testELFSymbol{
+		{name: "foo", typ: elf.STT_FUNC, size: 24, defined: true},
+		{name: "bar", typ: elf.STT_OBJECT, size: 16, defined: true},
+		{name: "baz", typ: elf.STT_FUNC, size: 8, defined: true},
+	}
+	changed.textSize = 48
+	changed.relocations = []elf.R_X86_64{elf.R_X86_64_RELATIVE, elf.R_X86_64_RELATIVE}
+
+	reordered := lib
+	reordered.needed = []string{"libm.so", "libc.so"}
+
+	// Different code with the same size
+	codeChanged := lib
+	codeChanged.textFill = 0x90
+
+	sameA := bytesToZipArtifactFile("system/lib64/libsame.so", lib.bytes())
+	sameB := bytesToZipArtifactFile("system/lib64/libsame.so", rebuilt.bytes())
+	changedA := bytesToZipArtifactFile("system/lib64/libchanged.so", lib.bytes())
+	changedB := bytesToZipArtifactFile("system/lib64/libchanged.so", changed.bytes())
+	reorderedA := bytesToZipArtifactFile("system/lib64/libreordered.so", lib.bytes())
+	reorderedB := bytesToZipArtifactFile("system/lib64/libreordered.so", reordered.bytes())
+	codeChangedA := bytesToZipArtifactFile("system/lib64/libcode.so", lib.bytes())
+	codeChangedB := bytesToZipArtifactFile("system/lib64/libcode.so", codeChanged.bytes())
+	textA := bytesToZipArtifactFile("system/etc/text", []byte("a"))
+	textB := bytesToZipArtifactFile("system/etc/text", []byte("b"))
+
+	modified, diffs, err := compareELFFiles([][2]*ZipArtifactFile{
+		{sameA, sameB},
+		{changedA, changedB},
+		{reorderedA, reorderedB},
+		{codeChangedA, codeChangedB},
+		{textA, textB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedModified := [][2]*ZipArtifactFile{
+		{changedA, changedB},
+		{reorderedA, reorderedB},
+		{codeChangedA, codeChangedB},
+		{textA, textB},
+	}
+	if !reflect.DeepEqual(modified, expectedModified) {
+		t.Errorf("expected only the ELF files with semantic differences and the other files to be modified, got:\n%v",
+			(&zipDiff{modified: modified}).String())
+	}
+
+	expectedDiffs := elfDiffs{
+		changedA: {
+			"NEEDED removed: libm.so",
+			"NEEDED added: liblog.so",
+			"symbol changed: bar: STT_OBJECT STB_GLOBAL STV_DEFAULT size 8 -> STT_OBJECT STB_GLOBAL STV_DEFAULT size 16",
+			"symbol added: baz (STT_FUNC STB_GLOBAL STV_DEFAULT)",
+			"symbol removed: malloc (STT_FUNC STB_GLOBAL STV_DEFAULT undefined)",
+			"section size changed: .dynstr: 42 -> 41 bytes",
+			"section size changed: .text: 32 -> 48 bytes",
+			"relocations changed: .rela.dyn R_X86_64_JMP_SLOT: 1 -> 0",
+			"relocations changed: .rela.dyn R_X86_64_RELATIVE: 1 -> 2",
+		},
+		reorderedA: {
+			"NEEDED reordered: libc.so, libm.so -> libm.so, libc.so",
+		},
+		codeChangedA: {
+			"section contents changed: .text",
+		},
+	}
+	if !reflect.DeepEqual(diffs, expectedDiffs) {
+		t.Errorf("incorrect ELF differences\nexpected:\n%v\ngot:\n%v", expectedDiffs.String(modified), diffs.String(modified))
+	}
+}
+
+func TestCompareCorruptELFFiles(t *testing.T) {
+	lib := testELFFile{soname: "libfoo.so", textSize: 32, buildID: "1234"}
+	rebuilt := lib
+	rebuilt.buildID = "5678"
+
+	// Files that start with the ELF magic but can't be parsed are compared byte for byte.
+	truncatedA := bytesToZipArtifactFile("vendor/firmware/blob", lib.bytes()[:32])
+	truncatedB := bytesToZipArtifactFile("vendor/firmware/blob", rebuilt.bytes()[:32])
+	corruptA := bytesToZipArtifactFile("vendor/firmware/other", []byte(elf.ELFMAG+"garbage"))
+	corruptB := bytesToZipArtifactFile("vendor/firmware/other", []byte(elf.ELFMAG+"rubbish"))
+
+	modified, diffs, err := compareELFFiles([][2]*ZipArtifactFile{
+		{truncatedA, truncatedB},
+		{corruptA, corruptB},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedModified := [][2]*ZipArtifactFile{
+		{truncatedA, truncatedB},
+		{corruptA, corruptB},
+	}
+	if !reflect.DeepEqual(modified, expectedModified) {
+		t.Errorf("expected the corrupt ELF files to be modified, got:\n%v",
+			(&zipDiff{modified: modified}).String())
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no ELF differences, got:\n%v", diffs.String(modified))
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// nestedZipExtensions are the extensions of the zip files whose contents are compared when
+// nested zips are compared.
+var nestedZipExtensions = []string{".apex", ".apk", ".apks", ".capex", ".jar", ".zip"}
+
+func isNestedZip(name string) bool {
+	// Compressed APEXes contain the original APEX without an extension
+	if strings.HasSuffix(name, ".capex"+nestedZipSeparator+"original_apex") {
+		return true
+	}
+	ext := path.Ext(name)
+	for _, e := range nestedZipExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// signedZipExtensions are the extensions of the nested zips that are signed with apksigner, whose
+// META-INF/MANIFEST.MF only contains the digests of the v1 signature.
+var signedZipExtensions = []string{".apex", ".apk", ".capex"}
+
+func isSignedZip(name string) bool {
+	if strings.HasSuffix(name, ".capex"+nestedZipSeparator+"original_apex") {
+		return true
+	}
+	ext := path.Ext(name)
+	for _, e := range signedZipExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// isSignatureFile returns true for the files in a nested zip that contain its v1 (jar) signature
+// or its source stamp, which differ whenever the zip is signed again.  The v2+ signing block is
+// not a file in the zip and is never compared.  META-INF/MANIFEST.MF is only a signature file in
+// APKs and APEXes, in jars it also contains attributes like Main-Class and Class-Path.
+func isSignatureFile(name string) bool {
+	container := ""
+	if i := strings.LastIndex(name, nestedZipSeparator); i >= 0 {
+		container = name[:i]
+		name = name[i+len(nestedZipSeparator):]
+	}
+	if name == "stamp-cert-sha256" {
+		return true
+	}
+	dir, file := path.Split(name)
+	if dir != "META-INF/" {
+		return false
+	}
+	if file == "MANIFEST.MF" {
+		return isSignedZip(container)
+	}
+	if strings.HasPrefix(file, "SIG-") {
+		return true
+	}
+	switch path.Ext(file) {
+	case ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+	return false
+}
+
+// compareNestedZips replaces the modified files in diff that are zip files in both zips, like
+// APKs and APEXes, with the differences between the files they contain, recursively.  Signature
+// files are ignored, as are the AVB footers of images like apex payloads, so that zips that were
+// only signed differently are not considered different.  Files that are not valid zip files are
+// left in the modified list.  Images are only compared byte for byte up to their AVB footer, the
+// files inside filesystem images like apex payloads are not listed or compared.
+func compareNestedZips(diff zipDiff) (zipDiff, error) {
+	ret := zipDiff{
+		onlyInA: diff.onlyInA,
+		onlyInB: diff.onlyInB,
+	}
+
+	for _, f := range diff.modified {
+		if path.Ext(f[0].Name) == ".img" {
+			if same, err := sameIgnoringAVBFooter(f[0], f[1]); err != nil {
+				return zipDiff{}, err
+			} else if same {
+				continue
+			}
+		}
+
+		if !isNestedZip(f[0].Name) {
+			ret.modified = append(ret.modified, f)
+			continue
+		}
+
+		aFiles, aErr := nestedZipFiles(f[0])
+		bFiles, bErr := nestedZipFiles(f[1])
+		if errors.Is(aErr, zip.ErrFormat) || errors.Is(bErr, zip.ErrFormat) {
+			ret.modified = append(ret.modified, f)
+			continue
+		} else if aErr != nil {
+			return zipDiff{}, aErr
+		} else if bErr != nil {
+			return zipDiff{}, bErr
+		}
+
+		nested, err := compareNestedZips(diffTargetFilesLists(aFiles, bFiles))
+		if err != nil {
+			return zipDiff{}, err
+		}
+		ret.modified = append(ret.modified, nested.modified...)
+		ret.onlyInA = append(ret.onlyInA, nested.onlyInA...)
+		ret.onlyInB = append(ret.onlyInB, nested.onlyInB...)
+	}
+
+	return ret, nil
+}
+
+// nestedZipFiles returns the files in a nested zip file that are compared.
+func nestedZipFiles(zf *ZipArtifactFile) ([]*ZipArtifactFile, error) {
+	z, err := NewNestedZipArtifact(zf)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	files, err := z.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*ZipArtifactFile
+	for _, f := range files {
+		if !f.FileInfo().IsDir() && !isSignatureFile(f.Name) {
+			ret = append(ret, f)
+		}
+	}
+	return ret, nil
+}
+
+const (
+	avbFooterMagic = "AVBf"
+	avbFooterSize  = 64
+)
+
+// avbImageSize returns the size of an image before avbtool appended its hash tree, vbmeta and
+// footer, or false if the file doesn't have an AVB footer.
+func avbImageSize(zf *ZipArtifactFile) (uint64, bool, error) {
+	if zf.UncompressedSize64 < avbFooterSize {
+		return 0, false, nil
+	}
+
+	r, err := zf.Open()
+	if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+
+	if _, err := io.CopyN(io.Discard, r, int64(zf.UncompressedSize64-avbFooterSize)); err != nil {
+		return 0, false, err
+	}
+	footer := make([]byte, avbFooterSize)
+	if _, err := io.ReadFull(r, footer); err != nil {
+		return 0, false, err
+	}
+
+	// The footer is the magic, the major and minor versions, and the original image size,
+	// followed by the offset and size of the vbmeta.
+	if !bytes.HasPrefix(footer, []byte(avbFooterMagic)) {
+		return 0, false, nil
+	}
+	size := binary.BigEndian.Uint64(footer[12:])
+	if size > zf.UncompressedSize64-avbFooterSize {
+		return 0, false, nil
+	}
+	return size, true, nil
+}
+
+// sameIgnoringAVBFooter returns true if two images with AVB footers contain the same image.
+func sameIgnoringAVBFooter(a, b *ZipArtifactFile) (bool, error) {
+	aSize, aOk, err := avbImageSize(a)
+	if err != nil || !aOk {
+		return false, err
+	}
+	bSize, bOk, err := avbImageSize(b)
+	if err != nil || !bOk || aSize != bSize {
+		return false, err
+	}
+
+	hash := func(zf *ZipArtifactFile, size uint64) ([]byte, error) {
+		r, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		h := sha256.New()
+		if _, err := io.CopyN(h, r, int64(size)); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	aHash, err := hash(a, aSize)
+	if err != nil {
+		return false, err
+	}
+	bHash, err := hash(b, bSize)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aHash, bHash), nil
+}
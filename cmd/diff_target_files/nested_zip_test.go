@@ -0,0 +1,251 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// zipBytes returns a zip file containing files, a list of names and contents.
+func zipBytes(files ...string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for i := 0; i < len(files); i += 2 {
+		f, err := w.Create(files[i])
+		if err != nil {
+			panic(err)
+		}
+		if _, err := f.Write([]byte(files[i+1])); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// avbImage returns an image with an AVB footer, where vbmeta stands for the hash tree and vbmeta
+// that avbtool appends to the image.
+func avbImage(image, vbmeta string) string {
+	footer := make([]byte, avbFooterSize)
+	copy(footer, avbFooterMagic)
+	binary.BigEndian.PutUint32(footer[4:], 1)
+	binary.BigEndian.PutUint64(footer[12:], uint64(len(image)))
+	binary.BigEndian.PutUint64(footer[20:], uint64(len(image)))
+	binary.BigEndian.PutUint64(footer[28:], uint64(len(vbmeta)))
+	return image + vbmeta + string(footer)
+}
+
+func names(files []*ZipArtifactFile) []string {
+	var ret []string
+	for _, f := range files {
+		ret = append(ret, f.Name)
+	}
+	return ret
+}
+
+func TestCompareNestedZips(t *testing.T) {
+	fooA := bytesToZipArtifactFile("system/app/Foo/Foo.apk", zipBytes(
+		"AndroidManifest.xml", "manifest",
+		"classes.dex", "dex 1",
+		"res/a.xml", "a",
+		"META-INF/MANIFEST.MF", "digests 1",
+		"META-INF/CERT.SF", "digests 1",
+		"META-INF/CERT.RSA", "signature 1",
+	))
+	fooB := bytesToZipArtifactFile("system/app/Foo/Foo.apk", zipBytes(
+		"classes.dex", "dex 2",
+		"AndroidManifest.xml", "manifest",
+		"res/b.xml", "b",
+		"META-INF/MANIFEST.MF", "digests 2",
+		"META-INF/CERT.SF", "digests 2",
+		"META-INF/CERT.RSA", "signature 2",
+		"stamp-cert-sha256", "stamp",
+	))
+	resignedA := bytesToZipArtifactFile("system/app/Resigned/Resigned.apk", zipBytes(
+		"classes.dex", "dex",
+		"META-INF/CERT.RSA", "signature 1",
+	))
+	resignedB := bytesToZipArtifactFile("system/app/Resigned/Resigned.apk", zipBytes(
+		"classes.dex", "dex",
+		"META-INF/CERT.RSA", "signature 2",
+	))
+	// A compressed apex contains the original apex, which contains the payload
+	apexA := bytesToZipArtifactFile("system/apex/com.android.foo.capex", zipBytes(
+		"original_apex", string(zipBytes(
+			"apex_manifest.pb", "manifest",
+			"apex_payload.img", avbImage("payload", "vbmeta 1"),
+		)),
+	))
+	apexB := bytesToZipArtifactFile("system/apex/com.android.foo.capex", zipBytes(
+		"original_apex", string(zipBytes(
+			"apex_manifest.pb", "manifest",
+			"apex_payload.img", avbImage("payload", "vbmeta 2"),
+		)),
+	))
+	// The manifest of a jar contains attributes, not just digests
+	jarA := bytesToZipArtifactFile("system/framework/foo.jar", zipBytes(
+		"classes.dex", "dex",
+		"META-INF/MANIFEST.MF", "Main-Class: Foo",
+	))
+	jarB := bytesToZipArtifactFile("system/framework/foo.jar", zipBytes(
+		"classes.dex", "dex",
+		"META-INF/MANIFEST.MF", "Main-Class: Bar",
+	))
+	invalidA := bytesToZipArtifactFile("system/app/Invalid/Invalid.apk", []byte("not a zip 1"))
+	invalidB := bytesToZipArtifactFile("system/app/Invalid/Invalid.apk", []byte("not a zip 2"))
+
+	diff, err := compareNestedZips(zipDiff{
+		modified: [][2]*ZipArtifactFile{{fooA, fooB}, {resignedA, resignedB}, {apexA, apexB}, {jarA, jarB}, {invalidA, invalidB}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var modified []string
+	for _, f := range diff.modified {
+		modified = append(modified, f[0].Name)
+	}
+	if expected := []string{
+		"system/app/Foo/Foo.apk!/classes.dex",
+		"system/framework/foo.jar!/META-INF/MANIFEST.MF",
+		"system/app/Invalid/Invalid.apk",
+	}; !reflect.DeepEqual(modified, expected) {
+		t.Errorf("incorrect modified files\nexpected: %q\n     got: %q", expected, modified)
+	}
+	if expected := []string{"system/app/Foo/Foo.apk!/res/a.xml"}; !reflect.DeepEqual(names(diff.onlyInA), expected) {
+		t.Errorf("incorrect removed files\nexpected: %q\n     got: %q", expected, names(diff.onlyInA))
+	}
+	if expected := []string{"system/app/Foo/Foo.apk!/res/b.xml"}; !reflect.DeepEqual(names(diff.onlyInB), expected) {
+		t.Errorf("incorrect added files\nexpected: %q\n     got: %q", expected, names(diff.onlyInB))
+	}
+
+	// The files in nested zips can be allowlisted
+	diff, err = applyAllowLists(diff, []allowList{{path: "system/app/*/*.apk!/res/*.xml"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.onlyInA) > 0 || len(diff.onlyInB) > 0 {
+		t.Errorf("expected the files in nested zips to be allowlisted, got:\n%v", diff.String())
+	}
+}
+
+func TestNestedZipArtifactOpen(t *testing.T) {
+	apex := bytesToZipArtifactFile("system/apex/com.android.foo.capex", zipBytes(
+		"original_apex", string(zipBytes(
+			"apex_manifest.pb", "manifest",
+			"lib64/libfoo.so", "libfoo",
+		)),
+	))
+
+	// The files of nested zips only keep their headers, and are read again when opened.
+	outer, err := nestedZipFiles(apex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner, err := nestedZipFiles(outer[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{
+		"system/apex/com.android.foo.capex!/original_apex!/apex_manifest.pb",
+		"system/apex/com.android.foo.capex!/original_apex!/lib64/libfoo.so",
+	}; !reflect.DeepEqual(names(inner), expected) {
+		t.Fatalf("incorrect nested files\nexpected: %q\n     got: %q", expected, names(inner))
+	}
+
+	r, err := inner[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "libfoo" {
+		t.Errorf("expected %q, got %q", "libfoo", data)
+	}
+}
+
+func TestIsSignatureFile(t *testing.T) {
+	testCases := map[string]bool{
+		"Foo.apk!/META-INF/MANIFEST.MF":                   true,
+		"Foo.apk!/META-INF/CERT.SF":                       true,
+		"Foo.apk!/META-INF/CERT.RSA":                      true,
+		"Foo.apk!/stamp-cert-sha256":                      true,
+		"foo.apex!/META-INF/MANIFEST.MF":                  true,
+		"foo.capex!/original_apex!/META-INF/MANIFEST.MF":  true,
+		"foo.jar!/META-INF/MANIFEST.MF":                   false,
+		"foo.jar!/META-INF/CERT.SF":                       true,
+		"foo.zip!/META-INF/MANIFEST.MF":                   false,
+		"Foo.apk!/META-INF/services/foo.Bar":              false,
+		"Foo.apk!/res/META-INF/MANIFEST.MF":               false,
+		"Foo.apks!/splits/base.apk!/META-INF/MANIFEST.MF": true,
+		"Foo.apks!/META-INF/MANIFEST.MF":                  false,
+	}
+	for name, expected := range testCases {
+		if got := isSignatureFile(name); got != expected {
+			t.Errorf("isSignatureFile(%q): expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestSameIgnoringAVBFooter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		a, b     string
+		expected bool
+	}{
+		{
+			name:     "different vbmeta",
+			a:        avbImage("image", "vbmeta 1"),
+			b:        avbImage("image", "vbmeta 2"),
+			expected: true,
+		},
+		{
+			name:     "different image",
+			a:        avbImage("image 1", "vbmeta"),
+			b:        avbImage("image 2", "vbmeta"),
+			expected: false,
+		},
+		{
+			name:     "no footer",
+			a:        "image 1",
+			b:        "image 2",
+			expected: false,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			a := bytesToZipArtifactFile("apex_payload.img", []byte(test.a))
+			b := bytesToZipArtifactFile("apex_payload.img", []byte(test.b))
+			same, err := sameIgnoringAVBFooter(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, same)
+			}
+		})
+	}
+}
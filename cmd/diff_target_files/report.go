@@ -0,0 +1,346 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// diffCategory is the kind of a difference between the reference and the primary zip files.
+type diffCategory string
+
+const (
+	categoryAdded       diffCategory = "added"
+	categoryRemoved     diffCategory = "removed"
+	categoryModified    diffCategory = "modified"
+	categoryAllowListed diffCategory = "allowlisted"
+)
+
+// reportEntry is a file that differs between the reference and the primary zip files.
+type reportEntry struct {
+	Path      string
+	Partition string
+	Category  diffCategory
+
+	// Change is the difference that was ignored for allowlisted entries: added, removed or
+	// modified.
+	Change diffCategory `json:",omitempty"`
+	// AllowList is the allowlist pattern that matched an allowlisted entry.
+	AllowList string `json:",omitempty"`
+
+	ReferenceSize uint64
+	PrimarySize   uint64
+
+	// Diff is a unified diff of modified text files.
+	Diff string `json:",omitempty"`
+
+	// ELFDiff are the semantic differences of modified ELF files.
+	ELFDiff []string `json:",omitempty"`
+
+	// ignoreMatchingLines are the patterns that would allowlist the changed lines of a
+	// modified text file.
+	ignoreMatchingLines []string
+}
+
+// partitionSummary counts the differences in a partition.
+type partitionSummary struct {
+	Partition   string
+	Added       int
+	Removed     int
+	Modified    int
+	AllowListed int
+	SizeChange  int64
+}
+
+func (s *partitionSummary) add(e reportEntry) {
+	switch e.Category {
+	case categoryAdded:
+		s.Added++
+	case categoryRemoved:
+		s.Removed++
+	case categoryModified:
+		s.Modified++
+	case categoryAllowListed:
+		s.AllowListed++
+	}
+	if e.Category != categoryAllowListed {
+		s.SizeChange += int64(e.PrimarySize) - int64(e.ReferenceSize)
+	}
+}
+
+// diffReport is the structured form of a zipDiff, written by the -json_report and -html_report
+// flags.
+type diffReport struct {
+	Reference string
+	Primary   string
+
+	Total      partitionSummary
+	Partitions []partitionSummary
+	Entries    []reportEntry
+
+	// SuggestedAllowList would allowlist all the differences that are not allowlisted yet, in
+	// the format of allowlist files.
+	SuggestedAllowList []jsonAllowList
+}
+
+// partitionOf returns the partition a file in a target files zip belongs to, or the first
+// directory of the path for other zip files.
+func partitionOf(name string) string {
+	for _, p := range targetZipPartitions {
+		if p := strings.ToLower(p); strings.HasPrefix(name, p) {
+			return strings.TrimSuffix(p, "/")
+		}
+	}
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// newDiffReport returns a report of the differences in unfiltered, where the differences that
+// are not in diff were allowlisted by allowLists.  elfDiffs contains the semantic differences of
+// modified ELF files, if they were compared.
+func newDiffReport(reference, primary string, diff, unfiltered zipDiff, elfDiffs elfDiffs,
+	allowLists []allowList) (*diffReport, error) {
+	kept := make(map[*ZipArtifactFile]bool)
+	for _, f := range diff.modified {
+		kept[f[0]] = true
+	}
+	for _, f := range diff.onlyInA {
+		kept[f] = true
+	}
+	for _, f := range diff.onlyInB {
+		kept[f] = true
+	}
+
+	allowListOf := func(name string) string {
+		for _, w := range allowLists {
+			if match, _ := Match(w.path, name); match {
+				return w.path
+			}
+		}
+		return ""
+	}
+
+	r := &diffReport{
+		Reference: reference,
+		Primary:   primary,
+	}
+	add := func(f *ZipArtifactFile, e reportEntry) {
+		e.Path = f.Name
+		e.Partition = partitionOf(f.Name)
+		if !kept[f] {
+			e.Change = e.Category
+			e.Category = categoryAllowListed
+			e.AllowList = allowListOf(f.Name)
+		}
+		r.Entries = append(r.Entries, e)
+	}
+
+	for _, f := range unfiltered.modified {
+		diff, ops, err := textDiff(f[0], f[1])
+		if err != nil {
+			return nil, err
+		}
+		add(f[0], reportEntry{
+			Category:            categoryModified,
+			ReferenceSize:       f[0].UncompressedSize64,
+			PrimarySize:         f[1].UncompressedSize64,
+			Diff:                diff,
+			ELFDiff:             elfDiffs[f[0]],
+			ignoreMatchingLines: suggestIgnoreMatchingLines(ops),
+		})
+	}
+	for _, f := range unfiltered.onlyInA {
+		add(f, reportEntry{Category: categoryRemoved, ReferenceSize: f.UncompressedSize64})
+	}
+	for _, f := range unfiltered.onlyInB {
+		add(f, reportEntry{Category: categoryAdded, PrimarySize: f.UncompressedSize64})
+	}
+
+	sort.SliceStable(r.Entries, func(i, j int) bool { return r.Entries[i].Path < r.Entries[j].Path })
+
+	partitions := make(map[string]*partitionSummary)
+	for _, e := range r.Entries {
+		if partitions[e.Partition] == nil {
+			partitions[e.Partition] = &partitionSummary{Partition: e.Partition}
+		}
+		partitions[e.Partition].add(e)
+		r.Total.add(e)
+	}
+	for _, s := range partitions {
+		r.Partitions = append(r.Partitions, *s)
+	}
+	sort.Slice(r.Partitions, func(i, j int) bool { return r.Partitions[i].Partition < r.Partitions[j].Partition })
+
+	r.SuggestedAllowList = suggestAllowList(r.Entries)
+	return r, nil
+}
+
+// propertyLine matches lines of build.prop and similar files.
+var propertyLine = regexp.MustCompile(`^\s*([^#=\s][^=]*?)\s*=`)
+
+// suggestIgnoreMatchingLines returns patterns that match the changed lines of a text file if
+// they are all properties, which can be allowlisted with IgnoreMatchingLines.
+func suggestIgnoreMatchingLines(ops []diffOp) []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		if op.kind == ' ' {
+			continue
+		}
+		m := propertyLine.FindStringSubmatch(op.line)
+		if m == nil {
+			return nil
+		}
+		// Allow the same whitespace around the key as propertyLine.
+		pattern := `^\s*` + regexp.QuoteMeta(m[1]) + `\s*=.*`
+		if !seen[pattern] {
+			seen[pattern] = true
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// suggestAllowList returns allowlist entries for the entries that are not allowlisted yet. Files
+// whose changed lines are all properties are allowlisted with IgnoreMatchingLines, the others
+// are allowlisted completely.
+func suggestAllowList(entries []reportEntry) []jsonAllowList {
+	var suggestions []jsonAllowList
+	index := make(map[string]int)
+	for _, e := range entries {
+		if e.Category == categoryAllowListed {
+			continue
+		}
+		key := strings.Join(e.ignoreMatchingLines, "\n")
+		i, ok := index[key]
+		if !ok {
+			i = len(suggestions)
+			index[key] = i
+			suggestions = append(suggestions, jsonAllowList{IgnoreMatchingLines: e.ignoreMatchingLines})
+		}
+		suggestions[i].Paths = append(suggestions[i].Paths, e.Path)
+	}
+	return suggestions
+}
+
+// writeJSON writes the report as JSON to w.
+func (r *diffReport) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// writeSuggestedAllowList writes the suggested allowlist in the format of allowlist files to w.
+func (r *diffReport) writeSuggestedAllowList(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	suggestions := r.SuggestedAllowList
+	if suggestions == nil {
+		suggestions = []jsonAllowList{}
+	}
+	return e.Encode(suggestions)
+}
+
+// writeHTML writes the report as a self-contained HTML page to w.
+func (r *diffReport) writeHTML(w io.Writer) error {
+	suggestions := &strings.Builder{}
+	if err := r.writeSuggestedAllowList(suggestions); err != nil {
+		return err
+	}
+	return htmlReportTemplate.Execute(w, struct {
+		*diffReport
+		SuggestedAllowListJSON string
+	}{r, suggestions.String()})
+}
+
+// diffLineClass returns the CSS class of a line of a unified diff.
+func diffLineClass(line string) string {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		return "header"
+	case strings.HasPrefix(line, "@@"):
+		return "hunk"
+	case strings.HasPrefix(line, "+"):
+		return "add"
+	case strings.HasPrefix(line, "-"):
+		return "del"
+	}
+	return ""
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lines":         func(s string) []string { return strings.Split(strings.TrimSuffix(s, "\n"), "\n") },
+	"diffLineClass": diffLineClass,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>diff_target_files: {{.Reference}} vs {{.Primary}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+td.num { text-align: right; }
+.added { color: #080; }
+.removed { color: #a00; }
+.modified { color: #a60; }
+.allowlisted { color: #888; }
+pre { background: #f6f6f6; padding: 8px; overflow-x: auto; }
+pre span { display: block; }
+pre .add { background: #dfd; }
+pre .del { background: #fdd; }
+pre .hunk { color: #06a; }
+pre .header { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>diff_target_files</h1>
+<p>Reference: {{.Reference}}<br>Primary: {{.Primary}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Partition</th><th>Added</th><th>Removed</th><th>Modified</th><th>Allowlisted</th><th>Size change (bytes)</th></tr>
+{{range .Partitions}}<tr><td><a href="#partition-{{.Partition}}">{{or .Partition "(none)"}}</a></td><td class="num">{{.Added}}</td><td class="num">{{.Removed}}</td><td class="num">{{.Modified}}</td><td class="num">{{.AllowListed}}</td><td class="num">{{.SizeChange}}</td></tr>
+{{end}}<tr><th>Total</th><th>{{.Total.Added}}</th><th>{{.Total.Removed}}</th><th>{{.Total.Modified}}</th><th>{{.Total.AllowListed}}</th><th>{{.Total.SizeChange}}</th></tr>
+</table>
+
+{{$entries := .Entries}}
+{{range .Partitions}}{{$partition := .Partition}}
+<h2 id="partition-{{.Partition}}">{{or .Partition "(none)"}}</h2>
+<table>
+<tr><th>File</th><th>Change</th><th>Reference size</th><th>Primary size</th></tr>
+{{range $entries}}{{if eq .Partition $partition}}<tr class="{{.Category}}">
+<td>{{if .Diff}}<details><summary>{{.Path}}</summary><pre>{{range lines .Diff}}<span class="{{diffLineClass .}}">{{.}}</span>{{end}}</pre></details>{{else if .ELFDiff}}<details><summary>{{.Path}}</summary><pre>{{range .ELFDiff}}<span>{{.}}</span>{{end}}</pre></details>{{else}}{{.Path}}{{end}}</td>
+<td>{{.Category}}{{if .Change}} ({{.Change}}{{if .AllowList}} by {{.AllowList}}{{end}}){{end}}</td>
+<td class="num">{{.ReferenceSize}}</td><td class="num">{{.PrimarySize}}</td>
+</tr>
+{{end}}{{end}}</table>
+{{end}}
+
+{{if .SuggestedAllowList}}<h2>Suggested allowlist</h2>
+<p>Review these entries before adding them to an allowlist file.</p>
+<pre>{{.SuggestedAllowListJSON}}</pre>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,192 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDiffReport(t *testing.T) {
+	propA := bytesToZipArtifactFile("system/build.prop", []byte("ro.a=1\nro.build.date=Mon\nro.c=3\n"))
+	propB := bytesToZipArtifactFile("system/build.prop", []byte("ro.a=1\nro.build.date=Tue\nro.c=3\n"))
+	ignoredA := bytesToZipArtifactFile("vendor/etc/ignored", []byte("1"))
+	ignoredB := bytesToZipArtifactFile("vendor/etc/ignored", []byte("2"))
+	binA := bytesToZipArtifactFile("vendor/bin/tool", []byte("\x00\x01"))
+	binB := bytesToZipArtifactFile("vendor/bin/tool", []byte("\x00\x02\x03"))
+	removed := bytesToZipArtifactFile("boot/ramdisk/init", []byte("init"))
+	added := bytesToZipArtifactFile("system/app/New.apk", []byte("apk"))
+
+	allowLists := []allowList{{path: "vendor/etc/*"}}
+	unfiltered := zipDiff{
+		modified: [][2]*ZipArtifactFile{{propA, propB}, {binA, binB}, {ignoredA, ignoredB}},
+		onlyInA:  []*ZipArtifactFile{removed},
+		onlyInB:  []*ZipArtifactFile{added},
+	}
+	diff, err := applyAllowLists(unfiltered.copy(), allowLists)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := newDiffReport("ref.zip", "pri.zip", diff, unfiltered, nil, allowLists)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		path, partition string
+		category        diffCategory
+		change          diffCategory
+		allowList       string
+		hasDiff         bool
+	}
+	var got []entry
+	for _, e := range report.Entries {
+		got = append(got, entry{e.Path, e.Partition, e.Category, e.Change, e.AllowList, e.Diff != ""})
+	}
+	expected := []entry{
+		{"boot/ramdisk/init", "boot/ramdisk", categoryRemoved, "", "", false},
+		{"system/app/New.apk", "system", categoryAdded, "", "", false},
+		{"system/build.prop", "system", categoryModified, "", "", true},
+		{"vendor/bin/tool", "vendor", categoryModified, "", "", false},
+		{"vendor/etc/ignored", "vendor", categoryAllowListed, categoryModified, "vendor/etc/*", true},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect entries\nexpected: %+v\n     got: %+v", expected, got)
+	}
+
+	expectedDiff := "--- reference/system/build.prop\n+++ primary/system/build.prop\n" +
+		"@@ -1,3 +1,3 @@\n ro.a=1\n-ro.build.date=Mon\n+ro.build.date=Tue\n ro.c=3\n"
+	if report.Entries[2].Diff != expectedDiff {
+		t.Errorf("incorrect diff\nexpected:\n%s\ngot:\n%s", expectedDiff, report.Entries[2].Diff)
+	}
+
+	expectedPartitions := []partitionSummary{
+		{Partition: "boot/ramdisk", Removed: 1, SizeChange: -4},
+		{Partition: "system", Added: 1, Modified: 1, SizeChange: 3},
+		{Partition: "vendor", Modified: 1, AllowListed: 1, SizeChange: 1},
+	}
+	if !reflect.DeepEqual(report.Partitions, expectedPartitions) {
+		t.Errorf("incorrect partitions\nexpected: %+v\n     got: %+v", expectedPartitions, report.Partitions)
+	}
+	expectedTotal := partitionSummary{Added: 1, Removed: 1, Modified: 2, AllowListed: 1}
+	if report.Total != expectedTotal {
+		t.Errorf("incorrect total\nexpected: %+v\n     got: %+v", expectedTotal, report.Total)
+	}
+
+	// The suggestions must be readable as an allowlist file, and allowlist all the differences
+	buf := &bytes.Buffer{}
+	if err := report.writeSuggestedAllowList(buf); err != nil {
+		t.Fatal(err)
+	}
+	var suggested []jsonAllowList
+	if err := json.Unmarshal(buf.Bytes(), &suggested); err != nil {
+		t.Fatal(err)
+	}
+	expectedSuggested := []jsonAllowList{
+		{Paths: []string{"boot/ramdisk/init", "system/app/New.apk", "vendor/bin/tool"}},
+		{Paths: []string{"system/build.prop"}, IgnoreMatchingLines: []string{`^\s*ro\.build\.date\s*=.*`}},
+	}
+	if !reflect.DeepEqual(suggested, expectedSuggested) {
+		t.Errorf("incorrect suggested allowlist\nexpected: %+v\n     got: %+v", expectedSuggested, suggested)
+	}
+
+	var suggestedAllowLists []allowList
+	for _, s := range suggested {
+		for _, p := range s.Paths {
+			suggestedAllowLists = append(suggestedAllowLists, allowList{path: p, ignoreMatchingLines: s.IgnoreMatchingLines})
+		}
+	}
+	remaining, err := applyAllowLists(diff.copy(), suggestedAllowLists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(remaining, zipDiff{}) {
+		t.Errorf("expected the suggested allowlist to allowlist everything, remaining: %v", remaining.String())
+	}
+}
+
+func TestDiffReportJSONAndHTML(t *testing.T) {
+	propA := bytesToZipArtifactFile("system/build.prop", []byte("ro.a=<1>\n"))
+	propB := bytesToZipArtifactFile("system/build.prop", []byte("ro.a=<2>\n"))
+	diff := zipDiff{modified: [][2]*ZipArtifactFile{{propA, propB}}}
+
+	report, err := newDiffReport("ref.zip", "pri.zip", diff, diff, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := report.writeJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded diffReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Entries) != 1 || decoded.Entries[0].Category != categoryModified ||
+		decoded.Entries[0].Partition != "system" {
+		t.Errorf("unexpected entries in JSON report: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.writeHTML(buf); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	for _, expected := range []string{
+		`<a href="#partition-system">system</a>`,
+		`<span class="del">-ro.a=&lt;1&gt;</span>`,
+		`<span class="add">&#43;ro.a=&lt;2&gt;</span>`,
+		`Suggested allowlist`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected HTML report to contain %q:\n%s", expected, html)
+		}
+	}
+}
+
+func TestSuggestIgnoreMatchingLines(t *testing.T) {
+	ops := []diffOp{
+		{kind: ' ', line: "# comment"},
+		{kind: '-', line: "ro.build.date = Mon"},
+		{kind: '+', line: "  ro.build.date=Tue"},
+		{kind: '+', line: "ro.build.id\t= 1"},
+	}
+	patterns := suggestIgnoreMatchingLines(ops)
+	expected := []string{`^\s*ro\.build\.date\s*=.*`, `^\s*ro\.build\.id\s*=.*`}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Fatalf("expected patterns %q, got %q", expected, patterns)
+	}
+	for _, op := range ops[1:] {
+		matched := false
+		for _, pattern := range patterns {
+			if regexp.MustCompile(pattern).MatchString(op.line) {
+				matched = true
+			}
+		}
+		if !matched {
+			t.Errorf("expected %q to match the suggested patterns", op.line)
+		}
+	}
+
+	if patterns := suggestIgnoreMatchingLines([]diffOp{{kind: '+', line: "not a property"}}); patterns != nil {
+		t.Errorf("expected no patterns for lines that are not properties, got %q", patterns)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Files larger than this are not diffed.
+	maxTextDiffSize = 1 << 20
+
+	// Limit on the size of the table used to diff the lines that differ between two files.
+	maxTextDiffCells = 1 << 24
+
+	// Number of unchanged lines shown around each change.
+	textDiffContext = 3
+)
+
+// readTextFile returns the lines of a file in a zip if it is a text file that is small enough to
+// diff.
+func readTextFile(f *ZipArtifactFile) ([]string, bool, error) {
+	if f.UncompressedSize64 > maxTextDiffSize {
+		return nil, false, nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, false, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	if bytes.IndexByte(data, 0) != -1 || !utf8.Valid(data) {
+		return nil, false, nil
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, true, nil
+}
+
+// A diffOp is a line of a diff: an unchanged line (' '), a removed line ('-') or an added
+// line ('+').
+type diffOp struct {
+	kind byte
+	line string
+	// The number of lines of a and b before this line
+	aLine, bLine int
+}
+
+// diffLines returns the operations that turn a into b, or false if a and b are too large to diff.
+func diffLines(a, b []string) ([]diffOp, bool) {
+	// Only diff the lines between the common prefix and suffix
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	n, m := len(midA), len(midB)
+	if (n+1)*(m+1) > maxTextDiffCells {
+		return nil, false
+	}
+
+	// lcs[i*(m+1)+j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([]int32, (n+1)*(m+1))
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i*(m+1)+j] = lcs[(i+1)*(m+1)+j+1] + 1
+			} else {
+				lcs[i*(m+1)+j] = max(lcs[(i+1)*(m+1)+j], lcs[i*(m+1)+j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, diffOp{' ', a[i], i, i})
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && midA[i] == midB[j]:
+			ops = append(ops, diffOp{' ', midA[i], prefix + i, prefix + j})
+			i++
+			j++
+		case j == m || (i < n && lcs[(i+1)*(m+1)+j] >= lcs[i*(m+1)+j+1]):
+			ops = append(ops, diffOp{'-', midA[i], prefix + i, prefix + j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', midB[j], prefix + i, prefix + j})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ops = append(ops, diffOp{' ', a[len(a)-suffix+k], len(a) - suffix + k, len(b) - suffix + k})
+	}
+	return ops, true
+}
+
+// unifiedDiff formats the changes in ops as a unified diff with context unchanged lines around
+// each change.
+func unifiedDiff(aName, bName string, ops []diffOp, context int) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "--- %s\n+++ %s\n", aName, bName)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Merge the changes that are separated by less than twice the context
+		start := max(0, i-context)
+		end := i
+		for j := i; j < len(ops) && j-end <= 2*context; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+		stop := min(len(ops), end+context+1)
+
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		hunkStart := func(line, count int) int {
+			if count == 0 {
+				return line
+			}
+			return line + 1
+		}
+		fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", hunkStart(ops[start].aLine, aCount), aCount,
+			hunkStart(ops[start].bLine, bCount), bCount)
+		for _, op := range ops[start:stop] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return buf.String()
+}
+
+// textDiff returns a unified diff of two versions of a file if they are text files that are
+// small enough to diff.
+func textDiff(a, b *ZipArtifactFile) (string, []diffOp, error) {
+	aLines, aText, err := readTextFile(a)
+	if err != nil || !aText {
+		return "", nil, err
+	}
+	bLines, bText, err := readTextFile(b)
+	if err != nil || !bText {
+		return "", nil, err
+	}
+	ops, ok := diffLines(aLines, bLines)
+	if !ok {
+		return "", nil, nil
+	}
+	return unifiedDiff("reference/"+a.Name, "primary/"+b.Name, ops, textDiffContext), ops, nil
+}
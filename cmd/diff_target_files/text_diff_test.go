@@ -0,0 +1,106 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(s string) []string {
+		lines := strings.SplitAfter(s, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		return lines
+	}
+
+	testCases := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name:     "same",
+			a:        "a\nb\n",
+			b:        "a\nb\n",
+			expected: "",
+		},
+		{
+			name: "changed line",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n",
+			expected: "@@ -2,7 +2,7 @@\n" +
+				" 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "separate hunks",
+			a:    "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			b:    "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			expected: "@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n" +
+				"@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name:     "merged hunks",
+			a:        "a\n1\n2\nb\n",
+			b:        "A\n1\n2\nB\n",
+			expected: "@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n-b\n+B\n",
+		},
+		{
+			name:     "added to empty",
+			a:        "",
+			b:        "a\nb\n",
+			expected: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "inserted line",
+			a:        "a\nc\n",
+			b:        "a\nb\nc\n",
+			expected: "@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+		{
+			name:     "no newline at end",
+			a:        "a\nb",
+			b:        "a\nc",
+			expected: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ops, ok := diffLines(lines(test.a), lines(test.b))
+			if !ok {
+				t.Fatal("expected the files to be diffed")
+			}
+			got := strings.TrimPrefix(unifiedDiff("a", "b", ops, 3), "--- a\n+++ b\n")
+			if got != test.expected {
+				t.Errorf("incorrect diff\nexpected:\n%s\ngot:\n%s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestTextDiffSkipsBinaryFiles(t *testing.T) {
+	a := bytesToZipArtifactFile("bin", []byte("a\x00b"))
+	b := bytesToZipArtifactFile("bin", []byte("a\x00c"))
+	diff, _, err := textDiff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff != "" {
+		t.Errorf("expected no diff of binary files, got %q", diff)
+	}
+}
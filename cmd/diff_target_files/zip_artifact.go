@@ -16,6 +16,7 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
 	"fmt"
 	"hash/crc32"
@@ -23,6 +24,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 // ZipArtifact represents a zip file that may be local or remote.
@@ -49,7 +51,7 @@ func NewLocalZipArtifact(name string) (ZipArtifact, error) {
 
 	var files []*ZipArtifactFile
 	for _, zf := range zr.File {
-		files = append(files, &ZipArtifactFile{zf})
+		files = append(files, &ZipArtifactFile{File: zf})
 	}
 
 	return &localZipArtifact{
@@ -68,10 +70,101 @@ func (z *localZipArtifact) Close() {
 	z.zr.Close()
 }
 
+// nestedZipSeparator separates the name of a zip file inside a ZipArtifact from the names of the
+// files it contains, for example system/app/Foo/Foo.apk!/classes.dex.
+const nestedZipSeparator = "!/"
+
+// nestedZipArtifact is a handle to a zip file inside another ZipArtifact, for example an APK in a
+// target files zip.
+type nestedZipArtifact struct {
+	files []*ZipArtifactFile
+}
+
+// NewNestedZipArtifact returns a ZipArtifact for a zip file inside another ZipArtifact.  The names
+// of the files it contains are prefixed with the name of the zip file and nestedZipSeparator, so
+// that they can be matched by allowlists and filters, and the files are sorted by name.
+//
+// The contents of the zip file are only held in memory while its file list is read, the files
+// only keep their headers.  Opening one of the files reads the zip file again, so that comparing
+// a target files zip where every APK differs doesn't hold every APK in memory.
+func NewNestedZipArtifact(zf *ZipArtifactFile) (ZipArtifact, error) {
+	zr, err := readNestedZip(zf)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*ZipArtifactFile
+	for i, f := range zr.File {
+		i := i
+		header := f.FileHeader
+		header.Name = zf.Name + nestedZipSeparator + f.Name
+		files = append(files, &ZipArtifactFile{
+			File: &zip.File{FileHeader: header},
+			open: func() (io.ReadCloser, error) { return openNestedZipFile(zf, i) },
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return &nestedZipArtifact{
+		files: files,
+	}, nil
+}
+
+// readNestedZip reads a zip file inside another ZipArtifact into memory.
+func readNestedZip(zf *ZipArtifactFile) (*zip.Reader, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// openNestedZipFile opens the file at index in a zip file inside another ZipArtifact.  The
+// contents of the zip file are released once the returned reader is no longer used.
+func openNestedZipFile(zf *ZipArtifactFile, index int) (io.ReadCloser, error) {
+	zr, err := readNestedZip(zf)
+	if err != nil {
+		return nil, err
+	}
+	if index >= len(zr.File) {
+		return nil, fmt.Errorf("%s changed while it was being compared", zf.Name)
+	}
+	return zr.File[index].Open()
+}
+
+// Files returns the list of files contained in the nested zip file artifact.
+func (z *nestedZipArtifact) Files() ([]*ZipArtifactFile, error) {
+	return z.files, nil
+}
+
+// Close releases the nested zip file artifact.
+func (z *nestedZipArtifact) Close() {
+	z.files = nil
+}
+
 // ZipArtifactFile contains a zip.File handle to the data inside the remote *-target_files-*.zip
 // build artifact.
 type ZipArtifactFile struct {
 	*zip.File
+
+	// open opens the contents of a file in a nested zip, whose zip.File only contains the
+	// header.
+	open func() (io.ReadCloser, error)
+}
+
+// Open returns a reader of the contents of the file.
+func (zf *ZipArtifactFile) Open() (io.ReadCloser, error) {
+	if zf.open != nil {
+		return zf.open()
+	}
+	return zf.File.Open()
 }
 
 // Extract begins extract a file from inside a ZipArtifact.  It returns an
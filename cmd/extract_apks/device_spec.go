@@ -0,0 +1,185 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	android_bundle_proto "android/soong/cmd/extract_apks/bundle_proto"
+)
+
+// DeviceSpec is a device specification in the JSON format used by bundletool's --device-spec
+// flag, as written by `bundletool get-device-spec`.
+type DeviceSpec struct {
+	SupportedAbis    []string `json:"supportedAbis"`
+	SupportedLocales []string `json:"supportedLocales"`
+	DeviceFeatures   []string `json:"deviceFeatures"`
+	GlExtensions     []string `json:"glExtensions"`
+	ScreenDensity    int32    `json:"screenDensity"`
+	SdkVersion       int32    `json:"sdkVersion"`
+	Codename         string   `json:"codename"`
+
+	// CountrySet is the name of the country set of the device, used by bundletool for country
+	// set targeting.  The bundle protos here have no country set targeting, so it is ignored.
+	CountrySet string `json:"countrySet"`
+
+	// UserCountries are the two-letter CLDR codes of the countries the device is used in, which
+	// are matched against the user countries targeting of conditional modules.  This is not part
+	// of bundletool's device spec format, which has no way to set the user countries.
+	UserCountries []string `json:"userCountries"`
+}
+
+func readDeviceSpec(file string) (*DeviceSpec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	spec := &DeviceSpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return spec, nil
+}
+
+// Bundletool's names of the ABIs in device specs.
+var deviceSpecAbis = map[string]android_bundle_proto.Abi_AbiAlias{
+	"armeabi":     android_bundle_proto.Abi_ARMEABI,
+	"armeabi-v7a": android_bundle_proto.Abi_ARMEABI_V7A,
+	"arm64-v8a":   android_bundle_proto.Abi_ARM64_V8A,
+	"x86":         android_bundle_proto.Abi_X86,
+	"x86_64":      android_bundle_proto.Abi_X86_64,
+	"mips":        android_bundle_proto.Abi_MIPS,
+	"mips64":      android_bundle_proto.Abi_MIPS64,
+}
+
+// The DPI of each screen density alias, in increasing order.
+var screenDensityDpis = []struct {
+	alias android_bundle_proto.ScreenDensity_DensityAlias
+	dpi   int32
+}{
+	{android_bundle_proto.ScreenDensity_LDPI, 120},
+	{android_bundle_proto.ScreenDensity_MDPI, 160},
+	{android_bundle_proto.ScreenDensity_TVDPI, 213},
+	{android_bundle_proto.ScreenDensity_HDPI, 240},
+	{android_bundle_proto.ScreenDensity_XHDPI, 320},
+	{android_bundle_proto.ScreenDensity_XXHDPI, 480},
+	{android_bundle_proto.ScreenDensity_XXXHDPI, 640},
+}
+
+// screenDensityAliasDpi returns the DPI of a screen density alias, or 0 for NODPI.
+func screenDensityAliasDpi(alias android_bundle_proto.ScreenDensity_DensityAlias) int32 {
+	for _, d := range screenDensityDpis {
+		if d.alias == alias {
+			return d.dpi
+		}
+	}
+	return 0
+}
+
+// The OpenGL extensions that indicate support for each texture compression format.
+var textureCompressionFormatGlExtensions = map[string]android_bundle_proto.TextureCompressionFormat_TextureCompressionFormatAlias{
+	"GL_OES_compressed_ETC1_RGB8_texture":         android_bundle_proto.TextureCompressionFormat_ETC1_RGB8,
+	"GL_OES_compressed_paletted_texture":          android_bundle_proto.TextureCompressionFormat_PALETTED,
+	"GL_AMD_compressed_3DC_texture":               android_bundle_proto.TextureCompressionFormat_THREE_DC,
+	"GL_AMD_compressed_ATC_texture":               android_bundle_proto.TextureCompressionFormat_ATC,
+	"GL_ATI_texture_compression_atitc":            android_bundle_proto.TextureCompressionFormat_ATC,
+	"GL_EXT_texture_compression_latc":             android_bundle_proto.TextureCompressionFormat_LATC,
+	"GL_EXT_texture_compression_dxt1":             android_bundle_proto.TextureCompressionFormat_DXT1,
+	"GL_EXT_texture_compression_s3tc":             android_bundle_proto.TextureCompressionFormat_S3TC,
+	"GL_IMG_texture_compression_pvrtc":            android_bundle_proto.TextureCompressionFormat_PVRTC,
+	"GL_KHR_texture_compression_astc_ldr":         android_bundle_proto.TextureCompressionFormat_ASTC,
+	"GL_KHR_texture_compression_astc_hdr":         android_bundle_proto.TextureCompressionFormat_ASTC,
+	"GL_OES_texture_compression_astc":             android_bundle_proto.TextureCompressionFormat_ASTC,
+	"GL_EXT_texture_compression_astc_decode_mode": android_bundle_proto.TextureCompressionFormat_ASTC,
+}
+
+const (
+	// The device feature holding the OpenGL ES version of the device.
+	glEsVersionFeature = "reqGlEsVersion"
+
+	// ETC2 is supported by all devices with OpenGL ES 3.0.
+	etc2MinGlEsVersion = 0x30000
+)
+
+// parseDeviceFeature parses a device feature in the format of device specs, either a feature
+// name or a name and a version separated by '=', like reqGlEsVersion=0x30000.
+func parseDeviceFeature(feature string) (string, int32, error) {
+	name, version, found := strings.Cut(feature, "=")
+	if !found {
+		return name, 0, nil
+	}
+	v, err := strconv.ParseInt(version, 0, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad device feature version %q: %w", feature, err)
+	}
+	return name, int32(v), nil
+}
+
+// targetConfig returns base with the device properties from the spec.
+func (spec *DeviceSpec) targetConfig(base TargetConfig) (TargetConfig, error) {
+	config := base
+	config.sdkVersion = spec.SdkVersion
+	if spec.Codename != "" {
+		// The device runs a prerelease version of the next SDK
+		config.allowPrereleased = true
+	}
+
+	config.abis = make(map[android_bundle_proto.Abi_AbiAlias]int)
+	for i, abi := range spec.SupportedAbis {
+		alias, ok := deviceSpecAbis[abi]
+		if !ok {
+			return TargetConfig{}, fmt.Errorf("bad ABI value in device spec: %q", abi)
+		}
+		config.abis[alias] = i
+	}
+
+	config.screenDensity = spec.ScreenDensity
+
+	config.languages = make(map[string]bool)
+	for _, locale := range spec.SupportedLocales {
+		language, _, _ := strings.Cut(locale, "-")
+		config.languages[strings.ToLower(language)] = true
+	}
+
+	config.deviceFeatures = make(map[string]int32)
+	for _, feature := range spec.DeviceFeatures {
+		name, version, err := parseDeviceFeature(feature)
+		if err != nil {
+			return TargetConfig{}, err
+		}
+		config.deviceFeatures[name] = version
+	}
+
+	config.textureCompressionFormats = make(map[android_bundle_proto.TextureCompressionFormat_TextureCompressionFormatAlias]bool)
+	for _, extension := range spec.GlExtensions {
+		if format, ok := textureCompressionFormatGlExtensions[extension]; ok {
+			config.textureCompressionFormats[format] = true
+		}
+	}
+	if config.deviceFeatures[glEsVersionFeature] >= etc2MinGlEsVersion {
+		config.textureCompressionFormats[android_bundle_proto.TextureCompressionFormat_ETC2] = true
+	}
+
+	config.countries = make(map[string]bool)
+	for _, country := range spec.UserCountries {
+		config.countries[strings.ToUpper(country)] = true
+	}
+
+	return config, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bp "android/soong/cmd/extract_apks/bundle_proto"
+)
+
+func TestReadDeviceSpec(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "device-spec.json")
+	err := os.WriteFile(file, []byte(`{
+  "supportedAbis": ["arm64-v8a", "armeabi-v7a"],
+  "supportedLocales": ["en-US", "fr"],
+  "deviceFeatures": ["reqGlEsVersion=0x30002", "android.hardware.camera"],
+  "glExtensions": ["GL_OES_compressed_ETC1_RGB8_texture", "GL_KHR_texture_compression_astc_ldr", "GL_OES_EGL_image"],
+  "screenDensity": 420,
+  "sdkVersion": 33,
+  "countrySet": "latam",
+  "userCountries": ["us", "CA"]
+}`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := readDeviceSpec(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := spec.targetConfig(TargetConfig{stem: "Foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := TargetConfig{
+		sdkVersion: 33,
+		abis: map[bp.Abi_AbiAlias]int{
+			bp.Abi_ARM64_V8A:   0,
+			bp.Abi_ARMEABI_V7A: 1,
+		},
+		stem:          "Foo",
+		screenDensity: 420,
+		languages:     map[string]bool{"en": true, "fr": true},
+		textureCompressionFormats: map[bp.TextureCompressionFormat_TextureCompressionFormatAlias]bool{
+			bp.TextureCompressionFormat_ETC1_RGB8: true,
+			bp.TextureCompressionFormat_ASTC:      true,
+			bp.TextureCompressionFormat_ETC2:      true,
+		},
+		deviceFeatures: map[string]int32{
+			"reqGlEsVersion":          0x30002,
+			"android.hardware.camera": 0,
+		},
+		countries: map[string]bool{"US": true, "CA": true},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("incorrect target config\nexpected: %#v\n     got: %#v", expected, config)
+	}
+}
+
+func TestDeviceSpecErrors(t *testing.T) {
+	if _, err := (&DeviceSpec{SupportedAbis: []string{"sparc"}}).targetConfig(TargetConfig{}); err == nil {
+		t.Errorf("expected an error for an unknown ABI")
+	}
+	if _, err := (&DeviceSpec{DeviceFeatures: []string{"reqGlEsVersion=three"}}).targetConfig(TargetConfig{}); err == nil {
+		t.Errorf("expected an error for a bad feature version")
+	}
+}
@@ -42,6 +42,23 @@ type TargetConfig struct {
 	allowPrereleased bool
 	stem             string
 	skipSdkCheck     bool
+
+	// The following are only set from device specs.
+
+	// The screen density of the device in DPI.  If set, it is used instead of screenDpi.
+	screenDensity int32
+
+	// Set of the ISO-639 language codes of the device locales.  All the language splits are
+	// selected if it is empty.
+	languages map[string]bool
+	// Set of the texture compression formats supported by the device.  Only the entries without
+	// texture compression format targeting are selected if it is empty.
+	textureCompressionFormats map[android_bundle_proto.TextureCompressionFormat_TextureCompressionFormatAlias]bool
+	// Map holding <device feature name>:<feature version> info.  Device feature targeting is
+	// ignored if it is nil.
+	deviceFeatures map[string]int32
+	// Set of the two-letter codes of the countries the device is used in.
+	countries map[string]bool
 }
 
 // An APK set is a zip archive. An entry 'toc.pb' describes its contents.
@@ -147,18 +164,33 @@ func (m apkTargetingMatcher) matches(config TargetConfig, allAbisMustMatch bool)
 			languageTargetingMatcher{m.LanguageTargeting}.matches(config) &&
 			screenDensityTargetingMatcher{m.ScreenDensityTargeting}.matches(config) &&
 			sdkVersionTargetingMatcher{m.SdkVersionTargeting}.matches(config) &&
-			multiAbiTargetingMatcher{m.MultiAbiTargeting}.matches(config, allAbisMustMatch))
+			multiAbiTargetingMatcher{m.MultiAbiTargeting}.matches(config, allAbisMustMatch) &&
+			textureCompressionFormatTargetingMatcher{m.TextureCompressionFormatTargeting}.matches(config))
 }
 
 type languageTargetingMatcher struct {
 	*android_bundle_proto.LanguageTargeting
 }
 
-func (m languageTargetingMatcher) matches(_ TargetConfig) bool {
-	if m.LanguageTargeting == nil {
+func (m languageTargetingMatcher) matches(config TargetConfig) bool {
+	if m.LanguageTargeting == nil || len(config.languages) == 0 {
 		return true
 	}
-	log.Fatal("language based entry selection is not implemented")
+	if len(m.GetValue()) == 0 {
+		// The fallback for the languages without a split is selected if none of the other
+		// splits match.
+		for _, a := range m.GetAlternatives() {
+			if config.languages[strings.ToLower(a)] {
+				return false
+			}
+		}
+		return true
+	}
+	for _, v := range m.GetValue() {
+		if config.languages[strings.ToLower(v)] {
+			return true
+		}
+	}
 	return false
 }
 
@@ -178,9 +210,28 @@ type moduleTargetingMatcher struct {
 }
 
 func (m moduleTargetingMatcher) matches(config TargetConfig) bool {
-	return m.ModuleTargeting == nil ||
-		(sdkVersionTargetingMatcher{m.SdkVersionTargeting}.matches(config) &&
-			userCountriesTargetingMatcher{m.UserCountriesTargeting}.matches(config))
+	if m.ModuleTargeting == nil {
+		return true
+	}
+	for _, f := range m.GetDeviceFeatureTargeting() {
+		if !(deviceFeatureTargetingMatcher{f}.matches(config)) {
+			return false
+		}
+	}
+	return sdkVersionTargetingMatcher{m.SdkVersionTargeting}.matches(config) &&
+		userCountriesTargetingMatcher{m.UserCountriesTargeting}.matches(config)
+}
+
+type deviceFeatureTargetingMatcher struct {
+	*android_bundle_proto.DeviceFeatureTargeting
+}
+
+func (m deviceFeatureTargetingMatcher) matches(config TargetConfig) bool {
+	if m.DeviceFeatureTargeting == nil || m.RequiredFeature == nil || config.deviceFeatures == nil {
+		return true
+	}
+	version, ok := config.deviceFeatures[m.RequiredFeature.GetFeatureName()]
+	return ok && version >= m.RequiredFeature.GetFeatureVersion()
 }
 
 // A higher number means a higher priority.
@@ -301,6 +352,9 @@ func (m screenDensityTargetingMatcher) matches(config TargetConfig) bool {
 	if m.ScreenDensityTargeting == nil {
 		return true
 	}
+	if config.screenDensity > 0 {
+		return m.matchesDpi(config.screenDensity)
+	}
 	if _, ok := config.screenDpi[android_bundle_proto.ScreenDensity_DENSITY_UNSPECIFIED]; ok {
 		return true
 	}
@@ -317,6 +371,35 @@ func (m screenDensityTargetingMatcher) matches(config TargetConfig) bool {
 	return false
 }
 
+// Selects the density that is the closest to the device density, preferring higher densities, of
+// the value and the alternatives, like bundletool does.
+func (m screenDensityTargetingMatcher) matchesDpi(deviceDpi int32) bool {
+	dpi := func(d *android_bundle_proto.ScreenDensity) int32 {
+		switch x := d.GetDensityOneof().(type) {
+		case *android_bundle_proto.ScreenDensity_DensityAlias_:
+			return screenDensityAliasDpi(x.DensityAlias)
+		case *android_bundle_proto.ScreenDensity_DensityDpi:
+			return x.DensityDpi
+		}
+		return 0
+	}
+	var best int32
+	for _, d := range append(append([]*android_bundle_proto.ScreenDensity{}, m.GetValue()...), m.GetAlternatives()...) {
+		v := dpi(d)
+		if best == 0 ||
+			(v >= deviceDpi && (best < deviceDpi || v < best)) ||
+			(v < deviceDpi && best < deviceDpi && v > best) {
+			best = v
+		}
+	}
+	for _, d := range m.GetValue() {
+		if dpi(d) == best {
+			return true
+		}
+	}
+	return false
+}
+
 type sdkVersionTargetingMatcher struct {
 	*android_bundle_proto.SdkVersionTargeting
 }
@@ -347,24 +430,62 @@ type textureCompressionFormatTargetingMatcher struct {
 	*android_bundle_proto.TextureCompressionFormatTargeting
 }
 
-func (m textureCompressionFormatTargetingMatcher) matches(_ TargetConfig) bool {
+// The texture compression formats in order of preference when a device supports several of the
+// alternatives.
+var textureCompressionFormatPriorities = map[android_bundle_proto.TextureCompressionFormat_TextureCompressionFormatAlias]int{
+	android_bundle_proto.TextureCompressionFormat_ETC1_RGB8: 1,
+	android_bundle_proto.TextureCompressionFormat_PALETTED:  2,
+	android_bundle_proto.TextureCompressionFormat_THREE_DC:  3,
+	android_bundle_proto.TextureCompressionFormat_LATC:      4,
+	android_bundle_proto.TextureCompressionFormat_ATC:       5,
+	android_bundle_proto.TextureCompressionFormat_PVRTC:     6,
+	android_bundle_proto.TextureCompressionFormat_DXT1:      7,
+	android_bundle_proto.TextureCompressionFormat_S3TC:      8,
+	android_bundle_proto.TextureCompressionFormat_ETC2:      9,
+	android_bundle_proto.TextureCompressionFormat_ASTC:      10,
+}
+
+func (m textureCompressionFormatTargetingMatcher) matches(config TargetConfig) bool {
 	if m.TextureCompressionFormatTargeting == nil {
 		return true
 	}
-	log.Fatal("texture based entry selection is not implemented")
-	return false
+	// Find the highest priority supported format of the values and of the alternatives.
+	bestSupported := func(formats []*android_bundle_proto.TextureCompressionFormat) int {
+		best := 0
+		for _, f := range formats {
+			if config.textureCompressionFormats[f.Alias] && textureCompressionFormatPriorities[f.Alias] > best {
+				best = textureCompressionFormatPriorities[f.Alias]
+			}
+		}
+		return best
+	}
+	alternative := bestSupported(m.GetAlternatives())
+	if len(m.GetValue()) == 0 {
+		// The fallback is selected if the device supports none of the alternatives.
+		return alternative == 0
+	}
+	value := bestSupported(m.GetValue())
+	return value > 0 && value >= alternative
 }
 
 type userCountriesTargetingMatcher struct {
 	*android_bundle_proto.UserCountriesTargeting
 }
 
-func (m userCountriesTargetingMatcher) matches(_ TargetConfig) bool {
+// Matches if any of the countries of the device is in the country codes, or none of them is when
+// the list is exclusive.  A device without countries only matches exclusive lists.
+func (m userCountriesTargetingMatcher) matches(config TargetConfig) bool {
 	if m.UserCountriesTargeting == nil {
 		return true
 	}
-	log.Fatal("country based entry selection is not implemented")
-	return false
+	listed := false
+	for _, c := range m.GetCountryCodes() {
+		if config.countries[strings.ToUpper(c)] {
+			listed = true
+			break
+		}
+	}
+	return listed != m.GetExclude()
 }
 
 type variantTargetingMatcher struct {
@@ -516,19 +637,31 @@ func (apkSet *ApkSet) extractAndCopySingle(selected SelectionResult, outFile *os
 
 // Arguments parsing
 var (
-	outputFile   = flag.String("o", "", "output file for primary entry")
-	zipFile      = flag.String("zip", "", "output file containing additional extracted entries")
+	outputFiles  listFlagValue
+	zipFiles     listFlagValue
 	targetConfig = TargetConfig{
 		screenDpi: map[android_bundle_proto.ScreenDensity_DensityAlias]bool{},
 		abis:      map[android_bundle_proto.Abi_AbiAlias]int{},
 	}
 	extractSingle = flag.Bool("extract-single", false,
 		"extract a single target and output it uncompressed. only available for standalone apks and apexes.")
-	apkcertsOutput = flag.String("apkcerts", "",
-		"optional apkcerts.txt output file containing signing info of all outputted apks")
-	partition = flag.String("partition", "", "partition string. required when -apkcerts is used.")
+	apkcertsOutputs listFlagValue
+	partition       = flag.String("partition", "", "partition string. required when -apkcerts is used.")
+	deviceSpecs     listFlagValue
 )
 
+// Parse repeated values
+type listFlagValue []string
+
+func (l *listFlagValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlagValue) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 // Parse abi values
 type abiFlagValue struct {
 	targetConfig *TargetConfig
@@ -579,12 +712,20 @@ func (s screenDensityFlagValue) Set(densityList string) error {
 func processArgs() {
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, `usage: extract_apks -o <output-file> [-zip <output-zip-file>] `+
-			`-sdk-version value -abis value [-skip-sdk-check]`+
-			`-screen-densities value {-stem value | -extract-single} [-allow-prereleased] `+
+			`{-sdk-version value -abis value -screen-densities value | -device-spec <device-spec.json>} `+
+			`[-skip-sdk-check] {-stem value | -extract-single} [-allow-prereleased] `+
 			`[-apkcerts <apkcerts output file> -partition <partition>] <APK set>`)
+		fmt.Fprintln(os.Stderr, `-device-spec can be repeated to extract the entries for several devices, `+
+			`then -o, -zip and -apkcerts must be repeated once per device spec, in the same order.`)
 		flag.PrintDefaults()
 		os.Exit(2)
 	}
+	flag.Var(&outputFiles, "o", "output file for primary entry")
+	flag.Var(&zipFiles, "zip", "output file containing additional extracted entries")
+	flag.Var(&apkcertsOutputs, "apkcerts",
+		"optional apkcerts.txt output file containing signing info of all outputted apks")
+	flag.Var(&deviceSpecs, "device-spec",
+		"bundletool device spec JSON file describing the target device, instead of -sdk-version, -abis and -screen-densities")
 	version := flag.Uint("sdk-version", 0, "SDK version")
 	flag.Var(abiFlagValue{&targetConfig}, "abis",
 		"comma-separated ABIs list of ARMEABI ARMEABI_V7A ARM64_V8A X86 X86_64 MIPS MIPS64")
@@ -595,15 +736,37 @@ func processArgs() {
 	flag.BoolVar(&targetConfig.skipSdkCheck, "skip-sdk-check", false, "Skip the SDK version check")
 	flag.StringVar(&targetConfig.stem, "stem", "", "output entries base name in the output zip file")
 	flag.Parse()
-	if (*outputFile == "") || len(flag.Args()) != 1 || *version == 0 ||
-		((targetConfig.stem == "" || *zipFile == "") && !*extractSingle) ||
-		(*apkcertsOutput != "" && *partition == "") {
+	devices := max(len(deviceSpecs), 1)
+	if len(outputFiles) != devices || len(flag.Args()) != 1 || (*version == 0 && len(deviceSpecs) == 0) ||
+		((targetConfig.stem == "" || len(zipFiles) != devices) && !*extractSingle) ||
+		(len(apkcertsOutputs) != 0 && (len(apkcertsOutputs) != devices || *partition == "")) {
 		flag.Usage()
 	}
 	targetConfig.sdkVersion = int32(*version)
 
 }
 
+// targetConfigs returns the target configuration of each device spec, or the configuration from
+// the flags if there are no device specs.
+func targetConfigs() ([]TargetConfig, error) {
+	if len(deviceSpecs) == 0 {
+		return []TargetConfig{targetConfig}, nil
+	}
+	var configs []TargetConfig
+	for _, file := range deviceSpecs {
+		spec, err := readDeviceSpec(file)
+		if err != nil {
+			return nil, err
+		}
+		config, err := spec.targetConfig(targetConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
 func main() {
 	processArgs()
 	var toc Toc
@@ -615,51 +778,70 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	sel := selectApks(toc, targetConfig)
+	configs, err := targetConfigs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i, config := range configs {
+		var zipFile, apkcertsOutput string
+		if len(zipFiles) > 0 {
+			zipFile = zipFiles[i]
+		}
+		if len(apkcertsOutputs) > 0 {
+			apkcertsOutput = apkcertsOutputs[i]
+		}
+		if err := apkSet.extract(toc, config, outputFiles[i], zipFile, apkcertsOutput); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// Extracts the entries matching the target configuration into outputFile and zipFile
+func (apkSet *ApkSet) extract(toc Toc, config TargetConfig, outputFile, zipFile, apkcertsOutput string) error {
+	sel := selectApks(toc, config)
 	if len(sel.entries) == 0 {
-		log.Fatalf("there are no entries for the target configuration: %#v", targetConfig)
+		return fmt.Errorf("there are no entries for the target configuration: %#v", config)
 	}
 
-	outFile, err := os.Create(*outputFile)
+	outFile, err := os.Create(outputFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer outFile.Close()
 
 	if *extractSingle {
-		err = apkSet.extractAndCopySingle(sel, outFile)
-	} else {
-		zipOutputFile, err := os.Create(*zipFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer zipOutputFile.Close()
+		return apkSet.extractAndCopySingle(sel, outFile)
+	}
 
-		zipWriter := zip.NewWriter(zipOutputFile)
-		defer func() {
-			if err := zipWriter.Close(); err != nil {
-				log.Fatal(err)
-			}
-		}()
+	zipOutputFile, err := os.Create(zipFile)
+	if err != nil {
+		return err
+	}
+	defer zipOutputFile.Close()
 
-		apkcerts, err := apkSet.writeApks(sel, targetConfig, outFile, zipWriter, *partition)
-		if err == nil && *apkcertsOutput != "" {
-			apkcertsFile, err := os.Create(*apkcertsOutput)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer apkcertsFile.Close()
-			for _, a := range apkcerts {
-				_, err = apkcertsFile.WriteString(a + "\n")
-				if err != nil {
-					log.Fatal(err)
-				}
+	zipWriter := zip.NewWriter(zipOutputFile)
+	apkcerts, err := apkSet.writeApks(sel, config, outFile, zipWriter, *partition)
+	if err != nil {
+		return err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+
+	if apkcertsOutput != "" {
+		apkcertsFile, err := os.Create(apkcertsOutput)
+		if err != nil {
+			return err
+		}
+		defer apkcertsFile.Close()
+		for _, a := range apkcerts {
+			if _, err := apkcertsFile.WriteString(a + "\n"); err != nil {
+				return err
 			}
 		}
 	}
-	if err != nil {
-		log.Fatal(err)
-	}
+	return nil
 }
 
 func writeZipEntryToFile(outFile io.Writer, zipEntry *zip.File) error {
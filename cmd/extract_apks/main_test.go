@@ -788,6 +788,238 @@ variant {
 	}
 }
 
+func TestSelectApks_DeviceSpec(t *testing.T) {
+	protoText := `
+variant {
+	targeting {
+		sdk_version_targeting {value {min {value: 21}}}
+		texture_compression_format_targeting {
+			value {alias: ASTC}
+			alternatives {alias: ETC2}
+		}
+	}
+	apk_set {
+		module_metadata {name: "base" targeting {} delivery_type: INSTALL_TIME}
+		apk_description {
+			targeting {sdk_version_targeting {value {min {value: 21}}}}
+			path: "splits/base-master.apk"
+			split_apk_metadata {is_master_split: true}
+		}
+		apk_description {
+			targeting {language_targeting {value: "fr"}}
+			path: "splits/base-fr.apk"
+			split_apk_metadata {split_id: "config.fr"}
+		}
+		apk_description {
+			targeting {language_targeting {value: "de"}}
+			path: "splits/base-de.apk"
+			split_apk_metadata {split_id: "config.de"}
+		}
+		apk_description {
+			targeting {language_targeting {alternatives: "fr" alternatives: "de"}}
+			path: "splits/base-other_lang.apk"
+			split_apk_metadata {split_id: "config.other_lang"}
+		}
+		apk_description {
+			targeting {
+				screen_density_targeting {
+					value {density_alias: XHDPI}
+					alternatives {density_alias: XXHDPI}
+				}
+			}
+			path: "splits/base-xhdpi.apk"
+			split_apk_metadata {split_id: "config.xhdpi"}
+		}
+		apk_description {
+			targeting {
+				screen_density_targeting {
+					value {density_alias: XXHDPI}
+					alternatives {density_alias: XHDPI}
+				}
+			}
+			path: "splits/base-xxhdpi.apk"
+			split_apk_metadata {split_id: "config.xxhdpi"}
+		}
+	}
+}
+variant {
+	targeting {
+		sdk_version_targeting {value {min {value: 21}}}
+		texture_compression_format_targeting {
+			value {alias: ETC2}
+			alternatives {alias: ASTC}
+		}
+	}
+	apk_set {
+		module_metadata {name: "base" targeting {} delivery_type: INSTALL_TIME}
+		apk_description {
+			targeting {}
+			path: "etc2/base-master.apk"
+			split_apk_metadata {is_master_split: true}
+		}
+	}
+}
+variant {
+	targeting {
+		sdk_version_targeting {value {min {value: 21}}}
+		texture_compression_format_targeting {
+			alternatives {alias: ASTC}
+			alternatives {alias: ETC2}
+		}
+	}
+	apk_set {
+		module_metadata {name: "base" targeting {} delivery_type: INSTALL_TIME}
+		apk_description {
+			targeting {}
+			path: "fallback/base-master.apk"
+			split_apk_metadata {is_master_split: true}
+		}
+	}
+}`
+
+	testCases := []struct {
+		name     string
+		spec     DeviceSpec
+		expected SelectionResult
+	}{
+		{
+			name: "astc french xxhdpi",
+			spec: DeviceSpec{
+				SdkVersion:       30,
+				SupportedAbis:    []string{"arm64-v8a"},
+				SupportedLocales: []string{"fr-FR"},
+				DeviceFeatures:   []string{"reqGlEsVersion=0x30000"},
+				GlExtensions:     []string{"GL_KHR_texture_compression_astc_ldr"},
+				ScreenDensity:    420,
+			},
+			expected: SelectionResult{
+				"base",
+				[]string{"splits/base-master.apk", "splits/base-fr.apk", "splits/base-xxhdpi.apk"},
+			},
+		},
+		{
+			name: "astc english xhdpi",
+			spec: DeviceSpec{
+				SdkVersion:       30,
+				SupportedLocales: []string{"en-US"},
+				GlExtensions:     []string{"GL_KHR_texture_compression_astc_ldr"},
+				ScreenDensity:    320,
+			},
+			expected: SelectionResult{
+				"base",
+				[]string{"splits/base-master.apk", "splits/base-other_lang.apk", "splits/base-xhdpi.apk"},
+			},
+		},
+		{
+			name: "etc2",
+			spec: DeviceSpec{
+				SdkVersion:     30,
+				DeviceFeatures: []string{"reqGlEsVersion=0x30000"},
+			},
+			expected: SelectionResult{
+				"base",
+				[]string{"etc2/base-master.apk"},
+			},
+		},
+		{
+			name: "fallback",
+			spec: DeviceSpec{
+				SdkVersion:     30,
+				DeviceFeatures: []string{"reqGlEsVersion=0x20000"},
+			},
+			expected: SelectionResult{
+				"base",
+				[]string{"fallback/base-master.apk"},
+			},
+		},
+	}
+
+	var toc bp.BuildApksResult
+	if err := prototext.Unmarshal([]byte(protoText), &toc); err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := testCase.spec.targetConfig(TargetConfig{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := selectApks(&toc, config)
+			if !reflect.DeepEqual(testCase.expected, actual) {
+				t.Errorf("expected %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestModuleTargeting_DeviceSpec(t *testing.T) {
+	protoText := `
+variant {
+	apk_set {
+		module_metadata {
+			name: "feature_vulkan"
+			targeting {device_feature_targeting {required_feature {feature_name: "android.hardware.vulkan.version" feature_version: 4198400}}}
+			delivery_type: INSTALL_TIME
+		}
+		apk_description {targeting {} path: "splits/feature_vulkan-master.apk"}
+	}
+	apk_set {
+		module_metadata {
+			name: "feature_eu"
+			targeting {user_countries_targeting {country_codes: "FR" country_codes: "DE"}}
+			delivery_type: INSTALL_TIME
+		}
+		apk_description {targeting {} path: "splits/feature_eu-master.apk"}
+	}
+	apk_set {
+		module_metadata {
+			name: "base"
+			targeting {user_countries_targeting {country_codes: "FR" country_codes: "DE" exclude: true}}
+			delivery_type: INSTALL_TIME
+		}
+		apk_description {targeting {} path: "splits/base-master.apk"}
+	}
+}`
+
+	testCases := []struct {
+		name     string
+		spec     DeviceSpec
+		expected string
+	}{
+		{
+			name:     "vulkan",
+			spec:     DeviceSpec{DeviceFeatures: []string{"android.hardware.vulkan.version=0x401000"}},
+			expected: "feature_vulkan",
+		},
+		{
+			name:     "old vulkan in germany",
+			spec:     DeviceSpec{DeviceFeatures: []string{"android.hardware.vulkan.version=0x400000"}, UserCountries: []string{"de"}},
+			expected: "feature_eu",
+		},
+		{
+			name:     "no country",
+			spec:     DeviceSpec{},
+			expected: "base",
+		},
+	}
+
+	var toc bp.BuildApksResult
+	if err := prototext.Unmarshal([]byte(protoText), &toc); err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config, err := testCase.spec.targetConfig(TargetConfig{skipSdkCheck: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := selectApks(&toc, config).moduleName; actual != testCase.expected {
+				t.Errorf("expected module %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
 type testZip2ZipWriter struct {
 	entries map[string]string
 }
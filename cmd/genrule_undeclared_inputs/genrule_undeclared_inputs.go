@@ -0,0 +1,158 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// genrule_undeclared_inputs compares the files read by the commands of a genrule, as traced by
+// sbox --file-access-trace, with the inputs declared in its Android.bp, and reports the inputs that
+// were not declared.  With --merge it combines the per-module reports into a single JSON report
+// and a human readable text report.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"android/soong/response"
+)
+
+type multiString []string
+
+func (ms *multiString) String() string     { return strings.Join(*ms, ", ") }
+func (ms *multiString) Set(s string) error { *ms = append(*ms, s); return nil }
+
+var (
+	merge      = flag.Bool("merge", false, "merge per-module reports instead of creating one")
+	output     = flag.String("o", "", "output JSON report")
+	textOutput = flag.String("text", "", "output text report, only used with --merge")
+
+	module     = flag.String("module", "", "name of the genrule module")
+	blueprint  = flag.String("blueprint", "", "path to the Android.bp file that defines the module")
+	declared   = flag.String("declared", "", "file containing the declared inputs of the module, one per line")
+	outDir     = flag.String("out-dir", "out", "the build output directory")
+	ownOutDir  = flag.String("module-out-dir", "", "the output directory of the module, whose files are ignored")
+	sandboxed  = flag.Bool("sandboxed", false, "whether the inputs of the module were sandboxed")
+	denyListed = flag.Bool("deny-listed", false, "whether the module is in the genrule sandboxing deny list")
+
+	ignorePrefixes multiString
+)
+
+func init() {
+	flag.Var(&ignorePrefixes, "ignore", "ignore accesses to files under this prefix, may be repeated")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: genrule_undeclared_inputs -module <name> -blueprint <Android.bp> -declared <file> -o <report.json> <traces>...\n")
+	fmt.Fprintf(os.Stderr, "       genrule_undeclared_inputs -merge -o <report.json> -text <report.txt> <reports or @rspfile>...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *output == "" {
+		usage()
+	}
+
+	files, err := expandRspFiles(flag.Args())
+	if err != nil {
+		fatal(err)
+	}
+
+	if *merge {
+		reports, err := readModuleReports(files)
+		if err != nil {
+			fatal(err)
+		}
+		if err := writeMergedReports(*output, *textOutput, reports); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if *module == "" || *declared == "" {
+		usage()
+	}
+
+	declaredInputs, err := readLines(*declared)
+	if err != nil {
+		fatal(err)
+	}
+
+	var accessed []string
+	for _, trace := range files {
+		lines, err := readLines(trace)
+		if err != nil {
+			fatal(err)
+		}
+		accessed = append(accessed, lines...)
+	}
+
+	report := newModuleReport(moduleInfo{
+		name:         *module,
+		blueprint:    *blueprint,
+		outDir:       *outDir,
+		moduleOutDir: *ownOutDir,
+		sandboxed:    *sandboxed,
+		denyListed:   *denyListed,
+		ignore:       ignorePrefixes,
+	}, declaredInputs, accessed)
+
+	if err := writeJSON(*output, report); err != nil {
+		fatal(err)
+	}
+}
+
+// expandRspFiles replaces any arguments that start with @ with the contents of the rsp file.
+func expandRspFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			files = append(files, arg)
+			continue
+		}
+		f, err := os.Open(strings.TrimPrefix(arg, "@"))
+		if err != nil {
+			return nil, err
+		}
+		list, err := response.ReadRspFile(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, list...)
+	}
+	return files, nil
+}
+
+func readLines(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "genrule_undeclared_inputs: %s\n", err)
+	os.Exit(1)
+}
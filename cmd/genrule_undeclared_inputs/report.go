@@ -0,0 +1,240 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// moduleInfo describes the genrule module whose traces are being checked.
+type moduleInfo struct {
+	name         string
+	blueprint    string
+	outDir       string
+	moduleOutDir string
+	sandboxed    bool
+	denyListed   bool
+	ignore       []string
+}
+
+// moduleReport is the report for a single genrule module.
+type moduleReport struct {
+	Module    string `json:"module"`
+	Blueprint string `json:"blueprint"`
+	ModuleDir string `json:"module_dir"`
+
+	// Whether the inputs of the module were copied into the sbox sandbox.
+	Sandboxed bool `json:"sandboxed"`
+	// Whether the module is listed in SandboxingDenyModuleList in genrule/allowlists.go.
+	InSandboxingDenyList bool `json:"in_sandboxing_deny_list"`
+
+	// Source files that were read but are not declared in srcs, tool_files or tools.
+	UndeclaredSources []string `json:"undeclared_sources,omitempty"`
+	// Generated files that were read but are not provided by a dependency in srcs or tools.
+	UndeclaredGenerated []string `json:"undeclared_generated,omitempty"`
+
+	// Entries that could be added to the srcs property of the module to declare the undeclared
+	// sources that are inside the module's directory.  Undeclared sources in other directories
+	// need a filegroup in the Android.bp file of that directory.
+	SuggestedSrcs []string `json:"suggested_srcs,omitempty"`
+
+	// True if the module is in the sandboxing deny list but didn't read any undeclared inputs,
+	// and so can be removed from it.
+	CanRemoveFromDenyList bool `json:"can_remove_from_deny_list"`
+}
+
+func (r *moduleReport) hasUndeclaredInputs() bool {
+	return len(r.UndeclaredSources) > 0 || len(r.UndeclaredGenerated) > 0
+}
+
+// newModuleReport creates a report for a module from its declared inputs and the files that were
+// read by its commands.
+func newModuleReport(info moduleInfo, declared, accessed []string) *moduleReport {
+	report := &moduleReport{
+		Module:               info.name,
+		Blueprint:            info.blueprint,
+		ModuleDir:            filepath.Dir(info.blueprint),
+		Sandboxed:            info.sandboxed,
+		InSandboxingDenyList: info.denyListed,
+	}
+
+	declaredSet := make(map[string]bool)
+	for _, path := range declared {
+		declaredSet[filepath.Clean(path)] = true
+	}
+
+	isUnder := func(path, dir string) bool {
+		dir = strings.TrimSuffix(dir, "/")
+		return dir != "" && strings.HasPrefix(path, dir+"/")
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range accessed {
+		path = filepath.Clean(path)
+		if seen[path] || declaredSet[path] {
+			continue
+		}
+		seen[path] = true
+
+		// Absolute paths are outside the source tree, for example host libraries.  They can't be
+		// declared in an Android.bp file.
+		if filepath.IsAbs(path) {
+			continue
+		}
+		if isUnder(path, info.moduleOutDir) {
+			continue
+		}
+		ignored := false
+		for _, prefix := range info.ignore {
+			if strings.HasPrefix(path, prefix) {
+				ignored = true
+				break
+			}
+		}
+		if ignored {
+			continue
+		}
+
+		if isUnder(path, info.outDir) {
+			report.UndeclaredGenerated = append(report.UndeclaredGenerated, path)
+		} else {
+			report.UndeclaredSources = append(report.UndeclaredSources, path)
+			if rel, err := filepath.Rel(report.ModuleDir, path); err == nil && !strings.HasPrefix(rel, "../") {
+				report.SuggestedSrcs = append(report.SuggestedSrcs, rel)
+			}
+		}
+	}
+
+	sort.Strings(report.UndeclaredSources)
+	sort.Strings(report.UndeclaredGenerated)
+	sort.Strings(report.SuggestedSrcs)
+
+	report.CanRemoveFromDenyList = info.denyListed && !report.hasUndeclaredInputs()
+
+	return report
+}
+
+func readModuleReports(files []string) ([]*moduleReport, error) {
+	var reports []*moduleReport
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var report moduleReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+		}
+		reports = append(reports, &report)
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Blueprint != reports[j].Blueprint {
+			return reports[i].Blueprint < reports[j].Blueprint
+		}
+		return reports[i].Module < reports[j].Module
+	})
+	return reports, nil
+}
+
+// writeMergedReports writes the reports of all modules that read undeclared inputs or that can be
+// removed from the deny list to a single JSON file, and optionally to a text file.
+func writeMergedReports(jsonFile, textFile string, reports []*moduleReport) error {
+	interesting := []*moduleReport{}
+	for _, report := range reports {
+		if report.hasUndeclaredInputs() || report.InSandboxingDenyList {
+			interesting = append(interesting, report)
+		}
+	}
+
+	if err := writeJSON(jsonFile, interesting); err != nil {
+		return err
+	}
+
+	if textFile != "" {
+		buf := &bytes.Buffer{}
+		writeTextReport(buf, interesting)
+		if err := os.WriteFile(textFile, buf.Bytes(), 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTextReport(w io.Writer, reports []*moduleReport) {
+	var removable []string
+	count := 0
+	for _, report := range reports {
+		if report.CanRemoveFromDenyList {
+			removable = append(removable, report.Module)
+		}
+		if !report.hasUndeclaredInputs() {
+			continue
+		}
+		count++
+
+		fmt.Fprintf(w, "%s: %s", report.Blueprint, report.Module)
+		if report.InSandboxingDenyList {
+			fmt.Fprintf(w, " (in sandboxing deny list)")
+		}
+		fmt.Fprintln(w)
+
+		if len(report.UndeclaredSources) > 0 {
+			fmt.Fprintln(w, "    undeclared source inputs:")
+			for _, path := range report.UndeclaredSources {
+				fmt.Fprintf(w, "        %s\n", path)
+			}
+		}
+		if len(report.UndeclaredGenerated) > 0 {
+			fmt.Fprintln(w, "    undeclared generated inputs, add the modules that produce them to srcs or tools:")
+			for _, path := range report.UndeclaredGenerated {
+				fmt.Fprintf(w, "        %s\n", path)
+			}
+		}
+		if len(report.SuggestedSrcs) > 0 {
+			quoted := make([]string, len(report.SuggestedSrcs))
+			for i, src := range report.SuggestedSrcs {
+				quoted[i] = fmt.Sprintf("%q", src)
+			}
+			fmt.Fprintf(w, "    suggested srcs additions: [%s]\n", strings.Join(quoted, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "%d genrule modules read undeclared inputs.\n", count)
+
+	if len(removable) > 0 {
+		sort.Strings(removable)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Modules that can be removed from SandboxingDenyModuleList in genrule/allowlists.go:")
+		for _, name := range removable {
+			fmt.Fprintf(w, "    %q,\n", name)
+		}
+	}
+}
+
+func writeJSON(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0666)
+}
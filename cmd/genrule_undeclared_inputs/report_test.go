@@ -0,0 +1,118 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNewModuleReport(t *testing.T) {
+	info := moduleInfo{
+		name:         "foo",
+		blueprint:    "external/foo/Android.bp",
+		outDir:       "out",
+		moduleOutDir: "out/soong/.intermediates/external/foo/foo",
+		sandboxed:    false,
+		denyListed:   true,
+		ignore:       []string{"prebuilts/build-tools/"},
+	}
+
+	declared := []string{
+		"external/foo/declared.txt",
+		"out/soong/.intermediates/external/bar/bar/gen/bar.h",
+	}
+
+	accessed := []string{
+		"external/foo/declared.txt",
+		"external/foo/include/undeclared.h",
+		"external/baz/other.h",
+		"out/soong/.intermediates/external/bar/bar/gen/bar.h",
+		"out/soong/.intermediates/external/baz/baz/gen/baz.h",
+		"out/soong/.intermediates/external/foo/foo/gen/foo.h",
+		"prebuilts/build-tools/linux-x86/bin/py3",
+		"/usr/lib/x86_64-linux-gnu/libc.so.6",
+		"external/foo/include/undeclared.h",
+	}
+
+	got := newModuleReport(info, declared, accessed)
+	want := &moduleReport{
+		Module:               "foo",
+		Blueprint:            "external/foo/Android.bp",
+		ModuleDir:            "external/foo",
+		Sandboxed:            false,
+		InSandboxingDenyList: true,
+		UndeclaredSources: []string{
+			"external/baz/other.h",
+			"external/foo/include/undeclared.h",
+		},
+		UndeclaredGenerated: []string{
+			"out/soong/.intermediates/external/baz/baz/gen/baz.h",
+		},
+		SuggestedSrcs:         []string{"include/undeclared.h"},
+		CanRemoveFromDenyList: false,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want:\n%#v\ngot:\n%#v", want, got)
+	}
+
+	clean := newModuleReport(info, declared, []string{"external/foo/declared.txt"})
+	if !clean.CanRemoveFromDenyList {
+		t.Errorf("expected a deny listed module with no undeclared inputs to be removable")
+	}
+}
+
+func TestWriteTextReport(t *testing.T) {
+	reports := []*moduleReport{
+		{
+			Module:               "foo",
+			Blueprint:            "external/foo/Android.bp",
+			ModuleDir:            "external/foo",
+			Sandboxed:            true,
+			UndeclaredSources:    []string{"external/foo/a.h"},
+			UndeclaredGenerated:  []string{"out/soong/b.h"},
+			SuggestedSrcs:        []string{"a.h"},
+			InSandboxingDenyList: false,
+		},
+		{
+			Module:                "bar",
+			Blueprint:             "external/bar/Android.bp",
+			ModuleDir:             "external/bar",
+			InSandboxingDenyList:  true,
+			CanRemoveFromDenyList: true,
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	writeTextReport(buf, reports)
+
+	want := `external/foo/Android.bp: foo
+    undeclared source inputs:
+        external/foo/a.h
+    undeclared generated inputs, add the modules that produce them to srcs or tools:
+        out/soong/b.h
+    suggested srcs additions: ["a.h"]
+
+1 genrule modules read undeclared inputs.
+
+Modules that can be removed from SandboxingDenyModuleList in genrule/allowlists.go:
+    "bar",
+`
+	if g := buf.String(); g != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, g)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// jacoco_lcov converts a coverage report in the jacoco XML format to the LCOV tracefile format.
+// Jacoco only records whether each instruction and branch was executed, not how many times, so
+// the counts in the tracefile are 1 for covered lines, functions and branches and 0 otherwise.
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+type report struct {
+	Name     string  `xml:"name,attr"`
+	Groups   []group `xml:"group"`
+	Packages []pkg   `xml:"package"`
+}
+
+// Reports with more than one group of classes put the packages in groups, which may be nested.
+type group struct {
+	Groups   []group `xml:"group"`
+	Packages []pkg   `xml:"package"`
+}
+
+type pkg struct {
+	Name        string       `xml:"name,attr"`
+	Classes     []class      `xml:"class"`
+	SourceFiles []sourceFile `xml:"sourcefile"`
+}
+
+type class struct {
+	Name           string   `xml:"name,attr"`
+	SourceFileName string   `xml:"sourcefilename,attr"`
+	Methods        []method `xml:"method"`
+}
+
+type method struct {
+	Name     string    `xml:"name,attr"`
+	Desc     string    `xml:"desc,attr"`
+	Line     int       `xml:"line,attr"`
+	Counters []counter `xml:"counter"`
+}
+
+type counter struct {
+	Type    string `xml:"type,attr"`
+	Missed  int    `xml:"missed,attr"`
+	Covered int    `xml:"covered,attr"`
+}
+
+type sourceFile struct {
+	Name  string `xml:"name,attr"`
+	Lines []line `xml:"line"`
+}
+
+type line struct {
+	Number              int `xml:"nr,attr"`
+	MissedInstructions  int `xml:"mi,attr"`
+	CoveredInstructions int `xml:"ci,attr"`
+	MissedBranches      int `xml:"mb,attr"`
+	CoveredBranches     int `xml:"cb,attr"`
+}
+
+func readReport(r io.Reader) (*report, error) {
+	// The DOCTYPE of the report refers to report.dtd, which encoding/xml ignores.
+	var rep report
+	if err := xml.NewDecoder(r).Decode(&rep); err != nil {
+		return nil, fmt.Errorf("failed to parse jacoco report: %w", err)
+	}
+	return &rep, nil
+}
+
+func (g group) packages() []pkg {
+	ret := append([]pkg(nil), g.Packages...)
+	for _, child := range g.Groups {
+		ret = append(ret, child.packages()...)
+	}
+	return ret
+}
+
+func (m method) covered() bool {
+	for _, c := range m.Counters {
+		if c.Type == "METHOD" {
+			return c.Covered > 0
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// function is a method of a class in a source file.
+type function struct {
+	line    int
+	name    string
+	covered bool
+}
+
+// writeLcov writes a tracefile record for each source file in the report.
+func writeLcov(w io.Writer, rep *report) error {
+	bw := bufio.NewWriter(w)
+	packages := group{Groups: rep.Groups, Packages: rep.Packages}.packages()
+
+	for _, p := range packages {
+		// The methods are listed in the classes, find the ones that are in each source file.
+		functions := make(map[string][]function)
+		for _, c := range p.Classes {
+			for _, m := range c.Methods {
+				if m.Line == 0 {
+					// Synthetic methods have no line numbers.
+					continue
+				}
+				functions[c.SourceFileName] = append(functions[c.SourceFileName], function{
+					line:    m.Line,
+					name:    c.Name + "." + m.Name + m.Desc,
+					covered: m.covered(),
+				})
+			}
+		}
+
+		for _, sf := range p.SourceFiles {
+			path := sf.Name
+			if p.Name != "" {
+				path = p.Name + "/" + sf.Name
+			}
+			fmt.Fprintf(bw, "TN:%s\n", rep.Name)
+			fmt.Fprintf(bw, "SF:%s\n", path)
+
+			functionsHit := 0
+			for _, fn := range functions[sf.Name] {
+				fmt.Fprintf(bw, "FN:%d,%s\n", fn.line, fn.name)
+			}
+			for _, fn := range functions[sf.Name] {
+				fmt.Fprintf(bw, "FNDA:%d,%s\n", boolToInt(fn.covered), fn.name)
+				functionsHit += boolToInt(fn.covered)
+			}
+			fmt.Fprintf(bw, "FNF:%d\n", len(functions[sf.Name]))
+			fmt.Fprintf(bw, "FNH:%d\n", functionsHit)
+
+			branches, branchesHit := 0, 0
+			for _, l := range sf.Lines {
+				for i := 0; i < l.MissedBranches+l.CoveredBranches; i++ {
+					// A branch on a line that was never executed is "-" rather than 0.
+					taken := "-"
+					if l.CoveredInstructions > 0 {
+						taken = strconv.Itoa(boolToInt(i < l.CoveredBranches))
+					}
+					fmt.Fprintf(bw, "BRDA:%d,0,%d,%s\n", l.Number, i, taken)
+				}
+				branches += l.MissedBranches + l.CoveredBranches
+				branchesHit += l.CoveredBranches
+			}
+			fmt.Fprintf(bw, "BRF:%d\n", branches)
+			fmt.Fprintf(bw, "BRH:%d\n", branchesHit)
+
+			linesHit := 0
+			for _, l := range sf.Lines {
+				executed := boolToInt(l.CoveredInstructions > 0)
+				fmt.Fprintf(bw, "DA:%d,%d\n", l.Number, executed)
+				linesHit += executed
+			}
+			fmt.Fprintf(bw, "LF:%d\n", len(sf.Lines))
+			fmt.Fprintf(bw, "LH:%d\n", linesHit)
+			fmt.Fprintln(bw, "end_of_record")
+		}
+	}
+
+	return bw.Flush()
+}
+
+func main() {
+	out := flag.String("o", "", "output LCOV tracefile")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: jacoco_lcov -o out.lcov report.xml")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *out == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := func() error {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		rep, err := readReport(f)
+		if err != nil {
+			return err
+		}
+
+		w, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := writeLcov(w, rep); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
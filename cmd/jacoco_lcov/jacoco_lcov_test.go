@@ -0,0 +1,127 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testReport = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<!DOCTYPE report PUBLIC "-//JACOCO//DTD Report 1.1//EN" "report.dtd">
+<report name="foo">
+  <sessioninfo id="host-1" start="1" dump="2"/>
+  <package name="com/example">
+    <class name="com/example/Foo" sourcefilename="Foo.java">
+      <method name="&lt;init&gt;" desc="()V" line="3">
+        <counter type="INSTRUCTION" missed="0" covered="3"/>
+        <counter type="METHOD" missed="0" covered="1"/>
+      </method>
+      <method name="bar" desc="(Z)I" line="5">
+        <counter type="INSTRUCTION" missed="2" covered="4"/>
+        <counter type="BRANCH" missed="1" covered="1"/>
+        <counter type="METHOD" missed="0" covered="1"/>
+      </method>
+      <method name="baz" desc="()V" line="12">
+        <counter type="INSTRUCTION" missed="2" covered="0"/>
+        <counter type="BRANCH" missed="2" covered="0"/>
+        <counter type="METHOD" missed="1" covered="0"/>
+      </method>
+      <method name="lambda$0" desc="()V">
+        <counter type="METHOD" missed="1" covered="0"/>
+      </method>
+    </class>
+    <sourcefile name="Foo.java">
+      <line nr="3" mi="0" ci="3" mb="0" cb="0"/>
+      <line nr="5" mi="0" ci="2" mb="1" cb="1"/>
+      <line nr="8" mi="2" ci="0" mb="0" cb="0"/>
+      <line nr="12" mi="2" ci="0" mb="2" cb="0"/>
+    </sourcefile>
+  </package>
+</report>
+`
+
+const expectedLcov = `TN:foo
+SF:com/example/Foo.java
+FN:3,com/example/Foo.<init>()V
+FN:5,com/example/Foo.bar(Z)I
+FN:12,com/example/Foo.baz()V
+FNDA:1,com/example/Foo.<init>()V
+FNDA:1,com/example/Foo.bar(Z)I
+FNDA:0,com/example/Foo.baz()V
+FNF:3
+FNH:2
+BRDA:5,0,0,1
+BRDA:5,0,1,0
+BRDA:12,0,0,-
+BRDA:12,0,1,-
+BRF:4
+BRH:1
+DA:3,1
+DA:5,1
+DA:8,0
+DA:12,0
+LF:4
+LH:2
+end_of_record
+`
+
+func TestWriteLcov(t *testing.T) {
+	rep, err := readReport(strings.NewReader(testReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeLcov(buf, rep); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expectedLcov {
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedLcov, buf.String())
+	}
+}
+
+func TestWriteLcovGroups(t *testing.T) {
+	// Packages in nested groups are written in order.
+	rep, err := readReport(strings.NewReader(`<report name="all">
+		<group name="a">
+			<package name="a"><sourcefile name="A.java"/></package>
+			<group name="b">
+				<package name="b"><sourcefile name="B.java"/></package>
+			</group>
+		</group>
+		<group name="c">
+			<package name="c"><sourcefile name="C.java"/></package>
+		</group>
+	</report>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeLcov(buf, rep); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(l, "SF:") {
+			files = append(files, strings.TrimPrefix(l, "SF:"))
+		}
+	}
+	if g, w := strings.Join(files, " "), "a/A.java b/B.java c/C.java"; g != w {
+		t.Errorf("expected source files %q, got %q", w, g)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lint_sarif merges the SARIF reports written by Android Lint for each module into a single
+// report, and compares a report against a baseline report to find the issues that are new.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"android/soong/response"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://schemastore.azurewebsites.net/schemas/json/sarif-2.1.0.json"
+)
+
+// sarifLog is the top level object of a SARIF file.  The runs are kept as raw JSON so that the
+// properties this tool doesn't know about are copied to the output unchanged.
+type sarifLog struct {
+	Schema  string            `json:"$schema,omitempty"`
+	Version string            `json:"version"`
+	Runs    []json.RawMessage `json:"runs"`
+}
+
+// sarifLevels orders the levels of SARIF results by severity.
+var sarifLevels = map[string]int{
+	"none":    0,
+	"note":    1,
+	"warning": 2,
+	"error":   3,
+}
+
+// sarifResult contains the parts of a SARIF result that identify an issue, and its level.
+type sarifResult struct {
+	RuleID  string `json:"ruleId"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+		} `json:"physicalLocation"`
+	} `json:"locations"`
+}
+
+// key returns the string used to match a result against the baseline.  Line numbers are not part
+// of the key so that unrelated edits to a file don't make its existing issues new.
+func (r sarifResult) key() string {
+	uri := ""
+	if len(r.Locations) > 0 {
+		uri = r.Locations[0].PhysicalLocation.ArtifactLocation.URI
+	}
+	return r.RuleID + "\x00" + uri + "\x00" + r.Message.Text
+}
+
+// level returns the level of the result, which is "warning" when it is not set.
+func (r sarifResult) level() string {
+	if r.Level == "" {
+		return "warning"
+	}
+	return r.Level
+}
+
+func (r sarifResult) String() string {
+	uri := "<unknown>"
+	if len(r.Locations) > 0 {
+		uri = r.Locations[0].PhysicalLocation.ArtifactLocation.URI
+	}
+	return fmt.Sprintf("%s: %s: %s", uri, r.RuleID, r.Message.Text)
+}
+
+func readLog(file string) (*sarifLog, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var log sarifLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if log.Version != sarifVersion {
+		return nil, fmt.Errorf("%s: unsupported SARIF version %q", file, log.Version)
+	}
+	return &log, nil
+}
+
+func writeLog(w io.Writer, log *sarifLog) error {
+	if log.Schema == "" {
+		log.Schema = sarifSchema
+	}
+	log.Version = sarifVersion
+	if log.Runs == nil {
+		log.Runs = []json.RawMessage{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// mergeLogs returns a log containing the runs of all the input logs.
+func mergeLogs(files []string) (*sarifLog, error) {
+	merged := &sarifLog{}
+	for _, file := range files {
+		log, err := readLog(file)
+		if err != nil {
+			return nil, err
+		}
+		if merged.Schema == "" {
+			merged.Schema = log.Schema
+		}
+		merged.Runs = append(merged.Runs, log.Runs...)
+	}
+	return merged, nil
+}
+
+// runResults returns the raw and the parsed results of a run.
+func runResults(run json.RawMessage) ([]json.RawMessage, []sarifResult, error) {
+	var r struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(run, &r); err != nil {
+		return nil, nil, err
+	}
+	parsed := make([]sarifResult, len(r.Results))
+	for i, result := range r.Results {
+		if err := json.Unmarshal(result, &parsed[i]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return r.Results, parsed, nil
+}
+
+// diffLogs returns a copy of log that only contains the results that are not in baseline, and the
+// new results.  A result that appears n times in baseline matches up to n results in log.
+func diffLogs(log, baseline *sarifLog) (*sarifLog, []sarifResult, error) {
+	known := make(map[string]int)
+	for _, run := range baseline.Runs {
+		_, results, err := runResults(run)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse baseline results: %w", err)
+		}
+		for _, result := range results {
+			known[result.key()]++
+		}
+	}
+
+	diff := &sarifLog{Schema: log.Schema}
+	var newResults []sarifResult
+	for _, run := range log.Runs {
+		raw, results, err := runResults(run)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse results: %w", err)
+		}
+
+		newRaw := []json.RawMessage{}
+		for i, result := range results {
+			if known[result.key()] > 0 {
+				known[result.key()]--
+				continue
+			}
+			newRaw = append(newRaw, raw[i])
+			newResults = append(newResults, result)
+		}
+
+		// Replace the results and keep the rest of the run.
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(run, &fields); err != nil {
+			return nil, nil, err
+		}
+		fields["results"], err = json.Marshal(newRaw)
+		if err != nil {
+			return nil, nil, err
+		}
+		newRun, err := json.Marshal(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		diff.Runs = append(diff.Runs, newRun)
+	}
+	return diff, newResults, nil
+}
+
+// resultsAtLevel returns the results whose level is at least as severe as level.
+func resultsAtLevel(results []sarifResult, level string) []sarifResult {
+	var ret []sarifResult
+	for _, result := range results {
+		if sarifLevels[result.level()] >= sarifLevels[level] {
+			ret = append(ret, result)
+		}
+	}
+	return ret
+}
+
+func writeOutput(file string, log *sarifLog) error {
+	buf := &bytes.Buffer{}
+	if err := writeLog(buf, log); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0666)
+}
+
+// expandArgs replaces the @file arguments with the contents of the response files.
+func expandArgs(args []string) ([]string, error) {
+	var ret []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "@") {
+			f, err := os.Open(strings.TrimPrefix(arg, "@"))
+			if err != nil {
+				return nil, err
+			}
+			rspArgs, err := response.ReadRspFile(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, rspArgs...)
+		} else {
+			ret = append(ret, arg)
+		}
+	}
+	return ret, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lint_sarif merge -o out.sarif in.sarif [in.sarif...]")
+	fmt.Fprintln(os.Stderr, "       lint_sarif diff -baseline baseline.sarif [-level error] -o out.sarif in.sarif")
+	fmt.Fprintln(os.Stderr, "merge writes the runs of all the input reports into a single report.")
+	fmt.Fprintln(os.Stderr, "diff writes the results of the input report that are not in the baseline,")
+	fmt.Fprintln(os.Stderr, "and fails if any of them are at least as severe as -level.")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	args, err := expandArgs(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("lint_sarif "+os.Args[1], flag.ExitOnError)
+	flags.Usage = usage
+	out := flags.String("o", "", "output SARIF file")
+
+	switch os.Args[1] {
+	case "merge":
+		flags.Parse(args)
+		if *out == "" {
+			usage()
+		}
+		merged, err := mergeLogs(flags.Args())
+		if err == nil {
+			err = writeOutput(*out, merged)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	case "diff":
+		baselineFile := flags.String("baseline", "", "baseline SARIF file")
+		level := flags.String("level", "error", "the lowest level of new results that fails: none, note, warning or error")
+		flags.Parse(args)
+		if *out == "" || *baselineFile == "" || flags.NArg() != 1 {
+			usage()
+		}
+		if _, ok := sarifLevels[*level]; !ok {
+			fmt.Fprintf(os.Stderr, "error: unknown level %q\n", *level)
+			os.Exit(1)
+		}
+		log, err := readLog(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		baseline, err := readLog(*baselineFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		diff, newResults, err := diffLogs(log, baseline)
+		if err == nil {
+			err = writeOutput(*out, diff)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		if failing := resultsAtLevel(newResults, *level); len(failing) > 0 {
+			for _, result := range failing {
+				fmt.Fprintln(os.Stderr, result)
+			}
+			fmt.Fprintf(os.Stderr, "lint_sarif: found %d lint issues at level %s or above that are not in the baseline %s\n",
+				len(failing), *level, *baselineFile)
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
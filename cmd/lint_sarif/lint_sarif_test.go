@@ -0,0 +1,197 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testReportA = `{
+	"$schema": "https://example.com/sarif.json",
+	"version": "2.1.0",
+	"runs": [{
+		"tool": {"driver": {"name": "Android Lint"}},
+		"results": [
+			{"ruleId": "NewApi", "message": {"text": "Call requires API level 31"},
+			 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a/Foo.java"}, "region": {"startLine": 10}}}]},
+			{"ruleId": "NewApi", "message": {"text": "Call requires API level 31"},
+			 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a/Foo.java"}, "region": {"startLine": 20}}}]}
+		]
+	}]
+}`
+
+const testReportB = `{
+	"version": "2.1.0",
+	"runs": [{
+		"tool": {"driver": {"name": "Android Lint"}},
+		"results": [
+			{"ruleId": "UnusedResources", "message": {"text": "Unused"},
+			 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "b/res/values/strings.xml"}}}]}
+		]
+	}]
+}`
+
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func ruleIDs(t *testing.T, log *sarifLog) []string {
+	t.Helper()
+	var ids []string
+	for _, run := range log.Runs {
+		_, results, err := runResults(run)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range results {
+			ids = append(ids, result.RuleID)
+		}
+	}
+	return ids
+}
+
+func TestMergeLogs(t *testing.T) {
+	a := writeTestFile(t, "a.sarif", testReportA)
+	b := writeTestFile(t, "b.sarif", testReportB)
+
+	merged, err := mergeLogs([]string{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged.Schema != "https://example.com/sarif.json" {
+		t.Errorf("expected the schema of the first report, got %q", merged.Schema)
+	}
+	if len(merged.Runs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(merged.Runs))
+	}
+	if g, w := ruleIDs(t, merged), []string{"NewApi", "NewApi", "UnusedResources"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected results %q, got %q", w, g)
+	}
+
+	empty, err := mergeLogs(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "empty.sarif")
+	if err := writeOutput(out, empty); err != nil {
+		t.Fatal(err)
+	}
+	if log, err := readLog(out); err != nil {
+		t.Error(err)
+	} else if log.Schema != sarifSchema || len(log.Runs) != 0 {
+		t.Errorf("unexpected empty report %#v", log)
+	}
+}
+
+func TestReadLogVersion(t *testing.T) {
+	file := writeTestFile(t, "old.sarif", `{"version": "1.0.0", "runs": []}`)
+	if _, err := readLog(file); err == nil {
+		t.Error("expected an error for an unsupported version")
+	}
+}
+
+func TestDiffLogs(t *testing.T) {
+	// The baseline has one of the two NewApi issues, at a different line.
+	baselineFile := writeTestFile(t, "baseline.sarif", `{
+		"version": "2.1.0",
+		"runs": [{
+			"results": [
+				{"ruleId": "NewApi", "message": {"text": "Call requires API level 31"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a/Foo.java"}, "region": {"startLine": 12}}}]},
+				{"ruleId": "UnusedResources", "message": {"text": "Unused"},
+				 "locations": [{"physicalLocation": {"artifactLocation": {"uri": "b/res/values/strings.xml"}}}]}
+			]
+		}]
+	}`)
+	baseline, err := readLog(baselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := mergeLogs([]string{
+		writeTestFile(t, "a.sarif", testReportA),
+		writeTestFile(t, "b.sarif", testReportB),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, newResults, err := diffLogs(log, baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(newResults) != 1 || newResults[0].String() != "a/Foo.java: NewApi: Call requires API level 31" {
+		t.Errorf("expected one new NewApi issue, got %q", newResults)
+	}
+	if g, w := ruleIDs(t, diff), []string{"NewApi"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected results %q, got %q", w, g)
+	}
+
+	// The rest of the runs is kept.
+	if len(diff.Runs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(diff.Runs))
+	}
+	var run struct {
+		Tool struct {
+			Driver struct {
+				Name string `json:"name"`
+			} `json:"driver"`
+		} `json:"tool"`
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(diff.Runs[1], &run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Tool.Driver.Name != "Android Lint" || run.Results == nil || len(run.Results) != 0 {
+		t.Errorf("unexpected run %s", diff.Runs[1])
+	}
+}
+
+func TestResultsAtLevel(t *testing.T) {
+	var results []sarifResult
+	for _, r := range []struct{ id, level string }{
+		{"Error", "error"},
+		{"Warning", "warning"},
+		{"Default", ""},
+		{"Note", "note"},
+	} {
+		results = append(results, sarifResult{RuleID: r.id, Level: r.level})
+	}
+
+	testCases := map[string][]string{
+		"error":   {"Error"},
+		"warning": {"Error", "Warning", "Default"},
+		"none":    {"Error", "Warning", "Default", "Note"},
+	}
+	for level, expected := range testCases {
+		var ids []string
+		for _, result := range resultsAtLevel(results, level) {
+			ids = append(ids, result.RuleID)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("level %s: expected %q, got %q", level, expected, ids)
+		}
+	}
+}
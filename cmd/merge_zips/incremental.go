@@ -0,0 +1,273 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"android/soong/jar"
+	"android/soong/third_party/zip"
+)
+
+// In incremental mode merge_zips writes a state file next to the output that lists every entry of
+// every input.  On the next run inputs whose size and modification time have not changed are not
+// opened, their entries are taken from the state file and their contents are copied from the
+// previous output.  Entries go through the same conflict and duplicate detection as entries read
+// from the inputs, so the result is identical to a full merge.
+
+const incrementalStateVersion = 1
+
+type fileStamp struct {
+	Size    int64
+	ModTime int64
+}
+
+func statFileStamp(name string) (fileStamp, error) {
+	s, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{Size: s.Size(), ModTime: s.ModTime().UnixNano()}, nil
+}
+
+type incrementalEntry struct {
+	Name string
+	// Index is the index of the entry in the input zip.
+	Index int
+	IsDir bool `json:",omitempty"`
+	CRC32 uint32
+	Size  uint64
+	// InOutput is set if the entry was written to the output, as opposed to being excluded or
+	// being a duplicate of an earlier entry.
+	InOutput bool `json:",omitempty"`
+}
+
+type incrementalInput struct {
+	Name  string
+	Stamp fileStamp
+	// HasServiceFiles is set if the input has META-INF/services files that were combined into a
+	// new entry, which requires reading the input again.
+	HasServiceFiles bool `json:",omitempty"`
+	Entries         []incrementalEntry
+}
+
+type incrementalState struct {
+	Version int
+	// Args contains the flags that affect the output, the previous output is not reused if they
+	// change.
+	Args   string
+	Output fileStamp
+	Inputs []incrementalInput
+}
+
+// incrementalMerge holds the previous state that can be reused, and records the state of the
+// current merge.
+type incrementalMerge struct {
+	args  string
+	stamp func(name string) (fileStamp, error)
+
+	previousInputs map[string]*incrementalInput
+	previousOutput map[string]*zip.File
+	previousReader *zip.ReadCloser
+
+	inputs []*incrementalInput
+
+	// Statistics for the current merge.
+	reusedInputs, readInputs int
+}
+
+func newIncrementalMerge(args string) *incrementalMerge {
+	return &incrementalMerge{
+		args:  args,
+		stamp: statFileStamp,
+	}
+}
+
+func incrementalStateFile(outputPath string) string {
+	return outputPath + ".incremental"
+}
+
+// loadPrevious reads the state and the output of the previous merge.  The previous output is
+// silently ignored if it can't be reused.
+func (im *incrementalMerge) loadPrevious(outputPath string) error {
+	data, err := os.ReadFile(incrementalStateFile(outputPath))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state incrementalState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	if state.Version != incrementalStateVersion || state.Args != im.args {
+		return nil
+	}
+	if outputStamp, err := im.stamp(outputPath); err != nil || outputStamp != state.Output {
+		// The output was modified or removed after the state was written.
+		return nil
+	}
+
+	reader, err := zip.OpenReader(outputPath)
+	if err != nil {
+		return nil
+	}
+
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+	im.setPrevious(state.Inputs, files)
+	im.previousReader = reader
+	return nil
+}
+
+func (im *incrementalMerge) setPrevious(inputs []incrementalInput, output map[string]*zip.File) {
+	im.previousInputs = make(map[string]*incrementalInput, len(inputs))
+	for i := range inputs {
+		im.previousInputs[inputs[i].Name] = &inputs[i]
+	}
+	im.previousOutput = output
+}
+
+func (im *incrementalMerge) Close() error {
+	if im.previousReader != nil {
+		return im.previousReader.Close()
+	}
+	return nil
+}
+
+// startInput returns the recorded entries of an input if it has not changed since the previous
+// merge, and starts recording the state of the input for the current merge.
+func (im *incrementalMerge) startInput(name string) (*incrementalInput, *incrementalInput, error) {
+	stamp, err := im.stamp(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := &incrementalInput{Name: name, Stamp: stamp}
+	im.inputs = append(im.inputs, current)
+
+	previous := im.previousInputs[name]
+	if previous != nil && previous.Stamp == stamp && !previous.HasServiceFiles {
+		im.reusedInputs++
+		return previous, current, nil
+	}
+	im.readInputs++
+	return nil, current, nil
+}
+
+// save writes the state of the current merge after the output has been written.
+func (im *incrementalMerge) save(outputPath string) error {
+	outputStamp, err := im.stamp(outputPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(im.state(outputStamp))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(incrementalStateFile(outputPath), data, 0666)
+}
+
+func (im *incrementalMerge) state(outputStamp fileStamp) incrementalState {
+	state := incrementalState{
+		Version: incrementalStateVersion,
+		Args:    im.args,
+		Output:  outputStamp,
+	}
+	for _, input := range im.inputs {
+		state.Inputs = append(state.Inputs, *input)
+	}
+	return state
+}
+
+func (im *incrementalMerge) String() string {
+	return fmt.Sprintf("reused %d inputs, read %d inputs", im.reusedInputs, im.readInputs)
+}
+
+// a ZipEntryFromPreviousOutput is a ZipEntryContents for an entry of an unchanged input.  Its
+// contents are copied from the previous output, or from the input if the entry was not written
+// to the previous output.
+type ZipEntryFromPreviousOutput struct {
+	inputZip InputZip
+	entry    incrementalEntry
+	previous *zip.File
+}
+
+func (ze ZipEntryFromPreviousOutput) String() string {
+	return fmt.Sprintf("%s!%s", ze.inputZip.Name(), ze.entry.Name)
+}
+
+func (ze ZipEntryFromPreviousOutput) IsDir() bool {
+	return ze.entry.IsDir
+}
+
+func (ze ZipEntryFromPreviousOutput) CRC32() uint32 {
+	return ze.entry.CRC32
+}
+
+func (ze ZipEntryFromPreviousOutput) Size() uint64 {
+	return ze.entry.Size
+}
+
+func (ze ZipEntryFromPreviousOutput) WriteToZip(dest string, zw *zip.Writer) error {
+	if ze.previous != nil {
+		ze.previous.SetModTime(jar.DefaultTime)
+		return zw.CopyFrom(ze.previous, dest)
+	}
+
+	if err := ze.inputZip.Open(); err != nil {
+		return err
+	}
+	entries := ze.inputZip.Entries()
+	if ze.entry.Index >= len(entries) || entries[ze.entry.Index].Name != ze.entry.Name {
+		return fmt.Errorf("%s: entry %q changed since the previous merge", ze.inputZip.Name(), ze.entry.Name)
+	}
+	entry := entries[ze.entry.Index]
+	entry.SetModTime(jar.DefaultTime)
+	return zw.CopyFrom(entry, dest)
+}
+
+// copyPreviousEntries adds the recorded entries of an unchanged input to the output.
+func (im *incrementalMerge) copyPreviousEntries(out *OutputZip, inputZip InputZip, previous,
+	current *incrementalInput, copyFully bool) error {
+
+	for _, e := range previous.Entries {
+		var previousFile *zip.File
+		if e.InOutput {
+			previousFile = im.previousOutput[e.Name]
+		}
+		e.InOutput = false
+		if copyFully || !out.isEntryExcluded(e.Name) {
+			entry := &ZipEntryFromPreviousOutput{
+				inputZip: inputZip,
+				entry:    e,
+				previous: previousFile,
+			}
+			added, err := out.copyZipEntry(e.Name, entry, inputZip.Name())
+			if err != nil {
+				return err
+			}
+			e.InOutput = added
+		}
+		current.Entries = append(current.Entries, e)
+	}
+	return nil
+}
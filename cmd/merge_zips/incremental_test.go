@@ -0,0 +1,280 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"android/soong/jar"
+	"android/soong/third_party/zip"
+)
+
+type testIncrementalInput struct {
+	entries []testZipEntry
+	version int64
+}
+
+// openCountingInputZip is a testInputZip that counts how many times it was opened.
+type openCountingInputZip struct {
+	testInputZip
+	opens int
+}
+
+func (z *openCountingInputZip) Open() error {
+	z.opens++
+	return z.testInputZip.Open()
+}
+
+type testIncrementalMerge struct {
+	sort, jar, ignoreDuplicates, stripDirEntries bool
+	stripFiles                                   []string
+}
+
+// merge merges the inputs and returns the output, the error and the incrementalMerge used to
+// record the state.  If previous is not nil the previous output and state are reused.
+func (m testIncrementalMerge) merge(t *testing.T, inputs []testIncrementalInput,
+	previousOutput []byte, previousState *incrementalState) ([]byte, *incrementalMerge, []*openCountingInputZip, error) {
+
+	t.Helper()
+
+	im := newIncrementalMerge("")
+	im.stamp = func(name string) (fileStamp, error) {
+		var i int
+		if _, err := fmt.Sscanf(name, "in%d", &i); err != nil {
+			return fileStamp{}, err
+		}
+		return fileStamp{Size: int64(len(inputs[i].entries)), ModTime: inputs[i].version}, nil
+	}
+
+	if previousState != nil {
+		// Round trip the state through JSON to make sure everything is saved.
+		data, err := json.Marshal(previousState)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var state incrementalState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := zip.NewReader(bytes.NewReader(previousOutput), int64(len(previousOutput)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]*zip.File)
+		for _, f := range r.File {
+			files[f.Name] = f
+		}
+		im.setPrevious(state.Inputs, files)
+	}
+
+	testInputZips := make([]*openCountingInputZip, len(inputs))
+	inputZips := make([]InputZip, len(inputs))
+	for i, in := range inputs {
+		testInputZips[i] = &openCountingInputZip{
+			testInputZip: testInputZip{name: fmt.Sprintf("in%d", i), entries: in.entries},
+		}
+		inputZips[i] = testInputZips[i]
+	}
+
+	out := &bytes.Buffer{}
+	writer := zip.NewWriter(out)
+	err := mergeZips(inputZips, writer, "", "", m.sort, m.jar, false, m.stripDirEntries,
+		m.ignoreDuplicates, m.stripFiles, nil, nil, im)
+	if closeErr := writer.Close(); closeErr != nil {
+		t.Fatal(closeErr)
+	}
+
+	return out.Bytes(), im, testInputZips, err
+}
+
+func TestIncrementalMergeZips(t *testing.T) {
+	service1 := testZipEntry{"META-INF/services/service1", 0755, []byte("class1\n"), zip.Store, jar.DefaultTime}
+
+	testCases := []struct {
+		name   string
+		merge  testIncrementalMerge
+		before []testIncrementalInput
+		after  []testIncrementalInput
+
+		reused []bool
+		err    string
+	}{
+		{
+			name:   "unchanged",
+			before: []testIncrementalInput{{[]testZipEntry{a, bDir, ba}, 1}, {[]testZipEntry{bc, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a, bDir, ba}, 1}, {[]testZipEntry{bc, bd}, 1}},
+			reused: []bool{true, true},
+		},
+		{
+			name:   "one changed",
+			before: []testIncrementalInput{{[]testZipEntry{a, bDir, ba}, 1}, {[]testZipEntry{bc, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a, bDir, ba}, 1}, {[]testZipEntry{bc, be}, 2}},
+			reused: []bool{true, false},
+		},
+		{
+			name:   "sorted",
+			merge:  testIncrementalMerge{sort: true},
+			before: []testIncrementalInput{{[]testZipEntry{bc, a}, 1}, {[]testZipEntry{bd, ba}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{bc, a2}, 2}, {[]testZipEntry{bd, ba}, 1}},
+			reused: []bool{false, true},
+		},
+		{
+			name:   "duplicate becomes output",
+			before: []testIncrementalInput{{[]testZipEntry{a, bc}, 1}, {[]testZipEntry{a, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{bc}, 2}, {[]testZipEntry{a, bd}, 1}},
+			reused: []bool{false, true},
+		},
+		{
+			name:   "conflict with unchanged input",
+			before: []testIncrementalInput{{[]testZipEntry{a, bc}, 1}, {[]testZipEntry{a, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a2, bc}, 2}, {[]testZipEntry{a, bd}, 1}},
+			reused: []bool{false, true},
+			err:    "duplicate",
+		},
+		{
+			name:   "ignored duplicates",
+			merge:  testIncrementalMerge{ignoreDuplicates: true},
+			before: []testIncrementalInput{{[]testZipEntry{a, bc}, 1}, {[]testZipEntry{a, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a2, bc}, 2}, {[]testZipEntry{a, bd}, 1}},
+			reused: []bool{false, true},
+		},
+		{
+			name:   "stripped files",
+			merge:  testIncrementalMerge{stripFiles: []string{"b/c"}},
+			before: []testIncrementalInput{{[]testZipEntry{a}, 1}, {[]testZipEntry{bc, bd}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a2}, 2}, {[]testZipEntry{bc, bd}, 1}},
+			reused: []bool{false, true},
+		},
+		{
+			name:   "stripped dir entries",
+			merge:  testIncrementalMerge{stripDirEntries: true},
+			before: []testIncrementalInput{{[]testZipEntry{a}, 1}, {[]testZipEntry{bDir, bc}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{a2}, 2}, {[]testZipEntry{bDir, bc}, 1}},
+			reused: []bool{false, true},
+		},
+		{
+			name:   "jar service files are reread",
+			merge:  testIncrementalMerge{jar: true},
+			before: []testIncrementalInput{{[]testZipEntry{service1, a}, 1}, {[]testZipEntry{bc}, 1}},
+			after:  []testIncrementalInput{{[]testZipEntry{service1, a}, 1}, {[]testZipEntry{bc}, 1}},
+			reused: []bool{false, true},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			before, im, _, err := test.merge.merge(t, test.before, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := im.state(fileStamp{})
+
+			want, _, _, wantErr := test.merge.merge(t, test.after, nil, nil)
+			got, im, _, gotErr := test.merge.merge(t, test.after, before, &state)
+
+			if test.err != "" {
+				if wantErr == nil || gotErr == nil {
+					t.Fatalf("expected errors containing %q, got %v and %v", test.err, wantErr, gotErr)
+				}
+				if !strings.Contains(strings.ToLower(gotErr.Error()), test.err) {
+					t.Errorf("incorrect error, want: %q got: %q", test.err, gotErr)
+				}
+				if wantErr.Error() != gotErr.Error() {
+					t.Errorf("incremental error differs from full merge error\nwant: %q\n got: %q", wantErr, gotErr)
+				}
+				return
+			}
+			if gotErr != nil {
+				t.Fatal(gotErr)
+			}
+
+			if !bytes.Equal(want, got) {
+				t.Error("incremental output differs from full merge output")
+				t.Errorf("want:\n%s", dumpZip(want))
+				t.Errorf("got:\n%s", dumpZip(got))
+			}
+
+			if g, w := im.reusedInputs, countTrue(test.reused); g != w {
+				t.Errorf("want %d reused inputs, got %d", w, g)
+			}
+
+			// The recorded state must be the same as the state of a full merge so that the next
+			// incremental merge is also correct.
+			_, fullIm, _, _ := test.merge.merge(t, test.after, nil, nil)
+			if g, w := im.state(fileStamp{}), fullIm.state(fileStamp{}); !statesEqual(g, w) {
+				t.Errorf("incremental state differs from full merge state\nwant: %+v\n got: %+v", w, g)
+			}
+		})
+	}
+}
+
+func TestIncrementalMergeZipsDoesNotOpenUnchangedInputs(t *testing.T) {
+	inputs := []testIncrementalInput{{[]testZipEntry{a, bDir, ba}, 1}, {[]testZipEntry{bc, bd}, 1}}
+	m := testIncrementalMerge{}
+
+	before, im, _, err := m.merge(t, inputs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := im.state(fileStamp{})
+
+	inputs[1] = testIncrementalInput{[]testZipEntry{bc, be}, 2}
+	_, _, inputZips, err := m.merge(t, inputs, before, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inputZips[0].opens != 0 {
+		t.Errorf("unchanged input %s was opened", inputZips[0].name)
+	}
+	if inputZips[1].opens == 0 {
+		t.Errorf("changed input %s was not opened", inputZips[1].name)
+	}
+}
+
+func countTrue(bools []bool) int {
+	n := 0
+	for _, b := range bools {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func statesEqual(a, b incrementalState) bool {
+	aj, _ := json.Marshal(a)
+	bj, _ := json.Marshal(b)
+	return bytes.Equal(aj, bj)
+}
+
+func TestIncrementalStateFile(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out.jar")
+
+	im := newIncrementalMerge("args")
+	if err := im.loadPrevious(output); err != nil {
+		t.Fatal(err)
+	}
+	if im.previousInputs != nil {
+		t.Errorf("expected no previous inputs without a state file")
+	}
+}
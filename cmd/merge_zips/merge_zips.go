@@ -275,36 +275,42 @@ func (oz *OutputZip) isEntryExcluded(name string) bool {
 }
 
 // Creates a zip entry whose contents is an entry from the given input zip.
-func (oz *OutputZip) copyEntry(inputZip InputZip, index int) error {
+func (oz *OutputZip) copyEntry(inputZip InputZip, index int) (bool, error) {
 	entry := NewZipEntryFromZip(inputZip, index)
+	return oz.copyZipEntry(entry.name, entry, inputZip.Name())
+}
+
+// Adds an entry from an input zip, checking it against any existing entry with the same name.
+// Returns true if the entry was added to the output.
+func (oz *OutputZip) copyZipEntry(name string, entry ZipEntryContents, inputZipName string) (bool, error) {
 	if oz.stripDirEntries && entry.IsDir() {
-		return nil
+		return false, nil
 	}
-	existingEntry, err := oz.addZipEntry(entry.name, entry)
+	existingEntry, err := oz.addZipEntry(name, entry)
 	if err != nil {
-		return err
+		return false, err
 	}
 	if existingEntry == nil {
-		return nil
+		return true, nil
 	}
 
 	// File types should match
 	if existingEntry.IsDir() != entry.IsDir() {
-		return fmt.Errorf("Directory/file mismatch at %v from %v and %v\n",
-			entry.name, existingEntry, entry)
+		return false, fmt.Errorf("Directory/file mismatch at %v from %v and %v\n",
+			name, existingEntry, entry)
 	}
 
 	if oz.ignoreDuplicates ||
 		// Skip manifest and module info files that are not from the first input file
-		(oz.emulateJar && entry.name == jar.ManifestFile || entry.name == jar.ModuleInfoClass) ||
+		(oz.emulateJar && name == jar.ManifestFile || name == jar.ModuleInfoClass) ||
 		// Identical entries
 		(existingEntry.CRC32() == entry.CRC32() && existingEntry.Size() == entry.Size()) ||
 		// Directory entries
 		entry.IsDir() {
-		return nil
+		return false, nil
 	}
 
-	return fmt.Errorf("Duplicate path %v found in %v and %v\n", entry.name, existingEntry, inputZip.Name())
+	return false, fmt.Errorf("Duplicate path %v found in %v and %v\n", name, existingEntry, inputZipName)
 }
 
 func (oz *OutputZip) entriesArray() []string {
@@ -538,7 +544,8 @@ func (miz *ManagedInputZip) Entries() []*zip.File {
 // Actual processing.
 func mergeZips(inputZips []InputZip, writer *zip.Writer, manifest, pyMain string,
 	sortEntries, emulateJar, emulatePar, stripDirEntries, ignoreDuplicates bool,
-	excludeFiles, excludeDirs []string, zipsToNotStrip map[string]bool) error {
+	excludeFiles, excludeDirs []string, zipsToNotStrip map[string]bool,
+	incremental *incrementalMerge) error {
 
 	out := NewOutputZip(writer, sortEntries, emulateJar, stripDirEntries, ignoreDuplicates)
 	out.setExcludeFiles(excludeFiles)
@@ -571,6 +578,24 @@ func mergeZips(inputZips []InputZip, writer *zip.Writer, manifest, pyMain string
 	// Finally, add entries from all the input zips.
 	for _, inputZip := range inputZips {
 		_, copyFully := zipsToNotStrip[inputZip.Name()]
+
+		var recorded *incrementalInput
+		if incremental != nil {
+			previous, current, err := incremental.startInput(inputZip.Name())
+			if err != nil {
+				return err
+			}
+			if previous != nil {
+				// The input has not changed, use the entries recorded by the previous merge.
+				err := incremental.copyPreviousEntries(out, inputZip, previous, current, copyFully)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			recorded = current
+		}
+
 		if err := inputZip.Open(); err != nil {
 			return err
 		}
@@ -582,13 +607,28 @@ func mergeZips(inputZips []InputZip, writer *zip.Writer, manifest, pyMain string
 				if err != nil {
 					return err
 				}
+				if recorded != nil {
+					recorded.HasServiceFiles = true
+				}
 				continue
 			}
+			added := false
 			if copyFully || !out.isEntryExcluded(entry.Name) {
-				if err := out.copyEntry(inputZip, i); err != nil {
+				var err error
+				if added, err = out.copyEntry(inputZip, i); err != nil {
 					return err
 				}
 			}
+			if recorded != nil {
+				recorded.Entries = append(recorded.Entries, incrementalEntry{
+					Name:     entry.Name,
+					Index:    i,
+					IsDir:    entry.FileInfo().IsDir(),
+					CRC32:    entry.CRC32,
+					Size:     entry.UncompressedSize64,
+					InOutput: added,
+				})
+			}
 		}
 		// Unless we need to rearrange the entries, the input zip can now be closed.
 		if !(emulateJar || sortEntries) {
@@ -652,6 +692,7 @@ var (
 	pyMain           = flag.String("pm", "", "__main__.py file to insert in par")
 	prefix           = flag.String("prefix", "", "A file to prefix to the zip file")
 	ignoreDuplicates = flag.Bool("ignore-duplicates", false, "take each entry from the first zip it exists in and don't warn")
+	incrementalFlag  = flag.Bool("incremental", false, "reuse entries from the previous output for inputs that have not changed")
 )
 
 func init() {
@@ -735,12 +776,34 @@ func main() {
 
 	log.SetFlags(log.Lshortfile)
 
+	// The previous output can't be reused when it has a prefix or when __init__.py files are
+	// generated, which requires reading all the inputs.
+	var incremental *incrementalMerge
+	if *incrementalFlag && *prefix == "" && !*emulatePar {
+		var notStripped []string
+		for zip := range zipsToNotStrip {
+			notStripped = append(notStripped, zip)
+		}
+		sort.Strings(notStripped)
+		incremental = newIncrementalMerge(fmt.Sprintf("%v %v %v %v %q %q %q %q",
+			*sortEntries, *emulateJar, *stripDirEntries, *ignoreDuplicates,
+			excludeDirs, excludeFiles, notStripped, *manifest))
+		if err := incremental.loadPrevious(outputPath); err != nil {
+			log.Fatal(err)
+		}
+		defer incremental.Close()
+	}
+
 	// make writer
-	outputZip, err := os.Create(outputPath)
+	writePath := outputPath
+	if incremental != nil {
+		// Write to a temporary file while the previous output is being read.
+		writePath = outputPath + ".tmp"
+	}
+	outputZip, err := os.Create(writePath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer outputZip.Close()
 
 	var offset int64
 	if *prefix != "" {
@@ -755,12 +818,6 @@ func main() {
 	}
 
 	writer := zip.NewWriter(outputZip)
-	defer func() {
-		err := writer.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
 	writer.SetOffset(offset)
 
 	if *manifest != "" && !*emulateJar {
@@ -779,8 +836,24 @@ func main() {
 	}
 	err = mergeZips(inputZips, writer, *manifest, *pyMain, *sortEntries, *emulateJar, *emulatePar,
 		*stripDirEntries, *ignoreDuplicates, []string(excludeFiles), []string(excludeDirs),
-		map[string]bool(zipsToNotStrip))
+		map[string]bool(zipsToNotStrip), incremental)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	if err := writer.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := outputZip.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if incremental != nil {
+		if err := os.Rename(writePath, outputPath); err != nil {
+			log.Fatal(err)
+		}
+		if err := incremental.save(outputPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
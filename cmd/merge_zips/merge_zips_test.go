@@ -310,7 +310,7 @@ func TestMergeZips(t *testing.T) {
 
 			err := mergeZips(inputZips, writer, "", "",
 				test.sort, test.jar, test.par, test.stripDirEntries, test.ignoreDuplicates,
-				test.stripFiles, test.stripDirs, test.zipsToNotStrip)
+				test.stripFiles, test.stripDirs, test.zipsToNotStrip, nil)
 
 			closeErr := writer.Close()
 			if closeErr != nil {
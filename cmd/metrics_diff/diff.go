@@ -0,0 +1,296 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sort"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+)
+
+// Units of the values in a Delta.
+const (
+	unitNanos  = "ns"
+	unitMicros = "us"
+	unitBytes  = "bytes"
+	unitKB     = "kB"
+	unitCount  = "count"
+)
+
+// Delta is the difference of a single value between the baseline and another build. Before or
+// After is nil if the value was only present in one of the builds.
+type Delta struct {
+	Name   string `json:"name"`
+	Unit   string `json:"unit"`
+	Before *int64 `json:"before,omitempty"`
+	After  *int64 `json:"after,omitempty"`
+}
+
+// Diff returns After - Before, treating missing values as 0.
+func (d Delta) Diff() int64 {
+	var before, after int64
+	if d.Before != nil {
+		before = *d.Before
+	}
+	if d.After != nil {
+		after = *d.After
+	}
+	return after - before
+}
+
+// Percent returns the relative change from Before to After, and false if it is undefined.
+func (d Delta) Percent() (float64, bool) {
+	if d.Before == nil || d.After == nil || *d.Before == 0 {
+		return 0, false
+	}
+	return float64(d.Diff()) / float64(*d.Before) * 100, true
+}
+
+// Job is a single job on the critical path or in the list of long running jobs.
+type Job struct {
+	Description string `json:"description"`
+	Micros      int64  `json:"micros"`
+}
+
+// CriticalPathDiff describes how the critical path changed between two builds.
+type CriticalPathDiff struct {
+	// Elapsed and critical path time.
+	Times []Delta `json:"times,omitempty"`
+
+	// Jobs that are only on the critical path of one of the builds.
+	Added   []Job `json:"added,omitempty"`
+	Removed []Job `json:"removed,omitempty"`
+
+	// Long running jobs (>30 seconds) in either build.
+	LongRunningJobs []Delta `json:"long_running_jobs,omitempty"`
+}
+
+// Comparison is the difference between the baseline build and another build.
+type Comparison struct {
+	Baseline string `json:"baseline"`
+	Build    string `json:"build"`
+
+	// Time spent in each phase of the build (setup tools, kati, soong, ninja, ...).
+	Phases []Delta `json:"phases,omitempty"`
+
+	// Memory usage, allocation counts, GC and event timings of soong_build.
+	SoongBuild []Delta `json:"soong_build,omitempty"`
+
+	// Number of ninja actions in the build.
+	Actions []Delta `json:"actions,omitempty"`
+
+	CriticalPath *CriticalPathDiff `json:"critical_path,omitempty"`
+}
+
+// deltaSet collects Deltas by name, preserving the order in which names were first seen.
+type deltaSet struct {
+	names  []string
+	deltas map[string]*Delta
+}
+
+func newDeltaSet() *deltaSet {
+	return &deltaSet{deltas: make(map[string]*Delta)}
+}
+
+func (s *deltaSet) get(name, unit string) *Delta {
+	d, ok := s.deltas[name]
+	if !ok {
+		d = &Delta{Name: name, Unit: unit}
+		s.deltas[name] = d
+		s.names = append(s.names, name)
+	}
+	return d
+}
+
+// add adds value to the before or after value of name. Repeated values, for example multiple
+// kati runs, are summed.
+func (s *deltaSet) add(name, unit string, after bool, value int64) {
+	d := s.get(name, unit)
+	p := &d.Before
+	if after {
+		p = &d.After
+	}
+	if *p == nil {
+		*p = new(int64)
+	}
+	**p += value
+}
+
+func (s *deltaSet) list() []Delta {
+	var ret []Delta
+	for _, name := range s.names {
+		ret = append(ret, *s.deltas[name])
+	}
+	return ret
+}
+
+// compare computes the difference between baseline and build.
+func compare(baseline, build *buildMetrics) Comparison {
+	ret := Comparison{
+		Baseline: baseline.name,
+		Build:    build.name,
+	}
+
+	phases := newDeltaSet()
+	soongBuild := newDeltaSet()
+	actions := newDeltaSet()
+	for i, m := range []*buildMetrics{baseline, build} {
+		after := i == 1
+		if m.base != nil {
+			addPhases(phases, soongBuild, after, m.base)
+		}
+		if m.soongBuild != nil {
+			addSoongBuild(soongBuild, after, m.soongBuild)
+		}
+		if m.progress != nil {
+			actions.add("total_actions", unitCount, after, int64(m.progress.GetTotalActions()))
+			actions.add("finished_actions", unitCount, after, int64(m.progress.GetFinishedActions()))
+			actions.add("failed_actions", unitCount, after, int64(m.progress.GetFailedActions()))
+		}
+	}
+	ret.Phases = phases.list()
+	ret.SoongBuild = soongBuild.list()
+	ret.Actions = actions.list()
+
+	if baseline.base.GetCriticalPathInfo() != nil || build.base.GetCriticalPathInfo() != nil {
+		ret.CriticalPath = compareCriticalPaths(baseline.base.GetCriticalPathInfo(), build.base.GetCriticalPathInfo())
+	}
+
+	return ret
+}
+
+func addPhases(phases, soongBuild *deltaSet, after bool, base *soong_metrics_proto.MetricsBase) {
+	categories := []struct {
+		name  string
+		perfs []*soong_metrics_proto.PerfInfo
+	}{
+		{"setup_tools", base.GetSetupTools()},
+		{"kati", base.GetKatiRuns()},
+		{"soong", base.GetSoongRuns()},
+		{"bazel", base.GetBazelRuns()},
+		{"ninja", base.GetNinjaRuns()},
+	}
+	for _, category := range categories {
+		for _, perf := range category.perfs {
+			name := category.name + "/" + perfName(perf)
+			phases.add(name, unitNanos, after, int64(perf.GetRealTime()))
+			for _, process := range perf.GetProcessesResourceInfo() {
+				prefix := "process/" + process.GetName() + "/"
+				soongBuild.add(prefix+"max_rss", unitKB, after, int64(process.GetMaxRssKb()))
+				soongBuild.add(prefix+"user_time", unitMicros, after, int64(process.GetUserTimeMicros()))
+				soongBuild.add(prefix+"system_time", unitMicros, after, int64(process.GetSystemTimeMicros()))
+			}
+		}
+	}
+	if total := base.GetTotal(); total != nil {
+		phases.add("total", unitNanos, after, int64(total.GetRealTime()))
+	}
+}
+
+func addSoongBuild(soongBuild *deltaSet, after bool, metrics *soong_metrics_proto.SoongBuildMetrics) {
+	soongBuild.add("modules", unitCount, after, int64(metrics.GetModules()))
+	soongBuild.add("variants", unitCount, after, int64(metrics.GetVariants()))
+	soongBuild.add("total_alloc_count", unitCount, after, int64(metrics.GetTotalAllocCount()))
+	soongBuild.add("total_alloc_size", unitBytes, after, int64(metrics.GetTotalAllocSize()))
+	soongBuild.add("max_heap_size", unitBytes, after, int64(metrics.GetMaxHeapSize()))
+
+	for _, event := range metrics.GetEvents() {
+		soongBuild.add("event/"+perfName(event), unitNanos, after, int64(event.GetRealTime()))
+	}
+
+	// The perf counters are sampled periodically, compare the last sample of each counter, which
+	// holds the totals for cumulative counters like the number of GCs.
+	samples := append([]*soong_metrics_proto.PerfCounters(nil), metrics.GetPerfCounters()...)
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].GetTime() < samples[j].GetTime() })
+	last := make(map[string]int64)
+	var names []string
+	for _, sample := range samples {
+		for _, group := range sample.GetGroups() {
+			for _, counter := range group.GetCounters() {
+				name := "counter/" + group.GetName() + "/" + counter.GetName()
+				if _, ok := last[name]; !ok {
+					names = append(names, name)
+				}
+				last[name] = counter.GetValue()
+			}
+		}
+	}
+	for _, name := range names {
+		soongBuild.add(name, unitCount, after, last[name])
+	}
+}
+
+func compareCriticalPaths(before, after *soong_metrics_proto.CriticalPathInfo) *CriticalPathDiff {
+	ret := &CriticalPathDiff{}
+
+	times := newDeltaSet()
+	for i, info := range []*soong_metrics_proto.CriticalPathInfo{before, after} {
+		if info == nil {
+			continue
+		}
+		times.add("elapsed_time", unitMicros, i == 1, int64(info.GetElapsedTimeMicros()))
+		times.add("critical_path_time", unitMicros, i == 1, int64(info.GetCriticalPathTimeMicros()))
+	}
+	ret.Times = times.list()
+
+	beforeJobs := jobsByDescription(before.GetCriticalPath())
+	afterJobs := jobsByDescription(after.GetCriticalPath())
+	for _, job := range after.GetCriticalPath() {
+		if _, ok := beforeJobs[job.GetJobDescription()]; !ok {
+			ret.Added = append(ret.Added, Job{job.GetJobDescription(), int64(job.GetElapsedTimeMicros())})
+		}
+	}
+	for _, job := range before.GetCriticalPath() {
+		if _, ok := afterJobs[job.GetJobDescription()]; !ok {
+			ret.Removed = append(ret.Removed, Job{job.GetJobDescription(), int64(job.GetElapsedTimeMicros())})
+		}
+	}
+
+	longRunning := newDeltaSet()
+	for i, info := range []*soong_metrics_proto.CriticalPathInfo{before, after} {
+		for _, job := range info.GetLongRunningJobs() {
+			longRunning.add(job.GetJobDescription(), unitMicros, i == 1, int64(job.GetElapsedTimeMicros()))
+		}
+	}
+	ret.LongRunningJobs = longRunning.list()
+	sort.SliceStable(ret.LongRunningJobs, func(i, j int) bool {
+		return abs(ret.LongRunningJobs[i].Diff()) > abs(ret.LongRunningJobs[j].Diff())
+	})
+
+	return ret
+}
+
+func jobsByDescription(jobs []*soong_metrics_proto.JobInfo) map[string]*soong_metrics_proto.JobInfo {
+	ret := make(map[string]*soong_metrics_proto.JobInfo)
+	for _, job := range jobs {
+		ret[job.GetJobDescription()] = job
+	}
+	return ret
+}
+
+// perfName returns the name used to match a PerfInfo between builds.
+func perfName(perf *soong_metrics_proto.PerfInfo) string {
+	if perf.GetDescription() != "" {
+		return perf.GetDescription()
+	}
+	return perf.GetName()
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
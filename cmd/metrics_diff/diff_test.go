@@ -0,0 +1,213 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	soong_build_progress_proto "android/soong/ui/status/build_progress_proto"
+)
+
+func perf(name, desc string, realTime uint64) *soong_metrics_proto.PerfInfo {
+	return &soong_metrics_proto.PerfInfo{
+		Name:        proto.String(name),
+		Description: proto.String(desc),
+		RealTime:    proto.Uint64(realTime),
+	}
+}
+
+func job(desc string, micros uint64) *soong_metrics_proto.JobInfo {
+	return &soong_metrics_proto.JobInfo{
+		JobDescription:    proto.String(desc),
+		ElapsedTimeMicros: proto.Uint64(micros),
+	}
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func findDelta(deltas []Delta, name string) *Delta {
+	for i := range deltas {
+		if deltas[i].Name == name {
+			return &deltas[i]
+		}
+	}
+	return nil
+}
+
+func TestCompare(t *testing.T) {
+	baseline := &buildMetrics{
+		name: "before",
+		base: &soong_metrics_proto.MetricsBase{
+			KatiRuns: []*soong_metrics_proto.PerfInfo{
+				perf("kati", "kati build", 1000),
+				perf("kati", "kati build", 500),
+			},
+			SoongRuns: []*soong_metrics_proto.PerfInfo{perf("soong", "bootstrap", 2000)},
+			Total:     perf("total", "", 10000),
+			CriticalPathInfo: &soong_metrics_proto.CriticalPathInfo{
+				ElapsedTimeMicros:      proto.Uint64(100),
+				CriticalPathTimeMicros: proto.Uint64(50),
+				CriticalPath:           []*soong_metrics_proto.JobInfo{job("a", 10), job("b", 40)},
+				LongRunningJobs:        []*soong_metrics_proto.JobInfo{job("slow", 40)},
+			},
+		},
+		soongBuild: &soong_metrics_proto.SoongBuildMetrics{
+			Modules:     proto.Uint32(10),
+			MaxHeapSize: proto.Uint64(1 << 30),
+			PerfCounters: []*soong_metrics_proto.PerfCounters{
+				{
+					Time: proto.Uint64(2),
+					Groups: []*soong_metrics_proto.PerfCounterGroup{{
+						Name:     proto.String("runtime"),
+						Counters: []*soong_metrics_proto.PerfCounter{{Name: proto.String("gc_count"), Value: proto.Int64(7)}},
+					}},
+				},
+				{
+					Time: proto.Uint64(1),
+					Groups: []*soong_metrics_proto.PerfCounterGroup{{
+						Name:     proto.String("runtime"),
+						Counters: []*soong_metrics_proto.PerfCounter{{Name: proto.String("gc_count"), Value: proto.Int64(3)}},
+					}},
+				},
+			},
+		},
+		progress: &soong_build_progress_proto.BuildProgress{TotalActions: proto.Uint64(100)},
+	}
+
+	build := &buildMetrics{
+		name: "after",
+		base: &soong_metrics_proto.MetricsBase{
+			KatiRuns:  []*soong_metrics_proto.PerfInfo{perf("kati", "kati build", 3000)},
+			NinjaRuns: []*soong_metrics_proto.PerfInfo{perf("ninja", "ninja", 4000)},
+			Total:     perf("total", "", 12000),
+			CriticalPathInfo: &soong_metrics_proto.CriticalPathInfo{
+				ElapsedTimeMicros:      proto.Uint64(150),
+				CriticalPathTimeMicros: proto.Uint64(80),
+				CriticalPath:           []*soong_metrics_proto.JobInfo{job("a", 10), job("c", 70)},
+				LongRunningJobs:        []*soong_metrics_proto.JobInfo{job("slow", 70)},
+			},
+		},
+		soongBuild: &soong_metrics_proto.SoongBuildMetrics{
+			Modules:     proto.Uint32(12),
+			MaxHeapSize: proto.Uint64(2 << 30),
+		},
+		progress: &soong_build_progress_proto.BuildProgress{TotalActions: proto.Uint64(90)},
+	}
+
+	c := compare(baseline, build)
+
+	checkDelta := func(deltas []Delta, name string, before, after *int64) {
+		t.Helper()
+		d := findDelta(deltas, name)
+		if d == nil {
+			t.Errorf("missing delta %q", name)
+			return
+		}
+		if !reflect.DeepEqual(d.Before, before) || !reflect.DeepEqual(d.After, after) {
+			t.Errorf("delta %q: want %v -> %v, got %v -> %v", name, before, after, d.Before, d.After)
+		}
+	}
+
+	checkDelta(c.Phases, "kati/kati build", int64Ptr(1500), int64Ptr(3000))
+	checkDelta(c.Phases, "soong/bootstrap", int64Ptr(2000), nil)
+	checkDelta(c.Phases, "ninja/ninja", nil, int64Ptr(4000))
+	checkDelta(c.Phases, "total", int64Ptr(10000), int64Ptr(12000))
+
+	checkDelta(c.SoongBuild, "modules", int64Ptr(10), int64Ptr(12))
+	checkDelta(c.SoongBuild, "max_heap_size", int64Ptr(1<<30), int64Ptr(2<<30))
+	checkDelta(c.SoongBuild, "counter/runtime/gc_count", int64Ptr(7), nil)
+
+	checkDelta(c.Actions, "total_actions", int64Ptr(100), int64Ptr(90))
+
+	if c.CriticalPath == nil {
+		t.Fatal("missing critical path diff")
+	}
+	checkDelta(c.CriticalPath.Times, "critical_path_time", int64Ptr(50), int64Ptr(80))
+	if w := []Job{{"c", 70}}; !reflect.DeepEqual(c.CriticalPath.Added, w) {
+		t.Errorf("added jobs: want %v, got %v", w, c.CriticalPath.Added)
+	}
+	if w := []Job{{"b", 40}}; !reflect.DeepEqual(c.CriticalPath.Removed, w) {
+		t.Errorf("removed jobs: want %v, got %v", w, c.CriticalPath.Removed)
+	}
+	checkDelta(c.CriticalPath.LongRunningJobs, "slow", int64Ptr(40), int64Ptr(70))
+
+	if p, ok := findDelta(c.Phases, "total").Percent(); !ok || p != 20 {
+		t.Errorf("total percent: want 20, got %v (%v)", p, ok)
+	}
+	if _, ok := findDelta(c.Phases, "ninja/ninja").Percent(); ok {
+		t.Errorf("expected no percentage for a phase missing from the baseline")
+	}
+
+	buf := &bytes.Buffer{}
+	writeTextReport(buf, []Comparison{c}, 0)
+	for _, want := range []string{
+		"Comparing after against baseline before",
+		"kati/kati build",
+		"+100.0%",
+		"Jobs added to the critical path:",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected text report to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestLoadBuildMetrics(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, m proto.Message) {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(soongMetricsFile, &soong_metrics_proto.MetricsBase{
+		Total: perf("total", "", 10),
+		SoongBuildMetrics: &soong_metrics_proto.SoongBuildMetrics{
+			Modules: proto.Uint32(1),
+		},
+	})
+	write(buildProgressFile, &soong_build_progress_proto.BuildProgress{TotalActions: proto.Uint64(5)})
+
+	m, err := loadBuildMetrics(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := m.base.GetTotal().GetRealTime(); g != 10 {
+		t.Errorf("total time: want 10, got %d", g)
+	}
+	if g := m.soongBuild.GetModules(); g != 1 {
+		t.Errorf("expected soong_build metrics to fall back to soong_metrics, got %d modules", g)
+	}
+	if g := m.progress.GetTotalActions(); g != 5 {
+		t.Errorf("total actions: want 5, got %d", g)
+	}
+
+	if _, err := loadBuildMetrics(t.TempDir()); err == nil {
+		t.Errorf("expected error loading an empty directory")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	soong_build_progress_proto "android/soong/ui/status/build_progress_proto"
+)
+
+// Names of the metrics files written by soong_ui into the logs directory.
+const (
+	soongMetricsFile      = "soong_metrics"
+	soongBuildMetricsFile = "soong_build_metrics.pb"
+	buildProgressFile     = "build_progress.pb"
+)
+
+// buildMetrics holds all the metrics that were found for a single build.
+type buildMetrics struct {
+	// name is the path the metrics were loaded from.
+	name string
+
+	base       *soong_metrics_proto.MetricsBase
+	soongBuild *soong_metrics_proto.SoongBuildMetrics
+	progress   *soong_build_progress_proto.BuildProgress
+}
+
+// loadBuildMetrics loads the metrics for a single build. path is either a logs directory
+// containing any of soong_metrics, soong_build_metrics.pb and build_progress.pb, or a single
+// metrics file whose type is determined by its name.
+func loadBuildMetrics(path string) (*buildMetrics, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &buildMetrics{name: path}
+	if info.IsDir() {
+		found := false
+		for _, file := range []string{soongMetricsFile, soongBuildMetricsFile, buildProgressFile} {
+			if _, err := os.Stat(filepath.Join(path, file)); err == nil {
+				found = true
+				if err := ret.loadFile(filepath.Join(path, file)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s does not contain any of %s, %s or %s", path,
+				soongMetricsFile, soongBuildMetricsFile, buildProgressFile)
+		}
+	} else if err := ret.loadFile(path); err != nil {
+		return nil, err
+	}
+
+	// soong_ui copies the soong_build metrics into soong_metrics, use them if the
+	// soong_build_metrics.pb file wasn't available.
+	if ret.soongBuild == nil && ret.base != nil && ret.base.SoongBuildMetrics != nil {
+		ret.soongBuild = ret.base.SoongBuildMetrics
+	}
+
+	return ret, nil
+}
+
+func (m *buildMetrics) loadFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	base := filepath.Base(file)
+	switch {
+	case strings.Contains(base, "soong_build_metrics"):
+		m.soongBuild = &soong_metrics_proto.SoongBuildMetrics{}
+		err = proto.Unmarshal(data, m.soongBuild)
+	case strings.Contains(base, "build_progress"):
+		m.progress = &soong_build_progress_proto.BuildProgress{}
+		err = proto.Unmarshal(data, m.progress)
+	default:
+		m.base = &soong_metrics_proto.MetricsBase{}
+		err = proto.Unmarshal(data, m.base)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// metrics_diff compares the metrics written by soong_ui for two or more builds. The first build
+// is the baseline, every other build is compared against it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	flags := flag.NewFlagSet("flags", flag.ExitOnError)
+
+	// Hide the flag package to prevent accidental references to flag instead of flags.
+	flag := struct{}{}
+	_ = flag
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "  %s [-json] [-threshold <percent>] <baseline> <build> [<build>...]\n", os.Args[0])
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Each build is either a logs directory (usually out/) containing soong_metrics,")
+		fmt.Fprintln(flags.Output(), "soong_build_metrics.pb and build_progress.pb, or a single one of those files.")
+		fmt.Fprintln(flags.Output())
+
+		flags.PrintDefaults()
+	}
+
+	jsonOutput := flags.Bool("json", false, "write the report as JSON")
+	threshold := flags.Float64("threshold", 0, "omit values that changed by less than this percentage from the text report")
+
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var builds []*buildMetrics
+	for _, arg := range flags.Args() {
+		m, err := loadBuildMetrics(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading metrics: %s\n", err)
+			os.Exit(1)
+		}
+		builds = append(builds, m)
+	}
+
+	var comparisons []Comparison
+	for _, build := range builds[1:] {
+		comparisons = append(comparisons, compare(builds[0], build))
+	}
+
+	var err error
+	if *jsonOutput {
+		err = writeJSONReport(os.Stdout, comparisons)
+	} else {
+		err = writeTextReport(os.Stdout, comparisons, *threshold)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing report: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func writeJSONReport(w io.Writer, comparisons []Comparison) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(comparisons)
+}
+
+// writeTextReport writes the comparisons as human readable tables. Deltas whose absolute
+// relative change is below threshold percent are omitted.
+func writeTextReport(w io.Writer, comparisons []Comparison, threshold float64) error {
+	for i, c := range comparisons {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Comparing %s against baseline %s\n", c.Build, c.Baseline)
+
+		writeDeltaTable(w, "Phases", c.Phases, threshold)
+		writeDeltaTable(w, "soong_build", c.SoongBuild, threshold)
+		writeDeltaTable(w, "Actions", c.Actions, threshold)
+
+		if cp := c.CriticalPath; cp != nil {
+			writeDeltaTable(w, "Critical path", cp.Times, threshold)
+			writeJobs(w, "Jobs added to the critical path", cp.Added)
+			writeJobs(w, "Jobs removed from the critical path", cp.Removed)
+			writeDeltaTable(w, "Long running jobs", cp.LongRunningJobs, threshold)
+		}
+	}
+	return nil
+}
+
+func writeDeltaTable(w io.Writer, title string, deltas []Delta, threshold float64) {
+	var rows []Delta
+	for _, d := range deltas {
+		if percent, ok := d.Percent(); ok && abs64(percent) < threshold {
+			continue
+		}
+		rows = append(rows, d)
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s:\n", title)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\tbaseline\tnew\tdelta\t\t\n")
+	for _, d := range rows {
+		percent := ""
+		if p, ok := d.Percent(); ok {
+			percent = fmt.Sprintf("%+.1f%%", p)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", "  "+d.Name,
+			formatValue(d.Before, d.Unit), formatValue(d.After, d.Unit), formatDiff(d.Diff(), d.Unit), percent)
+	}
+	tw.Flush()
+}
+
+func writeJobs(w io.Writer, title string, jobs []Job) {
+	if len(jobs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, job := range jobs {
+		fmt.Fprintf(w, "  %10s  %s\n", formatValue(&job.Micros, unitMicros), job.Description)
+	}
+}
+
+func formatValue(v *int64, unit string) string {
+	if v == nil {
+		return "-"
+	}
+	switch unit {
+	case unitNanos:
+		return time.Duration(*v).Round(time.Millisecond).String()
+	case unitMicros:
+		return (time.Duration(*v) * time.Microsecond).Round(time.Millisecond).String()
+	case unitBytes:
+		return formatBytes(*v)
+	case unitKB:
+		return formatBytes(*v * 1024)
+	default:
+		return fmt.Sprint(*v)
+	}
+}
+
+func formatDiff(diff int64, unit string) string {
+	s := formatValue(&diff, unit)
+	if diff >= 0 && !strings.HasPrefix(s, "+") {
+		s = "+" + s
+	}
+	return s
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	size := abs(b)
+	if size < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func abs64(x float64) float64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
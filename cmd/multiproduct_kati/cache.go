@@ -0,0 +1,717 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"android/soong/shared"
+)
+
+// This file implements a content addressed cache of the results of Soong and Kati, so that
+// products with identical inputs don't analyze the tree again.
+//
+// Product config is run first for every product, and its results are hashed along with the
+// Android.bp files, the Go sources of Soong and of its plugins, and the values of the
+// environment variables that soong_build read the last time it ran with the same inputs, to find
+// the cache key. Kati reads every .mk file and the product name, so those are part of the key too
+// unless Kati is skipped, along with the ckati binary. Only successful results are cached.
+//
+// The files matched by the globs in Android.bp files are not part of the key, so every key also
+// contains the revision of the source tree, and entries are only reused between the products and
+// shards that analyze the same revision.
+//
+// The product name, e.g. in Make_suffix, and the output directory of the product are replaced by
+// placeholders in the product variables before they are hashed, and in the cached outputs, so
+// that products that only differ by their names share an entry.
+
+// Bump this when the format of the cache or of its keys changes.
+const analysisCacheVersion = 3
+
+// The file in a cache entry that describes its key.
+const analysisCacheKeyFile = "key.txt"
+
+// productPlaceholder replaces the product name in the paths and the contents of the cached
+// outputs.
+const productPlaceholder = "{PRODUCT}"
+
+// outDirPlaceholder replaces the output directory of the product in the contents of the cached
+// outputs.
+const outDirPlaceholder = "{OUT_DIR}"
+
+// Lists of files written by the source finder in the .module_paths directory.
+var (
+	androidBpLists = []string{"Android.bp.list"}
+	makefileLists  = []string{"Android.mk.list", "AndroidProducts.mk.list", "CleanSpec.mk.list", "configuration.list"}
+)
+
+// Directories containing the Go sources of Soong, relative to the source tree.  soong_ui is
+// built from these sources too.
+var soongSourceDirs = []string{"build/soong", "build/blueprint"}
+
+// Module types whose Go sources are built into soong_build as plugins when they are in an
+// Android.bp file outside of soongSourceDirs.
+var goPackageModuleTypes = []string{"bootstrap_go_package", "bootstrap_go_binary", "blueprint_go_binary"}
+
+// The environment variables that multiproduct_kati sets differently for each product.  Their
+// values are covered by the rest of the key.
+var productEnvVars = []string{"DIST_DIR", "OUT_DIR", "TARGET_PRODUCT"}
+
+// The directory in the cache that contains the environment variables read by soong_build for
+// each key description.
+const analysisCacheEnvDir = "env"
+
+type analysisCache struct {
+	dir    string
+	srcDir string
+
+	// The revision of the source tree, see -analysis-cache-revision.
+	revision string
+
+	// Hashes of the source files, and whether each Android.bp file defines a Go package, shared
+	// between products.
+	lock           sync.Mutex
+	fileHashes     map[string]string
+	goPackageFiles map[string]bool
+
+	soongSourcesOnce sync.Once
+	soongSources     string
+	soongSourcesErr  error
+
+	// Whether each product was found in the cache.
+	results map[string]analysisCacheResult
+}
+
+type analysisCacheResult struct {
+	hit bool
+	key string
+	// The product the cached outputs were produced by.
+	source string
+}
+
+func newAnalysisCache(dir, srcDir, revision string) *analysisCache {
+	return &analysisCache{
+		dir:            dir,
+		srcDir:         srcDir,
+		revision:       revision,
+		fileHashes:     make(map[string]string),
+		goPackageFiles: make(map[string]bool),
+		results:        make(map[string]analysisCacheResult),
+	}
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashSourceFile returns the hash of a file in the source tree, which is only read once.
+func (c *analysisCache) hashSourceFile(path string) (string, error) {
+	c.lock.Lock()
+	hash, ok := c.fileHashes[path]
+	c.lock.Unlock()
+	if ok {
+		return hash, nil
+	}
+
+	f, err := os.Open(filepath.Join(c.srcDir, path))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash, err = hashReader(f)
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	c.fileHashes[path] = hash
+	c.lock.Unlock()
+	return hash, nil
+}
+
+// writeFileListHashes writes the path and hash of every file in the lists written by the source
+// finder to w.
+func (c *analysisCache) writeFileListHashes(w io.Writer, prefix, outDir string, lists []string) error {
+	var files []string
+	for _, list := range lists {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, ".module_paths", list))
+		if err != nil {
+			return err
+		}
+		files = append(files, strings.Fields(string(data))...)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		hash, err := c.hashSourceFile(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %s %s\n", prefix, file, hash)
+	}
+	return nil
+}
+
+// walkGoSources calls fn with the path, relative to the source tree, of every Go source file in
+// dir.  A missing dir is not an error.
+func (c *analysisCache) walkGoSources(dir string, fn func(rel string) error) error {
+	err := filepath.WalkDir(filepath.Join(c.srcDir, dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.srcDir, path)
+		if err != nil {
+			return err
+		}
+		return fn(rel)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// soongSourcesHash returns a hash of the Go sources of Soong, which is only computed once.
+func (c *analysisCache) soongSourcesHash() (string, error) {
+	c.soongSourcesOnce.Do(func() {
+		h := sha256.New()
+		for _, dir := range soongSourceDirs {
+			err := c.walkGoSources(dir, func(rel string) error {
+				hash, err := c.hashSourceFile(rel)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(h, "%s %s\n", rel, hash)
+				return nil
+			})
+			if err != nil {
+				c.soongSourcesErr = err
+				return
+			}
+		}
+		c.soongSources = hex.EncodeToString(h.Sum(nil))
+	})
+	return c.soongSources, c.soongSourcesErr
+}
+
+var goPackageModuleTypeRegexp = regexp.MustCompile(`\b(` + strings.Join(goPackageModuleTypes, "|") + `)\s*\{`)
+
+// inSoongSourceDirs returns true if path is in one of soongSourceDirs.
+func inSoongSourceDirs(path string) bool {
+	for _, dir := range soongSourceDirs {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writePluginSourceHashes writes the path and hash of the Go sources of every Soong plugin, i.e.
+// of the directories outside of soongSourceDirs whose Android.bp file defines a Go package or
+// binary, to w.
+func (c *analysisCache) writePluginSourceHashes(w io.Writer, outDir string) error {
+	var files []string
+	for _, list := range androidBpLists {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, ".module_paths", list))
+		if err != nil {
+			return err
+		}
+		files = append(files, strings.Fields(string(data))...)
+	}
+
+	sources := make(map[string]bool)
+	for _, file := range files {
+		if inSoongSourceDirs(file) {
+			continue
+		}
+		isPlugin, err := c.definesGoPackage(file)
+		if err != nil {
+			return err
+		}
+		if !isPlugin {
+			continue
+		}
+		err = c.walkGoSources(filepath.Dir(file), func(rel string) error {
+			sources[rel] = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(sources))
+	for path := range sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		hash, err := c.hashSourceFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "plugin %s %s\n", path, hash)
+	}
+	return nil
+}
+
+// definesGoPackage returns true if the Android.bp file at path in the source tree defines a Go
+// package or binary, which is only checked once per file.
+func (c *analysisCache) definesGoPackage(path string) (bool, error) {
+	c.lock.Lock()
+	ret, ok := c.goPackageFiles[path]
+	c.lock.Unlock()
+	if ok {
+		return ret, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(c.srcDir, path))
+	if err != nil {
+		return false, err
+	}
+	ret = goPackageModuleTypeRegexp.Match(data)
+
+	c.lock.Lock()
+	c.goPackageFiles[path] = ret
+	c.lock.Unlock()
+	return ret, nil
+}
+
+// ckatiPath returns the path of the ckati binary that soong_ui runs, relative to the source tree.
+func ckatiPath() string {
+	return filepath.Join("prebuilts/build-tools", runtime.GOOS+"-x86", "bin", "ckati")
+}
+
+// keyDescription describes everything the result of running soong_ui with args for product
+// depends on, once product config has been run in outDir, except for the environment, see key.
+func (c *analysisCache) keyDescription(outDir, product, variant string, args []string, runsKati bool) (string, error) {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "version %d\n", analysisCacheVersion)
+	fmt.Fprintf(&buf, "revision %s\n", c.revision)
+	fmt.Fprintf(&buf, "args %s\n", strings.Join(args, " "))
+	fmt.Fprintf(&buf, "variant %s\n", variant)
+	if runsKati {
+		fmt.Fprintf(&buf, "product %s\n", product)
+		hash, err := c.hashSourceFile(ckatiPath())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "ckati %s\n", hash)
+	}
+
+	soongSources, err := c.soongSourcesHash()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&buf, "soong %s\n", soongSources)
+	if err := c.writePluginSourceHashes(&buf, outDir); err != nil {
+		return "", err
+	}
+
+	// The product variables written by product config, e.g. soong.<product>.variables
+	variables, err := filepath.Glob(filepath.Join(outDir, "soong", "soong*.variables"))
+	if err != nil {
+		return "", err
+	}
+	if len(variables) == 0 {
+		return "", fmt.Errorf("no soong variables found in %s", filepath.Join(outDir, "soong"))
+	}
+	sort.Strings(variables)
+	for _, file := range variables {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		hash, err := hashReader(bytes.NewReader(toPlaceholders(data, outDir, product)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "variables %s %s\n", strings.ReplaceAll(filepath.Base(file), product, productPlaceholder), hash)
+	}
+
+	if err := c.writeFileListHashes(&buf, "bp", outDir, androidBpLists); err != nil {
+		return "", err
+	}
+	if runsKati {
+		if err := c.writeFileListHashes(&buf, "mk", outDir, makefileLists); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// usedEnvVars returns the names of the environment variables that soong_build read in outDir,
+// from the soong.environment.used files it wrote, except for productEnvVars.
+func usedEnvVars(outDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(outDir, "soong", "soong.environment.used*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no soong.environment.used files found in %s", filepath.Join(outDir, "soong"))
+	}
+	names := make(map[string]bool)
+	for _, file := range files {
+		env, err := shared.EnvFromFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for name := range env {
+			names[name] = true
+		}
+	}
+	for _, name := range productEnvVars {
+		delete(names, name)
+	}
+
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (c *analysisCache) envFile(description string) string {
+	return filepath.Join(c.dir, analysisCacheEnvDir, keyFromDescription(description))
+}
+
+// keyWithEnv returns the key for description and the values in getenv of the environment
+// variables in names.
+func keyWithEnv(description string, names []string, getenv func(string) string) string {
+	var buf strings.Builder
+	buf.WriteString(description)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "env %s=%q\n", name, getenv(name))
+	}
+	return keyFromDescription(buf.String())
+}
+
+// key returns the key of the results described by description when soong_ui runs in the
+// environment of getenv.  The key contains the values of the environment variables that
+// soong_build read when results with the same description were stored, and it is unknown if
+// none were.
+func (c *analysisCache) key(description string, getenv func(string) string) (string, bool) {
+	data, err := ioutil.ReadFile(c.envFile(description))
+	if err != nil {
+		return "", false
+	}
+	return keyWithEnv(description, strings.Fields(string(data)), getenv), true
+}
+
+// envGetter returns a function that returns the value of a variable in env, a list of
+// NAME=value entries where the last one wins, like in exec.Cmd.Env.
+func envGetter(env []string) func(string) string {
+	values := make(map[string]string)
+	for _, entry := range env {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			values[name] = value
+		}
+	}
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func keyFromDescription(description string) string {
+	hash := sha256.Sum256([]byte(description))
+	return hex.EncodeToString(hash[:])
+}
+
+// isNameChar returns true for the characters that can be part of a product name.
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// replaceName replaces the occurrences of old in s that are not part of a longer name with new.
+func replaceName(s []byte, old, new string) []byte {
+	var ret []byte
+	last := 0
+	for i := 0; i < len(s); {
+		j := bytes.Index(s[i:], []byte(old))
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(old)
+		if (start == 0 || !isNameChar(s[start-1])) && (end == len(s) || !isNameChar(s[end])) {
+			ret = append(ret, s[last:start]...)
+			ret = append(ret, new...)
+			last = end
+		}
+		i = start + 1
+	}
+	if last == 0 {
+		return s
+	}
+	return append(ret, s[last:]...)
+}
+
+// outDirNames returns the names outDir may appear as in the outputs.
+func outDirNames(outDir string) []string {
+	names := []string{filepath.Clean(outDir)}
+	// The absolute path is replaced first, as it contains a relative outDir.
+	if abs, err := filepath.Abs(outDir); err == nil && abs != names[0] {
+		names = append([]string{abs}, names...)
+	}
+	return names
+}
+
+// toPlaceholders replaces outDir and the name of product in data with placeholders.
+func toPlaceholders(data []byte, outDir, product string) []byte {
+	for _, name := range outDirNames(outDir) {
+		data = replaceName(data, name, outDirPlaceholder)
+	}
+	return replaceName(data, product, productPlaceholder)
+}
+
+// fromPlaceholders replaces the placeholders in data with outDir and the name of product.
+func fromPlaceholders(data []byte, outDir, product string) []byte {
+	data = bytes.ReplaceAll(data, []byte(outDirPlaceholder), []byte(outDir))
+	return bytes.ReplaceAll(data, []byte(productPlaceholder), []byte(product))
+}
+
+// copyFileReplacing copies from to to, passing every line through replace.  Ninja files can be
+// large, so they are not read into memory at once.
+func copyFileReplacing(from, to string, replace func([]byte) []byte) error {
+	fromFile, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer fromFile.Close()
+
+	toFile, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	defer toFile.Close()
+
+	r := bufio.NewReader(fromFile)
+	w := bufio.NewWriter(toFile)
+	for {
+		line, err := r.ReadBytes('\n')
+		if _, err := w.Write(replace(line)); err != nil {
+			return err
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return toFile.Close()
+}
+
+// cachedOutputs returns the outputs of Soong and Kati for product in outDir, relative to outDir.
+func cachedOutputs(outDir, product string) ([]string, error) {
+	var outputs []string
+	for _, pattern := range []string{
+		filepath.Join("soong", "build."+product+".ninja"),
+		"build-" + product + "*.ninja",
+	} {
+		matches, err := filepath.Glob(filepath.Join(outDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(outDir, match)
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, rel)
+		}
+	}
+	sort.Strings(outputs)
+	return outputs, nil
+}
+
+func (c *analysisCache) entryDir(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// lookup returns whether there is an entry for key in the cache, and which product it was
+// produced by.
+func (c *analysisCache) lookup(key string) (bool, string) {
+	data, err := ioutil.ReadFile(filepath.Join(c.entryDir(key), "product"))
+	if err != nil {
+		return false, ""
+	}
+	if _, err := os.Stat(filepath.Join(c.entryDir(key), analysisCacheKeyFile)); err != nil {
+		return false, ""
+	}
+	return true, strings.TrimSpace(string(data))
+}
+
+// restore copies the outputs in the entry for key to outDir, renaming them and rewriting their
+// contents for product.
+func (c *analysisCache) restore(key, outDir, product string) error {
+	outputsDir := filepath.Join(c.entryDir(key), "outputs")
+	return filepath.WalkDir(outputsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outputsDir, path)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(outDir, strings.ReplaceAll(rel, productPlaceholder, product))
+		if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+			return err
+		}
+		return copyFileReplacing(path, to, func(line []byte) []byte {
+			return fromPlaceholders(line, outDir, product)
+		})
+	})
+}
+
+// writeFileAtomically writes data to path through a temporary file, so that other shards
+// sharing the cache never see a partial file.
+func writeFileAtomically(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// store adds the outputs of product in outDir to the cache, and returns their key, which
+// contains the values in getenv of the environment variables soong_build read.  The entry is
+// written to a temporary directory first so that other shards sharing the cache never see
+// partial entries.
+func (c *analysisCache) store(description, outDir, product string, getenv func(string) string) (string, error) {
+	names, err := usedEnvVars(outDir)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomically(c.envFile(description), []byte(strings.Join(names, "\n")+"\n")); err != nil {
+		return "", err
+	}
+	key := keyWithEnv(description, names, getenv)
+
+	if found, _ := c.lookup(key); found {
+		return key, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.entryDir(key)), 0777); err != nil {
+		return "", err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(c.entryDir(key)), ".tmp-"+key[:8])
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputs, err := cachedOutputs(outDir, product)
+	if err != nil {
+		return "", err
+	}
+	for _, output := range outputs {
+		to := filepath.Join(tmpDir, "outputs", strings.ReplaceAll(output, product, productPlaceholder))
+		if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+			return "", err
+		}
+		err := copyFileReplacing(filepath.Join(outDir, output), to, func(line []byte) []byte {
+			return toPlaceholders(line, outDir, product)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "product"), []byte(product+"\n"), 0666); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, analysisCacheKeyFile), []byte(description), 0666); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmpDir, c.entryDir(key)); err != nil {
+		// Another shard may have stored the same entry
+		if found, _ := c.lookup(key); found {
+			return key, nil
+		}
+		return "", err
+	}
+	return key, nil
+}
+
+func (c *analysisCache) setResult(product string, result analysisCacheResult) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.results[product] = result
+}
+
+// writeReport writes whether each product was found in the cache to w, and returns the number
+// of hits and misses.
+func (c *analysisCache) writeReport(w io.Writer) (hits, misses int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var products []string
+	for product := range c.results {
+		products = append(products, product)
+	}
+	sort.Strings(products)
+
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	for _, product := range products {
+		result := c.results[product]
+		switch {
+		case result.hit:
+			hits++
+			fmt.Fprintf(bw, "%s hit %s (from %s)\n", product, result.key, result.source)
+		case result.key != "":
+			misses++
+			fmt.Fprintf(bw, "%s miss %s\n", product, result.key)
+		default:
+			misses++
+			fmt.Fprintf(bw, "%s miss\n", product)
+		}
+	}
+	return hits, misses
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupProductConfig writes the files product config and the source finder write in outDir.
+func setupProductConfig(t *testing.T, outDir, product, variables string) {
+	t.Helper()
+	writeTestFile(t, filepath.Join(outDir, "soong", "soong."+product+".variables"), variables)
+	writeTestFile(t, filepath.Join(outDir, ".module_paths", "Android.bp.list"), "Android.bp\na/Android.bp\nvendor/foo/Android.bp")
+	for _, list := range makefileLists {
+		writeTestFile(t, filepath.Join(outDir, ".module_paths", list), "")
+	}
+	writeTestFile(t, filepath.Join(outDir, ".module_paths", "configuration.list"), "device/"+product+"/device.mk")
+}
+
+func TestAnalysisCacheKey(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+	writeTestFile(t, filepath.Join(srcDir, "Android.bp"), "root")
+	writeTestFile(t, filepath.Join(srcDir, "a", "Android.bp"), "a")
+	writeTestFile(t, filepath.Join(srcDir, "build", "soong", "main.go"), "package main")
+	writeTestFile(t, filepath.Join(srcDir, "vendor", "foo", "Android.bp"), "bootstrap_go_package {\n}")
+	writeTestFile(t, filepath.Join(srcDir, "vendor", "foo", "plugin", "plugin.go"), "package plugin")
+	writeTestFile(t, filepath.Join(srcDir, ckatiPath()), "ckati")
+	for _, product := range []string{"p1", "p2"} {
+		writeTestFile(t, filepath.Join(srcDir, "device", product, "device.mk"), product)
+	}
+
+	args := []string{"--make-mode", "--soong-only"}
+	revision := "r1"
+	key := func(product, variables string, runsKati bool) string {
+		t.Helper()
+		c := newAnalysisCache(t.TempDir(), srcDir, revision)
+		productOutDir := filepath.Join(outDir, product)
+		setupProductConfig(t, productOutDir, product, variables)
+		description, err := c.keyDescription(productOutDir, product, "eng", args, runsKati)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keyFromDescription(description)
+	}
+
+	if key("p1", "{}", false) != key("p2", "{}", false) {
+		t.Errorf("expected products with the same Soong inputs to have the same key")
+	}
+	if key("p1", "{}", false) == key("p2", `{"Foo": true}`, false) {
+		t.Errorf("expected products with different variables to have different keys")
+	}
+	// The product name and output directory are replaced by placeholders in the variables.
+	if key("p1", `{"Make_suffix": "-p1", "Out": "`+filepath.Join(outDir, "p1")+`"}`, false) !=
+		key("p2", `{"Make_suffix": "-p2", "Out": "`+filepath.Join(outDir, "p2")+`"}`, false) {
+		t.Errorf("expected products with the same variables other than their names to have the same key")
+	}
+	if key("p1", `{"Foo": "p1x"}`, false) == key("p2", `{"Foo": "p2x"}`, false) {
+		t.Errorf("expected products with different variables containing their names to have different keys")
+	}
+	if key("p1", "{}", true) == key("p2", "{}", true) {
+		t.Errorf("expected products with different Kati inputs to have different keys")
+	}
+
+	before := key("p1", "{}", false)
+	writeTestFile(t, filepath.Join(srcDir, "a", "Android.bp"), "changed")
+	if key("p1", "{}", false) == before {
+		t.Errorf("expected a changed Android.bp file to change the key")
+	}
+
+	before = key("p1", "{}", false)
+	writeTestFile(t, filepath.Join(srcDir, "build", "soong", "main.go"), "package changed")
+	if key("p1", "{}", false) == before {
+		t.Errorf("expected changed Soong sources to change the key")
+	}
+
+	before = key("p1", "{}", false)
+	writeTestFile(t, filepath.Join(srcDir, "vendor", "foo", "plugin", "plugin.go"), "package changed")
+	if key("p1", "{}", false) == before {
+		t.Errorf("expected changed Soong plugin sources to change the key")
+	}
+
+	before = key("p1", "{}", true)
+	writeTestFile(t, filepath.Join(srcDir, ckatiPath()), "changed")
+	if key("p1", "{}", true) == before {
+		t.Errorf("expected a changed ckati to change the key")
+	}
+
+	// Glob results are not part of the key, so results are not reused across revisions.
+	before = key("p1", "{}", false)
+	revision = "r2"
+	if key("p1", "{}", false) == before {
+		t.Errorf("expected a different revision to change the key")
+	}
+
+	c := newAnalysisCache(t.TempDir(), srcDir, revision)
+	if _, err := c.keyDescription(t.TempDir(), "p1", "eng", args, false); err == nil {
+		t.Errorf("expected an error without product config results")
+	}
+}
+
+func TestAnalysisCacheStoreAndRestore(t *testing.T) {
+	c := newAnalysisCache(t.TempDir(), t.TempDir(), "r1")
+	description := "description"
+	getenv := envGetter([]string{"FOO=foo", "UNUSED=unused", "TARGET_PRODUCT=p1"})
+
+	if _, ok := c.key(description, getenv); ok {
+		t.Fatalf("unexpected key before soong_build recorded the environment variables it reads")
+	}
+
+	p1OutDir := t.TempDir()
+	writeTestFile(t, filepath.Join(p1OutDir, "soong", "build.p1.ninja"),
+		"build "+p1OutDir+"/soong/Android-p1.mk: touch\n")
+	writeTestFile(t, filepath.Join(p1OutDir, "build-p1.ninja"),
+		"subninja "+p1OutDir+"/build-p1-package.ninja\n# p10\nkati p1")
+	writeTestFile(t, filepath.Join(p1OutDir, "build-p1-package.ninja"), "kati package p1")
+	writeTestFile(t, filepath.Join(p1OutDir, "soong", "other.ninja"), "not cached")
+	writeTestFile(t, filepath.Join(p1OutDir, "soong", "soong.environment.used.p1.build"),
+		`[{"Key": "FOO", "Value": "foo"}, {"Key": "TARGET_PRODUCT", "Value": "p1"}]`)
+	key, err := c.store(description, p1OutDir, "p1", getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Storing the same key again is a no-op
+	if again, err := c.store(description, p1OutDir, "p1", getenv); err != nil {
+		t.Fatal(err)
+	} else if again != key {
+		t.Errorf("expected the same key %q, got %q", key, again)
+	}
+
+	found, source := c.lookup(key)
+	if !found || source != "p1" {
+		t.Fatalf("expected an entry from p1, got %v, %q", found, source)
+	}
+
+	// The key only depends on the environment variables that soong_build read, other than the
+	// ones that are set for each product.
+	if k, ok := c.key(description, envGetter([]string{"FOO=foo", "UNUSED=other", "TARGET_PRODUCT=p2"})); !ok || k != key {
+		t.Errorf("expected key %q, got %q", key, k)
+	}
+	if k, ok := c.key(description, envGetter([]string{"FOO=bar"})); !ok || k == key {
+		t.Errorf("expected a different key when a used environment variable changes, got %q", k)
+	}
+
+	// The output directory and the name of the product are replaced in the contents of the
+	// outputs too, but not the names that contain the product name.
+	p2OutDir := t.TempDir()
+	if err := c.restore(key, p2OutDir, "p2"); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"soong/build.p2.ninja":   "build " + p2OutDir + "/soong/Android-p2.mk: touch\n",
+		"build-p2.ninja":         "subninja " + p2OutDir + "/build-p2-package.ninja\n# p10\nkati p2",
+		"build-p2-package.ninja": "kati package p2",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(p2OutDir, path))
+		if err != nil {
+			t.Errorf("expected %s to be restored: %v", path, err)
+		} else if string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q", path, expected, string(data))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(p2OutDir, "soong", "other.ninja")); err == nil {
+		t.Errorf("unexpected restored soong/other.ninja")
+	}
+
+	// Results can't be stored without the environment variables soong_build read.
+	if _, err := c.store("other", t.TempDir(), "p3", getenv); err == nil {
+		t.Errorf("expected an error storing results without soong.environment.used")
+	}
+}
+
+func TestAnalysisCacheReport(t *testing.T) {
+	c := newAnalysisCache(t.TempDir(), t.TempDir(), "r1")
+	c.setResult("p2", analysisCacheResult{hit: true, key: "k1", source: "p1"})
+	c.setResult("p1", analysisCacheResult{key: "k1"})
+	c.setResult("p3", analysisCacheResult{})
+
+	buf := &strings.Builder{}
+	hits, misses := c.writeReport(buf)
+	if hits != 1 || misses != 2 {
+		t.Errorf("expected 1 hit and 2 misses, got %d and %d", hits, misses)
+	}
+	expected := "p1 miss k1\np2 hit k1 (from p1)\np3 miss\n"
+	if buf.String() != expected {
+		t.Errorf("incorrect report\nexpected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -59,6 +59,9 @@ var buildVariant = flag.String("variant", "eng", "build variant to use")
 var shardCount = flag.Int("shard-count", 1, "split the products into multiple shards (to spread the build onto multiple machines, etc)")
 var shard = flag.Int("shard", 1, "1-indexed shard to execute")
 
+var analysisCacheDir = flag.String("analysis-cache", "", "directory of a cache of Soong and Kati results, shared by the products and shards of a source tree")
+var analysisCacheRevision = flag.String("analysis-cache-revision", "", "revision of the source tree, like a build ID, that changes whenever files are added or removed. Required with -analysis-cache, which only reuses results of the same revision")
+
 var skipProducts multipleStringArg
 var includeProducts multipleStringArg
 
@@ -167,6 +170,9 @@ type mpContext struct {
 	SoongUi     string
 	MainOutDir  string
 	MainLogsDir string
+
+	// Cache is nil unless -analysis-cache is set
+	Cache *analysisCache
 }
 
 func findNamedProducts(soongUi string, log logger.Logger) []string {
@@ -387,6 +393,12 @@ func main() {
 		MainOutDir:  outputDir,
 		MainLogsDir: logsDir,
 	}
+	if *analysisCacheDir != "" && !*onlyConfig {
+		if *analysisCacheRevision == "" {
+			log.Fatalf("-analysis-cache requires -analysis-cache-revision")
+		}
+		mpCtx.Cache = newAnalysisCache(*analysisCacheDir, ".", *analysisCacheRevision)
+	}
 
 	products := make(chan string, len(productsList))
 	go func() {
@@ -434,6 +446,10 @@ func main() {
 
 	s.Finish()
 
+	if mpCtx.Cache != nil {
+		writeAnalysisCacheReport(mpCtx.Cache, filepath.Join(configLogsDir, "analysis_cache.txt"), log)
+	}
+
 	if failures.count == 1 {
 		log.Fatal("1 failure")
 	} else if failures.count > 1 {
@@ -443,6 +459,18 @@ func main() {
 	}
 }
 
+// writeAnalysisCacheReport writes whether each product was found in the analysis cache to
+// reportFile, and prints the number of hits and misses.
+func writeAnalysisCacheReport(cache *analysisCache, reportFile string, log logger.Logger) {
+	f, err := os.Create(reportFile)
+	if err != nil {
+		log.Fatalf("Error creating analysis cache report: %v", err)
+	}
+	defer f.Close()
+	hits, misses := cache.writeReport(f)
+	log.Printf("Analysis cache: %d hits, %d misses, see %s\n", hits, misses, reportFile)
+}
+
 func cleanupAfterProduct(outDir, productZip string) {
 	if *keepArtifacts {
 		args := zip.ZipArgs{
@@ -504,10 +532,7 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 		args = append(args, bazelStr)
 	}
 
-	cmd := exec.Command(mpctx.SoongUi, args...)
-	cmd.Stdout = consoleLogWriter
-	cmd.Stderr = consoleLogWriter
-	cmd.Env = append(os.Environ(),
+	env := append(os.Environ(),
 		"OUT_DIR="+outDir,
 		"TARGET_PRODUCT="+product,
 		"TARGET_BUILD_VARIANT="+*buildVariant,
@@ -517,10 +542,18 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 		"USE_RBE=false") // Disabling RBE saves ~10 secs per product
 
 	if *alternateResultDir {
-		cmd.Env = append(cmd.Env,
+		env = append(env,
 			"DIST_DIR="+filepath.Join(distDir(outDirBase()), "products/"+product))
 	}
 
+	soongUiCmd := func(args ...string) *exec.Cmd {
+		cmd := exec.Command(mpctx.SoongUi, args...)
+		cmd.Stdout = consoleLogWriter
+		cmd.Stderr = consoleLogWriter
+		cmd.Env = env
+		return cmd
+	}
+
 	action := &status.Action{
 		Description: product,
 		Outputs:     []string{product},
@@ -530,7 +563,11 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 	defer cleanupAfterProduct(outDir, productZip)
 
 	before := time.Now()
-	err = cmd.Run()
+	if mpctx.Cache != nil {
+		err = runSoongUiWithCache(mpctx, product, outDir, args, envGetter(env), soongUiCmd)
+	} else {
+		err = soongUiCmd(args...).Run()
+	}
 
 	if !*onlyConfig && !*onlySoong {
 		katiBuildNinjaFile := filepath.Join(outDir, "build-"+product+".ninja")
@@ -555,6 +592,58 @@ func runSoongUiForProduct(mpctx *mpContext, product string) {
 	})
 }
 
+// runSoongUiWithCache runs product config for product, and then only runs soong_ui with args
+// if its results are not in the analysis cache.
+func runSoongUiWithCache(mpctx *mpContext, product, outDir string, args []string, getenv func(string) string,
+	soongUiCmd func(args ...string) *exec.Cmd) error {
+	result := analysisCacheResult{}
+	defer func() { mpctx.Cache.setResult(product, result) }()
+
+	configArgs := []string{"--make-mode", "--skip-soong-tests", "--skip-ninja", "--config-only"}
+	if bazelStr := getBazelArg(); bazelStr != "" {
+		configArgs = append(configArgs, bazelStr)
+	}
+	if err := soongUiCmd(configArgs...).Run(); err != nil {
+		return err
+	}
+
+	description, err := mpctx.Cache.keyDescription(outDir, product, *buildVariant, args, !*onlySoong)
+	if err != nil {
+		// Analyze the product without the cache
+		mpctx.Logger.Verbosef("%s: not using the analysis cache: %v", product, err)
+		return soongUiCmd(args...).Run()
+	}
+	// The key is unknown until soong_build has run once with the same inputs, and recorded the
+	// environment variables it reads.
+	if key, ok := mpctx.Cache.key(description, getenv); ok {
+		result.key = key
+		if found, source := mpctx.Cache.lookup(key); found {
+			// The outputs are only needed to archive them
+			if *keepArtifacts {
+				if err := mpctx.Cache.restore(key, outDir, product); err != nil {
+					return fmt.Errorf("restoring the outputs of %s from the analysis cache: %w", source, err)
+				}
+			}
+			result.hit = true
+			result.source = source
+			mpctx.Logger.Verbosef("%s: analysis cache hit %s (from %s)", product, key, source)
+			return nil
+		}
+	}
+
+	mpctx.Logger.Verbosef("%s: analysis cache miss %s", product, result.key)
+	if err := soongUiCmd(args...).Run(); err != nil {
+		return err
+	}
+	key, err := mpctx.Cache.store(description, outDir, product, getenv)
+	if err != nil {
+		mpctx.Logger.Verbosef("%s: failed to store in the analysis cache: %v", product, err)
+	} else {
+		result.key = key
+	}
+	return nil
+}
+
 type failureCount struct {
 	count int
 	fails []string
@@ -2,6 +2,7 @@ package main
 
 import (
 	"cmp"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -58,9 +59,11 @@ type Flags struct {
 type CommandFunc func(*rc_lib.ReleaseConfigs, Flags, string, []string) error
 
 var commandMap map[string]CommandFunc = map[string]CommandFunc{
+	"diff":  DiffCommand,
 	"get":   GetCommand,
 	"set":   SetCommand,
 	"trace": GetCommand, // Also handled by GetCommand
+	"usage": UsageCommand,
 }
 
 // Find the top of the release config contribution directory.
@@ -333,6 +336,75 @@ func SetCommand(configs *rc_lib.ReleaseConfigs, commonFlags Flags, cmd string, a
 	return nil
 }
 
+func DiffCommand(configs *rc_lib.ReleaseConfigs, commonFlags Flags, cmd string, args []string) error {
+	diffFlags := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffFlags.Parse(args)
+	diffArgs := diffFlags.Args()
+	if len(diffArgs) != 2 {
+		return fmt.Errorf("diff command expected two releases, got: %s", strings.Join(diffArgs, " "))
+	}
+	a, err := configs.GetReleaseConfig(diffArgs[0])
+	if err != nil {
+		return err
+	}
+	b, err := configs.GetReleaseConfig(diffArgs[1])
+	if err != nil {
+		return err
+	}
+	return rc_lib.WriteFlagDifferences(os.Stdout, a, b, rc_lib.DiffReleaseConfigs(a, b))
+}
+
+func UsageCommand(configs *rc_lib.ReleaseConfigs, commonFlags Flags, cmd string, args []string) error {
+	var usagePath string
+	var jsonOutput bool
+	if len(commonFlags.targetReleases) > 1 {
+		return fmt.Errorf("usage command only allows one --release argument.  Got: %s", strings.Join(commonFlags.targetReleases, " "))
+	}
+	usageFlags := flag.NewFlagSet("usage", flag.ExitOnError)
+	usageFlags.StringVar(&usagePath, "usage", filepath.Join(filepath.Dir(commonFlags.outDir), "build_flag_usage.json"),
+		"build_flag_usage.json written by soong_build")
+	usageFlags.BoolVar(&jsonOutput, "json", false, "write the report as json")
+	usageFlags.Parse(args)
+
+	config, err := configs.GetReleaseConfig(commonFlags.targetReleases[0])
+	if err != nil {
+		return err
+	}
+	usage, err := rc_lib.ReadFlagUsage(usagePath)
+	if err != nil {
+		return err
+	}
+	report := config.FlagUsageReport(usage)
+	if jsonOutput {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	}
+	return report.Write(os.Stdout)
+}
+
+// The lint command loads the release configs itself, since it reports
+// problems that prevent them from being generated.
+func LintCommand(commonFlags Flags, args []string) error {
+	lintFlags := flag.NewFlagSet("lint", flag.ExitOnError)
+	lintFlags.Parse(args)
+
+	findings, err := rc_lib.LintReleaseConfigMaps(commonFlags.maps, commonFlags.useGetBuildVar)
+	if err != nil {
+		return err
+	}
+	for _, finding := range findings {
+		fmt.Println(finding)
+	}
+	if len(findings) > 0 {
+		return fmt.Errorf("Found %d problems in the release configs", len(findings))
+	}
+	return nil
+}
+
 func main() {
 	var commonFlags Flags
 	var configs *rc_lib.ReleaseConfigs
@@ -375,6 +447,13 @@ func main() {
 		errorExit(err)
 	}
 
+	if flag.Arg(0) == "lint" {
+		if err = LintCommand(commonFlags, flag.Args()[1:]); err != nil {
+			errorExit(err)
+		}
+		return
+	}
+
 	// Get the current state of flagging.
 	relName := commonFlags.targetReleases[0]
 	if relName == "--all" || relName == "-all" {
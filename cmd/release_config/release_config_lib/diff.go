@@ -0,0 +1,92 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A flag whose value differs between two release configs.
+type FlagDifference struct {
+	// The name of the flag.
+	Name string
+
+	// The flag artifact in each release config.  Nil if the flag is
+	// redacted in that release config.
+	A, B *FlagArtifact
+}
+
+// Compare the effective flag values of two release configs.
+//
+// Both release configs must have been generated.
+//
+// Returns:
+//
+//	[]FlagDifference: the flags with different values, sorted by name.
+func DiffReleaseConfigs(a, b *ReleaseConfig) []FlagDifference {
+	names := make(map[string]bool)
+	for name := range a.FlagArtifacts {
+		names[name] = true
+	}
+	for name := range b.FlagArtifacts {
+		names[name] = true
+	}
+
+	var ret []FlagDifference
+	for _, name := range SortedMapKeys(names) {
+		aFa, bFa := a.FlagArtifacts[name], b.FlagArtifacts[name]
+		if aFa != nil && bFa != nil && proto.Equal(aFa.Value, bFa.Value) {
+			continue
+		}
+		ret = append(ret, FlagDifference{Name: name, A: aFa, B: bFa})
+	}
+	return ret
+}
+
+// Write the differences between two release configs, with the trace of
+// where each value was set.
+func WriteFlagDifferences(w io.Writer, a, b *ReleaseConfig, diffs []FlagDifference) error {
+	writeValue := func(config *ReleaseConfig, fa *FlagArtifact) error {
+		if fa == nil {
+			_, err := fmt.Fprintf(w, "  %s: REDACTED\n", config.Name)
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "  %s: '%s'\n", config.Name, MarshalValue(fa.Value)); err != nil {
+			return err
+		}
+		for _, trace := range fa.Traces {
+			if _, err := fmt.Fprintf(w, "    => \"%s\" in %s\n", MarshalValue(trace.Value), *trace.Source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, diff := range diffs {
+		if _, err := fmt.Fprintln(w, diff.Name); err != nil {
+			return err
+		}
+		if err := writeValue(a, diff.A); err != nil {
+			return err
+		}
+		if err := writeValue(b, diff.B); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDiffReleaseConfigs(t *testing.T) {
+	mapPath := writeReleaseConfigDir(t, map[string]string{
+		"release_config_map.textproto":                    `default_containers: "system"`,
+		"flag_declarations/RELEASE_FOO.textproto":         `name: "RELEASE_FOO" namespace: "test" value { bool_value: false } workflow: LAUNCH`,
+		"flag_declarations/RELEASE_BAR.textproto":         `name: "RELEASE_BAR" namespace: "test" value { string_value: "a" } workflow: LAUNCH`,
+		"flag_declarations/RELEASE_SECRET.textproto":      `name: "RELEASE_SECRET" namespace: "test" value { string_value: "s" } workflow: LAUNCH`,
+		"release_configs/trunk_staging.textproto":         `name: "trunk_staging"`,
+		"release_configs/trunk.textproto":                 `name: "trunk" inherits: "trunk_staging"`,
+		"flag_values/trunk_staging/RELEASE_FOO.textproto": `name: "RELEASE_FOO" value { bool_value: true }`,
+		"flag_values/trunk/RELEASE_BAR.textproto":         `name: "RELEASE_BAR" value { string_value: "b" }`,
+		"flag_values/trunk/RELEASE_SECRET.textproto":      `name: "RELEASE_SECRET" redacted: true`,
+	})
+
+	configs, err := ReadReleaseConfigMaps(StringList{mapPath}, "trunk_staging", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := configs.GetReleaseConfig("trunk_staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := configs.GetReleaseConfig("trunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := DiffReleaseConfigs(a, b)
+	var names []string
+	for _, diff := range diffs {
+		names = append(names, diff.Name)
+	}
+	if expected := "RELEASE_BAR RELEASE_SECRET"; strings.Join(names, " ") != expected {
+		t.Errorf("Expected %q found %q", expected, strings.Join(names, " "))
+	}
+	if len(diffs) == 2 && (diffs[1].A == nil || diffs[1].B != nil) {
+		t.Errorf("Expected RELEASE_SECRET to be redacted only in trunk")
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteFlagDifferences(out, a, b, diffs); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"RELEASE_BAR\n  trunk_staging: 'a'\n",
+		"  trunk: 'b'\n",
+		"flag_values/trunk/RELEASE_BAR.textproto\n",
+		"RELEASE_SECRET\n  trunk_staging: 's'\n",
+		"  trunk: REDACTED\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// The flag usage written by the build_flag_usage singleton in soong_build.
+type FlagUsage struct {
+	// The modules that read each release build flag.
+	BuildFlagReads map[string][]string `json:"build_flag_reads"`
+
+	AconfigDeclarations []AconfigDeclarationsUsage `json:"aconfig_declarations"`
+	AconfigValueSets    []AconfigValueSetUsage     `json:"aconfig_value_sets"`
+}
+
+type AconfigDeclarationsUsage struct {
+	Name      string `json:"name"`
+	Package   string `json:"package"`
+	Container string `json:"container"`
+}
+
+type AconfigValueSetUsage struct {
+	Name string `json:"name"`
+
+	// The packages of the aconfig_values in the value set.
+	Packages []string `json:"packages"`
+}
+
+func ReadFlagUsage(path string) (*FlagUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	usage := &FlagUsage{}
+	if err := json.Unmarshal(data, usage); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return usage, nil
+}
+
+// The usage of the flags in a release config.
+type FlagUsageReport struct {
+	// The release config.
+	Name string `json:"name"`
+
+	// The modules that read each flag in the release config.
+	Consumers map[string][]string `json:"consumers"`
+
+	// Flags in the release config with no reads recorded by soong_build.  They
+	// may still be read by makefiles, product configuration or other tools, so
+	// they are not necessarily unused.
+	FlagsNotReadBySoong []string `json:"flags_not_read_by_soong"`
+
+	// Flags that modules read, but are not in the release config.
+	UndeclaredFlags []string `json:"undeclared_flags"`
+
+	// Value sets in RELEASE_ACONFIG_VALUE_SETS without an aconfig_value_set module.
+	MissingValueSets []string `json:"missing_value_sets"`
+
+	// aconfig_value_set modules that are not in RELEASE_ACONFIG_VALUE_SETS.
+	UnusedValueSets []string `json:"unused_value_sets"`
+
+	// Packages that the value sets in RELEASE_ACONFIG_VALUE_SETS set values
+	// for, but no aconfig_declarations module declares.
+	UndeclaredAconfigPackages []string `json:"undeclared_aconfig_packages"`
+}
+
+// Cross-reference the flags in a release config with their usage.
+//
+// Args:
+//
+//	usage *FlagUsage: the usage written by soong_build.
+//
+// Returns:
+//
+//	*FlagUsageReport: the usage of the flags in this release config.
+func (config *ReleaseConfig) FlagUsageReport(usage *FlagUsage) *FlagUsageReport {
+	report := &FlagUsageReport{
+		Name:      config.Name,
+		Consumers: make(map[string][]string),
+	}
+
+	for _, name := range config.FlagArtifacts.SortedFlagNames() {
+		if consumers := usage.BuildFlagReads[name]; len(consumers) > 0 {
+			report.Consumers[name] = consumers
+		} else {
+			report.FlagsNotReadBySoong = append(report.FlagsNotReadBySoong, name)
+		}
+	}
+	for name := range usage.BuildFlagReads {
+		if _, ok := config.FlagArtifacts[name]; !ok {
+			report.UndeclaredFlags = append(report.UndeclaredFlags, name)
+		}
+	}
+	slices.Sort(report.UndeclaredFlags)
+
+	releaseValueSets := make(map[string]bool)
+	if fa, ok := config.FlagArtifacts["RELEASE_ACONFIG_VALUE_SETS"]; ok {
+		for _, valueSet := range strings.Fields(fa.Value.GetStringValue()) {
+			releaseValueSets[valueSet] = true
+		}
+	}
+	declaredPackages := make(map[string]bool)
+	for _, decl := range usage.AconfigDeclarations {
+		declaredPackages[decl.Package] = true
+	}
+	valueSets := make(map[string]bool)
+	undeclaredPackages := make(map[string]bool)
+	for _, valueSet := range usage.AconfigValueSets {
+		valueSets[valueSet.Name] = true
+		if !releaseValueSets[valueSet.Name] {
+			report.UnusedValueSets = append(report.UnusedValueSets, valueSet.Name)
+			continue
+		}
+		for _, pkg := range valueSet.Packages {
+			if !declaredPackages[pkg] {
+				undeclaredPackages[pkg] = true
+			}
+		}
+	}
+	for _, valueSet := range SortedMapKeys(releaseValueSets) {
+		if !valueSets[valueSet] {
+			report.MissingValueSets = append(report.MissingValueSets, valueSet)
+		}
+	}
+	slices.Sort(report.UnusedValueSets)
+	report.UndeclaredAconfigPackages = SortedMapKeys(undeclaredPackages)
+	return report
+}
+
+// Write the report as text.
+func (report *FlagUsageReport) Write(w io.Writer) error {
+	var sb strings.Builder
+	section := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		fmt.Fprintf(&sb, "%s:\n", title)
+		for _, name := range names {
+			fmt.Fprintf(&sb, "  %s\n", name)
+		}
+		sb.WriteString("\n")
+	}
+
+	fmt.Fprintf(&sb, "# TARGET_RELEASE=%s\n\n", report.Name)
+	section("Flags not read by Soong (they may still be read by makefiles or other tools)", report.FlagsNotReadBySoong)
+	section("Flags read but not declared", report.UndeclaredFlags)
+	section("Value sets in RELEASE_ACONFIG_VALUE_SETS without an aconfig_value_set", report.MissingValueSets)
+	section("aconfig_value_sets not in RELEASE_ACONFIG_VALUE_SETS", report.UnusedValueSets)
+	section("aconfig packages with values but no aconfig_declarations", report.UndeclaredAconfigPackages)
+
+	names := make([]string, 0, len(report.Consumers))
+	for name := range report.Consumers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if len(names) > 0 {
+		sb.WriteString("Consumers:\n")
+	}
+	for _, name := range names {
+		fmt.Fprintf(&sb, "  %s\n", name)
+		for _, consumer := range report.Consumers[name] {
+			fmt.Fprintf(&sb, "    %s\n", consumer)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
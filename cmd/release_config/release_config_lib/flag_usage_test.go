@@ -0,0 +1,90 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFlagUsageReport(t *testing.T) {
+	mapPath := writeReleaseConfigDir(t, map[string]string{
+		"release_config_map.textproto":            `default_containers: "system"`,
+		"flag_declarations/RELEASE_FOO.textproto": `name: "RELEASE_FOO" namespace: "test" value { bool_value: false } workflow: LAUNCH`,
+		"flag_declarations/RELEASE_BAR.textproto": `name: "RELEASE_BAR" namespace: "test" value { bool_value: false } workflow: LAUNCH`,
+		"release_configs/trunk_staging.textproto": `name: "trunk_staging" aconfig_value_sets: "value_set_a" aconfig_value_sets: "value_set_missing"`,
+	})
+	configs, err := ReadReleaseConfigMaps(StringList{mapPath}, "trunk_staging", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := configs.GetReleaseConfig("trunk_staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usagePath := filepath.Join(t.TempDir(), "build_flag_usage.json")
+	err = os.WriteFile(usagePath, []byte(`{
+		"build_flag_reads": {
+			"RELEASE_FOO": ["libfoo", "libfoo_test"],
+			"RELEASE_GONE": ["libgone"],
+			"RELEASE_ACONFIG_VALUE_SETS": ["com.example.foo-aconfig"]
+		},
+		"aconfig_declarations": [{"name": "com.example.foo-aconfig", "package": "com.example.foo", "container": "system"}],
+		"aconfig_value_sets": [
+			{"name": "value_set_a", "packages": ["com.example.foo", "com.example.old"]},
+			{"name": "value_set_b", "packages": ["com.example.foo"]}
+		]
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := ReadFlagUsage(usagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := config.FlagUsageReport(usage)
+	check := func(what string, expected string, actual []string) {
+		t.Helper()
+		if strings.Join(actual, " ") != expected {
+			t.Errorf("Expected %s %q found %q", what, expected, strings.Join(actual, " "))
+		}
+	}
+	check("flags not read by soong", "RELEASE_BAR", report.FlagsNotReadBySoong)
+	check("undeclared flags", "RELEASE_GONE", report.UndeclaredFlags)
+	check("RELEASE_FOO consumers", "libfoo libfoo_test", report.Consumers["RELEASE_FOO"])
+	check("RELEASE_ACONFIG_VALUE_SETS consumers", "com.example.foo-aconfig", report.Consumers["RELEASE_ACONFIG_VALUE_SETS"])
+	check("missing value sets", "value_set_missing", report.MissingValueSets)
+	check("unused value sets", "value_set_b", report.UnusedValueSets)
+	check("undeclared aconfig packages", "com.example.old", report.UndeclaredAconfigPackages)
+
+	out := &bytes.Buffer{}
+	if err := report.Write(out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Flags not read by Soong (they may still be read by makefiles or other tools):\n  RELEASE_BAR\n",
+		"Flags read but not declared:\n  RELEASE_GONE\n",
+		"  RELEASE_FOO\n    libfoo\n    libfoo_test\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out.String())
+		}
+	}
+}
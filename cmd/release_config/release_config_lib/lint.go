@@ -0,0 +1,176 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type LintKind string
+
+const (
+	// A flag value is set for a flag that is not declared.
+	LintUndeclaredFlag LintKind = "undeclared-flag"
+
+	// A flag value sets a flag to its default value, and nothing before it
+	// in the inheritance chain changed the value.
+	LintOverrideToDefault LintKind = "override-to-default"
+
+	// A flag value sets a flag to the value that an earlier flag value in the
+	// inheritance chain already set.
+	LintRedundantOverride LintKind = "redundant-override"
+
+	// A release config map or flag declaration has an invalid container.
+	LintContainerMismatch LintKind = "container-mismatch"
+)
+
+// A problem found in the release config files.
+type LintFinding struct {
+	Kind LintKind
+
+	// The file with the problem.
+	Path string
+
+	// The flag with the problem, if any.
+	Flag string
+
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Path, f.Kind, f.Message)
+}
+
+// Record an invalid container.
+//
+// When linting, the problem is recorded and loading continues, otherwise
+// the problem is returned as an error.
+func (configs *ReleaseConfigs) containerError(path, flag, message string) error {
+	if !configs.linting {
+		return fmt.Errorf("%s", message)
+	}
+	configs.lintFindings = append(configs.lintFindings, LintFinding{
+		Kind:    LintContainerMismatch,
+		Path:    path,
+		Flag:    flag,
+		Message: message,
+	})
+	return nil
+}
+
+// Lint the release configs.
+//
+// The release config maps are loaded and all of the release configs are
+// generated, reporting problems that do not prevent the build from using
+// them, as well as undeclared flags and invalid containers, which would.
+//
+// Args:
+//
+//	releaseConfigMapPaths StringList: the release config maps to load.
+//	useBuildVar bool: use get_build_var to find the maps if none are given.
+//
+// Returns:
+//
+//	[]LintFinding: the problems found, sorted by path.
+//	error: any error that prevented linting.
+func LintReleaseConfigMaps(releaseConfigMapPaths StringList, useBuildVar bool) ([]LintFinding, error) {
+	configs := ReleaseConfigsFactory()
+	configs.linting = true
+	if err := configs.loadReleaseConfigMaps(releaseConfigMapPaths, useBuildVar); err != nil {
+		return nil, err
+	}
+	return configs.lint()
+}
+
+func (configs *ReleaseConfigs) lint() ([]LintFinding, error) {
+	findings := configs.lintFindings
+	sortedReleaseConfigs := configs.GetSortedReleaseConfigs()
+
+	// Setting an undeclared flag is an error when generating the release
+	// config, so report and drop those values first.
+	for _, config := range sortedReleaseConfigs {
+		for _, contrib := range config.Contributions {
+			var declared []*FlagValue
+			for _, value := range contrib.FlagValues {
+				name := *value.proto.Name
+				if _, ok := configs.FlagArtifacts[name]; !ok {
+					findings = append(findings, LintFinding{
+						Kind:    LintUndeclaredFlag,
+						Path:    value.path,
+						Flag:    name,
+						Message: fmt.Sprintf("Setting value for undeclared flag %s in %s", name, config.Name),
+					})
+					continue
+				}
+				declared = append(declared, value)
+			}
+			contrib.FlagValues = declared
+		}
+	}
+
+	for _, config := range sortedReleaseConfigs {
+		if err := config.GenerateReleaseConfig(configs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, config := range sortedReleaseConfigs {
+		// Only check the values set by this release config, the inherited
+		// values are checked in the release configs that set them.
+		ownValues := make(map[string]bool)
+		for _, contrib := range config.Contributions {
+			for _, value := range contrib.FlagValues {
+				ownValues[value.path] = true
+			}
+		}
+		for _, name := range config.FlagArtifacts.SortedFlagNames() {
+			if name == "RELEASE_ACONFIG_VALUE_SETS" {
+				// The value sets are accumulated, not overridden.
+				continue
+			}
+			fa := config.FlagArtifacts[name]
+			for i := 1; i < len(fa.Traces); i++ {
+				trace, prev := fa.Traces[i], fa.Traces[i-1]
+				if !ownValues[*trace.Source] || trace.Value == nil || !proto.Equal(trace.Value, prev.Value) {
+					continue
+				}
+				if i == 1 {
+					findings = append(findings, LintFinding{
+						Kind:    LintOverrideToDefault,
+						Path:    *trace.Source,
+						Flag:    name,
+						Message: fmt.Sprintf("%s is set to its default value %q in %s", name, MarshalValue(trace.Value), config.Name),
+					})
+				} else {
+					findings = append(findings, LintFinding{
+						Kind:    LintRedundantOverride,
+						Path:    *trace.Source,
+						Flag:    name,
+						Message: fmt.Sprintf("%s is already set to %q in %s", name, MarshalValue(trace.Value), *prev.Source),
+					})
+				}
+			}
+		}
+	}
+
+	slices.SortStableFunc(findings, func(a, b LintFinding) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+	return findings, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package release_config_lib
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Write a release config directory, and return the path to its release_config_map.
+func writeReleaseConfigDir(t *testing.T, files map[string]string) string {
+	dir := filepath.Join(t.TempDir(), "build", "release")
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "release_config_map.textproto")
+}
+
+func TestLintReleaseConfigMaps(t *testing.T) {
+	mapPath := writeReleaseConfigDir(t, map[string]string{
+		"release_config_map.textproto":                    `default_containers: "system"`,
+		"flag_declarations/RELEASE_FOO.textproto":         `name: "RELEASE_FOO" namespace: "test" value { bool_value: false } workflow: LAUNCH`,
+		"flag_declarations/RELEASE_BAR.textproto":         `name: "RELEASE_BAR" namespace: "test" value { string_value: "default" } workflow: LAUNCH`,
+		"flag_declarations/RELEASE_BAD.textproto":         `name: "RELEASE_BAD" namespace: "test" value { bool_value: false } workflow: LAUNCH containers: "Bad!"`,
+		"release_configs/trunk_staging.textproto":         `name: "trunk_staging"`,
+		"release_configs/trunk.textproto":                 `name: "trunk" inherits: "trunk_staging"`,
+		"flag_values/trunk_staging/RELEASE_FOO.textproto": `name: "RELEASE_FOO" value { bool_value: true }`,
+		"flag_values/trunk_staging/RELEASE_BAR.textproto": `name: "RELEASE_BAR" value { string_value: "default" }`,
+		"flag_values/trunk/RELEASE_FOO.textproto":         `name: "RELEASE_FOO" value { bool_value: true }`,
+		"flag_values/trunk/RELEASE_MISSING.textproto":     `name: "RELEASE_MISSING" value { bool_value: true }`,
+	})
+	dir := filepath.Dir(mapPath)
+
+	findings, err := LintReleaseConfigMaps(StringList{mapPath}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type finding struct {
+		kind LintKind
+		path string
+		flag string
+	}
+	var actual []finding
+	for _, f := range findings {
+		actual = append(actual, finding{f.Kind, f.Path, f.Flag})
+	}
+	expected := []finding{
+		{LintContainerMismatch, filepath.Join(dir, "flag_declarations/RELEASE_BAD.textproto"), "RELEASE_BAD"},
+		{LintRedundantOverride, filepath.Join(dir, "flag_values/trunk/RELEASE_FOO.textproto"), "RELEASE_FOO"},
+		{LintUndeclaredFlag, filepath.Join(dir, "flag_values/trunk/RELEASE_MISSING.textproto"), "RELEASE_MISSING"},
+		{LintOverrideToDefault, filepath.Join(dir, "flag_values/trunk_staging/RELEASE_BAR.textproto"), "RELEASE_BAR"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v found %v", expected, actual)
+	}
+}
@@ -81,6 +81,14 @@ type ReleaseConfigs struct {
 	// case, we will substitute `trunk_staging` values, but the release
 	// config will not be in ALL_RELEASE_CONFIGS_FOR_PRODUCT.
 	allowMissing bool
+
+	// True if we are linting the release configs.  Problems that are
+	// otherwise errors are recorded in lintFindings where possible, so that
+	// they can all be reported.
+	linting bool
+
+	// The problems found while loading the release configs for linting.
+	lintFindings []LintFinding
 }
 
 func (configs *ReleaseConfigs) WriteInheritanceGraph(outFile string) error {
@@ -254,7 +262,9 @@ func (configs *ReleaseConfigs) LoadReleaseConfigMap(path string, ConfigDirIndex
 	}
 	for _, container := range m.proto.DefaultContainers {
 		if !validContainer(container) {
-			return fmt.Errorf("Release config map %s has invalid container %s", path, container)
+			if err := configs.containerError(path, "", fmt.Sprintf("Release config map %s has invalid container %s", path, container)); err != nil {
+				return err
+			}
 		}
 	}
 	configs.FilesUsedMap[path] = true
@@ -280,7 +290,9 @@ func (configs *ReleaseConfigs) LoadReleaseConfigMap(path string, ConfigDirIndex
 		} else {
 			for _, container := range flagDeclaration.Containers {
 				if !validContainer(container) {
-					return fmt.Errorf("Flag declaration %s has invalid container %s", path, container)
+					if err := configs.containerError(path, flagDeclaration.GetName(), fmt.Sprintf("Flag declaration %s has invalid container %s", path, container)); err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -450,23 +462,34 @@ func (configs *ReleaseConfigs) GenerateReleaseConfigs(targetRelease string) erro
 }
 
 func ReadReleaseConfigMaps(releaseConfigMapPaths StringList, targetRelease string, useBuildVar, allowMissing bool) (*ReleaseConfigs, error) {
+	configs := ReleaseConfigsFactory()
+	configs.allowMissing = allowMissing
+	if err := configs.loadReleaseConfigMaps(releaseConfigMapPaths, useBuildVar); err != nil {
+		return nil, err
+	}
+
+	// Now that we have all of the release config maps, can meld them and generate the artifacts.
+	err := configs.GenerateReleaseConfigs(targetRelease)
+	return configs, err
+}
+
+// Load the release config maps, without generating the release configs.
+func (configs *ReleaseConfigs) loadReleaseConfigMaps(releaseConfigMapPaths StringList, useBuildVar bool) error {
 	var err error
 
 	if len(releaseConfigMapPaths) == 0 {
 		releaseConfigMapPaths, err = GetDefaultMapPaths(useBuildVar)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if len(releaseConfigMapPaths) == 0 {
-			return nil, fmt.Errorf("No maps found")
+			return fmt.Errorf("No maps found")
 		}
 		if !useBuildVar {
 			warnf("No --map argument provided.  Using: --map %s\n", strings.Join(releaseConfigMapPaths, " --map "))
 		}
 	}
 
-	configs := ReleaseConfigsFactory()
-	configs.allowMissing = allowMissing
 	mapsRead := make(map[string]bool)
 	var idx int
 	for _, releaseConfigMapPath := range releaseConfigMapPaths {
@@ -482,12 +505,9 @@ func ReadReleaseConfigMaps(releaseConfigMapPaths StringList, targetRelease strin
 		releaseConfigMapPath = filepath.Join(configDir, "release_config_map.textproto")
 		err = configs.LoadReleaseConfigMap(releaseConfigMapPath, idx)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		idx += 1
 	}
-
-	// Now that we have all of the release config maps, can meld them and generate the artifacts.
-	err = configs.GenerateReleaseConfigs(targetRelease)
-	return configs, err
+	return nil
 }
@@ -0,0 +1,98 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fileAccessTraceIgnoredDirs contains files that are never inputs of a build rule.
+var fileAccessTraceIgnoredDirs = []string{
+	"/dev",
+	"/proc",
+	"/sys",
+}
+
+// filterFileAccesses converts the set of absolute paths accessed by a traced command into the
+// sorted list of regular files that will be written to the file access trace.  Files under
+// ignoreDir, usually the sbox sandbox directory whose contents are all declared, are dropped, and
+// files under topDir are converted to paths relative to topDir.
+func filterFileAccesses(accessed map[string]bool, ignoreDir, topDir string) []string {
+	ignoreDirs := append([]string{ignoreDir}, fileAccessTraceIgnoredDirs...)
+	if realIgnoreDir, err := filepath.EvalSymlinks(ignoreDir); err == nil {
+		ignoreDirs = append(ignoreDirs, realIgnoreDir)
+	}
+
+	topDirs := []string{topDir}
+	if realTopDir, err := filepath.EvalSymlinks(topDir); err == nil && realTopDir != topDir {
+		topDirs = append(topDirs, realTopDir)
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	for path := range accessed {
+		if isVisible(path, ignoreDirs) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		for _, dir := range topDirs {
+			if isUnderDir(path, dir) && path != dir {
+				path = strings.TrimPrefix(path, dir+"/")
+				break
+			}
+		}
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// readFileAccessTrace reads a file access trace written by the tracer into accessed.
+func readFileAccessTrace(file string, accessed map[string]bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			accessed[line] = true
+		}
+	}
+	return nil
+}
+
+// writeFileAccessTrace writes the sorted list of accessed files to file.
+func writeFileAccessTrace(file string, accessed map[string]bool) error {
+	var files []string
+	for path := range accessed {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+
+	var contents string
+	if len(files) > 0 {
+		contents = strings.Join(files, "\n") + "\n"
+	}
+	return os.WriteFile(file, []byte(contents), 0666)
+}
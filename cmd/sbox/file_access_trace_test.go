@@ -0,0 +1,53 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilterFileAccesses(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	topDir := filepath.Join(dir, "top")
+	sandboxDir := filepath.Join(topDir, "out", "sandbox")
+
+	writeTestFile(t, filepath.Join(topDir, "external", "foo", "a.h"), "")
+	writeTestFile(t, filepath.Join(sandboxDir, "b.h"), "")
+	writeTestFile(t, filepath.Join(dir, "c.h"), "")
+
+	accessed := map[string]bool{
+		filepath.Join(topDir, "external", "foo", "a.h"): true,
+		filepath.Join(topDir, "external", "foo"):        true,
+		filepath.Join(topDir, "external", "missing.h"):  true,
+		filepath.Join(sandboxDir, "b.h"):                true,
+		filepath.Join(dir, "c.h"):                       true,
+		"/proc/self/maps":                               true,
+		"/dev/null":                                     true,
+	}
+
+	got := filterFileAccesses(accessed, sandboxDir, topDir)
+	want := []string{
+		filepath.Join(dir, "c.h"),
+		"external/foo/a.h",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
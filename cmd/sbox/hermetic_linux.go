@@ -0,0 +1,159 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// hermeticSystemDirs are mounted read-only into the hermetic sandbox so that the shell, the dynamic
+// linker and the host libraries are available to the command.
+var hermeticSystemDirs = []string{
+	"/bin",
+	"/dev",
+	"/etc",
+	"/lib",
+	"/lib64",
+	"/usr",
+}
+
+// wrapHermetic modifies cmd to run inside nsjail with a mount namespace in which only the sandbox
+// directory, the system directories and the directories on $PATH are visible.  Inputs that were
+// not copied into the sandbox can't be read, even by absolute path.  It returns the list of paths
+// that are visible inside the namespace.
+func wrapHermetic(cmd *exec.Cmd, sandboxDir string) ([]string, error) {
+	nsjail, err := filepath.Abs(nsjailPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(nsjail); err != nil {
+		return nil, fmt.Errorf("hermetic_inputs requires nsjail: %w", err)
+	}
+
+	sandboxDir, err = filepath.Abs(sandboxDir)
+	if err != nil {
+		return nil, err
+	}
+	if derefPath, err := filepath.EvalSymlinks(sandboxDir); err == nil {
+		sandboxDir = derefPath
+	}
+
+	readOnlyMounts := hermeticReadOnlyMounts(os.Getenv("PATH"))
+
+	sandboxArgs := []string{
+		// Use the sandbox directory as the working dir
+		"--cwd", sandboxDir,
+
+		// No time limit
+		"-t", "0",
+
+		// Keep all environment variables, sbox doesn't modify them
+		"-e",
+
+		// Set high values, as nsjail uses low defaults.
+		"--rlimit_as", "soft",
+		"--rlimit_core", "soft",
+		"--rlimit_cpu", "soft",
+		"--rlimit_fsize", "soft",
+		"--rlimit_nofile", "soft",
+
+		// Disable newcgroup, since it may require newer kernels
+		"--disable_clone_newcgroup",
+
+		// Only log important warnings / errors
+		"-q",
+
+		// Mount an empty writable tmp dir.  It is mounted first as the other directories may
+		// be inside /tmp in integration tests, and nsjail requires parents to be mounted first.
+		"-T", "/tmp",
+	}
+
+	for _, mount := range readOnlyMounts {
+		sandboxArgs = append(sandboxArgs, "-R", mount)
+	}
+
+	sandboxArgs = append(sandboxArgs,
+		// Mount the sandbox directory read-write, it contains all of the declared inputs, tools
+		// and outputs.
+		"-B", sandboxDir,
+
+		// Stop nsjail from parsing arguments
+		"--")
+
+	cmd.Args = append(append(sandboxArgs, cmd.Path), cmd.Args[1:]...)
+	cmd.Args = append([]string{nsjail}, cmd.Args...)
+	cmd.Path = nsjail
+
+	return append(readOnlyMounts, sandboxDir), nil
+}
+
+// hermeticReadOnlyMounts returns the sorted list of directories that should be mounted read-only
+// in the hermetic sandbox: the system directories, every directory on $PATH, and the directories
+// containing the targets of any symlinks on $PATH, for example the path interposer.
+func hermeticReadOnlyMounts(pathEnv string) []string {
+	dirs := make(map[string]bool)
+	addDir := func(dir string) {
+		if derefPath, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = derefPath
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs[filepath.Clean(dir)] = true
+		}
+	}
+
+	for _, dir := range hermeticSystemDirs {
+		addDir(dir)
+	}
+
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		addDir(dir)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			if target, err := filepath.EvalSymlinks(filepath.Join(dir, entry.Name())); err == nil {
+				addDir(filepath.Dir(target))
+			}
+		}
+	}
+
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+
+	// Drop any directories that are already visible through a parent directory, nsjail doesn't
+	// need them and mounting them again would hide the parent's view.
+	var mounts []string
+	for _, dir := range sorted {
+		if len(mounts) > 0 && isUnderDir(dir, mounts[len(mounts)-1]) {
+			continue
+		}
+		mounts = append(mounts, dir)
+	}
+	return mounts
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestHermeticReadOnlyMounts(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathDir := filepath.Join(dir, "out", ".path")
+	toolDir := filepath.Join(dir, "out", "host", "bin")
+	nestedDir := filepath.Join(dir, "out", "host", "bin", "nested")
+	for _, d := range []string{pathDir, nestedDir} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(toolDir, "path_interposer"), "")
+	if err := os.Symlink(filepath.Join(toolDir, "path_interposer"), filepath.Join(pathDir, "cat")); err != nil {
+		t.Fatal(err)
+	}
+
+	pathEnv := pathDir + ":" + nestedDir + ":relative/dir:" + filepath.Join(dir, "missing")
+	mounts := hermeticReadOnlyMounts(pathEnv)
+
+	contains := func(path string) bool {
+		for _, mount := range mounts {
+			if mount == path {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, want := range []string{pathDir, toolDir} {
+		if !contains(want) {
+			t.Errorf("expected %q in mounts %q", want, mounts)
+		}
+	}
+	for _, notWant := range []string{nestedDir, "relative/dir", filepath.Join(dir, "missing")} {
+		if contains(notWant) {
+			t.Errorf("expected %q not to be in mounts %q", notWant, mounts)
+		}
+	}
+}
+
+func TestWrapHermetic(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeNsjail := filepath.Join(dir, "nsjail")
+	writeTestFile(t, fakeNsjail, "")
+	sandboxDir := filepath.Join(dir, "sandbox")
+	if err := os.MkdirAll(sandboxDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	oldNsjailPath := nsjailPath
+	nsjailPath = fakeNsjail
+	defer func() { nsjailPath = oldNsjailPath }()
+
+	cmd := exec.Command("/bin/bash", "./sbox_command.0.bash")
+	visible, err := wrapHermetic(cmd, sandboxDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Path != fakeNsjail {
+		t.Errorf("expected command to run %q, got %q", fakeNsjail, cmd.Path)
+	}
+
+	n := len(cmd.Args)
+	if n < 4 || cmd.Args[n-3] != "--" || cmd.Args[n-2] != "/bin/bash" || cmd.Args[n-1] != "./sbox_command.0.bash" {
+		t.Errorf("expected command to end with -- /bin/bash ./sbox_command.0.bash, got %q", cmd.Args)
+	}
+
+	if !isExpectedMountFlag(cmd.Args, sandboxDir, "-B") {
+		t.Errorf("expected sandbox dir to be mounted read-write, got %q", cmd.Args)
+	}
+	if isVisible(filepath.Join(dir, "other"), visible) {
+		t.Errorf("expected %q not to be visible, got %q", filepath.Join(dir, "other"), visible)
+	}
+	if !isVisible(filepath.Join(sandboxDir, "out", "foo"), visible) {
+		t.Errorf("expected files in the sandbox to be visible, got %q", visible)
+	}
+}
+
+func isExpectedMountFlag(args []string, path, flag string) bool {
+	for i := 1; i < len(args); i++ {
+		if args[i] == path && args[i-1] == flag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,359 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"android/soong/cmd/sbox/sbox_proto"
+	"android/soong/response"
+)
+
+// The output cache stores the outputs of sandboxed commands in a local directory, keyed on a hash
+// of the command line and the contents of every file copied into the sandbox.  A later run of an
+// identical command restores the outputs from the cache instead of running the command.
+//
+// The cache directory is laid out as:
+//   entries/<key[:2]>/<key>/outputs/<index>  the output files, in the order of copy_after
+//   entries/<key[:2]>/<key>/output           the combined stdout and stderr of the command
+//   entries/<key[:2]>/<key>/size             the total size of the entry in bytes
+//   tmp/                                     entries that are being written
+//   .lock                                    held exclusively while evicting entries, and shared
+//                                            while restoring them
+//
+// The modification time of an entry directory is updated when it is used so that the least
+// recently used entries are evicted first once the total size of the cache exceeds its maximum
+// size.
+
+const (
+	// Bumped whenever the cache key or the layout of the cache changes.
+	outputCacheVersion = "sbox-output-cache-v1"
+
+	outputCacheEntriesDir = "entries"
+	outputCacheTmpDir     = "tmp"
+	outputCacheLockFile   = ".lock"
+
+	outputCacheOutputsDir = "outputs"
+	outputCacheOutputFile = "output"
+	outputCacheSizeFile   = "size"
+)
+
+type outputCache struct {
+	dir     string
+	maxSize int64
+}
+
+func newOutputCache(dir string, maxSize int64) *outputCache {
+	if dir == "" {
+		return nil
+	}
+	return &outputCache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+}
+
+// cacheable returns true if the outputs of command can be cached.  Only commands that run with
+// their inputs sandboxed are cacheable, as otherwise the command could read files whose contents
+// are not part of the cache key.  Commands that write a depfile are not cacheable either, as the
+// depfile describes inputs that were not copied into the sandbox.
+func cacheable(command *sbox_proto.Command) bool {
+	return command.GetChdir() &&
+		!command.GetDisableOutputCache() &&
+		!strings.Contains(command.GetCommand(), depFilePlaceholder) &&
+		len(command.CopyAfter) > 0
+}
+
+// key computes the cache key for command by hashing the command line and the contents of every
+// file that will be copied into the sandbox, which includes any sandboxed tools.
+func (c *outputCache) key(command *sbox_proto.Command) (string, error) {
+	h := sha256.New()
+	writeField := func(s string) {
+		// Length prefix every field so that different combinations can't produce the same hash.
+		fmt.Fprintf(h, "%d:%s\n", len(s), s)
+	}
+
+	writeField(outputCacheVersion)
+	writeField(command.GetCommand())
+	writeField(strconv.FormatBool(command.GetChdir()))
+	// Commands may run tools found on $PATH that aren't copied into the sandbox.
+	writeField(os.Getenv("PATH"))
+
+	hashInput := func(from, to string, executable bool) error {
+		fileHash, err := hashFile(from)
+		if err != nil {
+			return err
+		}
+		writeField(to)
+		writeField(strconv.FormatBool(executable))
+		writeField(fileHash)
+		return nil
+	}
+
+	for _, copyPair := range command.CopyBefore {
+		if err := hashInput(copyPair.GetFrom(), copyPair.GetTo(), copyPair.GetExecutable()); err != nil {
+			return "", err
+		}
+	}
+
+	for _, rspFile := range command.RspFiles {
+		in, err := os.Open(rspFile.GetFile())
+		if err != nil {
+			return "", err
+		}
+		files, err := response.ReadRspFile(in)
+		in.Close()
+		if err != nil {
+			return "", err
+		}
+		writeField(applyPathMappings(rspFile.PathMappings, rspFile.GetFile()))
+		for _, from := range files {
+			if err := hashInput(from, applyPathMappings(rspFile.PathMappings, from), false); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	for _, copyPair := range command.CopyAfter {
+		writeField(copyPair.GetFrom())
+		writeField(copyPair.GetTo())
+		writeField(strconv.FormatBool(copyPair.GetExecutable()))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile returns the sha256 of the contents of a file, or of the target of a symlink.
+func hashFile(path string) (string, error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if stat.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, "symlink:"+target)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		// The permissions of the inputs are copied into the sandbox, and may affect the command.
+		fmt.Fprintf(h, ":%o", stat.Mode().Perm())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *outputCache) entryDir(key string) string {
+	return filepath.Join(c.dir, outputCacheEntriesDir, key[:2], key)
+}
+
+// restore copies the outputs of command from the cache entry for key to their final locations.
+// It returns the cached output of the command and true if the entry existed.  If restoring fails
+// the outputs that were already restored are removed again.
+func (c *outputCache) restore(key string, command *sbox_proto.Command, write writeType) ([]byte, bool, error) {
+	entryDir := c.entryDir(key)
+	outputFile := filepath.Join(entryDir, outputCacheOutputFile)
+	if _, err := os.Stat(outputFile); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+
+	// Hold a shared lock so that the entry isn't evicted while its outputs are being copied.
+	lock, err := os.OpenFile(filepath.Join(c.dir, outputCacheLockFile), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_SH); err != nil {
+		return nil, false, err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	// The entry may have been evicted before the lock was taken.
+	output, err := os.ReadFile(outputFile)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	for i, copyPair := range command.CopyAfter {
+		from := filepath.Join(entryDir, outputCacheOutputsDir, strconv.Itoa(i))
+		if err := copyOneFile(from, copyPair.GetTo(), copyPair.GetExecutable(), requireFromExists, write); err != nil {
+			for _, restored := range command.CopyAfter[:i+1] {
+				os.Remove(restored.GetTo())
+			}
+			return nil, false, fmt.Errorf("failed to restore %q from the output cache: %w", copyPair.GetTo(), err)
+		}
+	}
+
+	// Mark the entry as recently used.
+	now := time.Now()
+	os.Chtimes(entryDir, now, now)
+
+	return output, true, nil
+}
+
+// store copies the outputs of command out of the sandbox directory and into a new cache entry for
+// key, and then evicts old entries if the cache is larger than its maximum size.
+func (c *outputCache) store(key string, command *sbox_proto.Command, sandboxDir string, output []byte) error {
+	tmpRoot := filepath.Join(c.dir, outputCacheTmpDir)
+	if err := os.MkdirAll(tmpRoot, 0777); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(tmpRoot, key)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	size := int64(len(output))
+	for i, copyPair := range command.CopyAfter {
+		from := joinPath(sandboxDir, copyPair.GetFrom())
+		to := filepath.Join(tmpDir, outputCacheOutputsDir, strconv.Itoa(i))
+		if err := copyOneFile(from, to, false, requireFromExists, alwaysWrite); err != nil {
+			return err
+		}
+		if stat, err := os.Lstat(to); err == nil {
+			size += stat.Size()
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, outputCacheOutputFile), output, 0666); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, outputCacheSizeFile), []byte(strconv.FormatInt(size, 10)), 0666); err != nil {
+		return err
+	}
+
+	entryDir := c.entryDir(key)
+	if err := os.MkdirAll(filepath.Dir(entryDir), 0777); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpDir, entryDir); err != nil {
+		// Another sbox process may have stored the same entry concurrently, which is fine.
+		if _, statErr := os.Stat(entryDir); statErr == nil {
+			return nil
+		}
+		return err
+	}
+
+	return c.evict()
+}
+
+type outputCacheEntry struct {
+	dir     string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used entries until the total size of the cache is below its
+// maximum size.
+func (c *outputCache) evict() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+
+	lock, err := os.OpenFile(filepath.Join(c.dir, outputCacheLockFile), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	// Only one process needs to evict at a time, skip eviction if another process is already
+	// doing it or if entries are being restored.  A later store will evict instead.
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return nil
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	if total <= c.maxSize {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, entry := range entries {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.RemoveAll(entry.dir); err != nil {
+			return err
+		}
+		total -= entry.size
+	}
+	return nil
+}
+
+func (c *outputCache) entries() ([]outputCacheEntry, error) {
+	var entries []outputCacheEntry
+	shards, err := os.ReadDir(filepath.Join(c.dir, outputCacheEntriesDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards {
+		shardDir := filepath.Join(c.dir, outputCacheEntriesDir, shard.Name())
+		keys, err := os.ReadDir(shardDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			dir := filepath.Join(shardDir, key.Name())
+			info, err := os.Stat(dir)
+			if err != nil {
+				continue
+			}
+			var size int64
+			if data, err := os.ReadFile(filepath.Join(dir, outputCacheSizeFile)); err == nil {
+				size, _ = strconv.ParseInt(string(data), 10, 64)
+			}
+			entries = append(entries, outputCacheEntry{
+				dir:     dir,
+				size:    size,
+				modTime: info.ModTime(),
+			})
+		}
+	}
+	return entries, nil
+}
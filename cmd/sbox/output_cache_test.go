@@ -0,0 +1,233 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"android/soong/cmd/sbox/sbox_proto"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func writeTestFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func testCacheCommand(dir string) *sbox_proto.Command {
+	return &sbox_proto.Command{
+		Command: proto.String("cp __SBOX_SANDBOX_DIR__/in __SBOX_SANDBOX_DIR__/out/out"),
+		Chdir:   proto.Bool(true),
+		CopyBefore: []*sbox_proto.Copy{{
+			From: proto.String(filepath.Join(dir, "src", "in")),
+			To:   proto.String("in"),
+		}},
+		CopyAfter: []*sbox_proto.Copy{{
+			From: proto.String("out/out"),
+			To:   proto.String(filepath.Join(dir, "gen", "out")),
+		}},
+	}
+}
+
+func TestOutputCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	cache := newOutputCache(filepath.Join(dir, "cache"), 0)
+	writeTestFile(t, filepath.Join(dir, "src", "in"), "foo")
+
+	command := testCacheCommand(dir)
+	key1, err := cache.key(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key2, err := cache.key(testCacheCommand(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key1 != key2 {
+		t.Errorf("expected identical commands to have the same key, got %q and %q", key1, key2)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "src", "in"), "bar")
+	key3, err := cache.key(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key1 == key3 {
+		t.Errorf("expected key to change when the contents of an input changed")
+	}
+
+	command.Command = proto.String("cat __SBOX_SANDBOX_DIR__/in > __SBOX_SANDBOX_DIR__/out/out")
+	key4, err := cache.key(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key3 == key4 {
+		t.Errorf("expected key to change when the command changed")
+	}
+}
+
+func TestOutputCacheable(t *testing.T) {
+	command := testCacheCommand("")
+	if !cacheable(command) {
+		t.Errorf("expected sandboxed command to be cacheable")
+	}
+
+	command.DisableOutputCache = proto.Bool(true)
+	if cacheable(command) {
+		t.Errorf("expected command with disable_output_cache to not be cacheable")
+	}
+
+	command = testCacheCommand("")
+	command.Chdir = proto.Bool(false)
+	if cacheable(command) {
+		t.Errorf("expected command without chdir to not be cacheable")
+	}
+
+	command = testCacheCommand("")
+	command.Command = proto.String("gen --depfile " + depFilePlaceholder)
+	if cacheable(command) {
+		t.Errorf("expected command with a depfile to not be cacheable")
+	}
+}
+
+func TestOutputCacheStoreRestore(t *testing.T) {
+	dir := t.TempDir()
+	cache := newOutputCache(filepath.Join(dir, "cache"), 0)
+	writeTestFile(t, filepath.Join(dir, "src", "in"), "foo")
+	writeTestFile(t, filepath.Join(dir, "sandbox", "out", "out"), "foo")
+
+	command := testCacheCommand(dir)
+	key, err := cache.key(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, hit, err := cache.restore(key, command, alwaysWrite); err != nil {
+		t.Fatal(err)
+	} else if hit {
+		t.Fatalf("expected a miss in an empty cache")
+	}
+
+	if err := cache.store(key, command, filepath.Join(dir, "sandbox"), []byte("warning: foo\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	output, hit, err := cache.restore(key, command, alwaysWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hit {
+		t.Fatalf("expected a hit after storing the outputs")
+	}
+	if g, w := string(output), "warning: foo\n"; g != w {
+		t.Errorf("expected output %q, got %q", w, g)
+	}
+	if g, w := readTestFile(t, filepath.Join(dir, "gen", "out")), "foo"; g != w {
+		t.Errorf("expected restored output to contain %q, got %q", w, g)
+	}
+}
+
+func TestOutputCacheRestoreBrokenEntry(t *testing.T) {
+	dir := t.TempDir()
+	cache := newOutputCache(filepath.Join(dir, "cache"), 0)
+	writeTestFile(t, filepath.Join(dir, "src", "in"), "foo")
+	writeTestFile(t, filepath.Join(dir, "sandbox", "out", "out"), "foo")
+	writeTestFile(t, filepath.Join(dir, "sandbox", "out", "out2"), "bar")
+
+	command := testCacheCommand(dir)
+	command.CopyAfter = append(command.CopyAfter, &sbox_proto.Copy{
+		From: proto.String("out/out2"),
+		To:   proto.String(filepath.Join(dir, "gen", "out2")),
+	})
+	key, err := cache.key(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.store(key, command, filepath.Join(dir, "sandbox"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an entry that is evicted while it is being restored.
+	if err := os.Remove(filepath.Join(cache.entryDir(key), outputCacheOutputsDir, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, hit, err := cache.restore(key, command, alwaysWrite); err == nil {
+		t.Fatalf("expected an error restoring a broken entry")
+	} else if hit {
+		t.Errorf("expected a miss restoring a broken entry")
+	}
+	// The outputs that were restored before the error are removed.
+	if _, err := os.Stat(filepath.Join(dir, "gen", "out")); !os.IsNotExist(err) {
+		t.Errorf("expected the partly restored outputs to be removed, got %v", err)
+	}
+}
+
+func TestOutputCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	cache := newOutputCache(filepath.Join(dir, "cache"), 0)
+
+	var keys []string
+	for i, contents := range []string{"a", "b", "c"} {
+		writeTestFile(t, filepath.Join(dir, "src", "in"), contents)
+		writeTestFile(t, filepath.Join(dir, "sandbox", "out", "out"), "0123456789")
+		command := testCacheCommand(dir)
+		key, err := cache.key(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.store(key, command, filepath.Join(dir, "sandbox"), nil); err != nil {
+			t.Fatal(err)
+		}
+		// Make the entries ordered by last use.
+		mtime := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(cache.entryDir(key), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	// Allow only two of the three 10 byte entries.
+	cache.maxSize = 25
+	if err := cache.evict(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range keys {
+		_, err := os.Stat(cache.entryDir(key))
+		if exists := err == nil; exists != (i > 0) {
+			t.Errorf("entry %d: expected exists to be %v, got %v", i, i > 0, exists)
+		}
+	}
+}
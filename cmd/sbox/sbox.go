@@ -44,6 +44,16 @@ var (
 	manifestFile   string
 	keepOutDir     bool
 	writeIfChanged bool
+	nsjailPath     string
+
+	fileAccessTrace        string
+	internalTraceOutput    string
+	internalTraceIgnoreDir string
+	internalTraceTopDir    string
+
+	outputCacheDir     string
+	outputCacheMaxSize int64
+	sboxOutputCache    *outputCache
 )
 
 const (
@@ -62,6 +72,19 @@ func init() {
 		"whether to keep the sandbox directory when done")
 	flag.BoolVar(&writeIfChanged, "write-if-changed", false,
 		"only write the output files if they have changed")
+	flag.StringVar(&nsjailPath, "nsjail", "prebuilts/build-tools/linux-x86/bin/nsjail",
+		"path to nsjail, used to run the commands when hermetic_inputs is set in the manifest")
+	flag.StringVar(&fileAccessTrace, "file-access-trace", "",
+		"trace the files read by the commands and write the ones outside the sandbox to this file")
+
+	// Used when sbox re-executes itself to trace the file accesses of a command.
+	flag.StringVar(&internalTraceOutput, "internal-trace-output", "", "")
+	flag.StringVar(&internalTraceIgnoreDir, "internal-trace-ignore-dir", "", "")
+	flag.StringVar(&internalTraceTopDir, "internal-trace-top-dir", "", "")
+	flag.StringVar(&outputCacheDir, "output-cache-dir", "",
+		"directory to restore the outputs of sandboxed commands from and store them in")
+	flag.Int64Var(&outputCacheMaxSize, "output-cache-max-size", 10<<30,
+		"maximum size in bytes of the output cache, the least recently used entries are evicted beyond it")
 }
 
 func usageViolation(violation string) {
@@ -83,6 +106,13 @@ func main() {
 	}
 	flag.Parse()
 
+	if internalTraceOutput != "" {
+		os.Exit(runFileAccessTracer(internalTraceOutput, internalTraceIgnoreDir, internalTraceTopDir,
+			flag.Args()))
+	}
+
+	sboxOutputCache = newOutputCache(outputCacheDir, outputCacheMaxSize)
+
 	error := run()
 	if error != nil {
 		fmt.Fprintln(os.Stderr, error)
@@ -168,13 +198,17 @@ func run() error {
 	// If there is more than one command in the manifest use a separate directory for each one.
 	useSubDir := len(manifest.Commands) > 1
 	var commandDepFiles []string
+	var fileAccesses map[string]bool
+	if fileAccessTrace != "" {
+		fileAccesses = make(map[string]bool)
+	}
 
 	for i, command := range manifest.Commands {
 		localTempDir := tempDir
 		if useSubDir {
 			localTempDir = filepath.Join(localTempDir, strconv.Itoa(i))
 		}
-		depFile, err := runCommand(command, localTempDir, i)
+		depFile, err := runCommand(command, localTempDir, i, manifest.GetHermeticInputs(), fileAccesses)
 		if err != nil {
 			// Running the command failed, keep the temporary output directory around in
 			// case a user wants to inspect it for debugging purposes.  Soong will delete
@@ -201,6 +235,13 @@ func run() error {
 		}
 	}
 
+	if fileAccessTrace != "" {
+		err = writeFileAccessTrace(fileAccessTrace, fileAccesses)
+		if err != nil {
+			return fmt.Errorf("failed writing file access trace: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -239,12 +280,19 @@ func readManifest(file string) (*sbox_proto.Manifest, error) {
 }
 
 // runCommand runs a single command from a manifest.  If the command references the
-// __SBOX_DEPFILE__ placeholder it returns the name of the depfile that was used.
-func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (depFile string, err error) {
+// __SBOX_DEPFILE__ placeholder it returns the name of the depfile that was used.  If hermetic is
+// true the command is run in a mount namespace where only the sandbox directory is visible.  If
+// fileAccesses is not nil the command is traced and the files it reads outside the sandbox
+// directory are added to it.
+func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int, hermetic bool,
+	fileAccesses map[string]bool) (depFile string, err error) {
 	rawCommand := command.GetCommand()
 	if rawCommand == "" {
 		return "", fmt.Errorf("command is required")
 	}
+	if hermetic && !command.GetChdir() {
+		return "", fmt.Errorf("hermetic_inputs requires chdir to be set on every command")
+	}
 
 	// Remove files from the output directory
 	err = clearOutputDirectory(command.CopyAfter, outputDir, writeType(writeIfChanged))
@@ -252,6 +300,23 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 		return "", err
 	}
 
+	var cacheKey string
+	// The file accesses of commands restored from the cache can't be traced.
+	if sboxOutputCache != nil && cacheable(command) && fileAccesses == nil {
+		cacheKey, err = sboxOutputCache.key(command)
+		if err != nil {
+			return "", fmt.Errorf("failed to compute output cache key: %w", err)
+		}
+		output, hit, err := sboxOutputCache.restore(cacheKey, command, writeType(writeIfChanged))
+		if err != nil {
+			// Treat a broken cache entry as a miss, the command will write all of its outputs.
+			fmt.Fprintf(os.Stderr, "sbox: %s, running the command\n", err)
+		} else if hit {
+			os.Stdout.Write(output)
+			return "", nil
+		}
+	}
+
 	pathToTempDirInSbox := tempDir
 	if command.GetChdir() {
 		pathToTempDirInSbox = "."
@@ -313,6 +378,24 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 			return "", fmt.Errorf("Failed to update PATH: %w", err)
 		}
 	}
+
+	var hermeticVisiblePaths []string
+	if hermetic {
+		hermeticVisiblePaths, err = wrapHermetic(cmd, tempDir)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var traceFile string
+	if fileAccesses != nil {
+		traceFile = joinPath(tempDir, fmt.Sprintf("sbox_file_accesses.%d", commandIndex))
+		err = wrapFileAccessTrace(cmd, tempDir, traceFile)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	err = cmd.Run()
 
 	if err != nil {
@@ -332,6 +415,9 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 				"The failing command line can be found in\n"+
 				"%s\n",
 			tempDir, scriptPath)
+		if hermeticVisiblePaths != nil {
+			reportUndeclaredAccesses(os.Stderr, buf.Bytes(), tempDir, hermeticVisiblePaths)
+		}
 	}
 
 	// Write the command's combined stdout/stderr.
@@ -346,6 +432,20 @@ func runCommand(command *sbox_proto.Command, tempDir string, commandIndex int) (
 		return "", err
 	}
 
+	if traceFile != "" {
+		err = readFileAccessTrace(traceFile, fileAccesses)
+		if err != nil {
+			return "", fmt.Errorf("failed reading file access trace: %w", err)
+		}
+	}
+
+	if cacheKey != "" {
+		// Failing to populate the cache shouldn't fail the build.
+		if err := sboxOutputCache.store(cacheKey, command, tempDir, buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "sbox: failed to store outputs in the output cache: %s\n", err)
+		}
+	}
+
 	// the created files match the declared files; now move them
 	err = moveFiles(command.CopyAfter, tempDir, "", writeType(writeIfChanged))
 	if err != nil {
@@ -0,0 +1,31 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// wrapFileAccessTrace returns an error on Darwin, which doesn't support ptrace syscall tracing.
+func wrapFileAccessTrace(cmd *exec.Cmd, sandboxDir, traceFile string) error {
+	return fmt.Errorf("--file-access-trace is only supported on Linux")
+}
+
+func runFileAccessTracer(output, ignoreDir, topDir string, args []string) int {
+	fmt.Fprintln(os.Stderr, "sbox: file access tracing is only supported on Linux")
+	return 1
+}
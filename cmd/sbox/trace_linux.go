@@ -0,0 +1,414 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// The file access tracer runs a command under ptrace and records every file that the command or
+// any of its descendants successfully opens for reading or executes.  It uses
+// PTRACE_GET_SYSCALL_INFO so that it doesn't need to know the register layout of the host
+// architecture, which requires Linux 5.3 or newer.
+//
+// The trace is incomplete, so it may under-report the undeclared inputs of a command.  Only the
+// open, openat, openat2, execve and execveat syscalls of the architectures in tracedSyscalls are
+// recorded.  Files that are only stat'ed, listed or read through a symlink, files opened with
+// open_by_handle_at or io_uring, and the syscalls of other ABIs like x32 are missed.
+//
+// sbox runs the tracer by re-executing itself with the --internal-trace-* flags, so that the
+// tracer can reap the traced processes without interfering with the exec.Cmd in the main sbox
+// process.
+
+const (
+	ptraceGetSiginfo     = 0x4202
+	ptraceGetSyscallInfo = 0x420e
+	ptraceOExitKill      = 0x100000
+
+	ptraceSyscallInfoEntry = 1
+	ptraceSyscallInfoExit  = 2
+
+	// The size of siginfo_t, which is the same on all architectures.
+	siginfoSize = 128
+
+	atFdCwd     = -100
+	atEmptyPath = 0x1000
+
+	// The AUDIT_ARCH_* values from linux/audit.h reported in ptrace_syscall_info.arch.
+	auditArchI386    = 0x40000003
+	auditArchX86_64  = 0xc000003e
+	auditArchArm     = 0x40000028
+	auditArchAarch64 = 0xc00000b7
+)
+
+type tracedSyscall int
+
+const (
+	sysOpen tracedSyscall = iota
+	sysOpenat
+	sysOpenat2
+	sysExecve
+	sysExecveat
+)
+
+// tracedSyscalls maps from an audit architecture to the numbers of the traced syscalls.  The
+// numbers depend on the architecture of the syscall rather than the architecture of the host,
+// so that 32-bit processes running on a 64-bit host are traced too.
+var tracedSyscalls = map[uint32]map[uint64]tracedSyscall{
+	auditArchX86_64: {
+		2:   sysOpen,
+		257: sysOpenat,
+		437: sysOpenat2,
+		59:  sysExecve,
+		322: sysExecveat,
+	},
+	auditArchI386: {
+		5:   sysOpen,
+		295: sysOpenat,
+		437: sysOpenat2,
+		11:  sysExecve,
+		358: sysExecveat,
+	},
+	// arm64 only has the *at variants of the syscalls.
+	auditArchAarch64: {
+		56:  sysOpenat,
+		437: sysOpenat2,
+		221: sysExecve,
+		281: sysExecveat,
+	},
+	auditArchArm: {
+		5:   sysOpen,
+		322: sysOpenat,
+		437: sysOpenat2,
+		11:  sysExecve,
+		387: sysExecveat,
+	},
+}
+
+// ptraceSyscallInfo matches struct ptrace_syscall_info from linux/ptrace.h.
+type ptraceSyscallInfo struct {
+	Op                 uint8
+	_                  [3]uint8
+	Arch               uint32
+	InstructionPointer uint64
+	StackPointer       uint64
+	// For entry stops this contains the syscall number followed by the 6 arguments, for exit
+	// stops it contains the return value followed by the is_error flag.
+	Data [8]uint64
+}
+
+// wrapFileAccessTrace modifies cmd to run under the file access tracer, which will write the list
+// of files accessed by the command to traceFile.
+func wrapFileAccessTrace(cmd *exec.Cmd, sandboxDir, traceFile string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	topDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	sandboxDir, err = filepath.Abs(sandboxDir)
+	if err != nil {
+		return err
+	}
+	traceFile, err = filepath.Abs(traceFile)
+	if err != nil {
+		return err
+	}
+
+	cmd.Args = append([]string{self,
+		"--internal-trace-output", traceFile,
+		"--internal-trace-ignore-dir", sandboxDir,
+		"--internal-trace-top-dir", topDir,
+		"--",
+		cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+	return nil
+}
+
+// runFileAccessTracer runs args under ptrace and writes the files it accessed to output.  Files
+// under ignoreDir are not recorded, and files under topDir are recorded relative to it.  It returns
+// the exit code of the traced command.
+func runFileAccessTracer(output, ignoreDir, topDir string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "sbox: missing command to trace")
+		return 1
+	}
+
+	// All ptrace requests must come from the thread that started the tracee.
+	runtime.LockOSThread()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sbox: failed to start traced command: %s\n", err)
+		return 1
+	}
+
+	t := &fileAccessTracer{
+		pending:  make(map[int]string),
+		accessed: make(map[string]bool),
+	}
+	exitCode, err := t.trace(cmd.Process.Pid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sbox: failed to trace command: %s\n", err)
+		return 1
+	}
+
+	files := filterFileAccesses(t.accessed, ignoreDir, topDir)
+	var buf bytes.Buffer
+	for _, file := range files {
+		buf.WriteString(file)
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(output, buf.Bytes(), 0666); err != nil {
+		fmt.Fprintf(os.Stderr, "sbox: failed to write file access trace: %s\n", err)
+		return 1
+	}
+
+	return exitCode
+}
+
+type fileAccessTracer struct {
+	// pending maps from a thread id to the path of the syscall it has entered.
+	pending map[int]string
+	// accessed is the set of absolute paths that were successfully opened or executed.
+	accessed map[string]bool
+}
+
+// trace follows the process pid and all of its descendants until all of them have exited, and
+// returns the exit code of pid.
+func (t *fileAccessTracer) trace(pid int) (int, error) {
+	var ws syscall.WaitStatus
+
+	// The child stops with a SIGTRAP when it calls exec after PTRACE_TRACEME.
+	if _, err := syscall.Wait4(pid, &ws, syscall.WALL, nil); err != nil {
+		return 0, err
+	}
+	if !ws.Stopped() {
+		return 0, fmt.Errorf("traced process did not stop at exec")
+	}
+	err := syscall.PtraceSetOptions(pid, syscall.PTRACE_O_TRACESYSGOOD|
+		syscall.PTRACE_O_TRACEFORK|syscall.PTRACE_O_TRACEVFORK|syscall.PTRACE_O_TRACECLONE|
+		syscall.PTRACE_O_TRACEEXEC|ptraceOExitKill)
+	if err != nil {
+		return 0, err
+	}
+	if err := syscall.PtraceSyscall(pid, 0); err != nil {
+		return 0, err
+	}
+
+	// Descendants that outlive pid, like background jobs of a shell, may still access files, so
+	// the loop continues until there are no traced processes left to wait for.
+	exitCode := -1
+	seen := map[int]bool{pid: true}
+	for {
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.ECHILD {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case ws.Exited():
+			if wpid == pid {
+				exitCode = ws.ExitStatus()
+			}
+			delete(t.pending, wpid)
+		case ws.Signaled():
+			if wpid == pid {
+				exitCode = 128 + int(ws.Signal())
+			}
+			delete(t.pending, wpid)
+		case ws.Stopped():
+			sig := ws.StopSignal()
+			switch {
+			case sig == syscall.SIGTRAP|0x80:
+				// A syscall entry or exit stop.
+				t.syscallStop(wpid)
+				sig = 0
+			case sig == syscall.SIGTRAP:
+				// A fork, clone or exec event stop.
+				sig = 0
+			case sig == syscall.SIGSTOP && !seen[wpid]:
+				// New tracees start with a SIGSTOP that shouldn't be delivered.
+				sig = 0
+			case isGroupStop(wpid):
+				// The stop signal was already delivered.  Injecting it again would stop the
+				// tracee again, resuming it without a signal lets it continue instead.
+				sig = 0
+			}
+			seen[wpid] = true
+			// The tracee may have been killed since it stopped, ignore the error.
+			syscall.PtraceSyscall(wpid, int(sig))
+		}
+	}
+
+	if exitCode < 0 {
+		return 0, fmt.Errorf("traced process %d exited without reporting its exit status", pid)
+	}
+	return exitCode, nil
+}
+
+// isGroupStop returns true if a tracee that stopped with a signal is in a group-stop rather than
+// a signal-delivery-stop, which look the same to a tracer attached with PTRACE_TRACEME.
+// PTRACE_GETSIGINFO fails with EINVAL for group-stops.
+func isGroupStop(tid int) bool {
+	var siginfo [siginfoSize]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceGetSiginfo, uintptr(tid), 0,
+		uintptr(unsafe.Pointer(&siginfo[0])), 0, 0)
+	return errno == syscall.EINVAL
+}
+
+func (t *fileAccessTracer) syscallStop(tid int) {
+	var info ptraceSyscallInfo
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceGetSyscallInfo, uintptr(tid),
+		unsafe.Sizeof(info), uintptr(unsafe.Pointer(&info)), 0, 0)
+	if errno != 0 {
+		return
+	}
+
+	switch info.Op {
+	case ptraceSyscallInfoEntry:
+		delete(t.pending, tid)
+		syscalls, ok := tracedSyscalls[info.Arch]
+		if !ok {
+			return
+		}
+		nr, args := info.Data[0], info.Data[1:7]
+		sys, ok := syscalls[nr]
+		if !ok {
+			return
+		}
+		// The arguments of 32-bit syscalls are zero extended, so dirfd is truncated to an
+		// int32 to get AT_FDCWD back.
+		var path string
+		switch sys {
+		case sysOpen:
+			if args[1]&syscall.O_ACCMODE == syscall.O_WRONLY {
+				return
+			}
+			path = t.resolvePath(tid, atFdCwd, uintptr(args[0]))
+		case sysOpenat:
+			if args[2]&syscall.O_ACCMODE == syscall.O_WRONLY {
+				return
+			}
+			path = t.resolvePath(tid, int32(args[0]), uintptr(args[1]))
+		case sysOpenat2:
+			// The flags are the first field of the struct open_how pointed to by the third
+			// argument.
+			flags, ok := readTraceeUint64(tid, uintptr(args[2]))
+			if !ok || flags&syscall.O_ACCMODE == syscall.O_WRONLY {
+				return
+			}
+			path = t.resolvePath(tid, int32(args[0]), uintptr(args[1]))
+		case sysExecve:
+			path = t.resolvePath(tid, atFdCwd, uintptr(args[0]))
+		case sysExecveat:
+			path = t.resolvePath(tid, int32(args[0]), uintptr(args[1]))
+			if path == "" && args[4]&atEmptyPath != 0 {
+				// The file referred to by dirfd is executed.
+				path = t.resolveFd(tid, int32(args[0]))
+			}
+		}
+		if path != "" {
+			t.pending[tid] = path
+		}
+	case ptraceSyscallInfoExit:
+		if path, ok := t.pending[tid]; ok {
+			delete(t.pending, tid)
+			isError := info.Data[1]&0xff != 0
+			if !isError {
+				t.accessed[path] = true
+			}
+		}
+	}
+}
+
+// resolvePath reads a path argument from the memory of the tracee and converts it to an absolute
+// path using the tracee's working directory or the directory referred to by dirfd.
+func (t *fileAccessTracer) resolvePath(tid int, dirfd int32, addr uintptr) string {
+	path := readTraceeString(tid, addr)
+	if path == "" {
+		return ""
+	}
+	if !filepath.IsAbs(path) {
+		dir := t.resolveFd(tid, dirfd)
+		if dir == "" {
+			return ""
+		}
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// resolveFd returns the path of the file referred to by the file descriptor fd of the tracee, or
+// its working directory for AT_FDCWD.
+func (t *fileAccessTracer) resolveFd(tid int, fd int32) string {
+	var link string
+	if fd == atFdCwd {
+		link = filepath.Join("/proc", strconv.Itoa(tid), "cwd")
+	} else {
+		link = filepath.Join("/proc", strconv.Itoa(tid), "fd", strconv.Itoa(int(fd)))
+	}
+	path, err := os.Readlink(link)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// readTraceeUint64 reads a little endian uint64 from the memory of the tracee.
+func readTraceeUint64(tid int, addr uintptr) (uint64, bool) {
+	buf := make([]byte, 8)
+	if n, err := syscall.PtracePeekData(tid, addr, buf); err != nil || n != len(buf) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(buf), true
+}
+
+// readTraceeString reads a NUL terminated string from the memory of the tracee.
+func readTraceeString(tid int, addr uintptr) string {
+	const maxPathLen = 4096
+	var buf []byte
+	chunk := make([]byte, 64)
+	for len(buf) < maxPathLen {
+		n, err := syscall.PtracePeekData(tid, addr+uintptr(len(buf)), chunk)
+		if i := bytes.IndexByte(chunk[:n], 0); i >= 0 {
+			return string(append(buf, chunk[:i]...))
+		}
+		if err != nil || n == 0 {
+			return ""
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFileAccessTracer(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	topDir := filepath.Join(dir, "top")
+	sandboxDir := filepath.Join(topDir, "out", "sandbox")
+	writeTestFile(t, filepath.Join(topDir, "external", "foo", "undeclared.txt"), "foo")
+	writeTestFile(t, filepath.Join(sandboxDir, "declared.txt"), "bar")
+	writeTestFile(t, filepath.Join(dir, "outside.txt"), "baz")
+
+	output := filepath.Join(dir, "trace")
+	script := "cat declared.txt ../../external/foo/undeclared.txt " + filepath.Join(dir, "outside.txt") +
+		" > out.txt; (cat missing.txt 2> /dev/null); exit 3"
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(sandboxDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	exitCode := runFileAccessTracer(output, sandboxDir, topDir, []string{"bash", "-c", script})
+	if _, err := os.Stat(output); err != nil {
+		t.Skipf("tracing is not supported in this environment: %s", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", exitCode)
+	}
+
+	trace := make(map[string]bool)
+	if err := readFileAccessTrace(output, trace); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"external/foo/undeclared.txt", filepath.Join(dir, "outside.txt")} {
+		if !trace[want] {
+			t.Errorf("expected %q in trace, got %v", want, trace)
+		}
+	}
+	for path := range trace {
+		if strings.Contains(path, "declared.txt") && !strings.Contains(path, "undeclared.txt") ||
+			strings.Contains(path, "missing.txt") || strings.Contains(path, "out.txt") {
+			t.Errorf("unexpected %q in trace", path)
+		}
+	}
+}
+
+func TestTracedSyscallsHostArch(t *testing.T) {
+	arch, ok := map[string]uint32{
+		"386":   auditArchI386,
+		"amd64": auditArchX86_64,
+		"arm":   auditArchArm,
+		"arm64": auditArchAarch64,
+	}[runtime.GOARCH]
+	if !ok {
+		t.Skipf("%s is not traced", runtime.GOARCH)
+	}
+
+	// The syscall numbers of the host architecture match the ones of the syscall package.
+	syscalls := tracedSyscalls[arch]
+	for nr, want := range map[uint64]tracedSyscall{
+		syscall.SYS_OPENAT: sysOpenat,
+		syscall.SYS_EXECVE: sysExecve,
+	} {
+		if got, ok := syscalls[nr]; !ok || got != want {
+			t.Errorf("expected syscall %d to be traced as %d, got %d", nr, want, got)
+		}
+	}
+}
+
+func TestFileAccessTracerDescendants(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "stopped.txt"), "foo")
+	writeTestFile(t, filepath.Join(dir, "background.txt"), "bar")
+
+	// The first process stops itself, which must not keep it stopped forever, and the background
+	// process reads its file after bash has exited.
+	output := filepath.Join(dir, "trace")
+	script := "bash -c 'kill -STOP $$; cat " + filepath.Join(dir, "stopped.txt") + " > /dev/null'; " +
+		"(sleep 0.2; cat " + filepath.Join(dir, "background.txt") + " > /dev/null) & exit 0"
+
+	exitCode := runFileAccessTracer(output, filepath.Join(dir, "sandbox"), filepath.Join(dir, "top"),
+		[]string{"bash", "-c", script})
+	if _, err := os.Stat(output); err != nil {
+		t.Skipf("tracing is not supported in this environment: %s", err)
+	}
+	if exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+
+	trace := make(map[string]bool)
+	if err := readFileAccessTrace(output, trace); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{filepath.Join(dir, "stopped.txt"), filepath.Join(dir, "background.txt")} {
+		if !trace[want] {
+			t.Errorf("expected %q in trace, got %v", want, trace)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pathRegexp matches anything in the output of a command that looks like a path with at least one
+// directory component.
+var pathRegexp = regexp.MustCompile(`[A-Za-z0-9_.+@~-]*/[A-Za-z0-9_.+@~/-]*[A-Za-z0-9_+@~-]`)
+
+// findUndeclaredAccesses looks for paths in the output of a command that failed in the hermetic
+// sandbox that are not visible inside the sandbox but that exist outside it.  These are most likely
+// inputs that the command tried to read but that were not declared as inputs of the rule.
+// Relative paths are resolved against the sandbox directory inside the sandbox, and against the
+// directory sbox was run from outside it.
+func findUndeclaredAccesses(output []byte, sandboxDir string, visible []string) []string {
+	absSandboxDir, err := filepath.Abs(sandboxDir)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var undeclared []string
+	for _, match := range pathRegexp.FindAll(output, -1) {
+		path := filepath.Clean(string(match))
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		if filepath.IsAbs(path) {
+			if isUnderDir(path, absSandboxDir) || isVisible(path, visible) {
+				continue
+			}
+		} else {
+			if _, err := os.Lstat(filepath.Join(sandboxDir, path)); err == nil {
+				continue
+			}
+			if strings.HasPrefix(path, "../") {
+				continue
+			}
+		}
+
+		if _, err := os.Lstat(path); err == nil {
+			undeclared = append(undeclared, path)
+		}
+	}
+
+	sort.Strings(undeclared)
+	return undeclared
+}
+
+func isVisible(path string, visible []string) bool {
+	for _, dir := range visible {
+		if isUnderDir(path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnderDir returns true if path is dir or is inside dir.
+func isUnderDir(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+}
+
+// reportUndeclaredAccesses prints the paths found by findUndeclaredAccesses to w.
+func reportUndeclaredAccesses(w io.Writer, output []byte, sandboxDir string, visible []string) {
+	undeclared := findUndeclaredAccesses(output, sandboxDir, visible)
+	if len(undeclared) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "The failing command was run with hermetic inputs, and its output refers to paths\n"+
+		"that exist but were not visible in the sandbox.  They may need to be declared as inputs or\n"+
+		"tools of the rule:\n")
+	for _, path := range undeclared {
+		fmt.Fprintf(w, "    %s\n", path)
+	}
+}
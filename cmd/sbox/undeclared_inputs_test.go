@@ -0,0 +1,60 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindUndeclaredAccesses(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	sandboxDir := "out/soong/.temp/sbox/0123"
+	writeTestFile(t, filepath.Join(sandboxDir, "out", "declared.h"), "")
+	writeTestFile(t, filepath.Join("external", "foo", "declared.h"), "")
+	writeTestFile(t, filepath.Join("external", "foo", "undeclared.h"), "")
+	writeTestFile(t, filepath.Join("prebuilts", "tools", "tool"), "")
+	absUndeclared := filepath.Join(dir, "external", "foo", "abs.h")
+	writeTestFile(t, absUndeclared, "")
+
+	output := []byte(
+		"out/declared.h:1:10: fatal error: 'external/foo/undeclared.h' file not found\n" +
+			"cat: " + absUndeclared + ": No such file or directory\n" +
+			"warning: " + filepath.Join(dir, "prebuilts", "tools", "tool") + " is slow\n" +
+			"error: external/foo/missing.h: No such file or directory\n" +
+			"error: external/foo/undeclared.h: No such file or directory\n")
+
+	visible := []string{filepath.Join(dir, "prebuilts")}
+
+	got := findUndeclaredAccesses(output, sandboxDir, visible)
+	want := []string{
+		absUndeclared,
+		"external/foo/undeclared.h",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -170,14 +171,16 @@ func main() {
 		stat.Finish()
 	})
 	criticalPath := status.NewCriticalPath()
+	rebuildExplainer := status.NewRebuildExplainer()
 	buildCtx := build.Context{ContextImpl: &build.ContextImpl{
-		Context:      ctx,
-		Logger:       log,
-		Metrics:      met,
-		Tracer:       trace,
-		Writer:       output,
-		Status:       stat,
-		CriticalPath: criticalPath,
+		Context:          ctx,
+		Logger:           log,
+		Metrics:          met,
+		Tracer:           trace,
+		Writer:           output,
+		Status:           stat,
+		CriticalPath:     criticalPath,
+		RebuildExplainer: rebuildExplainer,
 	}}
 
 	freshConfig := func() build.Config {
@@ -206,6 +209,10 @@ func main() {
 	defer func() {
 		stat.Finish()
 		criticalPath.WriteToMetrics(met)
+		writeBuildReport(log, output, criticalPath, filepath.Join(logsDir, c.logsPrefix+"build_report.txt"), c.simpleOutput)
+		if config.ExplainRebuild() {
+			writeRebuildExplanation(log, output, rebuildExplainer, filepath.Join(logsDir, c.logsPrefix+"explain_rebuild.txt"))
+		}
 		met.Dump(soongMetricsFile)
 		if !config.SkipMetricsUpload() {
 			build.UploadMetrics(buildCtx, config, c.simpleOutput, buildStarted, metricsFiles...)
@@ -239,6 +246,48 @@ func main() {
 	c.run(buildCtx, config, args)
 }
 
+const (
+	// Number of slowest actions listed in the build report file.
+	buildReportSlowestActions = 50
+	// Number of slowest actions listed in the summary printed to the terminal.
+	buildSummarySlowestActions = 5
+	// Builds that spent less time than this running actions don't print a summary to the terminal.
+	buildSummaryThreshold = time.Minute
+)
+
+// writeBuildReport writes the critical path, slowest actions and parallelism of the build to
+// reportFile, and prints a short summary of it to the terminal if the build was slow.
+func writeBuildReport(log logger.Logger, terminal io.Writer, criticalPath *status.CriticalPath, reportFile string, simpleOutput bool) {
+	if err := criticalPath.WriteReportFile(reportFile, buildReportSlowestActions); err != nil {
+		log.Verbosef("Failed to write build report %s: %v", reportFile, err)
+		return
+	}
+
+	if simpleOutput || build.OsEnvironment().IsEnvTrue("ANDROID_QUIET_BUILD") ||
+		criticalPath.ElapsedTime() < buildSummaryThreshold {
+		return
+	}
+	fmt.Fprintln(terminal)
+	criticalPath.WriteSummary(terminal, buildSummarySlowestActions)
+	fmt.Fprintf(terminal, "Full report: %s\n", reportFile)
+}
+
+// Number of causes of rerun actions printed to the terminal with --explain-rebuild.
+const rebuildExplanationSummaryCauses = 10
+
+// writeRebuildExplanation writes the root causes of the actions ninja reran to reportFile, and
+// prints the most common ones to the terminal.
+func writeRebuildExplanation(log logger.Logger, terminal io.Writer, explainer *status.RebuildExplainer, reportFile string) {
+	if err := explainer.WriteReportFile(reportFile); err != nil {
+		log.Verbosef("Failed to write rebuild explanation %s: %v", reportFile, err)
+		return
+	}
+
+	fmt.Fprintln(terminal)
+	explainer.WriteSummary(terminal, rebuildExplanationSummaryCauses)
+	fmt.Fprintf(terminal, "Full report: %s\n", reportFile)
+}
+
 // This function must not modify config, since product config may cause us to recreate the config,
 // and we won't call this function a second time.
 func preProductConfigSetup(buildCtx build.Context, config build.Config) {
@@ -268,6 +317,9 @@ func preProductConfigSetup(buildCtx build.Context, config build.Config) {
 	stat.AddOutput(status.NewProtoErrorLog(log, buildErrorFile))
 	stat.AddOutput(status.NewCriticalPathLogger(log, buildCtx.CriticalPath))
 	stat.AddOutput(status.NewBuildProgressLog(log, filepath.Join(logsDir, logsPrefix+"build_progress.pb")))
+	if jsonStatusFile := config.JSONStatusFile(); jsonStatusFile != "" {
+		stat.AddOutput(status.NewJSONLog(log, jsonStatusFile))
+	}
 
 	buildCtx.Verbosef("Detected %.3v GB total RAM", float32(config.TotalRAM())/(1024*1024*1024))
 	buildCtx.Verbosef("Parallelism (local/remote/highmem): %v/%v/%v",
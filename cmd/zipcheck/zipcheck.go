@@ -0,0 +1,482 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// zipcheck checks zip files produced by soong_zip, zip2zip and merge_zips for properties that
+// make them non-reproducible: timestamps other than jar.DefaultTime, extra fields that carry
+// timestamps or owners, unsorted or duplicate entries, inconsistent directory entries and the
+// same contents compressed differently in different zip files.  It can also rewrite a zip file
+// into the canonical form that merge_zips -s or merge_zips -j would produce.
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"android/soong/jar"
+	"android/soong/response"
+	"android/soong/third_party/zip"
+)
+
+// Extra field header IDs that are written by other zip tools and record timestamps or file owners.
+var nonCanonicalExtraFields = map[uint16]string{
+	0x000a: "NTFS timestamps",
+	0x5455: "extended timestamp",
+	0x5855: "Info-ZIP unix timestamp",
+	0x7875: "Info-ZIP unix owner",
+}
+
+type order string
+
+const (
+	orderNone  order = "none"
+	orderAny   order = "any"
+	orderAlpha order = "alpha"
+	orderJar   order = "jar"
+)
+
+type problem struct {
+	zip, entry, msg string
+}
+
+func (p problem) String() string {
+	if p.entry == "" {
+		return fmt.Sprintf("%s: %s", p.zip, p.msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.zip, p.entry, p.msg)
+}
+
+// compressedEntry remembers how an entry was compressed in the first zip file it was seen in.
+type compressedEntry struct {
+	zip              string
+	crc32            uint32
+	size             uint64
+	method           uint16
+	compressedSize64 uint64
+}
+
+type checker struct {
+	order    order
+	problems []problem
+
+	compression map[string]compressedEntry
+}
+
+func newChecker(order order) *checker {
+	return &checker{
+		order:       order,
+		compression: make(map[string]compressedEntry),
+	}
+}
+
+func (c *checker) report(zipName, entry, format string, args ...interface{}) {
+	c.problems = append(c.problems, problem{zipName, entry, fmt.Sprintf(format, args...)})
+}
+
+// check checks the entries of a single zip file, and compares its compression against the zip
+// files that were checked before it.
+func (c *checker) check(zipName string, files []*zip.File) {
+	c.checkEntries(zipName, files)
+	c.checkOrder(zipName, files)
+	c.checkDirectories(zipName, files)
+	c.checkCompression(zipName, files)
+}
+
+func (c *checker) checkEntries(zipName string, files []*zip.File) {
+	counts := make(map[string]int)
+	for _, f := range files {
+		counts[f.Name]++
+		if counts[f.Name] == 2 {
+			c.report(zipName, f.Name, "duplicate entry")
+		}
+
+		if t := f.ModTime(); !t.Equal(jar.DefaultTime) {
+			c.report(zipName, f.Name, "timestamp %s is not %s", t.Format("2006-01-02 15:04:05"),
+				jar.DefaultTime.Format("2006-01-02 15:04:05"))
+		}
+
+		for _, id := range extraFieldIDs(f.Extra) {
+			if desc, ok := nonCanonicalExtraFields[id]; ok {
+				c.report(zipName, f.Name, "has %s extra field %#04x", desc, id)
+			}
+		}
+	}
+}
+
+func (c *checker) checkOrder(zipName string, files []*zip.File) {
+	if c.order == orderNone {
+		return
+	}
+
+	alphaLess := func(a, b string) bool { return a < b }
+
+	firstUnsorted := func(less func(a, b string) bool) int {
+		for i := 1; i < len(files); i++ {
+			if less(files[i].Name, files[i-1].Name) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var i int
+	var desc string
+	switch c.order {
+	case orderAlpha:
+		i, desc = firstUnsorted(alphaLess), "alphabetical order"
+	case orderJar:
+		i, desc = firstUnsorted(jar.EntryNamesLess), "jar order"
+	case orderAny:
+		i = firstUnsorted(alphaLess)
+		if i >= 0 && firstUnsorted(jar.EntryNamesLess) < 0 {
+			i = -1
+		}
+		desc = "alphabetical or jar order"
+	}
+
+	if i >= 0 {
+		c.report(zipName, files[i].Name, "entries are not sorted in %s, found after %q", desc, files[i-1].Name)
+	}
+}
+
+func (c *checker) checkDirectories(zipName string, files []*zip.File) {
+	dirs := make(map[string]int)
+	regularFiles := make(map[string]bool)
+	for i, f := range files {
+		if strings.HasSuffix(f.Name, "/") {
+			if _, exists := dirs[f.Name]; !exists {
+				dirs[f.Name] = i
+			}
+			if f.UncompressedSize64 != 0 {
+				c.report(zipName, f.Name, "directory entry has %d bytes of contents", f.UncompressedSize64)
+			}
+		} else {
+			regularFiles[f.Name] = true
+			if f.Mode().IsDir() {
+				c.report(zipName, f.Name, "directory entry name does not end in /")
+			}
+		}
+	}
+
+	for i, f := range files {
+		if index, isDir := dirs[f.Name]; isDir && index == i && regularFiles[strings.TrimSuffix(f.Name, "/")] {
+			c.report(zipName, f.Name, "is both a file and a directory")
+		}
+	}
+
+	if len(dirs) == 0 {
+		// Zip files with no directory entries at all are consistent.
+		return
+	}
+
+	reportedMissing := make(map[string]bool)
+	for i, f := range files {
+		for _, parent := range parentDirs(f.Name) {
+			if index, exists := dirs[parent]; !exists {
+				if !reportedMissing[parent] {
+					c.report(zipName, parent, "missing directory entry, other directories have entries")
+					reportedMissing[parent] = true
+				}
+			} else if index > i {
+				c.report(zipName, f.Name, "precedes the entry for its directory %q", parent)
+			}
+		}
+	}
+}
+
+func (c *checker) checkCompression(zipName string, files []*zip.File) {
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		prev, exists := c.compression[f.Name]
+		if !exists {
+			c.compression[f.Name] = compressedEntry{
+				zip:              zipName,
+				crc32:            f.CRC32,
+				size:             f.UncompressedSize64,
+				method:           f.Method,
+				compressedSize64: f.CompressedSize64,
+			}
+			continue
+		}
+		if prev.zip == zipName || prev.crc32 != f.CRC32 || prev.size != f.UncompressedSize64 {
+			// Only identical contents are expected to be compressed identically.
+			continue
+		}
+		if prev.method != f.Method {
+			c.report(zipName, f.Name, "compressed with %s, but with %s in %s",
+				methodName(f.Method), methodName(prev.method), prev.zip)
+		} else if prev.compressedSize64 != f.CompressedSize64 {
+			c.report(zipName, f.Name, "compressed to %d bytes, but to %d bytes in %s",
+				f.CompressedSize64, prev.compressedSize64, prev.zip)
+		}
+	}
+}
+
+func methodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	case zip.Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("method %d", method)
+	}
+}
+
+// parentDirs returns the directory entry names of all the parents of name, outermost first.
+func parentDirs(name string) []string {
+	var dirs []string
+	for dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append([]string{dir + "/"}, dirs...)
+	}
+	return dirs
+}
+
+// extraFieldIDs returns the header IDs of the fields in a zip extra field.
+func extraFieldIDs(extra []byte) []uint16 {
+	var ids []uint16
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		ids = append(ids, id)
+		if len(extra) < 4+size {
+			break
+		}
+		extra = extra[4+size:]
+	}
+	return ids
+}
+
+// stripNonCanonicalExtraFields removes the fields listed in nonCanonicalExtraFields from a
+// zip extra field.
+func stripNonCanonicalExtraFields(extra []byte) []byte {
+	var ret []byte
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			ret = append(ret, extra...)
+			break
+		}
+		if _, ok := nonCanonicalExtraFields[id]; !ok {
+			ret = append(ret, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return ret
+}
+
+// writeCanonical writes the entries of files to w the way merge_zips would: the first of any
+// duplicate entries is kept, timestamps are set to jar.DefaultTime, extra fields that record
+// timestamps or owners are removed, and the entries are sorted in jar order if jarOrder is set
+// and alphabetically otherwise.  If any directory entries exist an entry is written for every
+// directory.  The compressed data is copied without recompressing it.
+func writeCanonical(files []*zip.File, w *zip.Writer, jarOrder bool) error {
+	entries := make(map[string]*zip.File)
+	dirs := make(map[string]bool)
+	var names []string
+	for _, f := range files {
+		if _, exists := entries[f.Name]; exists {
+			continue
+		}
+		entries[f.Name] = f
+		names = append(names, f.Name)
+		if strings.HasSuffix(f.Name, "/") {
+			dirs[f.Name] = true
+		}
+	}
+
+	if len(dirs) > 0 {
+		for _, f := range files {
+			for _, dir := range parentDirs(f.Name) {
+				if _, exists := entries[dir]; !exists {
+					entries[dir] = nil
+					names = append(names, dir)
+				}
+			}
+		}
+	}
+
+	if jarOrder {
+		sort.SliceStable(names, func(i, j int) bool { return jar.EntryNamesLess(names[i], names[j]) })
+	} else {
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		f := entries[name]
+		if f == nil {
+			var dirHeader *zip.FileHeader
+			if jarOrder && name == jar.MetaDir {
+				dirHeader = jar.MetaDirFileHeader()
+			} else {
+				dirHeader = &zip.FileHeader{Name: name}
+				dirHeader.SetMode(0755 | os.ModeDir)
+				dirHeader.SetModTime(jar.DefaultTime)
+			}
+			if _, err := w.CreateHeaderAndroid(dirHeader); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f.SetModTime(jar.DefaultTime)
+		f.Extra = stripNonCanonicalExtraFields(f.Extra)
+		if err := w.CopyFrom(f, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rewrite(input, output string, order order) error {
+	r, err := zip.OpenReader(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	jarOrder := order == orderJar
+	if order == orderAny {
+		// Keep jar files in jar order.
+		for _, f := range r.File {
+			if f.Name == jar.ManifestFile {
+				jarOrder = true
+				break
+			}
+		}
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	if err := writeCanonical(r.File, w, jarOrder); err != nil {
+		os.Remove(output)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
+
+func checkZips(inputs []string, order order, stderr io.Writer) (int, error) {
+	c := newChecker(order)
+	for _, input := range inputs {
+		r, err := zip.OpenReader(input)
+		if err != nil {
+			return 0, err
+		}
+		c.check(input, r.File)
+		r.Close()
+	}
+
+	for _, p := range c.problems {
+		fmt.Fprintln(stderr, p)
+	}
+	return len(c.problems), nil
+}
+
+func main() {
+	flags := flag.NewFlagSet("zipcheck", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: zipcheck [-order any|alpha|jar|none] [-stamp file] [-canonical out.zip] zip [zip...]")
+		fmt.Fprintln(os.Stderr, "Checks zip files for non-reproducible contents, and optionally writes a canonical copy.")
+		flags.PrintDefaults()
+	}
+
+	orderFlag := flags.String("order", string(orderAny), "required entry order: any (alphabetical or jar), alpha, jar or none")
+	stamp := flags.String("stamp", "", "file to touch if no problems are found, for use as a validation action")
+	canonical := flags.String("canonical", "", "write a canonical copy of the single input zip file to this path")
+	warnOnly := flags.Bool("warn", false, "print problems without failing")
+
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "@") {
+			f, err := os.Open(strings.TrimPrefix(arg, "@"))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			rspArgs, err := response.ReadRspFile(f)
+			f.Close()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			args = append(args, rspArgs...)
+		} else {
+			args = append(args, arg)
+		}
+	}
+	flags.Parse(args)
+
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	o := order(*orderFlag)
+	switch o {
+	case orderAny, orderAlpha, orderJar, orderNone:
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -order %q\n", *orderFlag)
+		os.Exit(2)
+	}
+
+	if *canonical != "" {
+		if len(inputs) != 1 {
+			fmt.Fprintln(os.Stderr, "-canonical requires exactly one input zip file")
+			os.Exit(2)
+		}
+		if err := rewrite(inputs[0], *canonical, o); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	n, err := checkZips(inputs, o, os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if n > 0 && !*warnOnly {
+		fmt.Fprintf(os.Stderr, "zipcheck: found %d problems, run zipcheck -canonical to write a canonical copy\n", n)
+		os.Exit(1)
+	}
+
+	if *stamp != "" {
+		if err := os.WriteFile(*stamp, nil, 0666); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"android/soong/jar"
+	"android/soong/third_party/zip"
+)
+
+type testZipEntry struct {
+	name      string
+	mode      os.FileMode
+	data      []byte
+	method    uint16
+	timestamp time.Time
+	extra     []byte
+}
+
+var (
+	A      = testZipEntry{"A", 0644, []byte("foo"), zip.Deflate, jar.DefaultTime, nil}
+	a      = testZipEntry{"a", 0644, []byte("foo"), zip.Deflate, jar.DefaultTime, nil}
+	aStore = testZipEntry{"a", 0644, []byte("foo"), zip.Store, jar.DefaultTime, nil}
+	a2     = testZipEntry{"a", 0644, []byte("FOO2"), zip.Deflate, jar.DefaultTime, nil}
+	bDir   = testZipEntry{"b/", os.ModeDir | 0755, nil, zip.Store, jar.DefaultTime, nil}
+	bbDir  = testZipEntry{"b/b/", os.ModeDir | 0755, nil, zip.Store, jar.DefaultTime, nil}
+	ba     = testZipEntry{"b/a", 0644, []byte("bar"), zip.Deflate, jar.DefaultTime, nil}
+	bbc    = testZipEntry{"b/b/c", 0644, []byte("baz"), zip.Deflate, jar.DefaultTime, nil}
+	c      = testZipEntry{"c", 0644, []byte("qux"), zip.Deflate, jar.DefaultTime, nil}
+
+	timestamped   = testZipEntry{"t", 0644, []byte("t"), zip.Store, jar.DefaultTime.Add(time.Hour), nil}
+	extTimestamp  = testZipEntry{"e", 0644, []byte("e"), zip.Store, jar.DefaultTime, []byte{0x55, 0x54, 0x01, 0x00, 0x03}}
+	sha256Extra   = testZipEntry{"s", 0644, []byte("s"), zip.Store, jar.DefaultTime, []byte{0x67, 0x49, 0x00, 0x00}}
+	metainfDir    = testZipEntry{jar.MetaDir, os.ModeDir | 0755, nil, zip.Store, jar.DefaultTime, nil}
+	manifestEntry = testZipEntry{jar.ManifestFile, 0644, []byte("manifest"), zip.Store, jar.DefaultTime, nil}
+)
+
+func testZipEntriesToBuf(entries []testZipEntry) []byte {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+
+	for _, e := range entries {
+		fh := zip.FileHeader{
+			Name:  e.name,
+			Extra: e.extra,
+		}
+		fh.SetMode(e.mode)
+		fh.Method = e.method
+		fh.SetModTime(e.timestamp)
+
+		w, err := zw.CreateHeader(&fh)
+		if err != nil {
+			panic(err)
+		}
+
+		_, err = w.Write(e.data)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	return b.Bytes()
+}
+
+func testZipEntriesToFiles(entries []testZipEntry) []*zip.File {
+	buf := testZipEntriesToBuf(entries)
+	r, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		panic(err)
+	}
+	return r.File
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name  string
+		order order
+		zips  [][]testZipEntry
+
+		problems []string
+	}{
+		{
+			name:  "canonical",
+			order: orderAny,
+			zips:  [][]testZipEntry{{a, bDir, ba, bbDir, bbc, c}},
+		},
+		{
+			name:  "canonical jar",
+			order: orderJar,
+			zips:  [][]testZipEntry{{metainfDir, manifestEntry, A, c}},
+		},
+		{
+			name:  "jar order is accepted by any",
+			order: orderAny,
+			zips:  [][]testZipEntry{{metainfDir, manifestEntry, A, c}},
+		},
+		{
+			name:     "jar order is not alphabetical",
+			order:    orderAlpha,
+			zips:     [][]testZipEntry{{metainfDir, manifestEntry, A, c}},
+			problems: []string{`0.zip: A: entries are not sorted in alphabetical order, found after "META-INF/MANIFEST.MF"`},
+		},
+		{
+			name:     "unsorted",
+			order:    orderAny,
+			zips:     [][]testZipEntry{{c, a}},
+			problems: []string{`0.zip: a: entries are not sorted in alphabetical or jar order, found after "c"`},
+		},
+		{
+			name:  "unsorted ignored",
+			order: orderNone,
+			zips:  [][]testZipEntry{{c, a}},
+		},
+		{
+			name:     "duplicate",
+			order:    orderNone,
+			zips:     [][]testZipEntry{{a, a2, a}},
+			problems: []string{"0.zip: a: duplicate entry"},
+		},
+		{
+			name:  "timestamps and extra fields",
+			order: orderNone,
+			zips:  [][]testZipEntry{{timestamped, extTimestamp, sha256Extra}},
+			problems: []string{
+				"0.zip: t: timestamp 2008-01-01 01:00:00 is not 2008-01-01 00:00:00",
+				"0.zip: e: has extended timestamp extra field 0x5455",
+			},
+		},
+		{
+			name:  "missing directory entry",
+			order: orderAny,
+			zips:  [][]testZipEntry{{a, bDir, ba, bbc, c}},
+			problems: []string{
+				"0.zip: b/b/: missing directory entry, other directories have entries",
+			},
+		},
+		{
+			name:  "directory entry after its contents",
+			order: orderNone,
+			zips:  [][]testZipEntry{{ba, bDir}},
+			problems: []string{
+				`0.zip: b/a: precedes the entry for its directory "b/"`,
+			},
+		},
+		{
+			name:  "file and directory",
+			order: orderNone,
+			zips: [][]testZipEntry{{
+				{"b", 0644, []byte("b"), zip.Store, jar.DefaultTime, nil},
+				bDir,
+			}},
+			problems: []string{"0.zip: b/: is both a file and a directory"},
+		},
+		{
+			name:  "no directory entries",
+			order: orderAny,
+			zips:  [][]testZipEntry{{a, ba, bbc, c}},
+		},
+		{
+			name:  "differing compression",
+			order: orderAny,
+			zips:  [][]testZipEntry{{a, c}, {aStore, c}},
+			problems: []string{
+				"1.zip: a: compressed with store, but with deflate in 0.zip",
+			},
+		},
+		{
+			name:  "different contents",
+			order: orderAny,
+			zips:  [][]testZipEntry{{a}, {a2}},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c := newChecker(test.order)
+			for i, entries := range test.zips {
+				c.check(string(rune('0'+i))+".zip", testZipEntriesToFiles(entries))
+			}
+
+			var got []string
+			for _, p := range c.problems {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, test.problems) {
+				t.Errorf("incorrect problems\nwant: %q\n got: %q", test.problems, got)
+			}
+		})
+	}
+}
+
+func TestWriteCanonical(t *testing.T) {
+	testCases := []struct {
+		name     string
+		in       []testZipEntry
+		jarOrder bool
+
+		out []string
+	}{
+		{
+			name: "sort and remove duplicates",
+			in:   []testZipEntry{c, a, a2},
+			out:  []string{"a", "c"},
+		},
+		{
+			name: "add missing directories",
+			in:   []testZipEntry{c, bbc, bDir, a},
+			out:  []string{"a", "b/", "b/b/", "b/b/c", "c"},
+		},
+		{
+			name:     "jar order",
+			in:       []testZipEntry{c, manifestEntry, a, metainfDir},
+			jarOrder: true,
+			out:      []string{jar.MetaDir, jar.ManifestFile, "a", "c"},
+		},
+		{
+			name: "timestamps and extra fields",
+			in:   []testZipEntry{timestamped, extTimestamp, sha256Extra},
+			out:  []string{"e", "s", "t"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			inFiles := testZipEntriesToFiles(test.in)
+
+			buf := &bytes.Buffer{}
+			w := zip.NewWriter(buf)
+			if err := writeCanonical(inFiles, w, test.jarOrder); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, f := range r.File {
+				names = append(names, f.Name)
+				if !strings.HasSuffix(f.Name, "/") {
+					rc, err := f.Open()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := io.Copy(io.Discard, rc); err != nil {
+						t.Errorf("error reading %s: %s", f.Name, err)
+					}
+					rc.Close()
+				}
+			}
+			if !reflect.DeepEqual(names, test.out) {
+				t.Errorf("incorrect entries\nwant: %q\n got: %q", test.out, names)
+			}
+
+			order := orderAlpha
+			if test.jarOrder {
+				order = orderJar
+			}
+			c := newChecker(order)
+			c.check("out.zip", r.File)
+			if len(c.problems) > 0 {
+				t.Errorf("canonical zip has problems: %q", c.problems)
+			}
+		})
+	}
+}
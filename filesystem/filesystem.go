@@ -90,15 +90,15 @@ type filesystemProperties struct {
 	// Name of the partition stored in vbmeta desc. Defaults to the name of this module.
 	Partition_name *string
 
-	// Type of the filesystem. Currently, ext4, cpio, and compressed_cpio are supported. Default
-	// is ext4.
+	// Type of the filesystem. Currently, ext4, erofs, f2fs, cpio, and compressed_cpio are
+	// supported. Default is ext4.
 	Type *string
 
 	// Identifies which partition this is for //visibility:any_system_image (and others) visibility
 	// checks, and will be used in the future for API surface checks.
 	Partition_type *string
 
-	// file_contexts file to make image. Currently, only ext4 is supported.
+	// file_contexts file to make image. Currently, ext4, erofs, and f2fs are supported.
 	File_contexts *string `android:"path"`
 
 	// Base directory relative to root, to which deps are installed, e.g. "system". Default is "."
@@ -135,6 +135,38 @@ type filesystemProperties struct {
 	Gen_aconfig_flags_pb *bool
 
 	Fsverity fsverityProperties
+
+	// Additional properties for the erofs filesystem. Only used when type is "erofs".
+	Erofs erofsProperties
+
+	// Additional properties for the f2fs filesystem. Only used when type is "f2fs".
+	F2fs f2fsProperties
+}
+
+// Additional properties required to generate erofs FS partitions.
+type erofsProperties struct {
+	// Compressor and compression level passed to mkfs.erofs, e.g. "lz4hc,9". Use "none" to
+	// disable compression. See external/erofs-utils/README for the complete documentation.
+	Compressor *string
+
+	// Used as --compress-hints for mkfs.erofs.
+	Compress_hints *string `android:"path"`
+
+	// Maximum size of a physical cluster in bytes, passed to mkfs.erofs as -C. Must be a
+	// multiple of 4096.
+	Pcluster_size *int64
+
+	// When set to true, the output image is a sparse image. Default is true.
+	Sparse *bool
+}
+
+// Additional properties required to generate f2fs FS partitions.
+type f2fsProperties struct {
+	// When set to true, enables file compression support in the image. Default is false.
+	Compression *bool
+
+	// When set to true, the output image is a sparse image. Default is true.
+	Sparse *bool
 }
 
 // android_filesystem packages a set of modules and their transitive dependencies into a filesystem
@@ -170,6 +202,8 @@ type fsType int
 
 const (
 	ext4Type fsType = iota
+	erofsType
+	f2fsType
 	compressedCpioType
 	cpioType // uncompressed
 	unknown
@@ -180,6 +214,10 @@ func (f *filesystem) fsType(ctx android.ModuleContext) fsType {
 	switch typeStr {
 	case "ext4":
 		return ext4Type
+	case "erofs":
+		return erofsType
+	case "f2fs":
+		return f2fsType
 	case "compressed_cpio":
 		return compressedCpioType
 	case "cpio":
@@ -209,7 +247,7 @@ var pctx = android.NewPackageContext("android/soong/filesystem")
 func (f *filesystem) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	validatePartitionType(ctx, f)
 	switch f.fsType(ctx) {
-	case ext4Type:
+	case ext4Type, erofsType, f2fsType:
 		f.output = f.buildImageUsingBuildImage(ctx)
 	case compressedCpioType:
 		f.output = f.buildCpioImage(ctx, true)
@@ -363,22 +401,40 @@ func (f *filesystem) buildPropFile(ctx android.ModuleContext) (propFile android.
 	// Type string that build_image.py accepts.
 	fsTypeStr := func(t fsType) string {
 		switch t {
-		// TODO(jiyong): add more types like f2fs, erofs, etc.
 		case ext4Type:
 			return "ext4"
+		case erofsType:
+			return "erofs"
+		case f2fsType:
+			return "f2fs"
 		}
 		panic(fmt.Errorf("unsupported fs type %v", t))
 	}
 
-	addStr("fs_type", fsTypeStr(f.fsType(ctx)))
+	fst := f.fsType(ctx)
+	addStr("fs_type", fsTypeStr(fst))
 	addStr("mount_point", proptools.StringDefault(f.properties.Mount_point, "/"))
 	addStr("use_dynamic_partition_size", "true")
-	addPath("ext_mkuserimg", ctx.Config().HostToolPath(ctx, "mkuserimg_mke2fs"))
+
+	// build_image.py invokes the host tools from PATH, so only their paths are recorded as deps.
 	// b/177813163 deps of the host tools have to be added. Remove this.
-	for _, t := range []string{"mke2fs", "e2fsdroid", "tune2fs"} {
+	var hostTools []string
+	switch fst {
+	case ext4Type:
+		addPath("ext_mkuserimg", ctx.Config().HostToolPath(ctx, "mkuserimg_mke2fs"))
+		hostTools = []string{"mke2fs", "e2fsdroid", "tune2fs"}
+	case erofsType:
+		hostTools = []string{"mkfs.erofs", "fsck.erofs", "img2simg"}
+	case f2fsType:
+		hostTools = []string{"mkf2fsuserimg", "make_f2fs", "sload_f2fs"}
+	}
+	for _, t := range hostTools {
 		deps = append(deps, ctx.Config().HostToolPath(ctx, t))
 	}
 
+	f.addErofsProps(ctx, fst, addStr, addPath)
+	f.addF2fsProps(ctx, fst, addStr)
+
 	if proptools.Bool(f.properties.Use_avb) {
 		addStr("avb_hashtree_enable", "true")
 		addPath("avb_avbtool", ctx.Config().HostToolPath(ctx, "avbtool"))
@@ -420,6 +476,48 @@ func (f *filesystem) buildPropFile(ctx android.ModuleContext) (propFile android.
 	return propFile, deps
 }
 
+func (f *filesystem) addErofsProps(ctx android.ModuleContext, fst fsType, addStr func(string, string), addPath func(string, android.Path)) {
+	props := f.properties.Erofs
+	if fst != erofsType {
+		if props.Compressor != nil || props.Compress_hints != nil || props.Pcluster_size != nil || props.Sparse != nil {
+			ctx.PropertyErrorf("erofs", "erofs properties are set, but the filesystem type is %q", proptools.StringDefault(f.properties.Type, "ext4"))
+		}
+		return
+	}
+	if compressor := proptools.String(props.Compressor); compressor != "" {
+		addStr("erofs_default_compressor", compressor)
+	}
+	if hints := proptools.String(props.Compress_hints); hints != "" {
+		addPath("erofs_default_compress_hints", android.PathForModuleSrc(ctx, hints))
+	}
+	if props.Pcluster_size != nil {
+		size := proptools.Int(props.Pcluster_size)
+		if size <= 0 || size%4096 != 0 {
+			ctx.PropertyErrorf("erofs.pcluster_size", "must be a positive multiple of 4096, found: %d", size)
+		}
+		addStr("erofs_pcluster_size", strconv.Itoa(size))
+	}
+	if proptools.BoolDefault(props.Sparse, true) {
+		addStr("erofs_sparse_flag", "-s")
+	}
+}
+
+func (f *filesystem) addF2fsProps(ctx android.ModuleContext, fst fsType, addStr func(string, string)) {
+	props := f.properties.F2fs
+	if fst != f2fsType {
+		if props.Compression != nil || props.Sparse != nil {
+			ctx.PropertyErrorf("f2fs", "f2fs properties are set, but the filesystem type is %q", proptools.StringDefault(f.properties.Type, "ext4"))
+		}
+		return
+	}
+	if proptools.Bool(props.Compression) {
+		addStr("f2fs_compress", "true")
+	}
+	if proptools.BoolDefault(props.Sparse, true) {
+		addStr("f2fs_sparse_flag", "-S")
+	}
+}
+
 func (f *filesystem) buildCpioImage(ctx android.ModuleContext, compressed bool) android.OutputPath {
 	if proptools.Bool(f.properties.Use_avb) {
 		ctx.PropertyErrorf("use_avb", "signing compresed cpio image using avbtool is not supported."+
@@ -560,3 +560,95 @@ func TestFilterOutUnsupportedArches(t *testing.T) {
 		}
 	}
 }
+
+func TestErofsFilesystem(t *testing.T) {
+	result := fixture.RunTestWithBp(t, `
+		android_filesystem {
+			name: "erofs_partition",
+			type: "erofs",
+			file_contexts: "file_contexts",
+			erofs: {
+				compressor: "lz4hc,9",
+				compress_hints: "compress_hints.txt",
+				pcluster_size: 262144,
+			},
+		}
+	`)
+
+	partition := result.ModuleForTests("erofs_partition", "android_common")
+	buildImageConfig := android.ContentFromFileRuleForTests(t, result.TestContext, partition.Output("prop"))
+	android.AssertStringDoesContain(t, "erofs fs type", buildImageConfig, "fs_type=erofs")
+	android.AssertStringDoesContain(t, "erofs compressor", buildImageConfig, "erofs_default_compressor=lz4hc,9")
+	android.AssertStringDoesContain(t, "erofs compress hints", buildImageConfig, "erofs_default_compress_hints=compress_hints.txt")
+	android.AssertStringDoesContain(t, "erofs pcluster size", buildImageConfig, "erofs_pcluster_size=262144")
+	android.AssertStringDoesContain(t, "erofs sparse by default", buildImageConfig, "erofs_sparse_flag=-s")
+	android.AssertStringDoesContain(t, "file contexts", buildImageConfig, "selinux_fc=")
+	android.AssertStringDoesNotContain(t, "ext4 specific tool", buildImageConfig, "ext_mkuserimg")
+
+	output := partition.Output("erofs_partition.img")
+	var toolNames []string
+	for _, implicit := range output.Implicits {
+		toolNames = append(toolNames, implicit.Base())
+	}
+	android.AssertStringListContains(t, "mkfs.erofs must be a dep", toolNames, "mkfs.erofs")
+	android.AssertStringListDoesNotContain(t, "mke2fs must not be a dep", toolNames, "mke2fs")
+}
+
+func TestF2fsFilesystem(t *testing.T) {
+	result := fixture.RunTestWithBp(t, `
+		android_filesystem {
+			name: "f2fs_partition",
+			type: "f2fs",
+			f2fs: {
+				compression: true,
+				sparse: false,
+			},
+		}
+	`)
+
+	partition := result.ModuleForTests("f2fs_partition", "android_common")
+	buildImageConfig := android.ContentFromFileRuleForTests(t, result.TestContext, partition.Output("prop"))
+	android.AssertStringDoesContain(t, "f2fs fs type", buildImageConfig, "fs_type=f2fs")
+	android.AssertStringDoesContain(t, "f2fs compression", buildImageConfig, "f2fs_compress=true")
+	android.AssertStringDoesNotContain(t, "f2fs sparse disabled", buildImageConfig, "f2fs_sparse_flag")
+}
+
+func TestErofsFilesystemWithAvb(t *testing.T) {
+	result := fixture.RunTestWithBp(t, `
+		android_filesystem {
+			name: "erofs_partition",
+			type: "erofs",
+			use_avb: true,
+			avb_private_key: "mykey",
+			avb_hash_algorithm: "sha256",
+			partition_name: "vendor",
+			erofs: {
+				sparse: false,
+			},
+		}
+	`)
+
+	partition := result.ModuleForTests("erofs_partition", "android_common")
+	buildImageConfig := android.ContentFromFileRuleForTests(t, result.TestContext, partition.Output("prop"))
+	android.AssertStringDoesContain(t, "hashtree enabled", buildImageConfig, "avb_hashtree_enable=true")
+	android.AssertStringDoesContain(t, "hashtree footer args", buildImageConfig,
+		"avb_add_hashtree_footer_args=--do_not_generate_fec --hash_algorithm sha256")
+	android.AssertStringDoesContain(t, "partition name", buildImageConfig, "partition_name=vendor")
+	android.AssertStringDoesNotContain(t, "erofs sparse disabled", buildImageConfig, "erofs_sparse_flag")
+
+	fs := partition.Module().(*filesystem)
+	android.AssertPathRelativeToTopEquals(t, "signed output", "out/soong/.intermediates/erofs_partition/android_common/erofs_partition.img", fs.SignedOutputPath())
+}
+
+func TestErofsPropertiesOnNonErofsFilesystem(t *testing.T) {
+	fixture.ExtendWithErrorHandler(android.FixtureExpectsOneErrorPattern(
+		`erofs properties are set, but the filesystem type is "ext4"`)).
+		RunTestWithBp(t, `
+		android_filesystem {
+			name: "ext4_partition",
+			erofs: {
+				compressor: "lz4",
+			},
+		}
+	`)
+}
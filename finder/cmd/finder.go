@@ -35,12 +35,20 @@ var (
 	// configuration of what to find
 	excludeDirs     string
 	filenamesToFind string
+	suffixesToFind  string
 	pruneFiles      string
 
+	// query of the results to print
+	globs    string
+	regexps  string
+	excludes string
+	limit    int
+
 	// other configuration
 	cpuprofile    string
 	verbose       bool
 	dbPath        string
+	daemonSocket  string
 	numIterations int
 )
 
@@ -49,14 +57,28 @@ func init() {
 		"filepath of profile file to write (optional)")
 	flag.BoolVar(&verbose, "v", false, "log additional information")
 	flag.StringVar(&dbPath, "db", "", "filepath of cache db")
+	flag.StringVar(&daemonSocket, "daemon", "",
+		"socket of a finder_daemon to query before falling back to the cache db (optional)")
 
 	flag.StringVar(&excludeDirs, "exclude-dirs", "",
 		"comma-separated list of directory names to exclude from search")
 	flag.StringVar(&filenamesToFind, "names", "",
 		"comma-separated list of filenames to find")
+	flag.StringVar(&suffixesToFind, "suffixes", "",
+		"comma-separated list of filename suffixes to find")
 	flag.StringVar(&pruneFiles, "prune-files", "",
 		"filenames that if discovered will exclude their entire directory "+
 			"(including sibling files and directories)")
+
+	flag.StringVar(&globs, "glob", "",
+		"comma-separated list of glob patterns of the files to print, relative to each "+
+			"<searchDirectory>; \"**\" matches any number of directories")
+	flag.StringVar(&regexps, "regex", "",
+		"comma-separated list of regular expressions of the files to print, which must match "+
+			"the whole path relative to each <searchDirectory>")
+	flag.StringVar(&excludes, "exclude", "",
+		"comma-separated list of glob patterns of files and directories not to print")
+	flag.IntVar(&limit, "limit", 0, "maximum number of files to print for each <searchDirectory>")
 	flag.IntVar(&numIterations, "count", 1,
 		"number of times to run. This is intended for use with --cpuprofile"+
 			" , to increase profile accuracy")
@@ -122,6 +144,7 @@ func run() error {
 		ExcludeDirs:      stringToList(excludeDirs),
 		PruneFiles:       stringToList(pruneFiles),
 		IncludeFiles:     stringToList(filenamesToFind),
+		IncludeSuffixes:  optionalStringToList(suffixesToFind),
 	}
 	if dbPath == "" {
 		usage()
@@ -147,10 +170,44 @@ func run() error {
 }
 
 func runFind(params finder.CacheParams, logger *log.Logger) (paths []string, err error) {
-	service, err := finder.New(params, fs.OsFs, logger, dbPath)
+	var service *finder.Finder
+	if daemonSocket != "" {
+		service, err = finder.NewFromDaemon(daemonSocket, params, fs.OsFs, logger, dbPath)
+		if err != nil {
+			logger.Printf("Not using finder daemon: %v\n", err)
+		}
+	}
+	if service == nil {
+		service, err = finder.New(params, fs.OsFs, logger, dbPath)
+	}
 	if err != nil {
 		return []string{}, err
 	}
 	defer service.Shutdown()
-	return service.FindAll(), nil
+
+	if globs == "" && regexps == "" && excludes == "" && limit == 0 {
+		return service.FindAll(), nil
+	}
+	query := finder.Query{
+		Patterns: optionalStringToList(globs),
+		Regexps:  optionalStringToList(regexps),
+		Excludes: optionalStringToList(excludes),
+		Limit:    limit,
+	}
+	for _, rootPath := range params.RootDirs {
+		matches, err := service.Query(rootPath, query)
+		if err != nil {
+			return []string{}, err
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// optionalStringToList is like stringToList but returns an empty list for an empty string
+func optionalStringToList(input string) []string {
+	if input == "" {
+		return nil
+	}
+	return stringToList(input)
 }
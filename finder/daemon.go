@@ -0,0 +1,526 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"android/soong/finder/fs"
+)
+
+// This file provides a long-lived finder daemon and its client.
+// The daemon keeps the node tree of a Finder in memory and watches every cached directory for
+// changes (using inotify on Linux), so that it only has to list the directories that changed
+// instead of stat'ing every directory in the tree.
+// Clients connect to the daemon over a unix socket, send their CacheParams, and receive the
+// database in the same format as the cache file. Because the daemon has just brought the
+// database up to date, the client can skip the stat calls that it makes when loading the cache
+// file.
+// If the daemon isn't running, or can't keep the tree up to date (for example because it
+// reached the inotify watch limit), the client returns an error and the caller is expected to
+// fall back to New.
+
+// Update daemonVersionString whenever making a backwards-incompatible change to the protocol
+const daemonVersionString = "Android finder daemon version 1"
+
+// daemonTimeout is the maximum time that a client waits for the daemon to respond. The first
+// request for a set of CacheParams may need to scan the whole tree.
+const daemonTimeout = 5 * time.Minute
+
+// ErrNoDaemon is returned by NewFromDaemon when no daemon is listening on the socket
+var ErrNoDaemon = errors.New("no finder daemon is running")
+
+// errWatchLimit is returned by a dirWatcher when no more directories can be watched
+var errWatchLimit = errors.New("reached the limit on the number of watched directories")
+
+// a daemonRequest is sent by the client, followed by a newline
+type daemonRequest struct {
+	Version string
+	Config  cacheConfig
+	// DbPath is the cache file that the daemon keeps up to date for clients that don't use the
+	// daemon
+	DbPath string
+}
+
+// a daemonResponse is sent by the daemon, followed by a newline and the database if Error is
+// empty
+type daemonResponse struct {
+	Error string
+}
+
+// NewFromDaemon creates a Finder from the database of the finder daemon listening on
+// <socketPath>. It returns an error wrapping ErrNoDaemon if there is no daemon, or another error
+// if the daemon couldn't answer; in both cases the caller should fall back to New.
+func NewFromDaemon(socketPath string, cacheParams CacheParams, filesystem fs.FileSystem,
+	logger Logger, dbPath string) (f *Finder, err error) {
+	return newFromDaemonImpl(socketPath, cacheParams, filesystem, logger, dbPath, defaultNumThreads)
+}
+
+func newFromDaemonImpl(socketPath string, cacheParams CacheParams, filesystem fs.FileSystem,
+	logger Logger, dbPath string, numThreads int) (f *Finder, err error) {
+	startTime := time.Now()
+	f = newEmptyFinder(cacheParams, filesystem, logger, dbPath, numThreads)
+
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoDaemon, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+
+	request, err := json.Marshal(daemonRequest{
+		Version: daemonVersionString,
+		Config:  f.cacheMetadata.Config,
+		DbPath:  dbPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(append(request, lineSeparator))
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to finder daemon: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	responseBytes, err := f.readLine(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from finder daemon: %v", err)
+	}
+	var response daemonResponse
+	err = json.Unmarshal(responseBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response from finder daemon: %v", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("finder daemon: %s", response.Error)
+	}
+
+	f.cacheIsCurrent = true
+	f.threadPool = newThreadPool(f.numDbLoadingThreads)
+	err = f.startFromCacheReader(reader)
+	f.threadPool = nil
+	if err != nil {
+		return nil, fmt.Errorf("failed to load database from finder daemon: %v", err)
+	}
+
+	err = f.checkRootDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	f.verbosef("Loaded db from finder daemon in %v\n", time.Since(startTime))
+	return f, nil
+}
+
+// a dirWatcher reports changes to the contents of a set of directories
+type dirWatcher interface {
+	// Add starts watching <path>. It returns an error wrapping errWatchLimit if no more
+	// directories can be watched.
+	Add(path string) error
+	// Remove stops watching <path>
+	Remove(path string) error
+	// Sync returns once the changes that happened before it was called have been reported
+	Sync() error
+	// Close stops watching all directories
+	Close() error
+}
+
+// a dirWatcherFactory creates a dirWatcher that calls <onChange> with the path of a directory
+// whose contents changed, and <onOverflow> when changes may have been lost
+type dirWatcherFactory func(onChange func(path string), onOverflow func()) (dirWatcher, error)
+
+// a Daemon serves the database of a Finder to clients and keeps it up to date
+type Daemon struct {
+	filesystem fs.FileSystem
+	logger     Logger
+	numThreads int
+	newWatcher dirWatcherFactory
+
+	// mutex is held while handling a request
+	mutex   sync.Mutex
+	finder  *Finder
+	config  []byte
+	watcher dirWatcher
+	watched map[string]bool
+	// err is set if the finder can't be kept up to date with the current config. The finder is
+	// started again on the next request, except after reaching the watch limit.
+	err error
+
+	// changeLock protects the changes reported by the watcher
+	changeLock sync.Mutex
+	changed    map[string]bool
+	overflowed bool
+}
+
+// NewDaemon creates a Daemon that serves Finders reading from <filesystem>
+func NewDaemon(filesystem fs.FileSystem, logger Logger) *Daemon {
+	return newDaemonImpl(filesystem, logger, defaultNumThreads, newDirWatcher)
+}
+
+func newDaemonImpl(filesystem fs.FileSystem, logger Logger, numThreads int,
+	newWatcher dirWatcherFactory) *Daemon {
+	return &Daemon{
+		filesystem: filesystem,
+		logger:     logger,
+		numThreads: numThreads,
+		newWatcher: newWatcher,
+		changed:    map[string]bool{},
+	}
+}
+
+// Serve accepts connections on <listener> and answers their requests until the listener is
+// closed
+func (d *Daemon) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go d.handle(conn)
+	}
+}
+
+// Close stops watching the filesystem
+func (d *Daemon) Close() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.stop()
+}
+
+func (d *Daemon) verbosef(format string, args ...interface{}) {
+	d.logger.Output(2, fmt.Sprintf(format, args...))
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(daemonTimeout))
+
+	reader := bufio.NewReader(conn)
+	requestBytes, err := reader.ReadBytes(lineSeparator)
+	if err != nil {
+		d.verbosef("Failed to read request: %v\n", err)
+		return
+	}
+
+	var db []byte
+	var request daemonRequest
+	err = json.Unmarshal(requestBytes, &request)
+	if err == nil {
+		db, err = d.query(request)
+	}
+
+	var response daemonResponse
+	if err != nil {
+		d.verbosef("Failed to answer request: %v\n", err)
+		response.Error = err.Error()
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		panic("failed to serialize finder daemon response")
+	}
+	responseBytes = append(responseBytes, lineSeparator)
+	_, err = conn.Write(append(responseBytes, db...))
+	if err != nil {
+		d.verbosef("Failed to write response: %v\n", err)
+	}
+}
+
+// query brings the database for the requested config up to date and returns it
+func (d *Daemon) query(request daemonRequest) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if request.Version != daemonVersionString {
+		return nil, fmt.Errorf("version %q is not supported, the daemon version is %q",
+			request.Version, daemonVersionString)
+	}
+	if view := d.filesystem.ViewId(); request.Config.FilesystemView != view {
+		return nil, fmt.Errorf("filesystem view %q does not match the daemon's view %q",
+			request.Config.FilesystemView, view)
+	}
+	config, err := request.Config.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.finder == nil || !bytes.Equal(config, d.config) || d.finder.DbPath != request.DbPath {
+		d.start(request.Config.CacheParams, request.DbPath)
+		d.config = config
+	} else if d.err == nil {
+		d.refresh()
+	}
+	if err := d.err; err != nil {
+		// Start again on the next request, unless starting again would reach the same limit
+		if !errors.Is(err, errWatchLimit) {
+			d.stop()
+		}
+		return nil, err
+	}
+
+	d.finder.lock()
+	defer d.finder.unlock()
+	return d.finder.serializeDb()
+}
+
+// start creates a new Finder for <cacheParams> and starts watching its directories
+func (d *Daemon) start(cacheParams CacheParams, dbPath string) {
+	d.stop()
+	d.verbosef("Starting finder for %v\n", cacheParams.RootDirs)
+
+	d.finder, d.err = newImpl(cacheParams, d.filesystem, d.logger, dbPath, d.numThreads)
+	if d.err != nil {
+		return
+	}
+
+	d.watcher, d.err = d.newWatcher(d.onChange, d.onOverflow)
+	if d.err != nil {
+		return
+	}
+	d.watched = map[string]bool{}
+	d.updateWatches()
+	if d.err != nil {
+		return
+	}
+
+	// Directories that changed between being scanned and being watched have been missed, so
+	// check every directory once, like when loading the cache file.
+	d.markOverflowed()
+	d.refresh()
+}
+
+// stop stops watching the filesystem and forgets the Finder
+func (d *Daemon) stop() {
+	if d.finder != nil {
+		d.finder.WaitForDbDump()
+	}
+	d.stopWatching()
+	d.finder = nil
+	d.config = nil
+	d.err = nil
+}
+
+func (d *Daemon) stopWatching() {
+	if d.watcher != nil {
+		d.watcher.Close()
+	}
+	d.watcher = nil
+	d.watched = nil
+
+	d.changeLock.Lock()
+	d.changed = map[string]bool{}
+	d.overflowed = false
+	d.changeLock.Unlock()
+}
+
+func (d *Daemon) onChange(path string) {
+	d.changeLock.Lock()
+	d.changed[path] = true
+	d.changeLock.Unlock()
+}
+
+func (d *Daemon) onOverflow() {
+	d.verbosef("Watcher overflowed, checking all directories\n")
+	d.markOverflowed()
+}
+
+func (d *Daemon) markOverflowed() {
+	d.changeLock.Lock()
+	d.overflowed = true
+	d.changeLock.Unlock()
+}
+
+// refresh updates the Finder with the changes reported by the watcher since the last refresh
+func (d *Daemon) refresh() {
+	// The client trusts the database without checking any directory, so wait for the watcher
+	// to report the changes made before the request, like a file created just before the build
+	if err := d.watcher.Sync(); err != nil {
+		d.verbosef("Failed to sync the watcher, checking all directories: %v\n", err)
+		d.markOverflowed()
+	}
+
+	d.changeLock.Lock()
+	changed := make([]string, 0, len(d.changed))
+	for path := range d.changed {
+		changed = append(changed, path)
+	}
+	overflowed := d.overflowed
+	d.changed = map[string]bool{}
+	d.overflowed = false
+	d.changeLock.Unlock()
+
+	if len(changed) == 0 && !overflowed {
+		return
+	}
+
+	f := d.finder
+	f.WaitForDbDump()
+	atomic.StoreInt32(&f.modifiedFlag, 0)
+
+	if overflowed {
+		f.restatAllDirs()
+	} else {
+		sort.Strings(changed)
+		f.relistDirs(changed)
+	}
+
+	d.err = f.getErr()
+	if d.err == nil {
+		d.err = f.checkRootDirs()
+	}
+	if d.err != nil {
+		d.stopWatching()
+		return
+	}
+
+	d.updateWatches()
+	f.goDumpDb()
+}
+
+// updateWatches watches every directory in the Finder and stops watching the directories that
+// were removed from it
+func (d *Daemon) updateWatches() {
+	dirs := d.finder.watchableDirs()
+	current := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		current[dir] = true
+	}
+
+	for path := range d.watched {
+		if !current[path] {
+			// The watcher may have already dropped the directory if it was removed
+			d.watcher.Remove(path)
+			delete(d.watched, path)
+		}
+	}
+
+	for _, path := range dirs {
+		if d.watched[path] {
+			continue
+		}
+		err := d.watcher.Add(path)
+		if errors.Is(err, errWatchLimit) {
+			// Release all the watches rather than serving a tree that is partially up to
+			// date. Clients will fall back to reading the cache file.
+			d.err = fmt.Errorf("%w; the limit can be raised with "+
+				"`sysctl fs.inotify.max_user_watches`, after which the daemon must be restarted", err)
+			d.verbosef("%v\n", d.err)
+			d.stopWatching()
+			return
+		} else if os.IsNotExist(err) {
+			// The directory was removed after being listed, the watcher of its parent will
+			// report the change
+			continue
+		} else if err != nil {
+			d.err = err
+			d.stopWatching()
+			return
+		}
+		d.watched[path] = true
+	}
+}
+
+// watchableDirs returns the paths of every directory that exists in the cache
+func (f *Finder) watchableDirs() []string {
+	f.lock()
+	defer f.unlock()
+
+	var dirs []string
+	nodes := []*pathMap{&f.nodes}
+	for len(nodes) > 0 {
+		node := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		if node.ModTime != 0 {
+			dirs = append(dirs, node.path)
+		}
+		for _, child := range node.children {
+			nodes = append(nodes, child)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// relistDirs lists the directories at <paths> again, along with any new subdirectories
+func (f *Finder) relistDirs(paths []string) {
+	f.lock()
+	defer f.unlock()
+
+	// Look up every node before listing any directory, because listing a directory replaces
+	// the children of its node
+	var nodes []*pathMap
+	for _, path := range paths {
+		node := f.nodes.GetNode(path, false)
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	f.threadPool = newThreadPool(f.numDbLoadingThreads)
+	for _, node := range nodes {
+		node.mapNode = mapNode{statResponse: f.statDirSync(node.path), FileNames: []string{}}
+		f.setModified()
+		if node.ModTime != 0 {
+			f.listDirAsync(node)
+		} else {
+			node.children = map[string]*pathMap{}
+		}
+	}
+	f.threadPool.Wait()
+	f.threadPool = nil
+
+	f.nodes.UpdateNumDescendentsRecursive()
+}
+
+// restatAllDirs checks every directory in the cache for changes, like when loading the cache
+// file
+func (f *Finder) restatAllDirs() {
+	f.lock()
+	defer f.unlock()
+
+	var nodes []*pathMap
+	stack := []*pathMap{&f.nodes}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if node.ModTime != 0 {
+			nodes = append(nodes, node)
+		}
+		for _, child := range node.children {
+			stack = append(stack, child)
+		}
+	}
+
+	f.threadPool = newThreadPool(f.numDbLoadingThreads)
+	for _, node := range nodes {
+		f.statDirAsync(node)
+	}
+	f.threadPool.Wait()
+	f.threadPool = nil
+
+	f.nodes.UpdateNumDescendentsRecursive()
+}
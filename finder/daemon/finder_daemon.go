@@ -0,0 +1,90 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// finder_daemon keeps the finder database of a source tree up to date by watching its
+// directories, and serves it to soong_ui over a unix socket. soong_ui uses the daemon when
+// SOONG_FINDER_DAEMON_SOCKET is set to the path of the socket.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"android/soong/finder"
+	"android/soong/finder/fs"
+)
+
+var (
+	socketPath = flag.String("socket", "", "path of the unix socket to listen on")
+	verbose    = flag.Bool("v", false, "log additional information")
+)
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	flag.Parse()
+	if *socketPath == "" {
+		flag.Usage()
+		return errors.New("Param 'socket' must be nonempty")
+	}
+
+	var writer io.Writer
+	if *verbose {
+		writer = os.Stderr
+	} else {
+		writer = ioutil.Discard
+	}
+	logger := log.New(writer, "", log.Ldate|log.Lmicroseconds|log.Lshortfile)
+
+	// Remove the socket of a daemon that didn't exit cleanly
+	if conn, err := net.Dial("unix", *socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("a finder daemon is already listening on %s", *socketPath)
+	}
+	os.Remove(*socketPath)
+
+	listener, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		return err
+	}
+
+	daemon := finder.NewDaemon(fs.OsFs, logger)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Closing the listener removes the socket and makes Serve return
+		listener.Close()
+	}()
+
+	logger.Printf("Listening on %s\n", *socketPath)
+	err = daemon.Serve(listener)
+	daemon.Close()
+	return err
+}
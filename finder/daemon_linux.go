@@ -0,0 +1,242 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The events that change the result of listing a directory, or the stats of the directory
+// itself
+const inotifyDirMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF |
+	syscall.IN_ONLYDIR
+
+// syncTimeout is the maximum time that Sync waits for the events of the inotify file descriptor
+// to be read
+const syncTimeout = 10 * time.Second
+
+// an inotifyWatcher is a dirWatcher implemented with inotify
+type inotifyWatcher struct {
+	// fd is used for the inotify calls, calling file.Fd() would make the file blocking
+	fd         int
+	file       *os.File
+	onChange   func(path string)
+	onOverflow func()
+
+	lock sync.Mutex
+	// more than one path may refer to the same directory when following symlinks
+	paths map[int32][]string
+	wds   map[string]int32
+
+	// Sync creates files in syncDir, which is watched by the same inotify file descriptor, and
+	// waits for their events.  Events are read in order, so every change that happened before
+	// the file was created has been reported by then.
+	syncLock  sync.Mutex
+	syncDir   string
+	syncWd    int32
+	syncCount int
+	synced    chan string
+}
+
+func newDirWatcher(onChange func(path string), onOverflow func()) (dirWatcher, error) {
+	// The file descriptor is non-blocking so that closing the os.File interrupts readEvents
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		fd:         fd,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		onChange:   onChange,
+		onOverflow: onOverflow,
+		paths:      map[int32][]string{},
+		wds:        map[string]int32{},
+		synced:     make(chan string, 1),
+	}
+
+	w.syncDir, err = os.MkdirTemp("", "finder-daemon-sync")
+	if err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, w.syncDir, syscall.IN_CREATE|syscall.IN_ONLYDIR)
+	if err != nil {
+		w.Close()
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: w.syncDir, Err: err}
+	}
+	w.syncWd = int32(wd)
+
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Add(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, found := w.wds[path]; found {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyDirMask)
+	if err == syscall.ENOSPC {
+		return fmt.Errorf("%w: %s", errWatchLimit, path)
+	} else if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	w.paths[int32(wd)] = append(w.paths[int32(wd)], path)
+	w.wds[path] = int32(wd)
+	return nil
+}
+
+func (w *inotifyWatcher) Remove(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	wd, found := w.wds[path]
+	if !found {
+		return nil
+	}
+	delete(w.wds, path)
+	paths := w.paths[wd][:0]
+	for _, p := range w.paths[wd] {
+		if p != path {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) > 0 {
+		w.paths[wd] = paths
+		return nil
+	}
+	delete(w.paths, wd)
+	_, err := syscall.InotifyRmWatch(w.fd, uint32(wd))
+	if err != nil && err != syscall.EINVAL {
+		// EINVAL means that the kernel already removed the watch
+		return &os.PathError{Op: "inotify_rm_watch", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Sync creates a file in syncDir and waits for its event to be read
+func (w *inotifyWatcher) Sync() error {
+	w.syncLock.Lock()
+	defer w.syncLock.Unlock()
+
+	// Forget the notifications that nobody waited for, like an earlier overflow
+	for len(w.synced) > 0 {
+		<-w.synced
+	}
+
+	w.syncCount++
+	name := strconv.Itoa(w.syncCount)
+	path := filepath.Join(w.syncDir, name)
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	timeout := time.After(syncTimeout)
+	for {
+		select {
+		case synced := <-w.synced:
+			// After an overflow the event may have been lost, but every directory will be
+			// checked anyway
+			if synced == name || synced == "" {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for the inotify event of %s", path)
+		}
+	}
+}
+
+// Close closes the inotify file descriptor, which releases all of its watches
+func (w *inotifyWatcher) Close() error {
+	os.RemoveAll(w.syncDir)
+	return w.file.Close()
+}
+
+// notifySynced wakes up Sync, unless it isn't waiting
+func (w *inotifyWatcher) notifySynced(name string) {
+	select {
+	case w.synced <- name:
+	default:
+	}
+}
+
+func (w *inotifyWatcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			// The watcher was closed
+			return
+		}
+		w.handleEvents(buf[:n])
+	}
+}
+
+func (w *inotifyWatcher) handleEvents(buf []byte) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := syscall.SizeofInotifyEvent + int(event.Len)
+		name := string(bytes.TrimRight(buf[syscall.SizeofInotifyEvent:end], "\x00"))
+		buf = buf[end:]
+
+		if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			w.onOverflow()
+			w.notifySynced("")
+			continue
+		}
+		if event.Wd == w.syncWd {
+			if event.Mask&syscall.IN_CREATE != 0 {
+				w.notifySynced(name)
+			}
+			continue
+		}
+
+		paths := w.paths[event.Wd]
+		if event.Mask&syscall.IN_IGNORED != 0 {
+			// The directory was removed, or its watch was removed
+			for _, path := range paths {
+				delete(w.wds, path)
+			}
+			delete(w.paths, event.Wd)
+			continue
+		}
+		if name != "" && event.Mask&syscall.IN_ATTRIB != 0 && event.Mask&syscall.IN_ISDIR == 0 {
+			// Changes to the attributes of files don't change the directory
+			continue
+		}
+
+		// Events for the directory itself (IN_DELETE_SELF, IN_MOVE_SELF and IN_ATTRIB without a
+		// name) are reported as a change of the directory too, which covers root directories
+		// whose parents aren't watched
+		for _, path := range paths {
+			w.onChange(path)
+		}
+	}
+}
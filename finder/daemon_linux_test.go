@@ -0,0 +1,176 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"android/soong/finder/fs"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	changes := make(chan string, 100)
+	w, err := newDirWatcher(func(path string) { changes <- path }, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expectChange := func(what string) {
+		t.Helper()
+		select {
+		case path := <-changes:
+			if path != dir {
+				t.Errorf("%s: expected a change of %q, got %q", what, dir, path)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no change reported", what)
+		}
+		// Drain any other events caused by the same change
+		for len(changes) > 0 {
+			<-changes
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("create file")
+
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("create directory")
+
+	if err := os.Remove(filepath.Join(dir, "file")); err != nil {
+		t.Fatal(err)
+	}
+	expectChange("remove file")
+
+	if err := w.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file2"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case path := <-changes:
+		t.Errorf("unexpected change of %q after removing the watch", path)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := w.Add(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
+
+func TestInotifyWatcherSync(t *testing.T) {
+	dir := t.TempDir()
+	var lock sync.Mutex
+	var changes []string
+	w, err := newDirWatcher(func(path string) {
+		lock.Lock()
+		defer lock.Unlock()
+		changes = append(changes, path)
+	}, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if err := w.Add(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every change made before Sync has been reported when it returns
+	for i := 0; i < 10; i++ {
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		reported := len(changes) > 0
+		changes = nil
+		lock.Unlock()
+		if !reported {
+			t.Fatalf("change %d was not reported by Sync", i)
+		}
+	}
+}
+
+func TestDaemonWithInotify(t *testing.T) {
+	root := t.TempDir()
+	create := func(path string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	create("a/Android.bp")
+	create(".git/b/Android.bp")
+
+	logger := log.New(ioutil.Discard, "", 0)
+	d := newDaemonImpl(fs.OsFs, logger, 2, newDirWatcher)
+	defer d.Close()
+	socket := filepath.Join(t.TempDir(), "finder.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go d.Serve(listener)
+
+	params := CacheParams{
+		WorkingDirectory: root,
+		RootDirs:         []string{"."},
+		ExcludeDirs:      []string{".git"},
+		IncludeFiles:     []string{"Android.bp"},
+	}
+	dbPath := filepath.Join(t.TempDir(), "files.db")
+	find := func() []string {
+		t.Helper()
+		f, err := newFromDaemonImpl(socket, params, fs.OsFs, logger, dbPath, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Shutdown()
+		return f.FindNamedAt(".", "Android.bp")
+	}
+
+	fs.AssertSameResponse(t, find(), []string{"a/Android.bp"})
+
+	// The daemon syncs with the watcher, so a change is found by the next query
+	create("a/c/Android.bp")
+	fs.AssertSameResponse(t, find(), []string{"a/Android.bp", "a/c/Android.bp"})
+}
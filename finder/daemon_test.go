@@ -0,0 +1,383 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"android/soong/finder/fs"
+)
+
+// a fakeWatcher is a dirWatcher whose changes are reported by the test
+type fakeWatcher struct {
+	lock       sync.Mutex
+	watched    map[string]bool
+	limit      int
+	onChange   func(path string)
+	onOverflow func()
+	// queued are the changes that are only reported by Sync, like the events that are still in
+	// the inotify queue
+	queued []string
+}
+
+func (w *fakeWatcher) Add(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.limit > 0 && len(w.watched) >= w.limit {
+		return fmt.Errorf("%w: %s", errWatchLimit, path)
+	}
+	w.watched[path] = true
+	return nil
+}
+
+func (w *fakeWatcher) Remove(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.watched, path)
+	return nil
+}
+
+func (w *fakeWatcher) Sync() error {
+	w.lock.Lock()
+	queued := w.queued
+	w.queued = nil
+	w.lock.Unlock()
+
+	for _, path := range queued {
+		w.onChange(path)
+	}
+	return nil
+}
+
+func (w *fakeWatcher) queueChange(path string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.queued = append(w.queued, path)
+}
+
+func (w *fakeWatcher) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.watched = map[string]bool{}
+	return nil
+}
+
+func (w *fakeWatcher) watchedDirs() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	dirs := []string{}
+	for dir := range w.watched {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// a testDaemon is a Daemon listening on a socket in a temporary directory
+type testDaemon struct {
+	*Daemon
+	socket  string
+	watcher *fakeWatcher
+}
+
+func newTestDaemon(t *testing.T, filesystem *fs.MockFs, watchLimit int) *testDaemon {
+	watcher := &fakeWatcher{watched: map[string]bool{}, limit: watchLimit}
+	newWatcher := func(onChange func(path string), onOverflow func()) (dirWatcher, error) {
+		watcher.onChange = onChange
+		watcher.onOverflow = onOverflow
+		return watcher, nil
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	d := &testDaemon{
+		Daemon:  newDaemonImpl(filesystem, logger, 2, newWatcher),
+		socket:  filepath.Join(t.TempDir(), "finder.sock"),
+		watcher: watcher,
+	}
+
+	listener, err := net.Listen("unix", d.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go d.Serve(listener)
+	t.Cleanup(func() {
+		listener.Close()
+		d.Close()
+	})
+	return d
+}
+
+// query creates a Finder from the daemon and waits for the daemon to finish updating the
+// cache file, so that the test can modify the filesystem.
+func (d *testDaemon) query(t *testing.T, filesystem *fs.MockFs, cacheParams CacheParams) (*Finder, error) {
+	filesystem.MkDirs("/finder")
+	if cacheParams.WorkingDirectory == "" {
+		cacheParams.WorkingDirectory = "/cwd"
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	f, err := newFromDaemonImpl(d.socket, cacheParams, filesystem, logger, "/finder/finder-db", 2)
+
+	d.mutex.Lock()
+	if d.finder != nil {
+		d.finder.WaitForDbDump()
+	}
+	d.mutex.Unlock()
+	return f, err
+}
+
+func (d *testDaemon) mustQuery(t *testing.T, filesystem *fs.MockFs, cacheParams CacheParams) *Finder {
+	f, err := d.query(t, filesystem, cacheParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func assertWatchedDirs(t *testing.T, d *testDaemon, expected []string) {
+	t.Helper()
+	if actual := d.watcher.watchedDirs(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("incorrect watched directories\nexpected: %q\n  actual: %q", expected, actual)
+	}
+}
+
+func TestDaemonFileAdded(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	fs.Create(t, "/tmp/b/c/nope.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	finder := d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"), []string{"/tmp/a/findme.txt"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/a", "/tmp/b", "/tmp/b/c"})
+
+	// Only the directories reported by the watcher are read again
+	filesystem.ClearMetrics()
+	fs.Create(t, "/tmp/b/c/findme.txt", filesystem)
+	fs.Create(t, "/tmp/b/c/d/findme.txt", filesystem)
+	d.watcher.onChange("/tmp/b/c")
+
+	finder = d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/a/findme.txt", "/tmp/b/c/d/findme.txt", "/tmp/b/c/findme.txt"})
+	fs.AssertSameStatCalls(t, filesystem.StatCalls, []string{"/tmp/b/c", "/tmp/b/c/d"})
+	fs.AssertSameReadDirCalls(t, filesystem.ReadDirCalls, []string{"/tmp/b/c", "/tmp/b/c/d"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/a", "/tmp/b", "/tmp/b/c", "/tmp/b/c/d"})
+
+	// Without changes the filesystem isn't accessed at all
+	filesystem.ClearMetrics()
+	finder = d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/a/findme.txt", "/tmp/b/c/d/findme.txt", "/tmp/b/c/findme.txt"})
+	fs.AssertSameStatCalls(t, filesystem.StatCalls, []string{})
+	fs.AssertSameReadDirCalls(t, filesystem.ReadDirCalls, []string{})
+}
+
+func TestDaemonChangeNotYetReported(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	d.mustQuery(t, filesystem, params)
+
+	// A file created just before the query is found, even though the watcher only reports
+	// the change when the daemon syncs with it
+	fs.Create(t, "/tmp/a/b/findme.txt", filesystem)
+	d.watcher.queueChange("/tmp/a")
+
+	finder := d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/a/b/findme.txt", "/tmp/a/findme.txt"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/a", "/tmp/a/b"})
+}
+
+func TestDaemonDirectoriesDeleted(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	fs.Create(t, "/tmp/b/c/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	d.mustQuery(t, filesystem, params)
+
+	fs.RemoveAll(t, "/tmp/b", filesystem)
+	d.watcher.onChange("/tmp")
+	d.watcher.onChange("/tmp/b")
+	d.watcher.onChange("/tmp/b/c")
+
+	finder := d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"), []string{"/tmp/a/findme.txt"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/a"})
+}
+
+func TestDaemonExcludeDirsAndPruneFiles(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/findme.txt", filesystem)
+	fs.Create(t, "/tmp/.git/objects/findme.txt", filesystem)
+	fs.Create(t, "/tmp/out/.ignore-out-dir", filesystem)
+	fs.Create(t, "/tmp/out/child/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		ExcludeDirs:  []string{".git"},
+		PruneFiles:   []string{".ignore-out-dir"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	finder := d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"), []string{"/tmp/findme.txt"})
+	// The pruned directory is watched for the removal of the prune file, but its children
+	// and the excluded directories are not
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/out"})
+
+	fs.Delete(t, "/tmp/out/.ignore-out-dir", filesystem)
+	d.watcher.onChange("/tmp/out")
+	finder = d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/findme.txt", "/tmp/out/child/findme.txt"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/out", "/tmp/out/child"})
+
+	fs.Create(t, "/tmp/out/.ignore-out-dir", filesystem)
+	d.watcher.onChange("/tmp/out")
+	finder = d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"), []string{"/tmp/findme.txt"})
+	assertWatchedDirs(t, d, []string{"/tmp", "/tmp/out"})
+}
+
+func TestDaemonOverflow(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	d.mustQuery(t, filesystem, params)
+
+	// After an overflow every directory is checked, like when loading the cache file
+	filesystem.Clock.Tick()
+	fs.Create(t, "/tmp/b/findme.txt", filesystem)
+	filesystem.ClearMetrics()
+	d.watcher.onOverflow()
+
+	finder := d.mustQuery(t, filesystem, params)
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/a/findme.txt", "/tmp/b/findme.txt"})
+	fs.AssertSameStatCalls(t, filesystem.StatCalls, []string{"/tmp", "/tmp/a", "/tmp/b"})
+	fs.AssertSameReadDirCalls(t, filesystem.ReadDirCalls, []string{"/tmp", "/tmp/b"})
+}
+
+func TestDaemonWatchLimit(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	fs.Create(t, "/tmp/b/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 2)
+	_, err := d.query(t, filesystem, params)
+	if err == nil || !strings.Contains(err.Error(), errWatchLimit.Error()) {
+		t.Fatalf("expected watch limit error, got %v", err)
+	}
+	if errors.Is(err, ErrNoDaemon) {
+		t.Errorf("expected an error from the daemon, got %v", err)
+	}
+	// The watches are released
+	assertWatchedDirs(t, d, []string{})
+
+	// The daemon doesn't try again
+	filesystem.ClearMetrics()
+	_, err = d.query(t, filesystem, params)
+	if err == nil || !strings.Contains(err.Error(), errWatchLimit.Error()) {
+		t.Fatalf("expected watch limit error, got %v", err)
+	}
+	fs.AssertSameReadDirCalls(t, filesystem.ReadDirCalls, []string{})
+}
+
+func TestDaemonChangedParams(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	fs.Create(t, "/tmp/a/alsome.txt", filesystem)
+
+	d := newTestDaemon(t, filesystem, 0)
+	finder := d.mustQuery(t, filesystem, CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	})
+	fs.AssertSameResponse(t, finder.FindAll(), []string{"/tmp/a/findme.txt"})
+
+	finder = d.mustQuery(t, filesystem, CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt", "alsome.txt"},
+	})
+	fs.AssertSameResponse(t, finder.FindAll(), []string{"/tmp/a/alsome.txt", "/tmp/a/findme.txt"})
+}
+
+func TestDaemonUpdatesCacheFile(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/tmp/a/findme.txt", filesystem)
+	params := CacheParams{
+		RootDirs:     []string{"/tmp"},
+		IncludeFiles: []string{"findme.txt"},
+	}
+
+	d := newTestDaemon(t, filesystem, 0)
+	d.mustQuery(t, filesystem, params)
+
+	filesystem.Clock.Tick()
+	fs.Create(t, "/tmp/b/findme.txt", filesystem)
+	d.watcher.onChange("/tmp")
+	d.mustQuery(t, filesystem, params)
+
+	// A Finder that doesn't use the daemon finds the changes in the cache file
+	filesystem.ClearMetrics()
+	finder := newFinder(t, filesystem, params)
+	defer finder.Shutdown()
+	fs.AssertSameResponse(t, finder.FindNamedAt("/tmp", "findme.txt"),
+		[]string{"/tmp/a/findme.txt", "/tmp/b/findme.txt"})
+	fs.AssertSameReadDirCalls(t, filesystem.ReadDirCalls, []string{})
+}
+
+func TestNoDaemon(t *testing.T) {
+	filesystem := newFs()
+	logger := log.New(ioutil.Discard, "", 0)
+	_, err := NewFromDaemon(filepath.Join(t.TempDir(), "finder.sock"),
+		CacheParams{RootDirs: []string{"/tmp"}}, filesystem, logger, "/finder/finder-db")
+	if !errors.Is(err, ErrNoDaemon) {
+		t.Errorf("expected ErrNoDaemon, got %v", err)
+	}
+}
@@ -164,6 +164,10 @@ type Finder struct {
 	// non-temporary state
 	modifiedFlag int32
 	nodes        pathMap
+
+	// cacheIsCurrent is set when the cache was received from a finder daemon that watches the
+	// filesystem, in which case the cached directories don't need to be stat'd again
+	cacheIsCurrent bool
 }
 
 var defaultNumThreads = runtime.NumCPU() * 2
@@ -177,6 +181,27 @@ func New(cacheParams CacheParams, filesystem fs.FileSystem,
 // newImpl is like New but accepts more params
 func newImpl(cacheParams CacheParams, filesystem fs.FileSystem,
 	logger Logger, dbPath string, numThreads int) (f *Finder, err error) {
+	f = newEmptyFinder(cacheParams, filesystem, logger, dbPath, numThreads)
+
+	f.loadFromFilesystem()
+
+	// check for any filesystem errors
+	err = f.getErr()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.checkRootDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// newEmptyFinder creates a Finder without loading anything
+func newEmptyFinder(cacheParams CacheParams, filesystem fs.FileSystem,
+	logger Logger, dbPath string, numThreads int) *Finder {
 	numDbLoadingThreads := numThreads
 	numSearchingThreads := numThreads
 
@@ -188,7 +213,7 @@ func newImpl(cacheParams CacheParams, filesystem fs.FileSystem,
 		},
 	}
 
-	f = &Finder{
+	return &Finder{
 		numDbLoadingThreads: numDbLoadingThreads,
 		numSearchingThreads: numSearchingThreads,
 		cacheMetadata:       metadata,
@@ -200,27 +225,20 @@ func newImpl(cacheParams CacheParams, filesystem fs.FileSystem,
 
 		shutdownWaitgroup: sync.WaitGroup{},
 	}
+}
 
-	f.loadFromFilesystem()
-
-	// check for any filesystem errors
-	err = f.getErr()
-	if err != nil {
-		return nil, err
-	}
-
-	// confirm that every path mentioned in the CacheConfig exists
-	for _, path := range cacheParams.RootDirs {
+// checkRootDirs confirms that every path mentioned in the CacheConfig exists
+func (f *Finder) checkRootDirs() error {
+	for _, path := range f.cacheMetadata.Config.RootDirs {
 		if !filepath.IsAbs(path) {
 			path = filepath.Join(f.cacheMetadata.Config.WorkingDirectory, path)
 		}
 		node := f.nodes.GetNode(filepath.Clean(path), false)
 		if node == nil || node.ModTime == 0 {
-			return nil, fmt.Errorf("path %v was specified to be included in the cache but does not exist\n", path)
+			return fmt.Errorf("path %v was specified to be included in the cache but does not exist\n", path)
 		}
 	}
-
-	return f, nil
+	return nil
 }
 
 // FindNamed searches for every cached file
@@ -810,6 +828,10 @@ func (f *Finder) loadBytes(id int, data []byte) (m *pathMap, dirsToWalk []string
 	stats := make([]statResponse, len(cachedNodes))
 
 	for i, node := range cachedNodes {
+		if f.cacheIsCurrent {
+			stats[i] = node.statResponse
+			continue
+		}
 		// check the file system for an updated timestamp
 		stats[i] = f.statDirSync(node.Path)
 	}
@@ -843,15 +865,22 @@ func (f *Finder) loadBytes(id int, data []byte) (m *pathMap, dirsToWalk []string
 // startFromExternalCache waits to return until the load of the cache db is complete, but
 // startFromExternalCache does not wait for all every listDir() or statDir() request to complete
 func (f *Finder) startFromExternalCache() (err error) {
-	startTime := time.Now()
 	dbPath := f.DbPath
 
-	// open cache file and validate its header
+	// open cache file
 	reader, err := f.filesystem.Open(dbPath)
 	if err != nil {
 		return errors.New("No data to load from database\n")
 	}
 	defer reader.Close()
+	return f.startFromCacheReader(reader)
+}
+
+// startFromCacheReader is like startFromExternalCache but reads the cache database from <reader>
+func (f *Finder) startFromCacheReader(reader io.Reader) (err error) {
+	startTime := time.Now()
+
+	// validate the cache header
 	bufferedReader := bufio.NewReader(reader)
 	if !f.validateCacheHeader(bufferedReader) {
 		return errors.New("Cache header does not match")
@@ -1476,6 +1505,7 @@ func (f *Finder) listDirSync(dir *pathMap) {
 func (f *Finder) listMatches(node *pathMap,
 	filter WalkFunc) (subDirs []*pathMap, filePaths []string) {
 	entries := DirEntries{
+		Path:      node.path,
 		FileNames: node.FileNames,
 	}
 	entries.DirNames = make([]string, 0, len(node.children))
@@ -0,0 +1,254 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// This file provides a declarative alternative to FindMatching.
+// A Query is answered from the cache like any other search, and only walks the directories
+// that could contain a match of its glob patterns.
+
+// a Query describes the files to find under a directory.
+// Paths are matched relative to the directory that is searched, using "/" as the separator.
+// Only files that are included in the cache (see CacheParams.IncludeFiles and
+// CacheParams.IncludeSuffixes) can be found.
+type Query struct {
+	// Patterns are glob patterns of the files to find. Besides the syntax of path.Match, a
+	// "**" path component matches any number of directories.
+	// A pattern that starts with "/" is anchored at the searched directory. Other patterns
+	// that contain a "/" are anchored too, and patterns without a "/" match the file name in
+	// any directory, so "Android.bp" is equivalent to "/**/Android.bp".
+	Patterns []string
+
+	// Regexps are regular expressions of the files to find. A regular expression must match
+	// the whole relative path of a file.
+	Regexps []string
+
+	// Files matching any of Patterns or Regexps are found. If both are empty, every file is
+	// found.
+
+	// Excludes are glob patterns, with the same syntax as Patterns, of files and directories
+	// to leave out. Excluded directories are not searched.
+	Excludes []string
+
+	// Limit is the maximum number of results, 0 means no limit. When the results are limited,
+	// the first results in sorted order are returned.
+	Limit int
+}
+
+// a globPattern is a parsed glob pattern
+type globPattern struct {
+	components []string
+}
+
+func parseGlobPattern(pattern string) (globPattern, error) {
+	if pattern == "" {
+		return globPattern{}, fmt.Errorf("empty pattern")
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+
+	components := strings.Split(pattern, "/")
+	if !anchored {
+		components = append([]string{"**"}, components...)
+	}
+	for _, component := range components {
+		if component == "" {
+			return globPattern{}, fmt.Errorf("pattern %q has an empty path component", pattern)
+		}
+		// path.Match checks the syntax of the whole pattern
+		if _, err := path.Match(component, ""); err != nil {
+			return globPattern{}, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+	}
+	return globPattern{components: components}, nil
+}
+
+// matches returns whether the pattern matches the path with the given components
+func (g globPattern) matches(pathComponents []string) bool {
+	return matchComponents(g.components, pathComponents, false)
+}
+
+// mayMatchUnder returns whether the pattern may match a path under the directory with the
+// given components
+func (g globPattern) mayMatchUnder(dirComponents []string) bool {
+	return matchComponents(g.components, dirComponents, true)
+}
+
+// matchComponents matches the components of a path against the components of a pattern.
+// If prefix is set, it returns whether the path may be a prefix of a match instead.
+func matchComponents(pattern []string, pathComponents []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Skip consecutive "**" components
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(pathComponents); i++ {
+				if matchComponents(pattern, pathComponents[i:], prefix) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(pathComponents) == 0 {
+			return prefix
+		}
+		if matched, _ := path.Match(pattern[0], pathComponents[0]); !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		pathComponents = pathComponents[1:]
+	}
+	// A path under a directory that matched the whole pattern can't match it too
+	return len(pathComponents) == 0 && !prefix
+}
+
+// a compiledQuery is a Query whose patterns have been parsed
+type compiledQuery struct {
+	patterns []globPattern
+	regexps  []*regexp.Regexp
+	excludes []globPattern
+}
+
+func compileQuery(query Query) (*compiledQuery, error) {
+	c := &compiledQuery{}
+	for _, pattern := range query.Patterns {
+		g, err := parseGlobPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		c.patterns = append(c.patterns, g)
+	}
+	for _, expr := range query.Regexps {
+		r, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("regular expression %q: %v", expr, err)
+		}
+		c.regexps = append(c.regexps, r)
+	}
+	for _, pattern := range query.Excludes {
+		g, err := parseGlobPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		c.excludes = append(c.excludes, g)
+	}
+	if query.Limit < 0 {
+		return nil, fmt.Errorf("limit %d is negative", query.Limit)
+	}
+	return c, nil
+}
+
+func (c *compiledQuery) excluded(components []string) bool {
+	for _, exclude := range c.excludes {
+		if exclude.matches(components) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFile returns whether the file with the given path relative to the searched directory
+// is a result of the query
+func (c *compiledQuery) matchesFile(relPath string, components []string) bool {
+	if c.excluded(components) {
+		return false
+	}
+	if len(c.patterns) == 0 && len(c.regexps) == 0 {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if pattern.matches(components) {
+			return true
+		}
+	}
+	for _, r := range c.regexps {
+		if r.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchesDir returns whether the directory with the given path components relative to the
+// searched directory may contain results of the query
+func (c *compiledQuery) searchesDir(components []string) bool {
+	if c.excluded(components) {
+		return false
+	}
+	if len(c.patterns) == 0 || len(c.regexps) > 0 {
+		return true
+	}
+	for _, pattern := range c.patterns {
+		if pattern.mayMatchUnder(components) {
+			return true
+		}
+	}
+	return false
+}
+
+// Query searches under <rootPath> for the files described by <query>
+func (f *Finder) Query(rootPath string, query Query) ([]string, error) {
+	c, err := compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	absRoot := rootPath
+	if !filepath.IsAbs(absRoot) {
+		absRoot = filepath.Join(f.cacheMetadata.Config.WorkingDirectory, absRoot)
+	}
+	absRoot = filepath.Clean(absRoot)
+
+	relComponents := func(dir string) []string {
+		rel := strings.TrimPrefix(strings.TrimPrefix(dir, absRoot), "/")
+		if rel == "" {
+			return nil
+		}
+		return strings.Split(rel, "/")
+	}
+
+	filter := func(entries DirEntries) (dirNames []string, fileNames []string) {
+		dir := relComponents(entries.Path)
+		for _, dirName := range entries.DirNames {
+			if c.searchesDir(append(dir[:len(dir):len(dir)], dirName)) {
+				dirNames = append(dirNames, dirName)
+			}
+		}
+		for _, fileName := range entries.FileNames {
+			components := append(dir[:len(dir):len(dir)], fileName)
+			if c.matchesFile(strings.Join(components, "/"), components) {
+				fileNames = append(fileNames, fileName)
+			}
+		}
+		return dirNames, fileNames
+	}
+
+	results := f.FindMatching(rootPath, filter)
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package finder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"android/soong/finder/fs"
+)
+
+func TestGlobPattern(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		path    string
+		matches bool
+		under   bool
+	}{
+		{pattern: "Android.bp", path: "Android.bp", matches: true, under: true},
+		{pattern: "Android.bp", path: "a/b/Android.bp", matches: true, under: true},
+		{pattern: "*.mk", path: "a/b/c.mk", matches: true, under: true},
+		{pattern: "*.mk", path: "a/b/c.bp", matches: false, under: true},
+		{pattern: "/Android.bp", path: "Android.bp", matches: true},
+		{pattern: "/Android.bp", path: "a/Android.bp", matches: false},
+		{pattern: "a/*.bp", path: "a/Android.bp", matches: true},
+		{pattern: "a/*.bp", path: "b/Android.bp", matches: false},
+		{pattern: "a/*.bp", path: "a/b/Android.bp", matches: false},
+		{pattern: "a/**/*.bp", path: "a/Android.bp", matches: true, under: true},
+		{pattern: "a/**/*.bp", path: "a/b/c/Android.bp", matches: true, under: true},
+		{pattern: "a/**/*.bp", path: "b/c/Android.bp", matches: false},
+		{pattern: "a/**/c/*.bp", path: "a/b/c/Android.bp", matches: true, under: true},
+		{pattern: "a/**/c/*.bp", path: "a/b/d/Android.bp", matches: false, under: true},
+		{pattern: "a/**", path: "a/b/c", matches: true, under: true},
+		{pattern: "a/?/[cd]", path: "a/b/d", matches: true},
+		{pattern: "a/?/[cd]", path: "a/bb/d", matches: false},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			g, err := parseGlobPattern(test.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			components := strings.Split(test.path, "/")
+			if got := g.matches(components); got != test.matches {
+				t.Errorf("matches: expected %v, got %v", test.matches, got)
+			}
+
+			// Every directory containing a match must be searched
+			dir := components[:len(components)-1]
+			if got := g.mayMatchUnder(dir); test.matches && !got {
+				t.Errorf("mayMatchUnder(%q): expected true, got false", dir)
+			}
+			// A file with the path of the directory can only have matches under it if the
+			// pattern continues after it
+			if got := g.mayMatchUnder(components); got != test.under {
+				t.Errorf("mayMatchUnder(%q): expected %v, got %v", components, test.under, got)
+			}
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	testCases := []struct {
+		query Query
+		err   string
+	}{
+		{Query{Patterns: []string{"a["}}, "syntax error in pattern"},
+		{Query{Patterns: []string{"a//b"}}, "empty path component"},
+		{Query{Excludes: []string{""}}, "empty pattern"},
+		{Query{Regexps: []string{"a("}}, "missing closing )"},
+		{Query{Limit: -1}, "negative"},
+	}
+
+	for _, test := range testCases {
+		_, err := compileQuery(test.query)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: expected error containing %q, got %v", test.query, test.err, err)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	filesystem := newFs()
+	for _, path := range []string{
+		"Android.bp",
+		"a/Android.bp",
+		"a/b/Android.bp",
+		"a/b/board.mk",
+		"a/b/Android.mk",
+		"a/out/Android.bp",
+		"c/Android.bp",
+		"c/product.mk",
+		"c/tests/Android.bp",
+	} {
+		fs.Create(t, "/cwd/"+path, filesystem)
+	}
+
+	f := newFinder(t, filesystem, CacheParams{
+		WorkingDirectory: "/cwd",
+		RootDirs:         []string{"."},
+		IncludeFiles:     []string{"Android.bp"},
+		IncludeSuffixes:  []string{".mk"},
+	})
+	defer f.Shutdown()
+
+	testCases := []struct {
+		name     string
+		root     string
+		query    Query
+		expected []string
+	}{
+		{
+			name:  "name in any directory",
+			root:  ".",
+			query: Query{Patterns: []string{"Android.bp"}},
+			expected: []string{"Android.bp", "a/Android.bp", "a/b/Android.bp", "a/out/Android.bp",
+				"c/Android.bp", "c/tests/Android.bp"},
+		},
+		{
+			name:     "anchored",
+			root:     ".",
+			query:    Query{Patterns: []string{"/Android.bp", "c/*"}},
+			expected: []string{"Android.bp", "c/Android.bp", "c/product.mk"},
+		},
+		{
+			name:     "doublestar",
+			root:     ".",
+			query:    Query{Patterns: []string{"a/**/*.mk"}},
+			expected: []string{"a/b/Android.mk", "a/b/board.mk"},
+		},
+		{
+			name:     "excludes",
+			root:     ".",
+			query:    Query{Patterns: []string{"*.mk"}, Excludes: []string{"Android.mk"}},
+			expected: []string{"a/b/board.mk", "c/product.mk"},
+		},
+		{
+			name:     "excluded directories",
+			root:     ".",
+			query:    Query{Patterns: []string{"Android.bp"}, Excludes: []string{"out", "c/tests"}},
+			expected: []string{"Android.bp", "a/Android.bp", "a/b/Android.bp", "c/Android.bp"},
+		},
+		{
+			name:     "regexps",
+			root:     ".",
+			query:    Query{Regexps: []string{`[ac]/[^/]*\.(bp|mk)`}},
+			expected: []string{"a/Android.bp", "c/Android.bp", "c/product.mk"},
+		},
+		{
+			name:     "patterns or regexps",
+			root:     ".",
+			query:    Query{Patterns: []string{"/Android.bp"}, Regexps: []string{`c/.*\.mk`}},
+			expected: []string{"Android.bp", "c/product.mk"},
+		},
+		{
+			name:     "limit",
+			root:     ".",
+			query:    Query{Patterns: []string{"Android.bp"}, Limit: 2},
+			expected: []string{"Android.bp", "a/Android.bp"},
+		},
+		{
+			name:     "everything",
+			root:     "c",
+			query:    Query{},
+			expected: []string{"c/Android.bp", "c/product.mk", "c/tests/Android.bp"},
+		},
+		{
+			name:     "relative to root",
+			root:     "a",
+			query:    Query{Patterns: []string{"/*/Android.bp"}},
+			expected: []string{"a/b/Android.bp", "a/out/Android.bp"},
+		},
+		{
+			name:     "absolute root",
+			root:     "/cwd/c",
+			query:    Query{Patterns: []string{"/tests/*"}},
+			expected: []string{"/cwd/c/tests/Android.bp"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := f.Query(test.root, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, test.expected) {
+				t.Errorf("incorrect results\nexpected: %q\n     got: %q", test.expected, results)
+			}
+		})
+	}
+}
+
+func TestQueryOnlySearchesMatchingDirectories(t *testing.T) {
+	filesystem := newFs()
+	fs.Create(t, "/cwd/a/b/Android.bp", filesystem)
+	fs.Create(t, "/cwd/c/d/Android.bp", filesystem)
+
+	f := newFinder(t, filesystem, CacheParams{
+		WorkingDirectory: "/cwd",
+		RootDirs:         []string{"."},
+		IncludeFiles:     []string{"Android.bp"},
+	})
+	defer f.Shutdown()
+
+	var searched []string
+	c, err := compileQuery(Query{Patterns: []string{"a/*/Android.bp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"a", "a/b", "c", "c/d"} {
+		if c.searchesDir(strings.Split(dir, "/")) {
+			searched = append(searched, dir)
+		}
+	}
+	if expected := []string{"a", "a/b"}; !reflect.DeepEqual(searched, expected) {
+		t.Errorf("expected to search %q, searched %q", expected, searched)
+	}
+
+	results, err := f.Query(".", Query{Patterns: []string{"a/*/Android.bp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.AssertSameResponse(t, results, []string{"a/b/Android.bp"})
+}
@@ -65,6 +65,8 @@ func RegisterGenruleBuildComponents(ctx android.RegistrationContext) {
 	ctx.FinalDepsMutators(func(ctx android.RegisterMutatorsContext) {
 		ctx.BottomUp("genrule_tool_deps", toolDepsMutator).Parallel()
 	})
+
+	ctx.RegisterParallelSingletonType("genrule_undeclared_inputs", undeclaredInputsSingletonFactory)
 }
 
 var (
@@ -180,6 +182,10 @@ type Module struct {
 
 	subName string
 	subDir  string
+
+	// The report of inputs read by the commands that were not declared, only set when
+	// SOONG_GENRULE_TRACE_INPUTS=true.
+	undeclaredInputsReport android.Path
 }
 
 type taskFunc func(ctx android.ModuleContext, rawCommand string, srcFiles android.Paths) []generateTask
@@ -409,6 +415,8 @@ func (g *Module) generateCommonBuildActions(ctx android.ModuleContext) {
 	}
 
 	var extraInputs android.Paths
+	var taskInputs android.Paths
+	var fileAccessTraces android.Paths
 	// Generate tasks, either from genrule or gensrcs.
 	for i, task := range g.taskGenerator(ctx, cmd, srcFiles) {
 		if len(task.out) == 0 {
@@ -443,6 +451,12 @@ func (g *Module) generateCommonBuildActions(ctx android.ModuleContext) {
 		if Bool(g.properties.Write_if_changed) {
 			rule.Restat()
 		}
+		if traceGenruleInputs(ctx) {
+			traceFile := android.PathForModuleOut(ctx, strings.TrimSuffix(manifestName, ".sbox.textproto")+".file_accesses")
+			rule.TraceFileAccesses(traceFile)
+			fileAccessTraces = append(fileAccessTraces, traceFile)
+			taskInputs = append(taskInputs, task.in...)
+		}
 		cmd := rule.Command()
 
 		for _, out := range task.out {
@@ -565,6 +579,15 @@ func (g *Module) generateCommonBuildActions(ctx android.ModuleContext) {
 	}
 
 	g.outputFiles = outputFiles.Paths()
+
+	if len(fileAccessTraces) > 0 {
+		var declaredInputs android.Paths
+		declaredInputs = append(declaredInputs, srcFiles...)
+		declaredInputs = append(declaredInputs, extraInputs...)
+		declaredInputs = append(declaredInputs, taskInputs...)
+		declaredInputs = append(declaredInputs, tools...)
+		g.undeclaredInputsReport = buildUndeclaredInputsReport(ctx, fileAccessTraces, declaredInputs)
+	}
 }
 
 func (g *Module) GenerateAndroidBuildActions(ctx android.ModuleContext) {
@@ -847,12 +870,16 @@ func getSandboxingAllowlistSets(ctx android.PathContext) *sandboxingAllowlistSet
 	}).(*sandboxingAllowlistSets)
 }
 
+func isInSandboxingDenyList(ctx android.ModuleContext) bool {
+	return getSandboxingAllowlistSets(ctx).sandboxingDenyModuleSet[ctx.ModuleName()]
+}
+
+func sandboxesInputs(ctx android.ModuleContext) bool {
+	return ctx.DeviceConfig().GenruleSandboxing() && !isInSandboxingDenyList(ctx)
+}
+
 func getSandboxedRuleBuilder(ctx android.ModuleContext, r *android.RuleBuilder) *android.RuleBuilder {
-	if !ctx.DeviceConfig().GenruleSandboxing() {
-		return r.SandboxTools()
-	}
-	sandboxingAllowlistSets := getSandboxingAllowlistSets(ctx)
-	if sandboxingAllowlistSets.sandboxingDenyModuleSet[ctx.ModuleName()] {
+	if !sandboxesInputs(ctx) {
 		return r.SandboxTools()
 	}
 	return r.SandboxInputs()
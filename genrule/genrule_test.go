@@ -523,6 +523,62 @@ func TestGenruleHashInputs(t *testing.T) {
 	}
 }
 
+func TestGenruleTraceInputs(t *testing.T) {
+	bp := `
+		genrule {
+			name: "gen",
+			srcs: ["in1.txt"],
+			tool_files: ["tool_file1"],
+			out: ["out"],
+			cmd: "cat $(in) > $(out)",
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		result := prepareForGenRuleTest.RunTestWithBp(t, testGenruleBp()+bp)
+
+		gen := result.ModuleForTests("gen", "")
+		android.AssertStringDoesNotContain(t, "sbox command", gen.Output("out").RuleParams.Command,
+			"--file-access-trace")
+		if report := gen.Module().(*Module).undeclaredInputsReport; report != nil {
+			t.Errorf("expected no undeclared inputs report, got %q", report)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		result := android.GroupFixturePreparers(
+			prepareForGenRuleTest,
+			android.FixtureMergeEnv(map[string]string{
+				"SOONG_GENRULE_TRACE_INPUTS": "true",
+			}),
+		).RunTestWithBp(t, testGenruleBp()+bp)
+
+		gen := result.ModuleForTests("gen", "")
+		genRule := gen.Output("out")
+		android.AssertStringDoesContain(t, "sbox command", genRule.RuleParams.Command,
+			"--file-access-trace out/soong/.intermediates/gen/genrule.file_accesses")
+		android.AssertPathsRelativeToTopEquals(t, "implicit outputs",
+			[]string{"out/soong/.intermediates/gen/genrule.file_accesses"}, genRule.ImplicitOutputs.Paths())
+
+		declared := android.ContentFromFileRuleForTests(t, result.TestContext, gen.Output("genrule.declared_inputs"))
+		android.AssertStringEquals(t, "declared inputs", "in1.txt\ntool_file1", declared)
+
+		report := gen.Rule("genrule_undeclared_inputs")
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "-module gen")
+		android.AssertStringDoesContain(t, "report command", report.RuleParams.Command, "-sandboxed")
+		android.AssertStringDoesNotContain(t, "report command", report.RuleParams.Command, "-deny-listed")
+		android.AssertPathsRelativeToTopEquals(t, "report inputs",
+			[]string{
+				"out/soong/.intermediates/gen/genrule.declared_inputs",
+				"out/soong/.intermediates/gen/genrule.file_accesses",
+			}, report.Implicits)
+
+		merge := result.SingletonForTests("genrule_undeclared_inputs").Rule("genrule_undeclared_inputs")
+		android.AssertPathsRelativeToTopEquals(t, "merged report inputs",
+			[]string{"out/soong/.intermediates/gen/genrule.undeclared_inputs.json"}, merge.Inputs)
+	})
+}
+
 func TestGenSrcs(t *testing.T) {
 	testcases := []struct {
 		name string
@@ -0,0 +1,110 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genrule
+
+import (
+	"path/filepath"
+	"strings"
+
+	"android/soong/android"
+)
+
+// When SOONG_GENRULE_TRACE_INPUTS=true is set, sbox traces the files read by the commands of
+// every genrule, and the genrule_undeclared_inputs tool compares them with the inputs declared in
+// the Android.bp file.  The per-module reports are merged into
+// $OUT_DIR/soong/genrule_undeclared_inputs.json and .txt, which are built by the
+// genrule-undeclared-inputs phony target.
+const traceGenruleInputsEnvVar = "SOONG_GENRULE_TRACE_INPUTS"
+
+// undeclaredInputsIgnoredPrefixes are directories of host tools and runtimes that commands read
+// implicitly, and that can't be declared in an Android.bp file.
+var undeclaredInputsIgnoredPrefixes = []string{
+	"prebuilts/build-tools/",
+	"prebuilts/clang/host/",
+	"prebuilts/go/",
+	"prebuilts/jdk/",
+	"prebuilts/python/",
+}
+
+func traceGenruleInputs(ctx android.PathContext) bool {
+	return ctx.Config().IsEnvTrue(traceGenruleInputsEnvVar)
+}
+
+// buildUndeclaredInputsReport creates a rule that compares the files read by the commands of the
+// module with its declared inputs and returns the path to the module's report.
+func buildUndeclaredInputsReport(ctx android.ModuleContext, traces, declared android.Paths) android.Path {
+	declaredList := android.PathForModuleOut(ctx, "genrule.declared_inputs")
+	android.WriteFileRule(ctx, declaredList, strings.Join(android.SortedUniqueStrings(declared.Strings()), "\n"))
+
+	report := android.PathForModuleOut(ctx, "genrule.undeclared_inputs.json")
+
+	// Shared libraries of host tools are loaded from the host out directory.
+	hostLibDir := filepath.Join(filepath.Dir(ctx.Config().HostToolDir()), "lib64") + "/"
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().
+		BuiltTool("genrule_undeclared_inputs").
+		FlagWithArg("-module ", ctx.ModuleName()).
+		FlagWithArg("-blueprint ", ctx.BlueprintsFile()).
+		FlagWithInput("-declared ", declaredList).
+		FlagWithArg("-out-dir ", ctx.Config().OutDir()).
+		FlagWithArg("-module-out-dir ", android.PathForModuleOut(ctx).String()).
+		FlagForEachArg("-ignore ", append(android.CopyOf(undeclaredInputsIgnoredPrefixes), hostLibDir))
+	if sandboxesInputs(ctx) {
+		cmd.Flag("-sandboxed")
+	}
+	if isInSandboxingDenyList(ctx) {
+		cmd.Flag("-deny-listed")
+	}
+	cmd.FlagWithOutput("-o ", report).
+		Inputs(traces)
+	rule.Build("genrule_undeclared_inputs", "genrule undeclared inputs")
+
+	return report
+}
+
+func undeclaredInputsSingletonFactory() android.Singleton {
+	return &undeclaredInputsSingleton{}
+}
+
+// undeclaredInputsSingleton merges the undeclared inputs reports of all genrule modules.
+type undeclaredInputsSingleton struct{}
+
+func (s *undeclaredInputsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	if !traceGenruleInputs(ctx) {
+		return
+	}
+
+	var reports android.Paths
+	ctx.VisitAllModules(func(m android.Module) {
+		if g, ok := m.(*Module); ok && g.undeclaredInputsReport != nil {
+			reports = append(reports, g.undeclaredInputsReport)
+		}
+	})
+
+	jsonReport := android.PathForOutput(ctx, "genrule_undeclared_inputs.json")
+	textReport := android.PathForOutput(ctx, "genrule_undeclared_inputs.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().
+		BuiltTool("genrule_undeclared_inputs").
+		Flag("-merge").
+		FlagWithOutput("-o ", jsonReport).
+		FlagWithOutput("-text ", textReport).
+		FlagWithRspFileInputList("@", android.PathForOutput(ctx, "genrule_undeclared_inputs.rsp"), reports)
+	rule.Build("genrule_undeclared_inputs", "merge genrule undeclared inputs reports")
+
+	ctx.Phony("genrule-undeclared-inputs", jsonReport, textReport)
+}
@@ -223,6 +223,12 @@ var (
 		},
 		"jarArgs")
 
+	zipCheck = pctx.AndroidStaticRule("zipCheck",
+		blueprint.RuleParams{
+			Command:     `${config.ZipCheckCmd} -order jar -stamp $out $in`,
+			CommandDeps: []string{"${config.ZipCheckCmd}"},
+		})
+
 	jarjar = pctx.AndroidStaticRule("jarjar",
 		blueprint.RuleParams{
 			Command: "" +
@@ -673,6 +679,11 @@ func TransformJarsToJar(ctx android.ModuleContext, outputFile android.WritablePa
 		jarArgs = append(jarArgs, "-D")
 	}
 
+	// Reuse the previous combined jar for the inputs that have not changed.
+	if ctx.Config().IsEnvTrue("SOONG_INCREMENTAL_MERGE_ZIPS") {
+		jarArgs = append(jarArgs, "-incremental")
+	}
+
 	rule := combineJar
 	// Keep the command line under the MAX_ARG_STRLEN limit by putting the list of jars into an rsp file
 	// if it is too long.
@@ -688,12 +699,27 @@ func TransformJarsToJar(ctx android.ModuleContext, outputFile android.WritablePa
 		rule = combineJarRsp
 	}
 
+	// Check the combined jar for non-reproducible contents when requested.  The check depends on
+	// the combined jar, which ninja allows for validations.
+	var validation android.Path
+	if ctx.Config().IsEnvTrue("SOONG_CHECK_ZIP_REPRODUCIBILITY") {
+		zipCheckStamp := android.PathForModuleOut(ctx, "zipcheck", outputFile.Rel()+".stamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        zipCheck,
+			Description: "zipcheck " + outputFile.Base(),
+			Input:       outputFile,
+			Output:      zipCheckStamp,
+		})
+		validation = zipCheckStamp
+	}
+
 	ctx.Build(pctx, android.BuildParams{
 		Rule:        rule,
 		Description: desc,
 		Output:      outputFile,
 		Inputs:      jars,
 		Implicits:   deps,
+		Validation:  validation,
 		Args: map[string]string{
 			"jarArgs": strings.Join(jarArgs, " "),
 		},
@@ -0,0 +1,231 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+// Rules for generating jacoco coverage reports
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	RegisterCoverageReportBuildComponents(android.InitRegistrationContext)
+}
+
+func RegisterCoverageReportBuildComponents(ctx android.RegistrationContext) {
+	ctx.RegisterModuleType("java_coverage_report", CoverageReportFactory)
+}
+
+var (
+	// Merges the execution data files, and generates the HTML, XML and LCOV reports for the
+	// classes in the jacoco-report-classes jars that match stripSpec.
+	jacocoReport = pctx.AndroidStaticRule("jacocoReport", blueprint.RuleParams{
+		Command: `rm -rf $tmpDir && mkdir -p $tmpDir/html && ` +
+			`${config.MergeZipsCmd} --ignore-duplicates -j $tmpDir/all-classes.jar $classesJars && ` +
+			`${config.Zip2ZipCmd} -i $tmpDir/all-classes.jar -o $tmpDir/classes.jar $stripSpec && ` +
+			`${config.JavaCmd} ${config.JavaVmFlags} -jar ${config.JacocoCLIJar} ` +
+			`  merge --quiet $in --destfile $execFile && ` +
+			`${config.JavaCmd} ${config.JavaVmFlags} -jar ${config.JacocoCLIJar} ` +
+			`  report --quiet $execFile --classfiles $tmpDir/classes.jar --name $reportName $sourceFiles ` +
+			`  --xml $xml --html $tmpDir/html && ` +
+			`${config.SoongZipCmd} -o $out -C $tmpDir/html -D $tmpDir/html && ` +
+			`${config.JacocoLcovCmd} -o $lcov $xml && ` +
+			`rm -rf $tmpDir`,
+		CommandDeps: []string{
+			"${config.MergeZipsCmd}",
+			"${config.Zip2ZipCmd}",
+			"${config.JavaCmd}",
+			"${config.JacocoCLIJar}",
+			"${config.SoongZipCmd}",
+			"${config.JacocoLcovCmd}",
+		},
+	},
+		"tmpDir", "classesJars", "stripSpec", "execFile", "reportName", "sourceFiles", "xml", "lcov")
+)
+
+var coverageReportInstrumentedTag = dependencyTag{name: "coverage-report-instrumented"}
+
+type coverageReportProperties struct {
+	// Java modules whose classes are included in the report.  The modules must be instrumented,
+	// for example by building with EMMA_INSTRUMENT=true.  The classes are taken from the
+	// jacoco-report-classes jars of the modules, so only the classes selected by the
+	// jacoco.include_filter and jacoco.exclude_filter properties of each module are reported.
+	// The classes run by an android_robolectric_test are those of the android_app in its
+	// instrumentation_for property, so list the app rather than the test.
+	Instrumented_modules []string
+
+	// Host Java modules whose classes are included in the report, like instrumented_modules.
+	Host_instrumented_modules []string
+
+	// Jacoco execution data files (.ec or .exec) to merge into the report, for example the
+	// outputs of a genrule that runs the tests.
+	Execution_data []string `android:"path"`
+
+	// Directories, relative to the module directory, that contain the sources of the reported
+	// classes in their package layout.  Used to show the sources in the HTML report.
+	Source_dirs []string
+
+	// Further restricts the reported classes.  Uses the same format as jacoco.include_filter and
+	// jacoco.exclude_filter in java modules.
+	Jacoco struct {
+		Include_filter []string
+		Exclude_filter []string
+	}
+}
+
+type CoverageReport struct {
+	android.ModuleBase
+
+	properties coverageReportProperties
+
+	execFile   android.Path
+	htmlReport android.Path
+	xmlReport  android.Path
+	lcovReport android.Path
+}
+
+// java_coverage_report merges jacoco execution data files collected by running instrumented
+// Java modules and generates HTML, XML and LCOV coverage reports for the classes of those modules.
+//
+// The reports are built by building the module name, and are written to report-html.zip,
+// report.xml and report.lcov in the module output directory.
+func CoverageReportFactory() android.Module {
+	module := &CoverageReport{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidArchModule(module, android.HostAndDeviceSupported, android.MultilibCommon)
+	return module
+}
+
+func (r *CoverageReport) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ctx.AddVariationDependencies(nil, coverageReportInstrumentedTag, r.properties.Instrumented_modules...)
+	ctx.AddFarVariationDependencies(ctx.Config().BuildOSCommonTarget.Variations(),
+		coverageReportInstrumentedTag, r.properties.Host_instrumented_modules...)
+}
+
+func (r *CoverageReport) stripSpec(ctx android.ModuleContext) string {
+	includes, err := jacocoFiltersToSpecs(r.properties.Jacoco.Include_filter)
+	if err != nil {
+		ctx.PropertyErrorf("jacoco.include_filter", "%s", err.Error())
+	}
+	excludes, err := jacocoFiltersToSpecs(r.properties.Jacoco.Exclude_filter)
+	if err != nil {
+		ctx.PropertyErrorf("jacoco.exclude_filter", "%s", err.Error())
+	}
+	return jacocoFiltersToZipCommand(includes, excludes)
+}
+
+func (r *CoverageReport) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	execData := android.PathsForModuleSrc(ctx, r.properties.Execution_data)
+	if len(execData) == 0 {
+		ctx.PropertyErrorf("execution_data", "at least one execution data file is required")
+	}
+
+	var classesJars android.Paths
+	var notInstrumented []string
+	ctx.VisitDirectDepsWithTag(coverageReportInstrumentedTag, func(m android.Module) {
+		info, ok := android.OtherModuleProvider(ctx, m, JavaInfoProvider)
+		if !ok {
+			ctx.PropertyErrorf("instrumented_modules", "%s is not a java module", ctx.OtherModuleName(m))
+			return
+		}
+		if info.JacocoReportClassesFile == nil {
+			notInstrumented = append(notInstrumented, ctx.OtherModuleName(m))
+			return
+		}
+		classesJars = append(classesJars, info.JacocoReportClassesFile)
+	})
+
+	var sourceFiles []string
+	var sources android.Paths
+	for _, dir := range r.properties.Source_dirs {
+		sourceFiles = append(sourceFiles, "--sourcefiles "+android.PathForModuleSrc(ctx, dir).String())
+		sources = append(sources, android.PathsForModuleSrc(ctx, []string{dir + "/**/*.java", dir + "/**/*.kt"})...)
+	}
+
+	stripSpec := r.stripSpec(ctx)
+	if ctx.Failed() {
+		return
+	}
+
+	execFile := android.PathForModuleOut(ctx, "coverage.ec")
+	htmlReport := android.PathForModuleOut(ctx, "report-html.zip")
+	xmlReport := android.PathForModuleOut(ctx, "report.xml")
+	lcovReport := android.PathForModuleOut(ctx, "report.lcov")
+
+	if len(notInstrumented) > 0 {
+		// Only fail when the report is built, so that the tree still builds without coverage.
+		ctx.Build(pctx, android.BuildParams{
+			Rule:            android.ErrorRule,
+			Description:     "coverage report",
+			Output:          htmlReport,
+			ImplicitOutputs: android.WritablePaths{execFile, xmlReport, lcovReport},
+			Args: map[string]string{
+				"error": fmt.Sprintf("%s: modules %s are not instrumented, build with EMMA_INSTRUMENT=true",
+					ctx.ModuleName(), strings.Join(notInstrumented, ", ")),
+			},
+		})
+	} else {
+		ctx.Build(pctx, android.BuildParams{
+			Rule:            jacocoReport,
+			Description:     "coverage report",
+			Output:          htmlReport,
+			ImplicitOutputs: android.WritablePaths{execFile, xmlReport, lcovReport},
+			Inputs:          execData,
+			Implicits:       append(android.CopyOfPaths(classesJars), sources...),
+			Args: map[string]string{
+				"tmpDir":      android.PathForModuleOut(ctx, "coverage-tmp").String(),
+				"classesJars": strings.Join(classesJars.Strings(), " "),
+				"stripSpec":   stripSpec,
+				"execFile":    execFile.String(),
+				"reportName":  proptools.NinjaAndShellEscape(ctx.ModuleName()),
+				"sourceFiles": strings.Join(sourceFiles, " "),
+				"xml":         xmlReport.String(),
+				"lcov":        lcovReport.String(),
+			},
+		})
+	}
+
+	r.execFile = execFile
+	r.htmlReport = htmlReport
+	r.xmlReport = xmlReport
+	r.lcovReport = lcovReport
+
+	ctx.Phony(ctx.ModuleName(), htmlReport, xmlReport, lcovReport)
+}
+
+func (r *CoverageReport) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{r.htmlReport, r.xmlReport, r.lcovReport}, nil
+	case ".html":
+		return android.Paths{r.htmlReport}, nil
+	case ".xml":
+		return android.Paths{r.xmlReport}, nil
+	case ".lcov":
+		return android.Paths{r.lcovReport}, nil
+	case ".ec":
+		return android.Paths{r.execFile}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+var _ android.OutputFileProducer = (*CoverageReport)(nil)
@@ -0,0 +1,156 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package java
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+var prepareForCoverageReportTest = android.GroupFixturePreparers(
+	PrepareForTestWithJavaDefaultModules,
+	android.FixtureRegisterWithContext(RegisterCoverageReportBuildComponents),
+	android.FixtureMergeMockFs(android.MockFS{
+		"coverage/a.ec":                 nil,
+		"coverage/b.ec":                 nil,
+		"coverage/src/com/foo/Foo.java": nil,
+	}),
+)
+
+const coverageReportBp = `
+	android_app {
+		name: "foo",
+		srcs: ["a.java"],
+		sdk_version: "current",
+	}
+
+	android_app {
+		name: "bar",
+		srcs: ["b.java"],
+		sdk_version: "current",
+		jacoco: {
+			include_filter: ["com.bar.**"],
+		},
+	}
+
+	java_coverage_report {
+		name: "foo_coverage",
+		instrumented_modules: ["foo", "bar"],
+		execution_data: ["coverage/*.ec"],
+		source_dirs: ["coverage/src"],
+		jacoco: {
+			exclude_filter: ["com.foo.Generated*"],
+		},
+	}
+`
+
+func TestCoverageReport(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCoverageReportTest,
+		PrepareForTestWithJacocoInstrumentation,
+	).RunTestWithBp(t, coverageReportBp)
+
+	report := result.ModuleForTests("foo_coverage", "android_common").Output("report-html.zip")
+	if report.Rule != jacocoReport {
+		t.Errorf("expected the jacocoReport rule, got %q", report.Rule)
+	}
+	android.AssertPathsRelativeToTopEquals(t, "inputs",
+		[]string{"coverage/a.ec", "coverage/b.ec"}, report.Inputs)
+	android.AssertStringPathsRelativeToTopEquals(t, "classes jars", result.Config, []string{
+		"out/soong/.intermediates/foo/android_common/jacoco-report-classes/foo.jar",
+		"out/soong/.intermediates/bar/android_common/jacoco-report-classes/bar.jar",
+	}, strings.Fields(report.Args["classesJars"]))
+	android.AssertStringEquals(t, "strip spec", "-x 'com/foo/Generated*.class' '**/*.class'", report.Args["stripSpec"])
+	android.AssertStringEquals(t, "source files", "--sourcefiles coverage/src", report.Args["sourceFiles"])
+	android.AssertPathsRelativeToTopEquals(t, "implicit outputs", []string{
+		"out/soong/.intermediates/foo_coverage/android_common/coverage.ec",
+		"out/soong/.intermediates/foo_coverage/android_common/report.xml",
+		"out/soong/.intermediates/foo_coverage/android_common/report.lcov",
+	}, report.ImplicitOutputs.Paths())
+	android.AssertPathsRelativeToTopEquals(t, "implicits include sources", []string{
+		"out/soong/.intermediates/foo/android_common/jacoco-report-classes/foo.jar",
+		"out/soong/.intermediates/bar/android_common/jacoco-report-classes/bar.jar",
+		"coverage/src/com/foo/Foo.java",
+	}, report.Implicits)
+}
+
+func TestCoverageReportNotInstrumented(t *testing.T) {
+	result := prepareForCoverageReportTest.RunTestWithBp(t, coverageReportBp)
+
+	report := result.ModuleForTests("foo_coverage", "android_common").Output("report-html.zip")
+	if report.Rule != android.ErrorRule {
+		t.Errorf("expected an error rule when the modules are not instrumented, got %q", report.Rule)
+	}
+	android.AssertStringDoesContain(t, "error", report.Args["error"], "modules foo, bar are not instrumented")
+}
+
+func TestCoverageReportErrors(t *testing.T) {
+	prepareForCoverageReportTest.
+		ExtendWithErrorHandler(android.FixtureExpectsAllErrorsToMatchAPattern([]string{
+			`execution_data: at least one execution data file is required`,
+			`jacoco.exclude_filter: '\*' is only supported as the last character in a filter`,
+		})).
+		RunTestWithBp(t, `
+			java_coverage_report {
+				name: "foo_coverage",
+				jacoco: {
+					exclude_filter: ["com.*.Foo"],
+				},
+			}
+		`)
+}
+
+func TestCoverageReportHostModules(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForCoverageReportTest,
+		PrepareForTestWithJacocoInstrumentation,
+	).RunTestWithBp(t, `
+		android_app {
+			name: "foo",
+			srcs: ["a.java"],
+			sdk_version: "current",
+		}
+
+		java_library_host {
+			name: "foo-host",
+			srcs: ["b.java"],
+		}
+
+		java_coverage_report {
+			name: "foo_coverage",
+			instrumented_modules: ["foo"],
+			host_instrumented_modules: ["foo-host"],
+			execution_data: ["coverage/*.ec"],
+		}
+	`)
+
+	// The host module is a dependency of the device report, and it is not instrumented.
+	module := result.ModuleForTests("foo_coverage", "android_common").Module()
+	hostModule := result.ModuleForTests("foo-host", "linux_glibc_common").Module()
+	dependsOnHostModule := false
+	result.VisitDirectDeps(module, func(dep blueprint.Module) {
+		if dep == hostModule {
+			dependsOnHostModule = true
+		}
+	})
+	android.AssertBoolEquals(t, "depends on the host variant", true, dependsOnHostModule)
+
+	report := result.ModuleForTests("foo_coverage", "android_common").Output("report-html.zip")
+	android.AssertStringDoesContain(t, "error", report.Args["error"], "modules foo-host are not instrumented")
+}
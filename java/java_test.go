@@ -250,6 +250,78 @@ func TestSimple(t *testing.T) {
 	}
 }
 
+func TestZipCheckValidation(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+		foo := ctx.ModuleForTests("foo", "android_common")
+		combined := foo.Output("combined/foo.jar")
+		if combined.Validation != nil {
+			t.Errorf("expected no validation, got %q", combined.Validation)
+		}
+		if zipCheck := foo.MaybeOutput("zipcheck/combined/foo.jar.stamp"); zipCheck.Rule != nil {
+			t.Errorf("expected no zipcheck rule")
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		ctx := android.GroupFixturePreparers(
+			PrepareForTestWithJavaDefaultModules,
+			android.FixtureMergeEnv(map[string]string{
+				"SOONG_CHECK_ZIP_REPRODUCIBILITY": "true",
+			}),
+		).RunTestWithBp(t, bp)
+		foo := ctx.ModuleForTests("foo", "android_common")
+		combined := foo.Output("combined/foo.jar")
+		zipCheck := foo.Output("zipcheck/combined/foo.jar.stamp")
+
+		android.AssertPathRelativeToTopEquals(t, "validation",
+			"out/soong/.intermediates/foo/android_common/zipcheck/combined/foo.jar.stamp", combined.Validation)
+		android.AssertPathRelativeToTopEquals(t, "zipcheck input",
+			"out/soong/.intermediates/foo/android_common/combined/foo.jar", zipCheck.Input)
+	})
+}
+
+func TestIncrementalMergeZips(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			static_libs: ["bar"],
+		}
+
+		java_library {
+			name: "bar",
+			srcs: ["b.java"],
+		}
+	`
+
+	ctx := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	combined := ctx.ModuleForTests("foo", "android_common").Output("combined/foo.jar")
+	android.AssertStringDoesNotContain(t, "jarArgs", combined.Args["jarArgs"], "-incremental")
+
+	ctx = android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"SOONG_INCREMENTAL_MERGE_ZIPS": "true",
+		}),
+	).RunTestWithBp(t, bp)
+	combined = ctx.ModuleForTests("foo", "android_common").Output("combined/foo.jar")
+	android.AssertStringDoesContain(t, "jarArgs", combined.Args["jarArgs"], "-incremental")
+}
+
 func TestExportedPlugins(t *testing.T) {
 	type Result struct {
 		library        string
@@ -104,6 +104,7 @@ type lintOutputs struct {
 	html              android.Path
 	text              android.Path
 	xml               android.Path
+	sarif             android.Path
 	referenceBaseline android.Path
 
 	depSets LintDepSets
@@ -122,25 +123,27 @@ type LintDepSetsIntf interface {
 }
 
 type LintDepSets struct {
-	HTML, Text, XML *android.DepSet[android.Path]
+	HTML, Text, XML, SARIF *android.DepSet[android.Path]
 }
 
 type LintDepSetsBuilder struct {
-	HTML, Text, XML *android.DepSetBuilder[android.Path]
+	HTML, Text, XML, SARIF *android.DepSetBuilder[android.Path]
 }
 
 func NewLintDepSetBuilder() LintDepSetsBuilder {
 	return LintDepSetsBuilder{
-		HTML: android.NewDepSetBuilder[android.Path](android.POSTORDER),
-		Text: android.NewDepSetBuilder[android.Path](android.POSTORDER),
-		XML:  android.NewDepSetBuilder[android.Path](android.POSTORDER),
+		HTML:  android.NewDepSetBuilder[android.Path](android.POSTORDER),
+		Text:  android.NewDepSetBuilder[android.Path](android.POSTORDER),
+		XML:   android.NewDepSetBuilder[android.Path](android.POSTORDER),
+		SARIF: android.NewDepSetBuilder[android.Path](android.POSTORDER),
 	}
 }
 
-func (l LintDepSetsBuilder) Direct(html, text, xml android.Path) LintDepSetsBuilder {
+func (l LintDepSetsBuilder) Direct(html, text, xml, sarif android.Path) LintDepSetsBuilder {
 	l.HTML.Direct(html)
 	l.Text.Direct(text)
 	l.XML.Direct(xml)
+	l.SARIF.Direct(sarif)
 	return l
 }
 
@@ -154,14 +157,18 @@ func (l LintDepSetsBuilder) Transitive(depSets LintDepSets) LintDepSetsBuilder {
 	if depSets.XML != nil {
 		l.XML.Transitive(depSets.XML)
 	}
+	if depSets.SARIF != nil {
+		l.SARIF.Transitive(depSets.SARIF)
+	}
 	return l
 }
 
 func (l LintDepSetsBuilder) Build() LintDepSets {
 	return LintDepSets{
-		HTML: l.HTML.Build(),
-		Text: l.Text.Build(),
-		XML:  l.XML.Build(),
+		HTML:  l.HTML.Build(),
+		Text:  l.Text.Build(),
+		XML:   l.XML.Build(),
+		SARIF: l.SARIF.Build(),
 	}
 }
 
@@ -442,9 +449,10 @@ func (l *linter) lint(ctx android.ModuleContext) {
 	html := android.PathForModuleOut(ctx, "lint", "lint-report.html")
 	text := android.PathForModuleOut(ctx, "lint", "lint-report.txt")
 	xml := android.PathForModuleOut(ctx, "lint", "lint-report.xml")
+	sarif := android.PathForModuleOut(ctx, "lint", "lint-report.sarif")
 	referenceBaseline := android.PathForModuleOut(ctx, "lint", "lint-baseline.xml")
 
-	depSetsBuilder := NewLintDepSetBuilder().Direct(html, text, xml)
+	depSetsBuilder := NewLintDepSetBuilder().Direct(html, text, xml, sarif)
 
 	ctx.VisitDirectDepsWithTag(staticLibTag, func(dep android.Module) {
 		if depLint, ok := dep.(LintDepSetsIntf); ok {
@@ -454,7 +462,7 @@ func (l *linter) lint(ctx android.ModuleContext) {
 
 	rule.Command().Text("rm -rf").Flag(lintPaths.cacheDir.String()).Flag(lintPaths.homeDir.String())
 	rule.Command().Text("mkdir -p").Flag(lintPaths.cacheDir.String()).Flag(lintPaths.homeDir.String())
-	rule.Command().Text("rm -f").Output(html).Output(text).Output(xml)
+	rule.Command().Text("rm -f").Output(html).Output(text).Output(xml).Output(sarif)
 
 	files, ok := allLintDatabasefiles[l.compileSdkKind]
 	if !ok {
@@ -484,6 +492,7 @@ func (l *linter) lint(ctx android.ModuleContext) {
 		FlagWithOutput("--html ", html).
 		FlagWithOutput("--text ", text).
 		FlagWithOutput("--xml ", xml).
+		FlagWithOutput("--sarif ", sarif).
 		FlagWithArg("--compile-sdk-version ", l.compileSdkVersion.String()).
 		FlagWithArg("--java-language-level ", l.javaLanguageLevel).
 		FlagWithArg("--kotlin-language-level ", l.kotlinLanguageLevel).
@@ -496,7 +505,11 @@ func (l *linter) lint(ctx android.ModuleContext) {
 	rule.Temporary(lintPaths.projectXML)
 	rule.Temporary(lintPaths.configXML)
 
-	suppressExitCode := BoolDefault(l.properties.Lint.Suppress_exit_code, false)
+	// When comparing against a SARIF baseline, lint-check fails on the new errors in the merged
+	// report instead of on any error in each module, except in modules with strict updatability
+	// linting, which must not have any errors at all.
+	suppressExitCode := BoolDefault(l.properties.Lint.Suppress_exit_code, false) ||
+		(lintSarifBaseline(ctx) != "" && !l.GetStrictUpdatabilityLinting())
 	if exitCode := ctx.Config().Getenv("ANDROID_LINT_SUPPRESS_EXIT_CODE"); exitCode == "" && !suppressExitCode {
 		cmd.Flag("--exitcode")
 	}
@@ -534,6 +547,7 @@ func (l *linter) lint(ctx android.ModuleContext) {
 		html:              html,
 		text:              text,
 		xml:               xml,
+		sarif:             sarif,
 		referenceBaseline: referenceBaseline,
 
 		depSets: depSetsBuilder.Build(),
@@ -552,8 +566,9 @@ func BuildModuleLintReportZips(ctx android.ModuleContext, depSets LintDepSets) a
 	htmlList := android.SortedUniquePaths(depSets.HTML.ToList())
 	textList := android.SortedUniquePaths(depSets.Text.ToList())
 	xmlList := android.SortedUniquePaths(depSets.XML.ToList())
+	sarifList := android.SortedUniquePaths(depSets.SARIF.ToList())
 
-	if len(htmlList) == 0 && len(textList) == 0 && len(xmlList) == 0 {
+	if len(htmlList) == 0 && len(textList) == 0 && len(xmlList) == 0 && len(sarifList) == 0 {
 		return nil
 	}
 
@@ -566,14 +581,29 @@ func BuildModuleLintReportZips(ctx android.ModuleContext, depSets LintDepSets) a
 	xmlZip := android.PathForModuleOut(ctx, "lint-report-xml.zip")
 	lintZip(ctx, xmlList, xmlZip)
 
-	return android.Paths{htmlZip, textZip, xmlZip}
+	sarifZip := android.PathForModuleOut(ctx, "lint-report-sarif.zip")
+	lintZip(ctx, sarifList, sarifZip)
+
+	return android.Paths{htmlZip, textZip, xmlZip, sarifZip}
+}
+
+// lintSarifBaseline returns the path, relative to the top of the source tree, of the SARIF report
+// that lint-check compares the merged SARIF report against, or "" if it is not set.
+func lintSarifBaseline(ctx android.PathContext) string {
+	return ctx.Config().Getenv("ANDROID_LINT_SARIF_BASELINE")
 }
 
 type lintSingleton struct {
 	htmlZip              android.WritablePath
 	textZip              android.WritablePath
 	xmlZip               android.WritablePath
+	sarifZip             android.WritablePath
 	referenceBaselineZip android.WritablePath
+
+	// The SARIF reports of all the modules merged into one, and the issues in it that are not
+	// in the baseline if ANDROID_LINT_SARIF_BASELINE is set.
+	sarif          android.WritablePath
+	sarifNewIssues android.WritablePath
 }
 
 func (l *lintSingleton) GenerateBuildActions(ctx android.SingletonContext) {
@@ -686,15 +716,44 @@ func (l *lintSingleton) generateLintReportZips(ctx android.SingletonContext) {
 	l.xmlZip = android.PathForOutput(ctx, "lint-report-xml.zip")
 	zip(l.xmlZip, func(l *lintOutputs) android.Path { return l.xml })
 
+	l.sarifZip = android.PathForOutput(ctx, "lint-report-sarif.zip")
+	zip(l.sarifZip, func(l *lintOutputs) android.Path { return l.sarif })
+
 	l.referenceBaselineZip = android.PathForOutput(ctx, "lint-report-reference-baselines.zip")
 	zip(l.referenceBaselineZip, func(l *lintOutputs) android.Path { return l.referenceBaseline })
 
-	ctx.Phony("lint-check", l.htmlZip, l.textZip, l.xmlZip, l.referenceBaselineZip)
+	var sarifs android.Paths
+	for _, output := range outputs {
+		if output.sarif != nil {
+			sarifs = append(sarifs, output.sarif)
+		}
+	}
+	l.sarif = android.PathForOutput(ctx, "lint-report.sarif")
+	lintSarifMerge(ctx, sarifs, l.sarif)
+
+	reports := android.Paths{l.htmlZip, l.textZip, l.xmlZip, l.sarifZip, l.referenceBaselineZip, l.sarif}
+
+	if baseline := lintSarifBaseline(ctx); baseline != "" {
+		l.sarifNewIssues = android.PathForOutput(ctx, "lint-report-new-issues.sarif")
+		rule := android.NewRuleBuilder(pctx, ctx)
+		rule.Command().BuiltTool("lint_sarif").
+			Text("diff").
+			FlagWithInput("-baseline ", android.PathForSource(ctx, baseline)).
+			FlagWithOutput("-o ", l.sarifNewIssues).
+			Input(l.sarif)
+		rule.Build("lint_sarif_diff", "lint new issues")
+		reports = append(reports, l.sarifNewIssues)
+	}
+
+	ctx.Phony("lint-check", reports...)
 }
 
 func (l *lintSingleton) MakeVars(ctx android.MakeVarsContext) {
 	if !ctx.Config().UnbundledBuild() {
-		ctx.DistForGoal("lint-check", l.htmlZip, l.textZip, l.xmlZip, l.referenceBaselineZip)
+		ctx.DistForGoal("lint-check", l.htmlZip, l.textZip, l.xmlZip, l.sarifZip, l.referenceBaselineZip, l.sarif)
+		if l.sarifNewIssues != nil {
+			ctx.DistForGoal("lint-check", l.sarifNewIssues)
+		}
 	}
 }
 
@@ -730,6 +789,20 @@ func lintZip(ctx android.BuilderContext, paths android.Paths, outputPath android
 	rule.Build(outputPath.Base(), outputPath.Base())
 }
 
+// lintSarifMerge merges the SARIF reports of modules into a single SARIF report.
+func lintSarifMerge(ctx android.BuilderContext, paths android.Paths, outputPath android.WritablePath) {
+	paths = android.SortedUniquePaths(android.CopyOfPaths(paths))
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+
+	rule.Command().BuiltTool("lint_sarif").
+		Text("merge").
+		FlagWithOutput("-o ", outputPath).
+		FlagWithRspFileInputList("", outputPath.ReplaceExtension(ctx, "rsp"), paths)
+
+	rule.Build(outputPath.Base(), outputPath.Base())
+}
+
 // Enforce the strict updatability linting to all applicable transitive dependencies.
 func enforceStrictUpdatabilityLintingMutator(ctx android.TopDownMutatorContext) {
 	m := ctx.Module()
@@ -100,6 +100,66 @@ func TestJavaLintUsesCorrectBpConfig(t *testing.T) {
 	}
 }
 
+func TestJavaLintSarif(t *testing.T) {
+	bp := `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			min_sdk_version: "29",
+			sdk_version: "system_current",
+		}
+	`
+
+	result := PrepareForTestWithJavaDefaultModules.RunTestWithBp(t, bp)
+	foo := result.ModuleForTests("foo", "android_common")
+	sboxProto := android.RuleBuilderSboxProtoForTests(t, result.TestContext, foo.Output("lint.sbox.textproto"))
+	command := *sboxProto.Commands[0].Command
+	if !strings.Contains(command, "--sarif __SBOX_SANDBOX_DIR__/out/lint-report.sarif") {
+		t.Errorf("expected --sarif flag, got %q", command)
+	}
+	if !strings.Contains(command, "--exitcode") {
+		t.Errorf("expected --exitcode flag, got %q", command)
+	}
+
+	// With a SARIF baseline lint-check fails on new issues, not on every issue in every module.
+	result = android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"ANDROID_LINT_SARIF_BASELINE": "lint-baseline.sarif",
+		}),
+	).RunTestWithBp(t, bp)
+	foo = result.ModuleForTests("foo", "android_common")
+	sboxProto = android.RuleBuilderSboxProtoForTests(t, result.TestContext, foo.Output("lint.sbox.textproto"))
+	command = *sboxProto.Commands[0].Command
+	if strings.Contains(command, "--exitcode") {
+		t.Errorf("unexpected --exitcode flag with a SARIF baseline, got %q", command)
+	}
+
+	// Modules with strict updatability linting still fail on their own errors.
+	result = android.GroupFixturePreparers(
+		PrepareForTestWithJavaDefaultModules,
+		android.FixtureMergeEnv(map[string]string{
+			"ANDROID_LINT_SARIF_BASELINE": "lint-baseline.sarif",
+		}),
+	).RunTestWithBp(t, `
+		java_library {
+			name: "foo",
+			srcs: ["a.java"],
+			min_sdk_version: "29",
+			sdk_version: "system_current",
+			lint: {
+				strict_updatability_linting: true,
+			},
+		}
+	`)
+	foo = result.ModuleForTests("foo", "android_common")
+	sboxProto = android.RuleBuilderSboxProtoForTests(t, result.TestContext, foo.Output("lint.sbox.textproto"))
+	command = *sboxProto.Commands[0].Command
+	if !strings.Contains(command, "--exitcode") {
+		t.Errorf("expected --exitcode flag with strict updatability linting, got %q", command)
+	}
+}
+
 func TestJavaLintBypassUpdatableChecks(t *testing.T) {
 	testCases := []struct {
 		name  string
@@ -108,3 +108,22 @@ func TestCoverageDeps(t *testing.T) {
 		t.Fatalf("missing expected coverage 'libprofile-clang-extras' dependency in linkFlags: %#v", fizz.Args["linkFlags"])
 	}
 }
+
+func TestNativeCoverageReport(t *testing.T) {
+	ctx := testRustCov(t, `
+		rust_binary {
+			name: "fizz",
+			srcs: ["foo.rs"],
+		}
+
+		native_coverage_report {
+			name: "fizz_coverage",
+			binaries: ["fizz"],
+			profiles: ["fizz.profraw"],
+		}`)
+
+	fizz := ctx.ModuleForTests("fizz", "android_arm64_armv8-a_cov").Module().(*Module)
+	report := ctx.ModuleForTests("fizz_coverage", "android_arm64_armv8-a_cov").Output("report.lcov")
+	android.AssertStringDoesContain(t, "llvm-cov uses the unstripped rust binary",
+		report.RuleParams.Command, fizz.UnstrippedOutputFile().String())
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"android/soong/symbol_inject"
@@ -29,6 +30,8 @@ var (
 	from   = flag.String("from", "", "optional existing value of the symbol for verification")
 	value  = flag.String("v", "", "value to inject into symbol")
 
+	manifest = flag.String("manifest", "", "JSON manifest of symbols to inject, instead of -s, -v and -from")
+
 	dump = flag.Bool("dump", false, "dump the symbol table for copying into a test")
 )
 
@@ -50,12 +53,18 @@ func main() {
 			usageError("-o is required")
 		}
 
-		if *symbol == "" {
-			usageError("-s is required")
-		}
-
-		if *value == "" {
-			usageError("-v is required")
+		if *manifest != "" {
+			if *symbol != "" || *value != "" || *from != "" {
+				usageError("-s, -v and -from cannot be used with -manifest")
+			}
+		} else {
+			if *symbol == "" {
+				usageError("-s is required")
+			}
+
+			if *value == "" {
+				usageError("-v is required")
+			}
 		}
 	}
 
@@ -88,7 +97,11 @@ func main() {
 		os.Exit(4)
 	}
 
-	err = symbol_inject.InjectStringSymbol(file, w, *symbol, *value, *from)
+	if *manifest != "" {
+		err = injectManifest(file, w, *manifest)
+	} else {
+		err = symbol_inject.InjectStringSymbol(file, w, *symbol, *value, *from)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Remove(*output)
@@ -104,3 +117,18 @@ func main() {
 		}
 	}
 }
+
+func injectManifest(file *symbol_inject.File, w io.Writer, manifest string) error {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	injections, err := symbol_inject.ReadManifest(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", manifest, err)
+	}
+
+	return symbol_inject.InjectSymbols(file, w, injections)
+}
@@ -72,11 +72,16 @@ func extractElfSymbols(elfFile mockableElfFile) (*File, error) {
 	file := &File{}
 
 	for _, section := range elfFile.Sections() {
+		fileSize := section.FileSize
+		if section.Type == elf.SHT_NOBITS {
+			fileSize = 0
+		}
 		file.Sections = append(file.Sections, &Section{
-			Name:   section.Name,
-			Addr:   section.Addr,
-			Offset: section.Offset,
-			Size:   section.Size,
+			Name:     section.Name,
+			Addr:     section.Addr,
+			Offset:   section.Offset,
+			Size:     section.Size,
+			FileSize: fileSize,
 		})
 	}
 
@@ -47,11 +47,16 @@ func extractMachoSymbols(machoFile *macho.File) (*File, error) {
 	file := &File{IsMachoFile: true}
 
 	for _, section := range machoFile.Sections {
+		fileSize := section.Size
+		if isMachoZerofillSection(section) {
+			fileSize = 0
+		}
 		file.Sections = append(file.Sections, &Section{
-			Name:   section.Name,
-			Addr:   section.Addr,
-			Offset: uint64(section.Offset),
-			Size:   section.Size,
+			Name:     section.Name,
+			Addr:     section.Addr,
+			Offset:   uint64(section.Offset),
+			Size:     section.Size,
+			FileSize: fileSize,
 		})
 	}
 
@@ -73,6 +78,22 @@ func extractMachoSymbols(machoFile *macho.File) (*File, error) {
 	return file, nil
 }
 
+// Section types from mach-o/loader.h whose contents are not stored in the file.
+const (
+	S_ZEROFILL              = 0x1
+	S_GB_ZEROFILL           = 0xc
+	S_THREAD_LOCAL_ZEROFILL = 0x12
+)
+
+// isMachoZerofillSection returns true for the sections that are filled with zeros at runtime.
+func isMachoZerofillSection(section *macho.Section) bool {
+	switch section.Flags & 0xff {
+	case S_ZEROFILL, S_GB_ZEROFILL, S_THREAD_LOCAL_ZEROFILL:
+		return true
+	}
+	return false
+}
+
 func dumpMachoSymbols(r io.ReaderAt) error {
 	machoFile, err := macho.NewFile(r)
 	if err != nil {
@@ -0,0 +1,305 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol_inject
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// SymbolType is the type of a value injected into a symbol or a field of a struct.
+type SymbolType string
+
+const (
+	// A NUL terminated string, padded with NULs to the size of the symbol.
+	StringType SymbolType = "string"
+
+	// Little endian unsigned integers, in decimal or in hexadecimal with a 0x prefix.
+	Uint8Type  SymbolType = "uint8"
+	Uint16Type SymbolType = "uint16"
+	Uint32Type SymbolType = "uint32"
+	Uint64Type SymbolType = "uint64"
+
+	// A fixed size byte array, hex encoded, that must be exactly the size of the symbol.
+	BytesType SymbolType = "bytes"
+
+	// A struct, whose fields are injected at their offsets into the symbol.  The bytes of the
+	// symbol that are not in a field are left unchanged.
+	StructType SymbolType = "struct"
+)
+
+var uintTypeSizes = map[SymbolType]uint64{
+	Uint8Type:  1,
+	Uint16Type: 2,
+	Uint32Type: 4,
+	Uint64Type: 8,
+}
+
+// Injection is an entry of a manifest, describing a value to inject into a symbol.
+type Injection struct {
+	Symbol string
+	Type   SymbolType
+
+	// Value is the value to inject, and From is the optional existing value of the symbol for
+	// verification, for all types but structs.
+	Value string
+	From  string `json:",omitempty"`
+
+	// Fields are the fields of a struct.
+	Fields []StructField `json:",omitempty"`
+}
+
+// StructField is a field of a struct injected into a symbol.
+type StructField struct {
+	// Name is only used in error messages.
+	Name   string `json:",omitempty"`
+	Offset uint64
+	Type   SymbolType
+	// Size is the size of string and bytes fields, the size of integer fields is implied by
+	// their type.
+	Size  uint64 `json:",omitempty"`
+	Value string
+	From  string `json:",omitempty"`
+}
+
+func (f StructField) String() string {
+	if f.Name != "" {
+		return fmt.Sprintf("field %q", f.Name)
+	}
+	return fmt.Sprintf("field at offset %d", f.Offset)
+}
+
+// ReadManifest reads a JSON list of injections.
+func ReadManifest(r io.Reader) ([]Injection, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	var injections []Injection
+	if err := decoder.Decode(&injections); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return injections, nil
+}
+
+// encodeValue returns a value of the given type encoded in size bytes.
+func encodeValue(typ SymbolType, value string, size uint64) ([]byte, error) {
+	buf := make([]byte, size)
+	switch typ {
+	case StringType:
+		if uint64(len(value))+1 > size {
+			return nil, fmt.Errorf("value length %d overflows symbol size %d", len(value), size)
+		}
+		copy(buf, value)
+	case Uint8Type, Uint16Type, Uint32Type, Uint64Type:
+		if size != uintTypeSizes[typ] {
+			return nil, fmt.Errorf("symbol is not a %s, it is %d bytes long", typ, size)
+		}
+		v, err := strconv.ParseUint(value, 0, int(size*8))
+		if err != nil {
+			return nil, fmt.Errorf("bad %s value: %w", typ, err)
+		}
+		for i := range buf {
+			buf[i] = byte(v >> (8 * i))
+		}
+	case BytesType:
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("bad bytes value: %w", err)
+		}
+		if uint64(len(b)) != size {
+			return nil, fmt.Errorf("value length %d does not match symbol size %d", len(b), size)
+		}
+		copy(buf, b)
+	default:
+		return nil, fmt.Errorf("unsupported type %q", typ)
+	}
+	return buf, nil
+}
+
+// formatValue returns the contents of a symbol of the given type in a human readable form.
+func formatValue(typ SymbolType, buf []byte) string {
+	switch typ {
+	case StringType:
+		if i := bytes.IndexByte(buf, 0); i >= 0 {
+			buf = buf[:i]
+		}
+		return strconv.Quote(string(buf))
+	case Uint8Type, Uint16Type, Uint32Type, Uint64Type:
+		var v uint64
+		for i := len(buf) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(buf[i])
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// verifyValue returns an error if the existing contents of a symbol don't match the expected value.
+func verifyValue(typ SymbolType, existing []byte, from string) error {
+	expected, err := encodeValue(typ, from, uint64(len(existing)))
+	if err != nil {
+		return fmt.Errorf("bad expected value: %w", err)
+	}
+	if !bytes.Equal(existing, expected) {
+		return fmt.Errorf("existing symbol contents %s did not match expected value %s",
+			formatValue(typ, existing), formatValue(typ, expected))
+	}
+	return nil
+}
+
+// patch is a range of a file to overwrite.
+type patch struct {
+	symbol string
+	offset uint64
+	buf    []byte
+}
+
+// InjectSymbols injects the values of all the injections into their symbols, copying the file to
+// w once.  All the symbols are verified to be inside their sections and not to overlap, and their
+// existing contents are verified, before anything is written.
+func InjectSymbols(file *File, w io.Writer, injections []Injection) error {
+	var patches []patch
+	for _, injection := range injections {
+		p, err := injectionPatch(file, injection)
+		if err != nil {
+			return fmt.Errorf("symbol %q: %w", injection.Symbol, err)
+		}
+		patches = append(patches, p)
+	}
+
+	sort.Slice(patches, func(i, j int) bool { return patches[i].offset < patches[j].offset })
+	for i := 1; i < len(patches); i++ {
+		prev := patches[i-1]
+		if patches[i].offset < prev.offset+uint64(len(prev.buf)) {
+			return fmt.Errorf("symbols %q and %q overlap", prev.symbol, patches[i].symbol)
+		}
+	}
+
+	return copyAndInjectPatches(file.r, w, patches)
+}
+
+// injectionPatch returns the bytes to write over a symbol for an injection.
+func injectionPatch(file *File, injection Injection) (patch, error) {
+	offset, size, section, err := findSymbolAndSection(file, injection.Symbol)
+	if err != nil {
+		return patch{}, err
+	}
+
+	addr := offset - section.Offset
+	if addr+size > section.Size {
+		return patch{}, fmt.Errorf("symbol at %#x with size %d extends past the end of section %q of size %d",
+			addr, size, section.Name, section.Size)
+	}
+	if addr+size > section.FileSize {
+		return patch{}, fmt.Errorf("symbol at %#x with size %d extends past the %d bytes of section %q in the file",
+			addr, size, section.FileSize, section.Name)
+	}
+
+	existing := make([]byte, size)
+	if _, err := file.r.ReadAt(existing, int64(offset)); err != nil {
+		return patch{}, fmt.Errorf("failed to read the existing symbol contents: %w", err)
+	}
+
+	if injection.Type != StructType {
+		if len(injection.Fields) > 0 {
+			return patch{}, fmt.Errorf("only structs have fields")
+		}
+		if injection.From != "" {
+			if err := verifyValue(injection.Type, existing, injection.From); err != nil {
+				return patch{}, err
+			}
+		}
+		buf, err := encodeValue(injection.Type, injection.Value, size)
+		if err != nil {
+			return patch{}, err
+		}
+		return patch{injection.Symbol, offset, buf}, nil
+	}
+
+	if injection.Value != "" || injection.From != "" {
+		return patch{}, fmt.Errorf("the values of structs are set by their fields")
+	}
+
+	buf := append([]byte(nil), existing...)
+	injected := make([]bool, size)
+	for _, field := range injection.Fields {
+		fieldSize := uintTypeSizes[field.Type]
+		if fieldSize == 0 {
+			fieldSize = field.Size
+		}
+		if field.Type == StructType {
+			return patch{}, fmt.Errorf("%s: nested structs are not supported", field)
+		} else if fieldSize == 0 {
+			return patch{}, fmt.Errorf("%s: size is required for %s fields", field, field.Type)
+		} else if field.Offset+fieldSize > size {
+			return patch{}, fmt.Errorf("%s with size %d overflows symbol size %d", field, fieldSize, size)
+		}
+
+		for i := field.Offset; i < field.Offset+fieldSize; i++ {
+			if injected[i] {
+				return patch{}, fmt.Errorf("%s overlaps another field", field)
+			}
+			injected[i] = true
+		}
+
+		fieldBuf := buf[field.Offset : field.Offset+fieldSize]
+		if field.From != "" {
+			if err := verifyValue(field.Type, fieldBuf, field.From); err != nil {
+				return patch{}, fmt.Errorf("%s: %w", field, err)
+			}
+		}
+		value, err := encodeValue(field.Type, field.Value, fieldSize)
+		if err != nil {
+			return patch{}, fmt.Errorf("%s: %w", field, err)
+		}
+		copy(fieldBuf, value)
+	}
+
+	return patch{injection.Symbol, offset, buf}, nil
+}
+
+// copyAndInjectPatches copies r to w, replacing the ranges of the patches, which must be sorted
+// by offset and must not overlap.
+func copyAndInjectPatches(r io.ReaderAt, w io.Writer, patches []patch) (err error) {
+	var pos int64
+	for _, p := range patches {
+		// Copy the bytes up to the symbol offset
+		_, err = io.Copy(w, io.NewSectionReader(r, pos, int64(p.offset)-pos))
+
+		// Write the injected value in the output file
+		if err == nil {
+			_, err = w.Write(p.buf)
+		}
+		if err != nil {
+			break
+		}
+		pos = int64(p.offset) + int64(len(p.buf))
+	}
+
+	// Write the remainder of the file
+	if err == nil {
+		_, err = io.Copy(w, io.NewSectionReader(r, pos, 1<<63-1-pos))
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,264 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbol_inject
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"strings"
+	"testing"
+)
+
+// testManifestFile returns a file with a 32 byte section at offset 4 containing the symbols
+// str (8 bytes), num (8 bytes), st (8 bytes), raw (4 bytes) and big, which claims to extend past
+// the end of the section.
+func testManifestFile() *File {
+	data := []byte("HEAD" +
+		"old\x00\x00\x00\x00\x00" +
+		"\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x02\x00\xaa\xbbxy\x00\x00" +
+		"\xde\xad\xbe\xef" +
+		"TAIL" +
+		"AFTER")
+	section := &Section{Name: ".data", Offset: 4, Size: 32, FileSize: 32}
+	return &File{
+		r:        bytes.NewReader(data),
+		Sections: []*Section{section},
+		Symbols: []*Symbol{
+			{Name: "str", Addr: 0, Section: section},
+			{Name: "num", Addr: 8, Section: section},
+			{Name: "st", Addr: 16, Section: section},
+			{Name: "raw", Addr: 24, Section: section},
+			{Name: "big", Addr: 28, Size: 8, Section: section},
+		},
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	injections, err := ReadManifest(strings.NewReader(`[
+		{"Symbol": "str", "Type": "string", "Value": "new", "From": "old"},
+		{"Symbol": "st", "Type": "struct", "Fields": [{"Name": "a", "Offset": 0, "Type": "uint16", "Value": "3"}]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(injections) != 2 || injections[0].From != "old" || injections[1].Fields[0].Type != Uint16Type {
+		t.Errorf("incorrect manifest: %#v", injections)
+	}
+
+	if _, err := ReadManifest(strings.NewReader(`[{"Symbol": "str", "Valu": "new"}]`)); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestInjectSymbols(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := InjectSymbols(testManifestFile(), out, []Injection{
+		{Symbol: "raw", Type: BytesType, Value: "01020304", From: "deadbeef"},
+		{Symbol: "str", Type: StringType, Value: "new", From: "old"},
+		{Symbol: "num", Type: Uint64Type, Value: "0x1122334455667788", From: "1"},
+		{Symbol: "st", Type: StructType, Fields: []StructField{
+			{Name: "a", Offset: 0, Type: Uint16Type, Value: "0x0304", From: "2"},
+			{Name: "b", Offset: 4, Type: StringType, Size: 4, Value: "z"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "HEAD" +
+		"new\x00\x00\x00\x00\x00" +
+		"\x88\x77\x66\x55\x44\x33\x22\x11" +
+		"\x04\x03\xaa\xbbz\x00\x00\x00" +
+		"\x01\x02\x03\x04" +
+		"TAIL" +
+		"AFTER"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestInjectSymbolsErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		injections []Injection
+		err        string
+	}{
+		{
+			name:       "missing symbol",
+			injections: []Injection{{Symbol: "missing", Type: StringType, Value: "x"}},
+			err:        "symbol not found",
+		},
+		{
+			name:       "past section end",
+			injections: []Injection{{Symbol: "big", Type: BytesType, Value: "0000000000000000"}},
+			err:        `extends past the end of section ".data"`,
+		},
+		{
+			name:       "string overflow",
+			injections: []Injection{{Symbol: "str", Type: StringType, Value: "too long"}},
+			err:        "overflows symbol size",
+		},
+		{
+			name:       "wrong integer size",
+			injections: []Injection{{Symbol: "raw", Type: Uint64Type, Value: "1"}},
+			err:        "not a uint64",
+		},
+		{
+			name:       "wrong bytes size",
+			injections: []Injection{{Symbol: "raw", Type: BytesType, Value: "0102"}},
+			err:        "does not match symbol size",
+		},
+		{
+			name:       "from mismatch",
+			injections: []Injection{{Symbol: "str", Type: StringType, Value: "new", From: "other"}},
+			err:        `existing symbol contents "old" did not match expected value "other"`,
+		},
+		{
+			name: "field overflow",
+			injections: []Injection{{Symbol: "st", Type: StructType, Fields: []StructField{
+				{Name: "a", Offset: 4, Type: Uint64Type, Value: "1"},
+			}}},
+			err: `field "a" with size 8 overflows symbol size 8`,
+		},
+		{
+			name: "overlapping fields",
+			injections: []Injection{{Symbol: "st", Type: StructType, Fields: []StructField{
+				{Offset: 0, Type: Uint32Type, Value: "1"},
+				{Offset: 2, Type: Uint16Type, Value: "1"},
+			}}},
+			err: "field at offset 2 overlaps another field",
+		},
+		{
+			name: "same symbol twice",
+			injections: []Injection{
+				{Symbol: "str", Type: StringType, Value: "a"},
+				{Symbol: "str", Type: StringType, Value: "b"},
+			},
+			err: `symbols "str" and "str" overlap`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := InjectSymbols(testManifestFile(), out, test.injections)
+			if err == nil {
+				t.Fatalf("expected error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %q", test.err, err.Error())
+			}
+			if out.Len() > 0 {
+				t.Errorf("expected nothing to be written, got %q", out.String())
+			}
+		})
+	}
+}
+
+// TestInjectSymbolsPastFileContents verifies that symbols in the parts of sections that are not
+// stored in the file are rejected instead of overwriting whatever follows the section in the file.
+func TestInjectSymbolsPastFileContents(t *testing.T) {
+	data := bytes.Repeat([]byte{0}, 0x400)
+
+	elfFile, err := extractElfSymbols(mockElfFile{
+		t: elf.ET_EXEC,
+		sections: []elf.SectionHeader{
+			{Name: "", Type: elf.SHT_NULL},
+			{Name: ".data", Type: elf.SHT_PROGBITS, Addr: 0x1000, Offset: 0x100, Size: 0x10, FileSize: 0x10},
+			{Name: ".bss", Type: elf.SHT_NOBITS, Addr: 0x1010, Offset: 0x110, Size: 0x10, FileSize: 0x10},
+		},
+		symbols: []elf.Symbol{
+			{Name: "bss", Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT), Section: 2, Value: 0x1010, Size: 8},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peFile, err := extractPESymbols(&pe.File{
+		FileHeader: pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64},
+		Sections: []*pe.Section{
+			{SectionHeader: pe.SectionHeader{Name: ".data", VirtualSize: 0x200, VirtualAddress: 0x3000, Size: 0x100, Offset: 0x100}},
+		},
+		Symbols: []*pe.Symbol{
+			{Name: "virtual", Value: 0xf8, SectionNumber: 1},
+			{Name: "next", Value: 0x108, SectionNumber: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machoFile, err := extractMachoSymbols(&macho.File{
+		Sections: []*macho.Section{
+			{SectionHeader: macho.SectionHeader{Name: "__bss", Seg: "__DATA", Addr: 0x100001000, Size: 0x10, Offset: 0, Flags: S_ZEROFILL}},
+		},
+		Symtab: &macho.Symtab{Syms: []macho.Symbol{
+			{Name: "_zerofill", Sect: 1, Value: 0x100001000},
+			{Name: "_next", Sect: 1, Value: 0x100001008},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		file   *File
+		symbol string
+		err    string
+	}{
+		{
+			name:   "elf bss",
+			file:   elfFile,
+			symbol: "bss",
+			err:    `extends past the 0 bytes of section ".bss" in the file`,
+		},
+		{
+			name:   "pe virtual size",
+			file:   peFile,
+			symbol: "virtual",
+			err:    `extends past the 256 bytes of section ".data" in the file`,
+		},
+		{
+			name:   "macho zerofill",
+			file:   machoFile,
+			symbol: "zerofill",
+			err:    `extends past the 0 bytes of section "__bss" in the file`,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			test.file.r = bytes.NewReader(data)
+			out := &bytes.Buffer{}
+			err := InjectSymbols(test.file, out, []Injection{
+				{Symbol: test.symbol, Type: Uint64Type, Value: "1"},
+			})
+			if err == nil {
+				t.Fatalf("expected error containing %q", test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %q", test.err, err.Error())
+			}
+			if out.Len() > 0 {
+				t.Errorf("expected nothing to be written, got %q", out.String())
+			}
+		})
+	}
+}
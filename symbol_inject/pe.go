@@ -54,6 +54,8 @@ func extractPESymbols(peFile *pe.File) (*File, error) {
 			Addr:   uint64(section.VirtualAddress),
 			Offset: uint64(section.Offset),
 			Size:   uint64(section.VirtualSize),
+			// The size of the raw data, which can be smaller than the virtual size
+			FileSize: uint64(section.Size),
 		})
 	}
 
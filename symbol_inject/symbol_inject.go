@@ -98,29 +98,18 @@ func InjectUint64Symbol(file *File, w io.Writer, symbol string, value uint64) er
 	return copyAndInject(file.r, w, offset, buf)
 }
 
-func copyAndInject(r io.ReaderAt, w io.Writer, offset uint64, buf []byte) (err error) {
-	// Copy the first bytes up to the symbol offset
-	_, err = io.Copy(w, io.NewSectionReader(r, 0, int64(offset)))
-
-	// Write the injected value in the output file
-	if err == nil {
-		_, err = w.Write(buf)
-	}
-
-	// Write the remainder of the file
-	pos := int64(offset) + int64(len(buf))
-	if err == nil {
-		_, err = io.Copy(w, io.NewSectionReader(r, pos, 1<<63-1-pos))
-	}
-
-	if err == io.EOF {
-		err = io.ErrUnexpectedEOF
-	}
-
-	return err
+func copyAndInject(r io.ReaderAt, w io.Writer, offset uint64, buf []byte) error {
+	return copyAndInjectPatches(r, w, []patch{{offset: offset, buf: buf}})
 }
 
 func findSymbol(file *File, symbolName string) (uint64, uint64, error) {
+	offset, size, _, err := findSymbolAndSection(file, symbolName)
+	return offset, size, err
+}
+
+// findSymbolAndSection returns the offset into the file and the size of a symbol, and the section
+// that contains it.
+func findSymbolAndSection(file *File, symbolName string) (uint64, uint64, *Section, error) {
 	for i, symbol := range file.Symbols {
 		if symbol.Name == symbolName {
 			// Find the next symbol (n the same section with a higher address
@@ -145,7 +134,7 @@ func findSymbol(file *File, symbolName string) (uint64, uint64, error) {
 				}
 
 				if end <= symbol.Addr || end > symbol.Addr+4096 {
-					return maxUint64, maxUint64, fmt.Errorf("symbol end address does not seem valid, %x:%x", symbol.Addr, end)
+					return maxUint64, maxUint64, nil, fmt.Errorf("symbol end address does not seem valid, %x:%x", symbol.Addr, end)
 				}
 
 				size = end - symbol.Addr
@@ -153,11 +142,11 @@ func findSymbol(file *File, symbolName string) (uint64, uint64, error) {
 
 			offset := symbol.Section.Offset + symbol.Addr
 
-			return uint64(offset), uint64(size), nil
+			return uint64(offset), uint64(size), symbol.Section, nil
 		}
 	}
 
-	return maxUint64, maxUint64, fmt.Errorf("symbol not found")
+	return maxUint64, maxUint64, nil, fmt.Errorf("symbol not found")
 }
 
 type File struct {
@@ -179,6 +168,9 @@ type Section struct {
 	Addr   uint64 // Virtual address of the start of the section.
 	Offset uint64 // Offset into the file of the start of the section.
 	Size   uint64
+	// Number of bytes of the section that are stored in the file, which is 0 for sections that
+	// are only allocated at runtime like .bss.
+	FileSize uint64
 }
 
 func DumpSymbols(r io.ReaderAt) error {
@@ -29,6 +29,7 @@ import (
 const (
 	Store   uint16 = 0
 	Deflate uint16 = 8
+	Zstd    uint16 = 93 // Zstandard, only frames of raw or RLE blocks are supported
 )
 
 const (
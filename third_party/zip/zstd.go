@@ -0,0 +1,292 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zip
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The Zstd compression method writes entries as Zstandard frames that only contain raw
+// (uncompressed) blocks.  This is much cheaper than Deflate while still producing a valid
+// zstd stream, which makes it useful for intermediate zip files that are only read by
+// other build tools.  The decompressor only supports raw and RLE blocks, which covers
+// everything written by NewZstdStoreWriter but not zstd streams with compressed blocks.
+
+const (
+	zstdMagic          = 0xFD2FB528
+	zstdSkippableMagic = 0x184D2A50
+	zstdSkippableMask  = 0xFFFFFFF0
+
+	// zstdMaxBlockSize is the largest block size allowed by the zstd format.
+	zstdMaxBlockSize = 128 * 1024
+
+	// A frame header descriptor with no content size, no checksum and no dictionary id,
+	// followed by a window descriptor for a 128kB window, which is enough for raw blocks.
+	zstdFrameHeaderDescriptor = 0x00
+	zstdWindowDescriptor      = (17 - 10) << 3
+
+	zstdBlockRaw        = 0
+	zstdBlockRLE        = 1
+	zstdBlockCompressed = 2
+)
+
+var errZstdCompressedBlock = errors.New("zip: zstd compressed blocks are not supported")
+
+func init() {
+	compressors[Zstd] = func(w io.Writer) (io.WriteCloser, error) { return NewZstdStoreWriter(w), nil }
+	decompressors[Zstd] = newZstdReader
+}
+
+// NewZstdStoreWriter returns a writer that wraps everything written to it in a single zstd
+// frame made of raw blocks.  Close must be called to write the last block, it does not
+// close the underlying writer.
+func NewZstdStoreWriter(w io.Writer) io.WriteCloser {
+	return &zstdStoreWriter{w: w, buf: make([]byte, 0, zstdMaxBlockSize)}
+}
+
+type zstdStoreWriter struct {
+	w             io.Writer
+	buf           []byte
+	headerWritten bool
+	closed        bool
+	err           error
+}
+
+func (z *zstdStoreWriter) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("zip: write to closed zstd writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		if z.err != nil {
+			return n, z.err
+		}
+		// Only write a block once more data is known to follow it, the last block
+		// has to be marked as such and is written by Close.
+		if len(z.buf) == zstdMaxBlockSize {
+			z.writeBlock(false)
+			continue
+		}
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, z.err
+}
+
+func (z *zstdStoreWriter) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err == nil {
+		z.writeBlock(true)
+	}
+	return z.err
+}
+
+func (z *zstdStoreWriter) writeBlock(last bool) {
+	if !z.headerWritten {
+		var header [6]byte
+		binary.LittleEndian.PutUint32(header[:], zstdMagic)
+		header[4] = zstdFrameHeaderDescriptor
+		header[5] = zstdWindowDescriptor
+		if _, z.err = z.w.Write(header[:]); z.err != nil {
+			return
+		}
+		z.headerWritten = true
+	}
+
+	blockHeader := uint32(len(z.buf))<<3 | zstdBlockRaw<<1
+	if last {
+		blockHeader |= 1
+	}
+	if _, z.err = z.w.Write([]byte{byte(blockHeader), byte(blockHeader >> 8), byte(blockHeader >> 16)}); z.err != nil {
+		return
+	}
+	if _, z.err = z.w.Write(z.buf); z.err != nil {
+		return
+	}
+	z.buf = z.buf[:0]
+}
+
+type zstdReader struct {
+	r   *bufio.Reader
+	rc  io.Closer
+	err error
+
+	// remaining is the number of bytes left in the current block.
+	remaining int
+	// rle is set when the current block repeats rleByte.
+	rle     bool
+	rleByte byte
+	// lastBlock is set when the current block is the last one in its frame.
+	lastBlock bool
+	inFrame   bool
+	// checksum is set when the current frame ends with a content checksum.
+	checksum bool
+}
+
+func newZstdReader(r io.Reader) io.ReadCloser {
+	z := &zstdReader{r: bufio.NewReader(r)}
+	if rc, ok := r.(io.Closer); ok {
+		z.rc = rc
+	}
+	return z
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	for z.remaining == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.nextBlock()
+	}
+
+	if len(p) > z.remaining {
+		p = p[:z.remaining]
+	}
+	var n int
+	if z.rle {
+		for i := range p {
+			p[i] = z.rleByte
+		}
+		n = len(p)
+	} else {
+		var err error
+		n, err = z.r.Read(p)
+		if err != nil {
+			z.err = unexpectedEOF(err)
+			z.remaining -= n
+			return n, z.err
+		}
+	}
+	z.remaining -= n
+	return n, nil
+}
+
+// nextBlock reads headers until it finds a block with data, the end of the stream or an error.
+func (z *zstdReader) nextBlock() error {
+	if z.inFrame && z.lastBlock {
+		if z.checksum {
+			// The content checksum is not verified, the zip entry has its own CRC32.
+			if _, err := z.r.Discard(4); err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		z.inFrame = false
+	}
+
+	if !z.inFrame {
+		var magic [4]byte
+		if _, err := io.ReadFull(z.r, magic[:]); err == io.EOF {
+			return io.EOF
+		} else if err != nil {
+			return unexpectedEOF(err)
+		}
+		m := binary.LittleEndian.Uint32(magic[:])
+		if m&zstdSkippableMask == zstdSkippableMagic {
+			var size [4]byte
+			if _, err := io.ReadFull(z.r, size[:]); err != nil {
+				return unexpectedEOF(err)
+			}
+			if _, err := z.r.Discard(int(binary.LittleEndian.Uint32(size[:]))); err != nil {
+				return unexpectedEOF(err)
+			}
+			return nil
+		} else if m != zstdMagic {
+			return fmt.Errorf("zip: invalid zstd magic number %#x", m)
+		}
+		if err := z.readFrameHeader(); err != nil {
+			return err
+		}
+		z.inFrame = true
+		z.lastBlock = false
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(z.r, header[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	h := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+	z.lastBlock = h&1 != 0
+	size := int(h >> 3)
+	if size > zstdMaxBlockSize {
+		return fmt.Errorf("zip: zstd block size %d is too large", size)
+	}
+	switch (h >> 1) & 3 {
+	case zstdBlockRaw:
+		z.rle = false
+	case zstdBlockRLE:
+		b, err := z.r.ReadByte()
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+		z.rle = true
+		z.rleByte = b
+	case zstdBlockCompressed:
+		return errZstdCompressedBlock
+	default:
+		return errors.New("zip: reserved zstd block type")
+	}
+	z.remaining = size
+	return nil
+}
+
+func (z *zstdReader) readFrameHeader() error {
+	descriptor, err := z.r.ReadByte()
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if descriptor&0x08 != 0 {
+		return errors.New("zip: reserved bit set in zstd frame header")
+	}
+	fcsFlag := descriptor >> 6
+	singleSegment := descriptor&0x20 != 0
+	z.checksum = descriptor&0x04 != 0
+	dictIDSize := [4]int{0, 1, 2, 4}[descriptor&0x3]
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+	skip := dictIDSize + fcsSize
+	if !singleSegment {
+		// Window descriptor.
+		skip++
+	}
+	if _, err := z.r.Discard(skip); err != nil {
+		return unexpectedEOF(err)
+	}
+	return nil
+}
+
+func (z *zstdReader) Close() error {
+	if z.rc != nil {
+		return z.rc.Close()
+	}
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
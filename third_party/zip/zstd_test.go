@@ -0,0 +1,165 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zip
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func zstdStore(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := NewZstdStoreWriter(buf)
+	// Write in uneven pieces to exercise block splitting.
+	for len(data) > 0 {
+		n := len(data)
+		if n > 1000 {
+			n = 1000
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZstdStoreRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name   string
+		size   int
+		blocks int
+	}{
+		{name: "empty", size: 0, blocks: 1},
+		{name: "small", size: 10, blocks: 1},
+		{name: "one block", size: zstdMaxBlockSize, blocks: 1},
+		{name: "one block plus one", size: zstdMaxBlockSize + 1, blocks: 2},
+		{name: "large", size: 5*zstdMaxBlockSize + 17, blocks: 6},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := make([]byte, tc.size)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			frame := zstdStore(t, data)
+
+			if g, w := len(frame), 6+3*tc.blocks+tc.size; g != w {
+				t.Errorf("expected frame size %d, got %d", w, g)
+			}
+
+			r := newZstdReader(bytes.NewReader(frame))
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("round trip mismatch")
+			}
+		})
+	}
+}
+
+func TestZstdReader(t *testing.T) {
+	testCases := []struct {
+		name string
+		in   []byte
+		out  []byte
+		err  error
+	}{
+		{
+			name: "rle block with content size and checksum",
+			in: []byte{0x28, 0xb5, 0x2f, 0xfd,
+				0x24,             // single segment, checksum, 1 byte content size
+				0x05,             // content size
+				0x2b, 0x00, 0x00, // last rle block of size 5
+				'a',
+				0x01, 0x02, 0x03, 0x04, // checksum
+			},
+			out: []byte("aaaaa"),
+		},
+		{
+			name: "skippable frame and multiple frames",
+			in: []byte{0x50, 0x2a, 0x4d, 0x18, 0x02, 0x00, 0x00, 0x00, 0xff, 0xff,
+				0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38, 0x11, 0x00, 0x00, 'a', 'b',
+				0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38, 0x09, 0x00, 0x00, 'c',
+			},
+			out: []byte("abc"),
+		},
+		{
+			name: "compressed block",
+			in:   []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38, 0x15, 0x00, 0x00, 0x00, 0x00},
+			err:  errZstdCompressedBlock,
+		},
+		{
+			name: "truncated",
+			in:   []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x38, 0x19, 0x00, 0x00, 'a'},
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := io.ReadAll(newZstdReader(bytes.NewReader(tc.in)))
+			if err != tc.err {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+			if tc.err == nil && !bytes.Equal(got, tc.out) {
+				t.Errorf("expected %q, got %q", tc.out, got)
+			}
+		})
+	}
+}
+
+func TestZstdZipEntry(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	fw, err := w.CreateHeader(&FileHeader{Name: "a", Method: Zstd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := r.File[0].Method, Zstd; g != w {
+		t.Errorf("expected method %d, got %d", w, g)
+	}
+	rc, err := r.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
@@ -85,7 +85,9 @@ type configImpl struct {
 	skipMetricsUpload        bool
 	buildStartedTime         int64 // For metrics-upload-only - manually specify a build-started time
 	buildFromSourceStub      bool
-	ensureAllowlistIntegrity bool // For CI builds - make sure modules are mixed-built
+	ensureAllowlistIntegrity bool   // For CI builds - make sure modules are mixed-built
+	jsonStatusFile           string // Where to stream newline-delimited JSON build status events
+	explainRebuild           bool   // Report why ninja reran actions
 
 	// From the product config
 	katiArgs        []string
@@ -785,6 +787,8 @@ func (c *configImpl) parseArgs(ctx Context, args []string) {
 			c.reportMkMetrics = true
 		} else if arg == "--search-api-dir" {
 			c.searchApiDir = true
+		} else if arg == "--explain-rebuild" {
+			c.explainRebuild = true
 		} else if strings.HasPrefix(arg, "--ninja_weight_source=") {
 			source := strings.TrimPrefix(arg, "--ninja_weight_source=")
 			if source == "ninja_log" {
@@ -817,6 +821,8 @@ func (c *configImpl) parseArgs(ctx Context, args []string) {
 			buildCmd = strings.TrimPrefix(buildCmd, "\"")
 			buildCmd = strings.TrimSuffix(buildCmd, "\"")
 			ctx.Metrics.SetBuildCommand([]string{buildCmd})
+		} else if strings.HasPrefix(arg, "--json-status=") {
+			c.jsonStatusFile = strings.TrimPrefix(arg, "--json-status=")
 		} else if strings.HasPrefix(arg, "--build-started-time-unix-millis=") {
 			buildTimeStr := strings.TrimPrefix(arg, "--build-started-time-unix-millis=")
 			val, err := strconv.ParseInt(buildTimeStr, 10, 64)
@@ -1111,6 +1117,12 @@ func (c *configImpl) SetSkipNinja(v bool) {
 	c.skipNinja = v
 }
 
+// ExplainRebuild returns whether ninja should explain why it reran actions, see
+// status.RebuildExplainer.
+func (c *configImpl) ExplainRebuild() bool {
+	return c.explainRebuild
+}
+
 func (c *configImpl) SkipConfig() bool {
 	return c.skipConfig
 }
@@ -1638,6 +1650,20 @@ func (c *configImpl) EmptyNinjaFile() bool {
 	return c.emptyNinjaFile
 }
 
+// JSONStatusFile returns the path that newline-delimited JSON build status
+// events should be written to, or an empty string if they are disabled. It is
+// set by the --json-status=<file> argument, or the SOONG_UI_JSON_STATUS
+// environment variable.
+func (c *configImpl) JSONStatusFile() string {
+	if c.jsonStatusFile != "" {
+		return c.jsonStatusFile
+	}
+	if v, ok := c.environ.Get("SOONG_UI_JSON_STATUS"); ok {
+		return v
+	}
+	return ""
+}
+
 func (c *configImpl) SkipMetricsUpload() bool {
 	return c.skipMetricsUpload
 }
@@ -186,6 +186,60 @@ func TestConfigParseArgsVars(t *testing.T) {
 	}
 }
 
+func TestConfigJSONStatusFile(t *testing.T) {
+	ctx := testContext()
+
+	testCases := []struct {
+		name string
+		env  []string
+		args []string
+
+		expected string
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:     "flag",
+			args:     []string{"--json-status=out/status.json"},
+			expected: "out/status.json",
+		},
+		{
+			name:     "env",
+			env:      []string{"SOONG_UI_JSON_STATUS=/tmp/status.json"},
+			expected: "/tmp/status.json",
+		},
+		{
+			name:     "flag overrides env",
+			env:      []string{"SOONG_UI_JSON_STATUS=/tmp/status.json"},
+			args:     []string{"--json-status=out/status.json"},
+			expected: "out/status.json",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer logger.Recover(func(err error) {
+				t.Fatal(err)
+			})
+
+			e := Environment(tc.env)
+			c := &configImpl{
+				environ: &e,
+			}
+			c.parseArgs(ctx, tc.args)
+
+			if g := c.JSONStatusFile(); g != tc.expected {
+				t.Errorf("for env=%q args=%q, JSON status file:\nwant: %q\n got: %q\n",
+					tc.env, tc.args, tc.expected, g)
+			}
+			if len(c.arguments) != 0 {
+				t.Errorf("for args=%q, unexpected remaining arguments: %q", tc.args, c.arguments)
+			}
+		})
+	}
+}
+
 func TestConfigCheckTopDir(t *testing.T) {
 	ctx := testContext()
 	buildRootDir := filepath.Dir(srcDirFileCheck)
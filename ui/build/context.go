@@ -42,6 +42,9 @@ type ContextImpl struct {
 	Tracer tracer.Tracer
 
 	CriticalPath *status.CriticalPath
+
+	// RebuildExplainer collects why ninja reran actions, it is only used with --explain-rebuild.
+	RebuildExplainer *status.RebuildExplainer
 }
 
 // BeginTrace starts a new Duration Event.
@@ -16,6 +16,7 @@ package build
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -88,8 +89,21 @@ func NewSourceFinder(ctx Context, config Config) (f *finder.Finder) {
 		IncludeSuffixes: []string{".mk"},
 	}
 	dumpDir := config.FileListDir()
-	f, err = finder.New(cacheParams, filesystem, logger.New(ioutil.Discard),
-		filepath.Join(dumpDir, "files.db"))
+	dbPath := filepath.Join(dumpDir, "files.db")
+
+	// Use the finder daemon if there is one, it avoids stat'ing every directory in the tree.
+	if socket, ok := config.environ.Get("SOONG_FINDER_DAEMON_SOCKET"); ok && socket != "" {
+		f, err = finder.NewFromDaemon(socket, cacheParams, filesystem, logger.New(ioutil.Discard), dbPath)
+		if err == nil {
+			return f
+		} else if errors.Is(err, finder.ErrNoDaemon) {
+			ctx.Verbosef("Not using finder daemon: %v", err)
+		} else {
+			ctx.Printf("Not using finder daemon: %v", err)
+		}
+	}
+
+	f, err = finder.New(cacheParams, filesystem, logger.New(ioutil.Discard), dbPath)
 	if err != nil {
 		ctx.Fatalf("Could not create module-finder: %v", err)
 	}
@@ -105,17 +119,11 @@ func androidBpSearchDirs(config Config) []string {
 	return dirs
 }
 
-func findProductAndBoardConfigFiles(entries finder.DirEntries) (dirNames []string, fileNames []string) {
-	matches := []string{}
-	for _, foundName := range entries.FileNames {
-		if foundName != "Android.mk" &&
-			foundName != "AndroidProducts.mk" &&
-			foundName != "CleanSpec.mk" &&
-			strings.HasSuffix(foundName, ".mk") {
-			matches = append(matches, foundName)
-		}
-	}
-	return entries.DirNames, matches
+// productAndBoardConfigFiles finds the .mk files other than the Kati build and clean
+// definitions and the product lists.
+var productAndBoardConfigFiles = finder.Query{
+	Patterns: []string{"*.mk"},
+	Excludes: []string{"Android.mk", "AndroidProducts.mk", "CleanSpec.mk"},
 }
 
 // FindSources searches for source files known to <f> and writes them to the filesystem for
@@ -193,7 +201,10 @@ func FindSources(ctx Context, config Config, f *finder.Finder) {
 	}
 
 	// Recursively look for all product/board config files.
-	configurationFiles := f.FindMatching(".", findProductAndBoardConfigFiles)
+	configurationFiles, err := f.Query(".", productAndBoardConfigFiles)
+	if err != nil {
+		ctx.Fatalf("Could not find product/board configuration files: %v", err)
+	}
 	err = dumpListToFile(ctx, config, configurationFiles, filepath.Join(dumpDir, "configuration.list"))
 	if err != nil {
 		ctx.Fatalf("Could not export product/board configuration list: %v", err)
@@ -46,7 +46,7 @@ func runNinjaForBuild(ctx Context, config Config) {
 	// translates it to the soong_ui status output, displaying real-time
 	// progress of the build.
 	fifo := filepath.Join(config.OutDir(), ".ninja_fifo")
-	nr := status.NewNinjaReader(ctx, ctx.Status.StartTool(), fifo)
+	nr := newNinjaReader(ctx, config, fifo)
 	defer nr.Close()
 
 	executable := config.PrebuiltBuildTool("ninja")
@@ -57,6 +57,10 @@ func runNinjaForBuild(ctx Context, config Config) {
 		"--frontend_file", fifo,
 	}
 
+	if config.ExplainRebuild() {
+		args = append(args, "-d", "explain")
+	}
+
 	args = append(args, config.NinjaArgs()...)
 
 	var parallel int
@@ -252,6 +256,15 @@ func runNinjaForBuild(ctx Context, config Config) {
 	cmd.RunAndStreamOrFatal()
 }
 
+// newNinjaReader returns a NinjaReader for the ninja status written to fifo, which passes the
+// explanations from `-d explain` to ctx.RebuildExplainer with --explain-rebuild.
+func newNinjaReader(ctx Context, config Config, fifo string) *status.NinjaReader {
+	if config.ExplainRebuild() && ctx.RebuildExplainer != nil {
+		return status.NewExplainingNinjaReader(ctx, ctx.Status.StartTool(), fifo, ctx.RebuildExplainer)
+	}
+	return status.NewNinjaReader(ctx, ctx.Status.StartTool(), fifo)
+}
+
 // A simple struct for checking if Ninja gets stuck, using timestamps.
 type ninjaStucknessChecker struct {
 	logPath     string
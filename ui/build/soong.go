@@ -30,7 +30,6 @@ import (
 	"android/soong/bazel"
 	"android/soong/ui/metrics"
 	"android/soong/ui/metrics/metrics_proto"
-	"android/soong/ui/status"
 
 	"android/soong/shared"
 
@@ -455,6 +454,10 @@ func checkEnvironmentFile(ctx Context, currentEnv *Environment, envFile string)
 		for _, changedEnvironmentVariable := range changedEnvironmentVariableList {
 			ctx.Metrics.AddChangedEnvironmentVariable(changedEnvironmentVariable)
 		}
+		// Removing the file makes soong_build rerun, tell --explain-rebuild why.
+		if ctx.RebuildExplainer != nil {
+			ctx.RebuildExplainer.AddChangedEnvironmentVariables(envFile, changedEnvironmentVariableList)
+		}
 		os.Remove(envFile)
 	}
 }
@@ -625,7 +628,7 @@ func runSoong(ctx Context, config Config) {
 		defer ctx.EndTrace()
 
 		fifo := filepath.Join(config.OutDir(), ".ninja_fifo")
-		nr := status.NewNinjaReader(ctx, ctx.Status.StartTool(), fifo)
+		nr := newNinjaReader(ctx, config, fifo)
 		defer nr.Close()
 
 		ninjaArgs := []string{
@@ -641,6 +644,10 @@ func runSoong(ctx Context, config Config) {
 			"-f", filepath.Join(config.SoongOutDir(), "bootstrap.ninja"),
 		}
 
+		if config.ExplainRebuild() {
+			ninjaArgs = append(ninjaArgs, "-d", "explain")
+		}
+
 		if extra, ok := config.Environment().Get("SOONG_UI_NINJA_ARGS"); ok {
 			ctx.Printf(`CAUTION: arguments in $SOONG_UI_NINJA_ARGS=%q, e.g. "-n", can make soong_build FAIL or INCORRECT`, extra)
 			ninjaArgs = append(ninjaArgs, strings.Fields(extra)...)
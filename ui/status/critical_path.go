@@ -18,6 +18,7 @@ import (
 	"android/soong/ui/metrics"
 
 	soong_metrics_proto "android/soong/ui/metrics/metrics_proto"
+	"sort"
 	"time"
 
 	"google.golang.org/protobuf/proto"
@@ -35,6 +36,10 @@ type CriticalPath struct {
 	nodes   map[string]*node
 	running map[*Action]time.Time
 
+	// The number of running actions after every change, used to compute the
+	// parallelism of the build over time.
+	runningSamples []runningSample
+
 	start, end time.Time
 
 	clock clock
@@ -57,12 +62,18 @@ type node struct {
 	input              *node
 }
 
+type runningSample struct {
+	time    time.Time
+	running int
+}
+
 func (cp *CriticalPath) StartAction(action *Action) {
 	start := cp.clock.Now()
 	if cp.start.IsZero() {
 		cp.start = start
 	}
 	cp.running[action] = start
+	cp.runningSamples = append(cp.runningSamples, runningSample{start, len(cp.running)})
 }
 
 func (cp *CriticalPath) FinishAction(action *Action) {
@@ -81,6 +92,7 @@ func (cp *CriticalPath) FinishAction(action *Action) {
 
 		end := cp.clock.Now()
 		duration := end.Sub(start)
+		cp.runningSamples = append(cp.runningSamples, runningSample{end, len(cp.running)})
 
 		cumulativeDuration := duration
 		if criticalPathInput != nil {
@@ -127,6 +139,15 @@ func (cp *CriticalPath) criticalPath() (path []*node, elapsedTime time.Duration,
 	return
 }
 
+// ElapsedTime returns the time between the start of the first action and the end of the last
+// action.
+func (cp *CriticalPath) ElapsedTime() time.Duration {
+	if cp.start.IsZero() {
+		return 0
+	}
+	return cp.end.Sub(cp.start)
+}
+
 func (cp *CriticalPath) longRunningJobs() (nodes []*node) {
 	threshold := time.Second * 30
 	for _, node := range cp.nodes {
@@ -137,6 +158,84 @@ func (cp *CriticalPath) longRunningJobs() (nodes []*node) {
 	return
 }
 
+// slowestActions returns up to n finished actions, sorted by decreasing duration.
+func (cp *CriticalPath) slowestActions(n int) []*node {
+	seen := make(map[*node]bool)
+	var nodes []*node
+	for _, node := range cp.nodes {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].duration != nodes[j].duration {
+			return nodes[i].duration > nodes[j].duration
+		}
+		return nodes[i].action.Description < nodes[j].action.Description
+	})
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// parallelismInterval is the average number of running actions between start and end, which are
+// relative to the start of the first action.
+type parallelismInterval struct {
+	start, end time.Duration
+	average    float64
+}
+
+// parallelism splits the time between the start of the first action and the end of the last
+// action into n equal intervals and returns the average number of running actions in each.
+func (cp *CriticalPath) parallelism(n int) []parallelismInterval {
+	if cp.start.IsZero() || !cp.end.After(cp.start) || n <= 0 {
+		return nil
+	}
+
+	elapsed := cp.end.Sub(cp.start)
+	width := elapsed / time.Duration(n)
+	if width <= 0 {
+		width = elapsed
+		n = 1
+	}
+
+	intervals := make([]parallelismInterval, n)
+	busy := make([]time.Duration, n)
+	for i := range intervals {
+		intervals[i].start = time.Duration(i) * width
+		intervals[i].end = time.Duration(i+1) * width
+	}
+	intervals[n-1].end = elapsed
+
+	// Integrate the number of running actions over each interval.
+	for i, sample := range cp.runningSamples {
+		segStart := sample.time.Sub(cp.start)
+		segEnd := elapsed
+		if i+1 < len(cp.runningSamples) {
+			segEnd = cp.runningSamples[i+1].time.Sub(cp.start)
+		}
+		if sample.running == 0 || segEnd <= segStart {
+			continue
+		}
+		for j := range intervals {
+			lo := max(segStart, intervals[j].start)
+			hi := min(segEnd, intervals[j].end)
+			if hi > lo {
+				busy[j] += time.Duration(sample.running) * (hi - lo)
+			}
+		}
+	}
+
+	for i := range intervals {
+		if d := intervals[i].end - intervals[i].start; d > 0 {
+			intervals[i].average = float64(busy[i]) / float64(d)
+		}
+	}
+	return intervals
+}
+
 func addJobInfos(jobInfos *[]*soong_metrics_proto.JobInfo, sources []*node) {
 	for _, job := range sources {
 		jobInfo := soong_metrics_proto.JobInfo{}
@@ -0,0 +1,137 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Number of intervals the build is split into for the parallelism report.
+	parallelismReportIntervals = 20
+
+	// Width of the bar for the busiest interval in the parallelism report.
+	parallelismReportBarWidth = 40
+)
+
+// WriteReport writes a human readable report of the build to w. It lists the critical path from
+// the first to the last action, the topN slowest actions, and the average number of running
+// actions over the course of the build. Nothing is written if no actions were run.
+func (cp *CriticalPath) WriteReport(w io.Writer, topN int) {
+	path, elapsedTime, criticalTime := cp.criticalPath()
+	if len(path) == 0 {
+		return
+	}
+
+	writeReportHeader(w, elapsedTime, criticalTime)
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Critical path (%d actions, cumulative and per-action time):\n", len(path))
+	for i := len(path) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "  %s  %s  %s\n", formatReportDuration(path[i].cumulativeDuration),
+			formatReportDuration(path[i].duration), path[i].action.Description)
+		writeReportOutputs(w, path[i].action.Outputs, 22)
+	}
+
+	fmt.Fprintln(w)
+	cp.writeSlowestActions(w, topN)
+
+	if intervals := cp.parallelism(parallelismReportIntervals); len(intervals) > 0 {
+		var peak float64
+		for _, interval := range intervals {
+			peak = max(peak, interval.average)
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Parallelism (average running actions):")
+		for _, interval := range intervals {
+			bar := 0
+			if peak > 0 {
+				bar = int(interval.average / peak * parallelismReportBarWidth)
+			}
+			fmt.Fprintf(w, "  %s - %s  %6.1f %s\n", formatReportDuration(interval.start),
+				formatReportDuration(interval.end), interval.average, strings.Repeat("#", bar))
+		}
+	}
+}
+
+// WriteSummary writes a short version of the report written by WriteReport to w, suitable for
+// printing to the terminal at the end of the build.
+func (cp *CriticalPath) WriteSummary(w io.Writer, topN int) {
+	path, elapsedTime, criticalTime := cp.criticalPath()
+	if len(path) == 0 {
+		return
+	}
+
+	writeReportHeader(w, elapsedTime, criticalTime)
+	cp.writeSlowestActions(w, topN)
+}
+
+// WriteReportFile writes the report from WriteReport to filename.
+func (cp *CriticalPath) WriteReportFile(filename string, topN int) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	cp.WriteReport(f, topN)
+	return f.Close()
+}
+
+func writeReportHeader(w io.Writer, elapsedTime, criticalTime time.Duration) {
+	fmt.Fprintf(w, "Critical path took %s", formatReportDuration(criticalTime))
+	if elapsedTime > 0 {
+		fmt.Fprintf(w, " of %s spent running actions (perfect parallelism ratio %d%%)",
+			formatReportDuration(elapsedTime), int(float64(criticalTime)/float64(elapsedTime)*100))
+	}
+	fmt.Fprintln(w)
+}
+
+func (cp *CriticalPath) writeSlowestActions(w io.Writer, topN int) {
+	slowest := cp.slowestActions(topN)
+	if len(slowest) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Slowest actions:\n")
+	for _, node := range slowest {
+		fmt.Fprintf(w, "  %s  %s\n", formatReportDuration(node.duration), node.action.Description)
+		writeReportOutputs(w, node.action.Outputs, 12)
+	}
+}
+
+// writeReportOutputs writes the first few outputs of an action, indented to line up with its
+// description.
+func writeReportOutputs(w io.Writer, outputs []string, indent int) {
+	const maxOutputs = 3
+	prefix := strings.Repeat(" ", indent)
+	for i, output := range outputs {
+		if i == maxOutputs {
+			fmt.Fprintf(w, "%s(%d more outputs)\n", prefix, len(outputs)-maxOutputs)
+			break
+		}
+		fmt.Fprintf(w, "%s%s\n", prefix, output)
+	}
+}
+
+// formatReportDuration formats a duration as m:ss.s, padded to a fixed width.
+func formatReportDuration(d time.Duration) string {
+	d = d.Round(100 * time.Millisecond)
+	minutes := int(d / time.Minute)
+	seconds := (d % time.Minute).Seconds()
+	return fmt.Sprintf("%3d:%04.1f", minutes, seconds)
+}
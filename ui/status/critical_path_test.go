@@ -16,6 +16,7 @@ package status
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -162,3 +163,73 @@ func TestCriticalPath(t *testing.T) {
 		})
 	}
 }
+
+func TestCriticalPathParallelism(t *testing.T) {
+	cp := &testCriticalPath{
+		CriticalPath: NewCriticalPath(),
+		actions:      make(map[int]*Action),
+	}
+
+	// Two actions run in parallel for the first half of the build, one for the second half.
+	cp.start(0, 0, []string{"a"}, nil)
+	cp.start(1, 0, []string{"b"}, nil)
+	cp.finish(0, 2*time.Second)
+	cp.start(2, 2*time.Second, []string{"c"}, []string{"a"})
+	cp.finish(1, 2*time.Second)
+	cp.finish(2, 4*time.Second)
+
+	got := cp.parallelism(2)
+	want := []parallelismInterval{
+		{start: 0, end: 2 * time.Second, average: 2},
+		{start: 2 * time.Second, end: 4 * time.Second, average: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parallelism(2) = %v, want %v", got, want)
+	}
+
+	slowest := cp.slowestActions(2)
+	var descs []string
+	for _, x := range slowest {
+		descs = append(descs, x.action.Description)
+	}
+	if w := []string{"a", "b"}; !reflect.DeepEqual(descs, w) {
+		t.Errorf("slowestActions(2) = %v, want %v", descs, w)
+	}
+}
+
+func TestCriticalPathReport(t *testing.T) {
+	cp := &testCriticalPath{
+		CriticalPath: NewCriticalPath(),
+		actions:      make(map[int]*Action),
+	}
+
+	cp.start(0, 0, []string{"out/a"}, nil)
+	cp.finish(0, 1500*time.Millisecond)
+	cp.start(1, 1500*time.Millisecond, []string{"out/b"}, []string{"out/a"})
+	cp.finish(1, 65*time.Second)
+
+	buf := &strings.Builder{}
+	cp.WriteReport(buf, 10)
+	report := buf.String()
+
+	for _, want := range []string{
+		"Critical path took   1:05.0 of   1:05.0 spent running actions (perfect parallelism ratio 100%)",
+		"  0:01.5    0:01.5  out/a\n",
+		"  1:05.0    1:03.5  out/b\n",
+		"Slowest actions:\n    1:03.5  out/b\n",
+		"Parallelism (average running actions):",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+	if strings.Index(report, "  1:05.0    1:03.5  out/b") < strings.Index(report, "  0:01.5    0:01.5  out/a") {
+		t.Errorf("expected critical path to be listed from first to last action, got:\n%s", report)
+	}
+
+	empty := &strings.Builder{}
+	NewCriticalPath().WriteReport(empty, 10)
+	if empty.Len() != 0 {
+		t.Errorf("expected no report without actions, got:\n%s", empty.String())
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// This file implements `m --explain-rebuild`. Ninja is run with `-d explain`, and NinjaReader
+// passes the explanations and the actions that were started to a RebuildExplainer. Ninja only
+// explains why each edge is dirty, which is usually because one of its inputs is the output of
+// another dirty edge, so the explainer follows the inputs of the actions that ran back to the
+// root causes: a changed environment variable, a file newer than its outputs, a changed command
+// line, and so on.
+
+// Number of example outputs listed per root cause in the report file.
+const explainReportExamples = 10
+
+type rebuildCauseKind int
+
+const (
+	causeEnvironmentVariable rebuildCauseKind = iota
+	causeGlob
+	causeInputNewer
+	causeInputMissing
+	causeCommandChanged
+	causeOutputMissing
+	causeNotInLog
+	causeDepsMissing
+	causeUnknown
+)
+
+// A rebuildCause is a root cause of actions being rerun.
+type rebuildCause struct {
+	kind rebuildCauseKind
+	// The environment variable, file or rule the cause is about, if any.
+	subject string
+}
+
+func (c rebuildCause) String() string {
+	switch c.kind {
+	case causeEnvironmentVariable:
+		return fmt.Sprintf("environment variable %s changed", c.subject)
+	case causeGlob:
+		return fmt.Sprintf("glob results %s changed", c.subject)
+	case causeInputNewer:
+		return fmt.Sprintf("file %s is newer than its outputs", c.subject)
+	case causeInputMissing:
+		return fmt.Sprintf("source file %s is missing", c.subject)
+	case causeCommandChanged:
+		return fmt.Sprintf("command line changed for rule %s", c.subject)
+	case causeOutputMissing:
+		return "outputs don't exist"
+	case causeNotInLog:
+		return "actions not found in the ninja log"
+	case causeDepsMissing:
+		return "dependency information is missing"
+	case causeUnknown:
+		if c.subject != "" {
+			return c.subject
+		}
+		return "unknown"
+	}
+	panic(fmt.Errorf("unknown rebuild cause kind %d", c.kind))
+}
+
+var (
+	explainOutputMissing  = regexp.MustCompile(`^output (.+?)(?: of phony edge with no inputs)? doesn't exist$`)
+	explainOlderThanInput = regexp.MustCompile(`^(?:restat of output|output|recorded mtime of) (.+?) older than most recent input (.+?)(?: \(-?\d+ vs -?\d+\))?$`)
+	explainCommandChanged = regexp.MustCompile(`^command line changed for (.+)$`)
+	explainNotInLog       = regexp.MustCompile(`^command line not found in log for (.+)$`)
+	explainInputMissing   = regexp.MustCompile(`^(.+) has no in-edge and is missing$`)
+	explainDirty          = regexp.MustCompile(`^(.+) is dirty$`)
+	explainDepsMissing    = regexp.MustCompile(`^(?:deps for '(.+)' are missing|depfile '(.+)' is missing)$`)
+)
+
+// isExplanation returns the explanation in a message from ninja if it has one.
+func isExplanation(message string) (string, bool) {
+	message = strings.TrimPrefix(message, "ninja ")
+	if !strings.HasPrefix(message, "explain: ") {
+		return "", false
+	}
+	return strings.TrimPrefix(message, "explain: "), true
+}
+
+// NewRebuildExplainer returns a RebuildExplainer that collects the explanations from every
+// ninja run of the build.
+func NewRebuildExplainer() *RebuildExplainer {
+	return &RebuildExplainer{
+		outputCauses:    make(map[string][]rebuildCause),
+		commandsChanged: make(map[string]bool),
+		producers:       make(map[string]*Action),
+		envFiles:        make(map[string][]string),
+	}
+}
+
+// RebuildExplainer groups the actions that ninja reran by their root causes.
+type RebuildExplainer struct {
+	lock sync.Mutex
+
+	// The causes ninja gave for each output or input being dirty, except for command line
+	// changes which need the action to be attributed to a rule.
+	outputCauses    map[string][]rebuildCause
+	commandsChanged map[string]bool
+
+	// The actions that were started, in order, and the action that produced each output.
+	actions   []*Action
+	producers map[string]*Action
+
+	// The environment variables that changed, keyed by the environment file that records them.
+	envFiles map[string][]string
+}
+
+// AddChangedEnvironmentVariables records that the environment variables in names differ from the
+// ones recorded in envFile, which will cause the actions that depend on envFile to rerun.
+func (e *RebuildExplainer) AddChangedEnvironmentVariables(envFile string, names []string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	envFile = filepath.Clean(envFile)
+	e.envFiles[envFile] = append(e.envFiles[envFile], names...)
+}
+
+// addExplanation parses an explanation from ninja, without its "ninja explain: " prefix.
+func (e *RebuildExplainer) addExplanation(explanation string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	addCause := func(path string, cause rebuildCause) {
+		e.outputCauses[path] = append(e.outputCauses[path], cause)
+	}
+
+	if m := explainOutputMissing.FindStringSubmatch(explanation); m != nil {
+		addCause(m[1], rebuildCause{kind: causeOutputMissing})
+	} else if m := explainOlderThanInput.FindStringSubmatch(explanation); m != nil {
+		// If the input is an output of another action that ran the causes of that action
+		// are used instead, see causesOf.
+		addCause(m[1], rebuildCause{kind: causeInputNewer, subject: m[2]})
+	} else if m := explainCommandChanged.FindStringSubmatch(explanation); m != nil {
+		e.commandsChanged[m[1]] = true
+	} else if m := explainNotInLog.FindStringSubmatch(explanation); m != nil {
+		addCause(m[1], rebuildCause{kind: causeNotInLog})
+	} else if m := explainInputMissing.FindStringSubmatch(explanation); m != nil {
+		addCause(m[1], rebuildCause{kind: causeInputMissing, subject: m[1]})
+	} else if m := explainDepsMissing.FindStringSubmatch(explanation); m != nil {
+		addCause(m[1]+m[2], rebuildCause{kind: causeDepsMissing})
+	} else if explainDirty.MatchString(explanation) {
+		// Dirty inputs are found by following the inputs of the actions that ran.
+	} else {
+		// Keep anything that isn't understood, but don't attribute it to any action.
+		addCause("", rebuildCause{kind: causeUnknown, subject: explanation})
+	}
+}
+
+// addAction records an action that ninja started.
+func (e *RebuildExplainer) addAction(action *Action) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.actions = append(e.actions, action)
+	for _, output := range action.Outputs {
+		e.producers[output] = action
+	}
+}
+
+// ruleName returns the name used to group the command line changes of an action. The rule of an
+// action isn't part of ninja's status output, so the tool run by its command is used instead.
+func ruleName(action *Action) string {
+	for _, field := range strings.Fields(action.Command) {
+		if field == "env" || strings.Contains(field, "=") {
+			continue
+		}
+		return filepath.Base(strings.Trim(field, `"'(`))
+	}
+	return "<unknown>"
+}
+
+// rootCauses maps a cause about an environment file or a glob result file to the cause that
+// changed it.
+func (e *RebuildExplainer) rootCauses(cause rebuildCause, path string) []rebuildCause {
+	if cause.kind == causeInputNewer || cause.kind == causeInputMissing || cause.kind == causeOutputMissing {
+		file := path
+		if cause.kind == causeInputNewer {
+			file = cause.subject
+		}
+		if names, ok := e.envFiles[filepath.Clean(file)]; ok {
+			var causes []rebuildCause
+			for _, name := range names {
+				causes = append(causes, rebuildCause{kind: causeEnvironmentVariable, subject: name})
+			}
+			return causes
+		}
+		if cause.kind == causeInputNewer && strings.Contains(file, "/globs/") {
+			return []rebuildCause{{kind: causeGlob, subject: file}}
+		}
+	}
+	return []rebuildCause{cause}
+}
+
+// causesOf returns the root causes of action being rerun. memo holds the causes of the actions
+// that have already been visited.
+func (e *RebuildExplainer) causesOf(action *Action, memo map[*Action][]rebuildCause) []rebuildCause {
+	if causes, ok := memo[action]; ok {
+		return causes
+	}
+	// Guard against cycles in the inputs, which ninja doesn't allow anyway.
+	memo[action] = nil
+
+	seen := make(map[rebuildCause]bool)
+	var causes []rebuildCause
+	add := func(newCauses ...rebuildCause) {
+		for _, cause := range newCauses {
+			if !seen[cause] {
+				seen[cause] = true
+				causes = append(causes, cause)
+			}
+		}
+	}
+
+	for _, output := range action.Outputs {
+		if e.commandsChanged[output] {
+			add(rebuildCause{kind: causeCommandChanged, subject: ruleName(action)})
+		}
+		for _, cause := range e.outputCauses[output] {
+			if producer := e.producers[cause.subject]; cause.kind == causeInputNewer && producer != nil && producer != action {
+				add(e.causesOf(producer, memo)...)
+			} else {
+				add(e.rootCauses(cause, output)...)
+			}
+		}
+	}
+
+	// Ninja only explains the first dirty input of an edge, so follow all the inputs that were
+	// rebuilt.
+	for _, input := range action.Inputs {
+		if producer := e.producers[input]; producer != nil && producer != action {
+			add(e.causesOf(producer, memo)...)
+		} else {
+			for _, cause := range e.outputCauses[input] {
+				add(e.rootCauses(cause, input)...)
+			}
+		}
+	}
+
+	// Fall back to the changed inputs reported by ninja if there was no explanation.
+	if len(causes) == 0 {
+		for _, input := range action.ChangedInputs {
+			add(e.rootCauses(rebuildCause{kind: causeInputNewer, subject: input}, input)...)
+		}
+	}
+	if len(causes) == 0 {
+		add(rebuildCause{kind: causeUnknown})
+	}
+
+	memo[action] = causes
+	return causes
+}
+
+// A rebuildGroup is a root cause and the actions it caused to rerun.
+type rebuildGroup struct {
+	cause   rebuildCause
+	actions []*Action
+}
+
+// groups returns the root causes of the actions that were rerun, ordered by the number of actions
+// each of them caused to rerun.
+func (e *RebuildExplainer) groups() []rebuildGroup {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	memo := make(map[*Action][]rebuildCause)
+	index := make(map[rebuildCause]int)
+	var groups []rebuildGroup
+	for _, action := range e.actions {
+		for _, cause := range e.causesOf(action, memo) {
+			i, ok := index[cause]
+			if !ok {
+				i = len(groups)
+				index[cause] = i
+				groups = append(groups, rebuildGroup{cause: cause})
+			}
+			groups[i].actions = append(groups[i].actions, action)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].actions) != len(groups[j].actions) {
+			return len(groups[i].actions) > len(groups[j].actions)
+		}
+		return groups[i].cause.String() < groups[j].cause.String()
+	})
+	return groups
+}
+
+// unattributed returns the explanations that could not be parsed.
+func (e *RebuildExplainer) unattributed() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	var explanations []string
+	for _, cause := range e.outputCauses[""] {
+		explanations = append(explanations, cause.subject)
+	}
+	return explanations
+}
+
+// WriteReport writes the root causes of the actions that were rerun to w, with up to
+// explainReportExamples outputs of the actions of each cause. An action is counted once for
+// each of its root causes.
+func (e *RebuildExplainer) WriteReport(w io.Writer) {
+	e.writeGroups(w, 0, explainReportExamples)
+
+	if explanations := e.unattributed(); len(explanations) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Explanations from ninja that were not attributed to any action:")
+		for _, explanation := range explanations {
+			fmt.Fprintf(w, "  %s\n", explanation)
+		}
+	}
+}
+
+// WriteSummary writes the topN root causes of the actions that were rerun to w, suitable for
+// printing to the terminal at the end of the build.
+func (e *RebuildExplainer) WriteSummary(w io.Writer, topN int) {
+	e.writeGroups(w, topN, 0)
+}
+
+func (e *RebuildExplainer) writeGroups(w io.Writer, topN, examples int) {
+	e.lock.Lock()
+	total := len(e.actions)
+	e.lock.Unlock()
+
+	if total == 0 {
+		fmt.Fprintln(w, "No actions were rerun.")
+		return
+	}
+
+	groups := e.groups()
+	fmt.Fprintf(w, "%d actions were rerun because of:\n", total)
+	for i, group := range groups {
+		if topN > 0 && i == topN {
+			fmt.Fprintf(w, "  ... and %d other causes\n", len(groups)-topN)
+			break
+		}
+		fmt.Fprintf(w, "  %7d  %s\n", len(group.actions), group.cause)
+		for j, action := range group.actions {
+			if examples == 0 {
+				break
+			} else if j == examples {
+				fmt.Fprintf(w, "           ...\n")
+				break
+			}
+			if len(action.Outputs) > 0 {
+				fmt.Fprintf(w, "           %s\n", action.Outputs[0])
+			} else {
+				fmt.Fprintf(w, "           %s\n", action.Description)
+			}
+		}
+	}
+}
+
+// WriteReportFile writes the report from WriteReport to filename.
+func (e *RebuildExplainer) WriteReportFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	e.WriteReport(f)
+	return f.Close()
+}
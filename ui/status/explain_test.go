@@ -0,0 +1,159 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIsExplanation(t *testing.T) {
+	testCases := []struct {
+		message     string
+		explanation string
+		ok          bool
+	}{
+		{"ninja explain: output a doesn't exist", "output a doesn't exist", true},
+		{"explain: a is dirty", "a is dirty", true},
+		{"build stopped: subcommand failed.", "", false},
+	}
+	for _, test := range testCases {
+		explanation, ok := isExplanation(test.message)
+		if explanation != test.explanation || ok != test.ok {
+			t.Errorf("isExplanation(%q): expected %q, %v, got %q, %v",
+				test.message, test.explanation, test.ok, explanation, ok)
+		}
+	}
+}
+
+func TestRebuildExplainer(t *testing.T) {
+	e := NewRebuildExplainer()
+	e.AddChangedEnvironmentVariables("out/soong/soong.environment.used.build", []string{"TARGET_PRODUCT", "USE_CCACHE"})
+
+	for _, explanation := range []string{
+		// soong_build reran because the environment file was removed
+		"output out/soong/soong.environment.used.build doesn't exist",
+		// A source file was touched
+		"output out/a.o older than most recent input a.c (1000 vs 2000)",
+		// The dependency of a.so was rerun, which ninja only reports as a dirty input
+		"out/a.o is dirty",
+		// Command lines changed after build.ninja was regenerated
+		"command line changed for out/b.o",
+		"command line changed for out/c.o",
+		// A glob changed
+		"output out/d.o older than most recent input out/soong/globs/build.ninja/1.glob_results (1 vs 2)",
+		"output out/e.o doesn't exist",
+		"something new",
+	} {
+		e.addExplanation(explanation)
+	}
+
+	actions := []*Action{
+		{
+			Description: "soong_build",
+			Outputs:     []string{"out/soong/build.ninja", "out/soong/soong.environment.used.build"},
+			Command:     "out/soong/bin/soong_build --used_env out/soong/soong.environment.used.build",
+		},
+		{Outputs: []string{"out/a.o"}, Inputs: []string{"a.c"}, Command: "prebuilts/clang/bin/clang -c a.c"},
+		{Outputs: []string{"out/a.so"}, Inputs: []string{"out/a.o", "out/b.o"}, Command: "prebuilts/clang/bin/clang -shared"},
+		{Outputs: []string{"out/b.o"}, Inputs: []string{"b.c"}, Command: "PWD=/proc/self/cwd prebuilts/clang/bin/clang -c b.c"},
+		{Outputs: []string{"out/c.o"}, Inputs: []string{"c.c"}, Command: "prebuilts/clang/bin/clang -c c.c"},
+		{Outputs: []string{"out/d.o"}, Command: "touch out/d.o"},
+		{Outputs: []string{"out/e.o"}, Command: "touch out/e.o"},
+		{Outputs: []string{"out/f.o"}, ChangedInputs: []string{"f.c"}, Command: "touch out/f.o"},
+		{Outputs: []string{"out/g.o"}, Command: "touch out/g.o"},
+	}
+	// a.so is started after b.o here, but its causes don't depend on the order
+	actions[2], actions[3] = actions[3], actions[2]
+	for _, action := range actions {
+		e.addAction(action)
+	}
+
+	got := map[string][]string{}
+	var order []string
+	for _, group := range e.groups() {
+		var outputs []string
+		for _, action := range group.actions {
+			outputs = append(outputs, action.Outputs[0])
+		}
+		got[group.cause.String()] = outputs
+		order = append(order, group.cause.String())
+	}
+
+	expected := map[string][]string{
+		"environment variable TARGET_PRODUCT changed":                     {"out/soong/build.ninja"},
+		"environment variable USE_CCACHE changed":                         {"out/soong/build.ninja"},
+		"file a.c is newer than its outputs":                              {"out/a.o", "out/a.so"},
+		"command line changed for rule clang":                             {"out/b.o", "out/a.so", "out/c.o"},
+		"glob results out/soong/globs/build.ninja/1.glob_results changed": {"out/d.o"},
+		"outputs don't exist":                                             {"out/e.o"},
+		"file f.c is newer than its outputs":                              {"out/f.o"},
+		"unknown":                                                         {"out/g.o"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("incorrect groups\nexpected: %q\n     got: %q", expected, got)
+	}
+	if order[0] != "command line changed for rule clang" {
+		t.Errorf("expected the cause with the most actions first, got %q", order)
+	}
+	if unattributed := e.unattributed(); !reflect.DeepEqual(unattributed, []string{"something new"}) {
+		t.Errorf("expected unattributed explanations %q, got %q", []string{"something new"}, unattributed)
+	}
+}
+
+func TestRebuildExplainerReport(t *testing.T) {
+	e := NewRebuildExplainer()
+	buf := &bytes.Buffer{}
+	e.WriteReport(buf)
+	if g, w := buf.String(), "No actions were rerun.\n"; g != w {
+		t.Errorf("expected %q, got %q", w, g)
+	}
+
+	e.addExplanation("command line changed for out/b.o")
+	e.addExplanation("command line changed for out/c.o")
+	e.addExplanation("output out/a.o older than most recent input a.c")
+	for _, output := range []string{"out/a.o", "out/b.o", "out/c.o"} {
+		e.addAction(&Action{Outputs: []string{output}, Command: "javac"})
+	}
+
+	buf.Reset()
+	e.WriteSummary(buf, 1)
+	expected := strings.Join([]string{
+		"3 actions were rerun because of:",
+		"        2  command line changed for rule javac",
+		"  ... and 1 other causes",
+		"",
+	}, "\n")
+	if g := buf.String(); g != expected {
+		t.Errorf("incorrect summary\nexpected:\n%s\ngot:\n%s", expected, g)
+	}
+
+	buf.Reset()
+	e.WriteReport(buf)
+	expected = strings.Join([]string{
+		"3 actions were rerun because of:",
+		"        2  command line changed for rule javac",
+		"           out/b.o",
+		"           out/c.o",
+		"        1  file a.c is newer than its outputs",
+		"           out/a.o",
+		"",
+	}, "\n")
+	if g := buf.String(); g != expected {
+		t.Errorf("incorrect report\nexpected:\n%s\ngot:\n%s", expected, g)
+	}
+}
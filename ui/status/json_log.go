@@ -0,0 +1,234 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"android/soong/ui/logger"
+)
+
+// Event types written by the JSON status output.
+const (
+	JSONEventActionStarted  = "action_started"
+	JSONEventActionFinished = "action_finished"
+	JSONEventMessage        = "message"
+	JSONEventBuildFinished  = "build_finished"
+)
+
+// JSONEvent is a single line of the newline-delimited JSON status stream.
+// Only the fields relevant to Type are set.
+type JSONEvent struct {
+	Type string `json:"type"`
+
+	// Milliseconds since the unix epoch at which the event was emitted.
+	TimeMillis int64 `json:"time_ms"`
+
+	Action *JSONAction `json:"action,omitempty"`
+	Result *JSONResult `json:"result,omitempty"`
+
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Counts *JSONCounts `json:"counts,omitempty"`
+}
+
+// JSONAction is the JSON representation of an Action.
+type JSONAction struct {
+	Description   string   `json:"description,omitempty"`
+	Command       string   `json:"command,omitempty"`
+	Outputs       []string `json:"outputs,omitempty"`
+	Inputs        []string `json:"inputs,omitempty"`
+	ChangedInputs []string `json:"changed_inputs,omitempty"`
+}
+
+// JSONResult is the JSON representation of an ActionResult, without the
+// embedded Action.
+type JSONResult struct {
+	Success  bool      `json:"success"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+	Stats    JSONStats `json:"stats"`
+}
+
+// JSONStats is the JSON representation of ActionResultStats.
+type JSONStats struct {
+	UserTimeMillis             uint32 `json:"user_time_ms"`
+	SystemTimeMillis           uint32 `json:"system_time_ms"`
+	MaxRssKB                   uint64 `json:"max_rss_kb"`
+	MinorPageFaults            uint64 `json:"minor_page_faults"`
+	MajorPageFaults            uint64 `json:"major_page_faults"`
+	IOInputKB                  uint64 `json:"io_input_kb"`
+	IOOutputKB                 uint64 `json:"io_output_kb"`
+	VoluntaryContextSwitches   uint64 `json:"voluntary_context_switches"`
+	InvoluntaryContextSwitches uint64 `json:"involuntary_context_switches"`
+	Tags                       string `json:"tags,omitempty"`
+}
+
+// JSONCounts is the JSON representation of Counts.
+type JSONCounts struct {
+	TotalActions    int `json:"total_actions"`
+	RunningActions  int `json:"running_actions"`
+	StartedActions  int `json:"started_actions"`
+	FinishedActions int `json:"finished_actions"`
+	FailedActions   int `json:"failed_actions"`
+
+	// Milliseconds since the unix epoch at which the build is estimated to
+	// finish, or 0 if there is no estimate.
+	EstimatedTimeMillis int64 `json:"estimated_time_ms,omitempty"`
+}
+
+type jsonLog struct {
+	w   io.Writer
+	enc *json.Encoder
+
+	failedActions int
+	lastCounts    Counts
+
+	// For testing
+	now func() time.Time
+}
+
+// NewJSONLog returns a StatusOutput that writes a newline-delimited JSON
+// event for every action start, action finish and message to filename. Each
+// event is written with a single write so that the file can be tailed while
+// the build is running.
+func NewJSONLog(log logger.Logger, filename string) StatusOutput {
+	f, err := logger.CreateFileWithRotation(filename, 5)
+	if err != nil {
+		log.Println("Failed to create JSON status log file:", err)
+		return nil
+	}
+
+	return NewJSONStatusOutput(f)
+}
+
+// NewJSONStatusOutput returns a StatusOutput that writes newline-delimited
+// JSON events to w. If w is an io.Closer it is closed on Flush.
+func NewJSONStatusOutput(w io.Writer) StatusOutput {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonLog{
+		w:   w,
+		enc: enc,
+		now: time.Now,
+	}
+}
+
+func (j *jsonLog) StartAction(action *Action, counts Counts) {
+	j.lastCounts = counts
+	j.write(&JSONEvent{
+		Type:   JSONEventActionStarted,
+		Action: newJSONAction(action),
+		Counts: j.jsonCounts(counts),
+	})
+}
+
+func (j *jsonLog) FinishAction(result ActionResult, counts Counts) {
+	j.lastCounts = counts
+
+	res := &JSONResult{
+		Success: result.Error == nil,
+		Output:  result.Output,
+		Stats: JSONStats{
+			UserTimeMillis:             result.Stats.UserTime,
+			SystemTimeMillis:           result.Stats.SystemTime,
+			MaxRssKB:                   result.Stats.MaxRssKB,
+			MinorPageFaults:            result.Stats.MinorPageFaults,
+			MajorPageFaults:            result.Stats.MajorPageFaults,
+			IOInputKB:                  result.Stats.IOInputKB,
+			IOOutputKB:                 result.Stats.IOOutputKB,
+			VoluntaryContextSwitches:   result.Stats.VoluntaryContextSwitches,
+			InvoluntaryContextSwitches: result.Stats.InvoluntaryContextSwitches,
+			Tags:                       result.Stats.Tags,
+		},
+	}
+	if result.Error != nil {
+		j.failedActions++
+		res.Error = result.Error.Error()
+		res.ExitCode = result.ExitCode
+		if res.ExitCode == 0 {
+			// Not every tool reports an exit code, make sure failures are never 0.
+			res.ExitCode = 1
+		}
+	}
+
+	j.write(&JSONEvent{
+		Type:   JSONEventActionFinished,
+		Action: newJSONAction(result.Action),
+		Result: res,
+		Counts: j.jsonCounts(counts),
+	})
+}
+
+func (j *jsonLog) Message(level MsgLevel, message string) {
+	j.write(&JSONEvent{
+		Type:    JSONEventMessage,
+		Level:   level.Name(),
+		Message: message,
+	})
+}
+
+func (j *jsonLog) Flush() {
+	j.write(&JSONEvent{
+		Type:   JSONEventBuildFinished,
+		Counts: j.jsonCounts(j.lastCounts),
+	})
+	if c, ok := j.w.(io.Closer); ok && j.w != os.Stdout && j.w != os.Stderr {
+		c.Close()
+	}
+}
+
+func (j *jsonLog) Write(p []byte) (int, error) {
+	return 0, errors.New("not supported")
+}
+
+func (j *jsonLog) write(event *JSONEvent) {
+	event.TimeMillis = j.now().UnixMilli()
+	// Errors are ignored, a broken status stream must not fail the build.
+	j.enc.Encode(event)
+}
+
+func (j *jsonLog) jsonCounts(counts Counts) *JSONCounts {
+	ret := &JSONCounts{
+		TotalActions:    counts.TotalActions,
+		RunningActions:  counts.RunningActions,
+		StartedActions:  counts.StartedActions,
+		FinishedActions: counts.FinishedActions,
+		FailedActions:   j.failedActions,
+	}
+	if !counts.EstimatedTime.IsZero() {
+		ret.EstimatedTimeMillis = counts.EstimatedTime.UnixMilli()
+	}
+	return ret
+}
+
+func newJSONAction(action *Action) *JSONAction {
+	if action == nil {
+		return nil
+	}
+	return &JSONAction{
+		Description:   action.Description,
+		Command:       action.Command,
+		Outputs:       action.Outputs,
+		Inputs:        action.Inputs,
+		ChangedInputs: action.ChangedInputs,
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package status
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	output := NewJSONStatusOutput(buf)
+	output.(*jsonLog).now = func() time.Time { return time.UnixMilli(1234) }
+
+	stat := &Status{}
+	stat.AddOutput(output)
+
+	tool := stat.StartTool()
+	tool.SetTotalActions(2)
+
+	a := &Action{Description: "a", Command: "touch out/a", Outputs: []string{"out/a"}}
+	b := &Action{Description: "b", Outputs: []string{"out/b"}}
+	tool.StartAction(a)
+	tool.StartAction(b)
+	tool.FinishAction(ActionResult{
+		Action: a,
+		Stats:  ActionResultStats{UserTime: 10, MaxRssKB: 20},
+	})
+	tool.FinishAction(ActionResult{
+		Action:   b,
+		Output:   "b failed",
+		Error:    fmt.Errorf("exited with code: 2"),
+		ExitCode: 2,
+	})
+	tool.Print("done")
+	tool.Finish()
+	stat.Finish()
+
+	var events []JSONEvent
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var event JSONEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("failed to parse line %q: %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+		if event.TimeMillis != 1234 {
+			t.Errorf("expected time 1234 in %q event, got %d", event.Type, event.TimeMillis)
+		}
+	}
+	expectedTypes := []string{
+		JSONEventActionStarted,
+		JSONEventActionStarted,
+		JSONEventActionFinished,
+		JSONEventActionFinished,
+		JSONEventMessage,
+		JSONEventBuildFinished,
+	}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Fatalf("expected events %q, got %q", expectedTypes, types)
+	}
+
+	if g, w := events[0].Action, (&JSONAction{Description: "a", Command: "touch out/a", Outputs: []string{"out/a"}}); !reflect.DeepEqual(g, w) {
+		t.Errorf("expected action %#v, got %#v", w, g)
+	}
+
+	success := events[2].Result
+	if !success.Success || success.ExitCode != 0 || success.Stats.UserTimeMillis != 10 || success.Stats.MaxRssKB != 20 {
+		t.Errorf("unexpected result for successful action: %#v", success)
+	}
+
+	failure := events[3].Result
+	if failure.Success || failure.ExitCode != 2 || failure.Output != "b failed" || failure.Error != "exited with code: 2" {
+		t.Errorf("unexpected result for failed action: %#v", failure)
+	}
+
+	if g, w := events[4].Level, "print"; g != w {
+		t.Errorf("expected message level %q, got %q", w, g)
+	}
+	if g, w := events[4].Message, "done"; g != w {
+		t.Errorf("expected message %q, got %q", w, g)
+	}
+
+	expectedCounts := &JSONCounts{
+		TotalActions:    2,
+		StartedActions:  2,
+		FinishedActions: 2,
+		FailedActions:   1,
+	}
+	if g := events[5].Counts; !reflect.DeepEqual(g, expectedCounts) {
+		t.Errorf("expected final counts %#v, got %#v", expectedCounts, g)
+	}
+}
+
+func TestJSONLogFailureWithoutExitCode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	output := NewJSONStatusOutput(buf)
+
+	action := &Action{Description: "kati"}
+	output.StartAction(action, Counts{})
+	buf.Reset()
+	output.FinishAction(ActionResult{Action: action, Error: fmt.Errorf("failed")}, Counts{})
+
+	var event JSONEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Result.ExitCode != 1 {
+		t.Errorf("expected failed action without exit code to report 1, got %d", event.Result.ExitCode)
+	}
+}
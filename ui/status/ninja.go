@@ -34,6 +34,12 @@ import (
 // NewNinjaReader reads the protobuf frontend format from ninja and translates it
 // into calls on the ToolStatus API.
 func NewNinjaReader(ctx logger.Logger, status ToolStatus, fifo string) *NinjaReader {
+	return NewExplainingNinjaReader(ctx, status, fifo, nil)
+}
+
+// NewExplainingNinjaReader is like NewNinjaReader, but also passes the explanations ninja prints
+// with `-d explain` and the actions it starts to explainer if it is not nil.
+func NewExplainingNinjaReader(ctx logger.Logger, status ToolStatus, fifo string, explainer *RebuildExplainer) *NinjaReader {
 	os.Remove(fifo)
 
 	if err := syscall.Mkfifo(fifo, 0666); err != nil {
@@ -42,6 +48,7 @@ func NewNinjaReader(ctx logger.Logger, status ToolStatus, fifo string) *NinjaRea
 
 	n := &NinjaReader{
 		status:     status,
+		explainer:  explainer,
 		fifo:       fifo,
 		forceClose: make(chan bool),
 		done:       make(chan bool),
@@ -55,6 +62,7 @@ func NewNinjaReader(ctx logger.Logger, status ToolStatus, fifo string) *NinjaRea
 
 type NinjaReader struct {
 	status     ToolStatus
+	explainer  *RebuildExplainer
 	fifo       string
 	forceClose chan bool
 	done       chan bool
@@ -213,6 +221,9 @@ func (n *NinjaReader) run() {
 				ChangedInputs: msg.EdgeStarted.ChangedInputs,
 			}
 			n.status.StartAction(action)
+			if n.explainer != nil {
+				n.explainer.addAction(action)
+			}
 			running[msg.EdgeStarted.GetId()] = action
 		}
 		if msg.EdgeFinished != nil {
@@ -227,9 +238,10 @@ func (n *NinjaReader) run() {
 
 				outputWithErrorHint := errorHintGenerator.GetOutputWithErrorHint(msg.EdgeFinished.GetOutput(), exitCode)
 				n.status.FinishAction(ActionResult{
-					Action: started,
-					Output: outputWithErrorHint,
-					Error:  err,
+					Action:   started,
+					Output:   outputWithErrorHint,
+					Error:    err,
+					ExitCode: exitCode,
 					Stats: ActionResultStats{
 						UserTime:                   msg.EdgeFinished.GetUserTime(),
 						SystemTime:                 msg.EdgeFinished.GetSystemTime(),
@@ -247,17 +259,23 @@ func (n *NinjaReader) run() {
 		}
 		if msg.Message != nil {
 			message := "ninja: " + msg.Message.GetMessage()
-			switch msg.Message.GetLevel() {
-			case ninja_frontend.Status_Message_INFO:
-				n.status.Status(message)
-			case ninja_frontend.Status_Message_WARNING:
-				n.status.Print("warning: " + message)
-			case ninja_frontend.Status_Message_ERROR:
-				n.status.Error(message)
-			case ninja_frontend.Status_Message_DEBUG:
+			if explanation, ok := isExplanation(msg.Message.GetMessage()); ok && n.explainer != nil {
+				// There is an explanation for every dirty node, keep them out of the terminal.
+				n.explainer.addExplanation(explanation)
 				n.status.Verbose(message)
-			default:
-				n.status.Print(message)
+			} else {
+				switch msg.Message.GetLevel() {
+				case ninja_frontend.Status_Message_INFO:
+					n.status.Status(message)
+				case ninja_frontend.Status_Message_WARNING:
+					n.status.Print("warning: " + message)
+				case ninja_frontend.Status_Message_ERROR:
+					n.status.Error(message)
+				case ninja_frontend.Status_Message_DEBUG:
+					n.status.Verbose(message)
+				default:
+					n.status.Print(message)
+				}
 			}
 		}
 		if msg.BuildFinished != nil {
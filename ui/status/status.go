@@ -60,6 +60,11 @@ type ActionResult struct {
 	// failed.
 	Error error
 
+	// ExitCode is the (optional) exit code of the command. It is only
+	// meaningful when Error is set, and may be left as 0 by tools that
+	// don't report exit codes.
+	ExitCode int
+
 	Stats ActionResultStats
 }
 
@@ -193,6 +198,23 @@ func (l MsgLevel) Prefix() string {
 	}
 }
 
+// Name returns a lower case name for the level, suitable for machine readable
+// outputs.
+func (l MsgLevel) Name() string {
+	switch l {
+	case VerboseLvl:
+		return "verbose"
+	case StatusLvl:
+		return "status"
+	case PrintLvl:
+		return "print"
+	case ErrorLvl:
+		return "error"
+	default:
+		panic("Unknown message level")
+	}
+}
+
 // StatusOutput is the interface used to get status information as a Status
 // output.
 //
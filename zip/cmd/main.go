@@ -175,6 +175,11 @@ func main() {
 	sha256Checksum := flags.Bool("sha256", false, "add a zip header to each file containing its SHA256 digest")
 	doNotWrite := flags.Bool("n", false, "Nothing is written to disk -- all other work happens")
 	quiet := flags.Bool("quiet", false, "do not print warnings to console")
+	streaming := flags.Bool("streaming", false, "bound memory usage by the chunks being compressed instead of by whole files")
+	memoryLimitMB := flags.Int64("memory_limit_mb", 0, "maximum MB of file data to hold in memory at once (default 512)")
+	zstdStore := flags.Bool("zstd_store", false, "store entries as uncompressed zstd frames instead of deflating them, "+
+		"only for intermediate zip files that are read by other build tools and never leave the out directory")
+	timing := flags.Bool("timing", false, "print the time spent in each phase to stderr")
 
 	flags.Var(&rootPrefix{}, "P", "path prefix within the zip at which to place files")
 	flags.Var(&listFiles{}, "l", "file containing list of files to zip")
@@ -240,6 +245,10 @@ func main() {
 		Sha256Checksum:           *sha256Checksum,
 		DoNotWrite:               *doNotWrite,
 		Quiet:                    *quiet,
+		Streaming:                *streaming,
+		MemoryLimit:              *memoryLimitMB * 1024 * 1024,
+		ZstdStore:                *zstdStore,
+		Timings:                  *timing,
 	})
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "error:", err.Error())
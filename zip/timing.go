@@ -0,0 +1,86 @@
+// Copyright 2024 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zip
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"android/soong/third_party/zip"
+)
+
+// zipTimings collects the time spent in each phase of creating a zip file.  The
+// phases overlap: files are compressed in parallel while earlier entries are written.
+type zipTimings struct {
+	start time.Time
+
+	// findFiles is the time spent expanding globs and directories into a list of files.
+	findFiles  time.Duration
+	foundFiles int
+
+	// compress is the sum of the time spent checksumming and compressing in all threads.
+	compress atomic.Int64
+
+	// write is the wall time from starting to write the first entry to finishing the last one.
+	write time.Duration
+
+	// Only updated by the writer goroutine.
+	files, dirs, symlinks int
+	inputBytes            uint64
+	outputBytes           int64
+}
+
+func newZipTimings() *zipTimings {
+	return &zipTimings{start: time.Now()}
+}
+
+func (t *zipTimings) addCompress(start time.Time) {
+	t.compress.Add(int64(time.Since(start)))
+}
+
+func (t *zipTimings) addEntry(fh *zip.FileHeader) {
+	mode := fh.Mode()
+	switch {
+	case mode.IsDir():
+		t.dirs++
+	case mode&os.ModeSymlink != 0:
+		t.symlinks++
+	default:
+		t.files++
+		t.inputBytes += fh.UncompressedSize64
+	}
+}
+
+func (t *zipTimings) print(w io.Writer) {
+	total := time.Since(t.start)
+	fmt.Fprintf(w, "soong_zip: find files: %s (%d paths)\n", formatDuration(t.findFiles), t.foundFiles)
+	fmt.Fprintf(w, "soong_zip: compress:   %s cpu time (%d files, %s)\n",
+		formatDuration(time.Duration(t.compress.Load())), t.files, formatBytes(int64(t.inputBytes)))
+	fmt.Fprintf(w, "soong_zip: write:      %s (%d dirs, %d symlinks, %s written)\n",
+		formatDuration(t.write), t.dirs, t.symlinks, formatBytes(t.outputBytes))
+	fmt.Fprintf(w, "soong_zip: total:      %s\n", formatDuration(total))
+}
+
+func formatDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func formatBytes(b int64) string {
+	const mib = 1024 * 1024
+	return fmt.Sprintf("%.1f MiB", float64(b)/mib)
+}
@@ -233,6 +233,8 @@ func (x ConflictingFileError) Error() string {
 	return fmt.Sprintf("destination %q has two files %q and %q", x.Dest, x.Prev, x.Src)
 }
 
+var errZstdStoreWithJar = errors.New("zstd store cannot be used with --jar, java tools can't read zstd entries")
+
 type ZipWriter struct {
 	time         time.Time
 	createdFiles map[string]string
@@ -255,6 +257,14 @@ type ZipWriter struct {
 	fs     pathtools.FileSystem
 
 	sha256Checksum bool
+
+	// streaming accounts memory per compressed chunk and holds it until the data has been
+	// written to the output, instead of per file until its header has been written.
+	streaming   bool
+	memoryLimit int64
+	zstdStore   bool
+
+	timings *zipTimings
 }
 
 type zipEntry struct {
@@ -268,6 +278,13 @@ type zipEntry struct {
 	allocatedSize int64
 }
 
+// releasingReader is a compressed chunk whose memory is released back to the
+// MemoryRateLimiter once it has been written to the output in streaming mode.
+type releasingReader struct {
+	io.Reader
+	allocatedSize int64
+}
+
 type ZipArgs struct {
 	FileArgs                 []FileArg
 	OutputFilePath           string
@@ -285,6 +302,21 @@ type ZipArgs struct {
 	DoNotWrite               bool
 	Quiet                    bool
 
+	// Streaming bounds memory usage by the size of the chunks that are being compressed
+	// or waiting to be written instead of by whole files, and writes the output through
+	// a temporary file when WriteIfChanged is set instead of buffering it in memory.
+	Streaming bool
+	// MemoryLimit is the number of bytes of uncompressed or compressed data that may be
+	// held in memory at once, or 0 for the default.
+	MemoryLimit int64
+	// ZstdStore writes entries that would have been deflated as zstd frames of uncompressed
+	// blocks.  This is much faster than deflate, but the resulting zip can only be read by
+	// tools that use android/soong/third_party/zip, so it must only be used for intermediate
+	// files that never leave the out directory.
+	ZstdStore bool
+	// Timings prints the time spent in each phase to Stderr.
+	Timings bool
+
 	Stderr     io.Writer
 	Filesystem pathtools.FileSystem
 }
@@ -294,6 +326,10 @@ func zipTo(args ZipArgs, w io.Writer) error {
 		args.AddDirectoryEntriesToZip = true
 	}
 
+	if args.ZstdStore && args.EmulateJar {
+		return errZstdStoreWithJar
+	}
+
 	// Have Glob follow symlinks if they are not being stored as symlinks in the zip file.
 	followSymlinks := pathtools.ShouldFollowSymlinks(!args.StoreSymlinks)
 
@@ -308,6 +344,10 @@ func zipTo(args ZipArgs, w io.Writer) error {
 		stderr:             args.Stderr,
 		fs:                 args.Filesystem,
 		sha256Checksum:     args.Sha256Checksum,
+		streaming:          args.Streaming,
+		memoryLimit:        args.MemoryLimit,
+		zstdStore:          args.ZstdStore,
+		timings:            newZipTimings(),
 	}
 
 	if z.fs == nil {
@@ -394,7 +434,18 @@ func zipTo(args ZipArgs, w io.Writer) error {
 		}
 	}
 
-	return z.write(w, pathMappings, args.ManifestSourcePath, args.EmulateJar, args.SrcJar, args.NumParallelJobs)
+	z.timings.findFiles = time.Since(z.timings.start)
+	z.timings.foundFiles = len(pathMappings)
+
+	err := z.write(w, pathMappings, args.ManifestSourcePath, args.EmulateJar, args.SrcJar, args.NumParallelJobs)
+	if err != nil {
+		return err
+	}
+
+	if args.Timings {
+		z.timings.print(z.stderr)
+	}
+	return nil
 }
 
 // Zip creates an output zip archive from given sources.
@@ -407,9 +458,23 @@ func Zip(args ZipArgs) error {
 	var out io.Writer = buf
 
 	var zipErr error
+	var tmpFile *os.File
 
 	if args.DoNotWrite {
 		out = io.Discard
+	} else if args.WriteIfChanged && args.Streaming {
+		// Write to a temporary file next to the output instead of holding the whole
+		// zip file in memory, it is moved over the output if it differs.
+		f, err := os.OpenFile(args.OutputFilePath+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		tmpFile = f
+		out = f
 	} else if !args.WriteIfChanged {
 		f, err := os.Create(args.OutputFilePath)
 		if err != nil {
@@ -431,7 +496,9 @@ func Zip(args ZipArgs) error {
 		return zipErr
 	}
 
-	if args.WriteIfChanged && !args.DoNotWrite {
+	if tmpFile != nil {
+		return replaceFileIfChanged(tmpFile, args.OutputFilePath)
+	} else if args.WriteIfChanged && !args.DoNotWrite {
 		err := pathtools.WriteFileIfChanged(args.OutputFilePath, buf.Bytes(), 0666)
 		if err != nil {
 			return err
@@ -441,6 +508,73 @@ func Zip(args ZipArgs) error {
 	return nil
 }
 
+// replaceFileIfChanged moves tmpFile over path if their contents differ, leaving the
+// modification time of path unchanged otherwise.
+func replaceFileIfChanged(tmpFile *os.File, path string) error {
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	equal, err := filesEqual(tmpFile.Name(), path)
+	if err != nil {
+		return err
+	}
+	if equal {
+		return nil
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+// filesEqual compares the contents of two files without reading either of them into memory.
+// A missing file b is treated as different.
+func filesEqual(a, b string) (bool, error) {
+	fb, err := os.Open(b)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+
+	sa, err := fa.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := fb.Stat()
+	if err != nil {
+		return false, err
+	}
+	if sa.Size() != sb.Size() {
+		return false, nil
+	}
+
+	bufA := make([]byte, 64*1024)
+	bufB := make([]byte, 64*1024)
+	for {
+		na, errA := io.ReadFull(fa, bufA)
+		nb, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		} else if errA != nil {
+			return false, errA
+		} else if errB != nil {
+			if errB == io.EOF || errB == io.ErrUnexpectedEOF {
+				return false, nil
+			}
+			return false, errB
+		}
+	}
+}
+
 func fillPathPairs(fa FileArg, src string, pathMappings *[]pathMapping,
 	nonDeflatedFiles map[string]bool, noCompression bool) error {
 
@@ -501,7 +635,7 @@ func (z *ZipWriter) write(f io.Writer, pathMappings []pathMapping, manifest stri
 	// parallel compressions and outstanding buffers.
 	z.writeOps = make(chan chan *zipEntry, 1000)
 	z.cpuRateLimiter = NewCPURateLimiter(int64(parallelJobs))
-	z.memoryRateLimiter = NewMemoryRateLimiter(0)
+	z.memoryRateLimiter = NewMemoryRateLimiter(z.memoryLimit)
 	defer func() {
 		z.cpuRateLimiter.Stop()
 		z.memoryRateLimiter.Stop()
@@ -541,8 +675,12 @@ func (z *ZipWriter) write(f io.Writer, pathMappings []pathMapping, manifest stri
 	var currentWriter io.WriteCloser
 	var currentReaders chan chan io.Reader
 	var currentReader chan io.Reader
+	var currentAllocatedSize int64
 	var done bool
 
+	writeStart := time.Now()
+	defer func() { z.timings.write = time.Since(writeStart) }()
+
 	for !done {
 		var writeOpsChan chan chan *zipEntry
 		var writeOpChan chan *zipEntry
@@ -569,8 +707,10 @@ func (z *ZipWriter) write(f io.Writer, pathMappings []pathMapping, manifest stri
 		case op := <-writeOpChan:
 			currentWriteOpChan = nil
 
+			z.timings.addEntry(op.fh)
+
 			var err error
-			if op.fh.Method == zip.Deflate {
+			if op.fh.Method == zip.Deflate || op.fh.Method == zip.Zstd {
 				currentWriter, err = zipw.CreateCompressedHeader(op.fh)
 			} else {
 				var zw io.Writer
@@ -589,7 +729,12 @@ func (z *ZipWriter) write(f io.Writer, pathMappings []pathMapping, manifest stri
 				currentWriter.Close()
 				currentWriter = nil
 			}
-			z.memoryRateLimiter.Finish(op.allocatedSize)
+			if z.streaming {
+				// Hold the memory until the data has been written.
+				currentAllocatedSize = op.allocatedSize
+			} else {
+				z.memoryRateLimiter.Finish(op.allocatedSize)
+			}
 
 		case futureReader, ok := <-readersChan:
 			if !ok {
@@ -597,15 +742,24 @@ func (z *ZipWriter) write(f io.Writer, pathMappings []pathMapping, manifest stri
 				currentWriter.Close()
 				currentWriter = nil
 				currentReaders = nil
+				if z.streaming {
+					z.memoryRateLimiter.Finish(currentAllocatedSize)
+					currentAllocatedSize = 0
+				}
 			}
 
 			currentReader = futureReader
 
 		case reader := <-currentReader:
-			_, err := io.Copy(currentWriter, reader)
+			n, err := io.Copy(currentWriter, reader)
 			if err != nil {
 				return err
 			}
+			z.timings.outputBytes += n
+
+			if rr, ok := reader.(releasingReader); ok {
+				z.memoryRateLimiter.Finish(rr.allocatedSize)
+			}
 
 			currentReader = nil
 
@@ -717,6 +871,10 @@ func (z *ZipWriter) addFile(dest, src string, method uint16, emulateJar, srcJar
 		fileSize = s.Size()
 		executable = s.Mode()&0100 != 0
 
+		if z.zstdStore && method == zip.Deflate {
+			method = zip.Zstd
+		}
+
 		header := &zip.FileHeader{
 			Name:               dest,
 			Method:             method,
@@ -804,16 +962,26 @@ func (z *ZipWriter) writeFileContents(header *zip.FileHeader, r pathtools.Reader
 		fh: header,
 	}
 
-	ze.allocatedSize = int64(header.UncompressedSize64)
-	z.cpuRateLimiter.Request()
-	z.memoryRateLimiter.Request(ze.allocatedSize)
-
 	fileSize := int64(header.UncompressedSize64)
 	if fileSize == 0 {
 		fileSize = int64(header.UncompressedSize)
 	}
 
-	if header.Method == zip.Deflate && fileSize >= minParallelFileSize {
+	parallel := header.Method == zip.Deflate && fileSize >= minParallelFileSize
+	// Large zstd entries are not buffered, they are framed while they are being written.
+	streamZstd := header.Method == zip.Zstd && fileSize >= minParallelFileSize
+
+	ze.allocatedSize = int64(header.UncompressedSize64)
+	if parallel && z.streaming {
+		// Memory is requested for each chunk below.
+		ze.allocatedSize = 0
+	} else if streamZstd {
+		ze.allocatedSize = parallelBlockSize
+	}
+	z.cpuRateLimiter.Request()
+	z.memoryRateLimiter.Request(ze.allocatedSize)
+
+	if parallel {
 		wg := new(sync.WaitGroup)
 
 		// Allocate enough buffer to hold all readers. We'll limit
@@ -837,6 +1005,11 @@ func (z *ZipWriter) writeFileContents(header *zip.FileHeader, r pathtools.Reader
 			resultChan := make(chan io.Reader, 1)
 			ze.futureReaders <- resultChan
 
+			var chunkAllocatedSize int64
+			if z.streaming {
+				chunkAllocatedSize = parallelBlockSize
+				z.memoryRateLimiter.Request(chunkAllocatedSize)
+			}
 			z.cpuRateLimiter.Request()
 
 			last := !(start+parallelBlockSize < fileSize)
@@ -849,7 +1022,7 @@ func (z *ZipWriter) writeFileContents(header *zip.FileHeader, r pathtools.Reader
 			}
 
 			wg.Add(1)
-			go z.compressPartialFile(sr, dict, last, resultChan, wg)
+			go z.compressPartialFile(sr, dict, last, chunkAllocatedSize, resultChan, wg)
 		}
 
 		close(ze.futureReaders)
@@ -859,6 +1032,8 @@ func (z *ZipWriter) writeFileContents(header *zip.FileHeader, r pathtools.Reader
 			wg.Wait()
 			closer.Close()
 		}(wg, r)
+	} else if header.Method == zip.Zstd {
+		go z.zstdStoreFile(ze, r, streamZstd, compressChan)
 	} else {
 		go func() {
 			z.compressWholeFile(ze, r, compressChan)
@@ -880,6 +1055,8 @@ func (z *ZipWriter) checksumFileAsync(r io.ReadSeeker, ze *zipEntry, resultChan
 }
 
 func (z *ZipWriter) checksumFile(r io.ReadSeeker, ze *zipEntry) {
+	defer z.timings.addCompress(time.Now())
+
 	crc := crc32.NewIEEE()
 	writers := []io.Writer{crc}
 
@@ -918,7 +1095,8 @@ func (z *ZipWriter) appendSHAToExtra(ze *zipEntry, checksum []byte) {
 	ze.fh.Extra = append(ze.fh.Extra, buf...)
 }
 
-func (z *ZipWriter) compressPartialFile(r io.Reader, dict []byte, last bool, resultChan chan io.Reader, wg *sync.WaitGroup) {
+func (z *ZipWriter) compressPartialFile(r io.Reader, dict []byte, last bool, allocatedSize int64,
+	resultChan chan io.Reader, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	result, err := z.compressBlock(r, dict, last)
@@ -929,10 +1107,16 @@ func (z *ZipWriter) compressPartialFile(r io.Reader, dict []byte, last bool, res
 
 	z.cpuRateLimiter.Finish()
 
-	resultChan <- result
+	if allocatedSize > 0 {
+		resultChan <- releasingReader{result, allocatedSize}
+	} else {
+		resultChan <- result
+	}
 }
 
 func (z *ZipWriter) compressBlock(r io.Reader, dict []byte, last bool) (*bytes.Buffer, error) {
+	defer z.timings.addCompress(time.Now())
+
 	buf := new(bytes.Buffer)
 	var fw *flate.Writer
 	var err error
@@ -1029,6 +1213,63 @@ func (z *ZipWriter) compressWholeFile(ze *zipEntry, r io.ReadSeeker, compressCha
 	close(compressChan)
 }
 
+// zstdStoreFile wraps the contents of a file in a zstd frame of uncompressed blocks.  Small files
+// are framed into memory, large files are framed through a pipe while they are being written so
+// that they never need to be held in memory.
+func (z *ZipWriter) zstdStoreFile(ze *zipEntry, r pathtools.ReaderAtSeekerCloser, stream bool,
+	compressChan chan *zipEntry) {
+
+	z.checksumFile(r, ze)
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		r.Close()
+		z.errors <- err
+		return
+	}
+
+	ze.futureReaders = make(chan chan io.Reader, 1)
+	futureReader := make(chan io.Reader, 1)
+	ze.futureReaders <- futureReader
+	close(ze.futureReaders)
+
+	if stream {
+		pr, pw := io.Pipe()
+		go func() {
+			zw := zip.NewZstdStoreWriter(pw)
+			_, err := io.Copy(zw, r)
+			if err == nil {
+				err = zw.Close()
+			}
+			r.Close()
+			pw.CloseWithError(err)
+		}()
+		futureReader <- pr
+	} else {
+		start := time.Now()
+		buf := bytes.NewBuffer(make([]byte, 0, ze.fh.UncompressedSize64+64))
+		zw := zip.NewZstdStoreWriter(buf)
+		_, err := io.Copy(zw, r)
+		if err == nil {
+			err = zw.Close()
+		}
+		r.Close()
+		if err != nil {
+			z.errors <- err
+			return
+		}
+		z.timings.addCompress(start)
+		futureReader <- buf
+	}
+
+	z.cpuRateLimiter.Finish()
+
+	close(futureReader)
+
+	compressChan <- ze
+	close(compressChan)
+}
+
 // writeDirectory annotates that dir is a directory created for the src file or directory, and adds
 // the directory entry to the zip file if directories are enabled.
 func (z *ZipWriter) writeDirectory(dir string, src string, emulateJar bool) error {
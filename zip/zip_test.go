@@ -17,12 +17,18 @@ package zip
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"android/soong/third_party/zip"
 
@@ -139,6 +145,8 @@ func TestZip(t *testing.T) {
 		storeSymlinks      bool
 		ignoreMissingFiles bool
 		sha256Checksum     bool
+		streaming          bool
+		zstdStore          bool
 
 		files []zip.FileHeader
 		err   error
@@ -508,6 +516,42 @@ func TestZip(t *testing.T) {
 				fh("b", fileB, zip.Deflate),
 			},
 		},
+		{
+			name: "streaming",
+			args: fileArgsBuilder().
+				File("a/a/a").
+				File("a/a/b").
+				File("c").
+				File(`\[`),
+			compressionLevel: 9,
+			streaming:        true,
+
+			files: []zip.FileHeader{
+				fh("a/a/a", fileA, zip.Deflate),
+				fh("a/a/b", fileB, zip.Deflate),
+				fh("c", fileC, zip.Deflate),
+				fh("[", fileEmpty, zip.Store),
+			},
+		},
+		{
+			name: "zstd store",
+			args: fileArgsBuilder().
+				File("a/a/a").
+				File("a/a/b").
+				File("a/a/c").
+				File(`\[`),
+			compressionLevel: 9,
+			storeSymlinks:    true,
+			nonDeflatedFiles: map[string]bool{"a/a/b": true},
+			zstdStore:        true,
+
+			files: []zip.FileHeader{
+				fh("a/a/a", fileA, zip.Zstd),
+				fh("a/a/b", fileB, zip.Store),
+				fhLink("a/a/c", "../../c"),
+				fh("[", fileEmpty, zip.Zstd),
+			},
+		},
 
 		// errors
 		{
@@ -560,6 +604,14 @@ func TestZip(t *testing.T) {
 				File("a/a/*"),
 			err: ConflictingFileError{},
 		},
+		{
+			name: "error zstd store with jar",
+			args: fileArgsBuilder().
+				File("a/a/a"),
+			emulateJar: true,
+			zstdStore:  true,
+			err:        errZstdStoreWithJar,
+		},
 	}
 
 	for _, test := range testCases {
@@ -578,6 +630,8 @@ func TestZip(t *testing.T) {
 			args.StoreSymlinks = test.storeSymlinks
 			args.IgnoreMissingFiles = test.ignoreMissingFiles
 			args.Sha256Checksum = test.sha256Checksum
+			args.Streaming = test.streaming
+			args.ZstdStore = test.zstdStore
 			args.Filesystem = mockFs
 			args.Stderr = &bytes.Buffer{}
 
@@ -599,7 +653,7 @@ func TestZip(t *testing.T) {
 					if _, gotConflictingFileError := err.(ConflictingFileError); !gotConflictingFileError {
 						t.Fatalf("want error %v, got %v", test.err, err)
 					}
-				} else {
+				} else if !errors.Is(err, test.err) {
 					t.Fatalf("want error %v, got %v", test.err, err)
 				}
 				return
@@ -744,3 +798,174 @@ func TestSrcJar(t *testing.T) {
 		t.Errorf("want files %q, got %q", want, got)
 	}
 }
+
+func largeFileFs(t *testing.T) (pathtools.FileSystem, []byte) {
+	t.Helper()
+	// Larger than minParallelFileSize and partially compressible.
+	contents := make([]byte, minParallelFileSize+parallelBlockSize/2)
+	r := rand.New(rand.NewSource(1))
+	for i := range contents {
+		contents[i] = byte(r.Intn(16))
+	}
+	return pathtools.MockFs(map[string][]byte{
+		"large": contents,
+		"small": fileA,
+	}), contents
+}
+
+func readZipFile(t *testing.T, data []byte, name string) ([]byte, uint16) {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name == name {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			contents, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("error reading %s: %s", name, err)
+			}
+			return contents, f.Method
+		}
+	}
+	t.Fatalf("missing file %s", name)
+	return nil, 0
+}
+
+func TestZipLargeFile(t *testing.T) {
+	fs, contents := largeFileFs(t)
+
+	zipLarge := func(t *testing.T, streaming, zstdStore bool, memoryLimit int64) []byte {
+		args := ZipArgs{}
+		args.FileArgs = NewFileArgsBuilder().File("large").File("small").FileArgs()
+		args.CompressionLevel = 5
+		args.NumParallelJobs = 4
+		args.Streaming = streaming
+		args.ZstdStore = zstdStore
+		args.MemoryLimit = memoryLimit
+		args.Filesystem = fs
+		args.Stderr = &bytes.Buffer{}
+
+		buf := &bytes.Buffer{}
+		if err := zipTo(args, buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	normal := zipLarge(t, false, false, 0)
+
+	t.Run("streaming", func(t *testing.T) {
+		// A memory limit smaller than the file forces chunks to wait for earlier
+		// ones to be written.
+		streaming := zipLarge(t, true, false, 2*parallelBlockSize)
+		if !bytes.Equal(normal, streaming) {
+			t.Errorf("streaming output differs from non-streaming output")
+		}
+		got, method := readZipFile(t, streaming, "large")
+		if method != zip.Deflate {
+			t.Errorf("want method %d, got %d", zip.Deflate, method)
+		}
+		if !bytes.Equal(got, contents) {
+			t.Errorf("incorrect contents for large")
+		}
+	})
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("zstd store streaming=%v", streaming), func(t *testing.T) {
+			out := zipLarge(t, streaming, true, 0)
+			for name, want := range map[string][]byte{"large": contents, "small": fileA} {
+				got, method := readZipFile(t, out, name)
+				if method != zip.Zstd {
+					t.Errorf("want method %d for %s, got %d", zip.Zstd, name, method)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("incorrect contents for %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestZipTimings(t *testing.T) {
+	args := ZipArgs{}
+	args.FileArgs = fileArgsBuilder().File("a/a/a").File("a/a/c").File("c").FileArgs()
+	args.CompressionLevel = 5
+	args.StoreSymlinks = true
+	args.AddDirectoryEntriesToZip = true
+	args.Timings = true
+	args.Filesystem = mockFs
+	stderr := &bytes.Buffer{}
+	args.Stderr = stderr
+
+	if err := zipTo(args, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"soong_zip: find files: ",
+		"(3 paths)",
+		"soong_zip: compress: ",
+		"(2 files, ",
+		"soong_zip: write: ",
+		"(2 dirs, 1 symlinks, ",
+		"soong_zip: total: ",
+	} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected timings to contain %q, got:\n%s", want, stderr.String())
+		}
+	}
+}
+
+func TestZipStreamingWriteIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out.zip")
+
+	zipFile := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(in, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+		err := Zip(ZipArgs{
+			FileArgs:         NewFileArgsBuilder().JunkPaths(true).File(in).FileArgs(),
+			OutputFilePath:   out,
+			CompressionLevel: 5,
+			WriteIfChanged:   true,
+			Streaming:        true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(out + ".tmp"); !os.IsNotExist(err) {
+			t.Errorf("expected temporary file to be removed, got %v", err)
+		}
+	}
+
+	zipFile("foo")
+	old := time.Unix(1000, 0)
+	if err := os.Chtimes(out, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	zipFile("foo")
+	if s, err := os.Stat(out); err != nil {
+		t.Fatal(err)
+	} else if !s.ModTime().Equal(old) {
+		t.Errorf("expected unchanged output to not be rewritten")
+	}
+
+	zipFile("bar")
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readZipFile(t, data, "in"); string(got) != "bar" {
+		t.Errorf("want contents %q, got %q", "bar", got)
+	}
+}